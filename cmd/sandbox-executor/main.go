@@ -8,59 +8,65 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/koyeb/sandbox-container/pkg/logger"
 	"github.com/koyeb/sandbox-container/pkg/server"
 )
 
 // Version is set via ldflags during build
 var Version = "dev"
 
-// LevelTrace is a custom log level below DEBUG for very verbose logging
-const LevelTrace = slog.Level(-8)
-
 type runtimeConfig struct {
-	Port      string
-	ProxyPort string
-	Auth      server.AuthConfig
+	Port                string
+	ProxyPort           string
+	UDPProxyPort        string
+	Root                string
+	ProcessTTL          time.Duration
+	ProcessLogDir       string
+	MaxProcesses        int
+	MaxProcessesMode    server.ProcessLimitMode
+	ProxyAllowedHosts   []string
+	ProxyDialRetry      time.Duration
+	ProxyIdleTimeout    time.Duration
+	ProxyMaxConnections int
+	ProxyConnLog        server.ProxyConnLog
+	ProxyNoTarget       server.ProxyNoTarget
+	ProxyRateLimit      int64
+	UDPMaxSessions      int
+	MaxOutputBytes      int64
+	MaxWatchers         int
+	MaxUntarBytes       int64
+	MaxRequestBytes     int64
+	MaxUploadBytes      int64
+	RateLimit           server.RateLimit
+	RouteRateLimits     map[string]server.RateLimit
+	Auth                server.AuthConfig
+	TLS                 server.TLSConfig
+	Shell               string
+	TempDir             string
+	// H2C accepts HTTP/2 without TLS from clients with prior knowledge
+	H2C bool
+	// IdleTimeout shuts the executor down after that long without activity,
+	// zero keeps it running
+	IdleTimeout time.Duration
+	// AuditLog is the audit log sink, empty when auditing is disabled
+	AuditLog string
+	Audit    server.AuditConfig
 }
 
 func main() {
-	// Configure logger based on LOG_LEVEL environment variable
-	logLevel := os.Getenv("LOG_LEVEL")
-	var level slog.Level
-	switch strings.ToUpper(logLevel) {
-	case "TRACE":
-		level = LevelTrace
-	case "DEBUG":
-		level = slog.LevelDebug
-	case "INFO", "":
-		level = slog.LevelInfo
-	case "WARN":
-		level = slog.LevelWarn
-	case "ERROR":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
+	// Configure logger based on the LOG_LEVEL and LOG_FORMAT environment
+	// variables
+	handler, err := logger.NewHandler(os.Stderr, logger.ParseLevel(os.Getenv("LOG_LEVEL")), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	// Create a custom handler that properly formats the TRACE level
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: level,
-		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			if a.Key == slog.LevelKey {
-				level := a.Value.Any().(slog.Level)
-				if level == LevelTrace {
-					a.Value = slog.StringValue("TRACE")
-				}
-			}
-			return a
-		},
-	})
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
+	slog.SetDefault(slog.New(handler))
 
 	config, err := loadConfigFromEnv()
 	if err != nil {
@@ -68,22 +74,77 @@ func main() {
 		os.Exit(1)
 	}
 
-	srv, err := server.New(config.Auth)
+	if config.AuditLog != "" {
+		config.Audit.Writer, err = server.OpenAuditSink(config.AuditLog)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	srv, err := server.New(server.Config{
+		Auth:                config.Auth,
+		Root:                config.Root,
+		ProcessTTL:          config.ProcessTTL,
+		ProcessLogDir:       config.ProcessLogDir,
+		MaxProcesses:        config.MaxProcesses,
+		MaxProcessesMode:    config.MaxProcessesMode,
+		ProxyAllowedHosts:   config.ProxyAllowedHosts,
+		ProxyDialRetry:      config.ProxyDialRetry,
+		ProxyIdleTimeout:    config.ProxyIdleTimeout,
+		ProxyMaxConnections: config.ProxyMaxConnections,
+		ProxyConnLog:        config.ProxyConnLog,
+		ProxyNoTarget:       config.ProxyNoTarget,
+		ProxyRateLimit:      config.ProxyRateLimit,
+		UDPMaxSessions:      config.UDPMaxSessions,
+		MaxOutputBytes:      config.MaxOutputBytes,
+		MaxWatchers:         config.MaxWatchers,
+		MaxUntarBytes:       config.MaxUntarBytes,
+		MaxRequestBytes:     config.MaxRequestBytes,
+		MaxUploadBytes:      config.MaxUploadBytes,
+		RateLimit:           config.RateLimit,
+		RouteRateLimits:     config.RouteRateLimits,
+		Audit:               config.Audit,
+		Shell:               config.Shell,
+		TempDir:             config.TempDir,
+		IdleTimeout:         config.IdleTimeout,
+	})
 	if err != nil {
 		slog.Error("Failed to initialize server", "error", err)
 		os.Exit(1)
 	}
 	mux := srv.RegisterRoutes()
 
-	// Start the main HTTP server
+	// Start the main HTTP server, over TLS when a certificate is configured
 	httpServer := &http.Server{
 		Addr:    ":" + config.Port,
 		Handler: mux,
 	}
+	// HTTP/2 is negotiated over TLS; without TLS, h2c is only accepted when
+	// enabled, for clients that need full-duplex /exec/interactive
+	httpServer.Protocols = new(http.Protocols)
+	httpServer.Protocols.SetHTTP1(true)
+	httpServer.Protocols.SetHTTP2(true)
+	httpServer.Protocols.SetUnencryptedHTTP2(config.H2C)
+	if config.TLS.Enabled() {
+		tlsConfig, err := server.NewTLSConfig(config.TLS, config.Auth.ClientAuth)
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		httpServer.TLSConfig = tlsConfig
+	}
 
-	slog.Info("Starting sandbox-executor", "version", Version, "port", config.Port, "auth_mode", config.Auth.Mode)
+	slog.Info("Starting sandbox-executor", "version", Version, "port", config.Port, "tls", config.TLS.Enabled(), "client_auth", config.Auth.ClientAuth, "auth_mode", config.Auth.Mode, "root", config.Root, "shell", config.Shell, "temp_dir", config.TempDir, "h2c", config.H2C, "idle_timeout", config.IdleTimeout, "process_ttl", config.ProcessTTL, "process_log_dir", config.ProcessLogDir, "max_processes", config.MaxProcesses, "max_processes_mode", config.MaxProcessesMode, "proxy_allowed_hosts", config.ProxyAllowedHosts, "proxy_dial_retry", config.ProxyDialRetry, "proxy_idle_timeout", config.ProxyIdleTimeout, "proxy_max_connections", config.ProxyMaxConnections, "proxy_conn_log", config.ProxyConnLog, "proxy_no_target", config.ProxyNoTarget, "proxy_rate_limit", config.ProxyRateLimit, "udp_max_sessions", config.UDPMaxSessions, "max_output_bytes", config.MaxOutputBytes, "max_watchers", config.MaxWatchers, "max_untar_bytes", config.MaxUntarBytes, "max_request_bytes", config.MaxRequestBytes, "max_upload_bytes", config.MaxUploadBytes, "rate_limit", config.RateLimit, "route_rate_limits", config.RouteRateLimits, "audit_log", config.AuditLog, "audit_redact_commands", config.Audit.RedactCommands, "audit_redact_content", config.Audit.RedactContent)
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if httpServer.TLSConfig != nil {
+			// The certificate is already in TLSConfig
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("HTTP server failed", "error", err)
 			os.Exit(1)
 		}
@@ -98,6 +159,13 @@ func main() {
 		}
 	}()
 
+	// Start the UDP proxy server
+	slog.Info("Starting UDP proxy", "port", config.UDPProxyPort)
+	if err := srv.StartUDPProxy(config.UDPProxyPort); err != nil {
+		slog.Error("UDP proxy failed to start", "error", err)
+		os.Exit(1)
+	}
+
 	// If a customer command is provided after --, run it as a subprocess.
 	var customerCmd *exec.Cmd
 	if cmdArgs := extractCustomerCommand(os.Args); len(cmdArgs) > 0 {
@@ -112,7 +180,8 @@ func main() {
 		}
 	}
 
-	// Wait for interrupt signal or customer command exit for graceful shutdown.
+	// Wait for interrupt signal, customer command exit or idle timeout for
+	// graceful shutdown.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -140,13 +209,25 @@ func main() {
 			case <-time.After(5 * time.Second):
 				customerCmd.Process.Kill()
 			}
+		case <-srv.Idle():
+			slog.Info("Idle timeout reached, stopping customer command", "idle_timeout", config.IdleTimeout)
+			customerCmd.Process.Signal(syscall.SIGTERM)
+			select {
+			case <-customerDone:
+			case <-time.After(5 * time.Second):
+				customerCmd.Process.Kill()
+			}
 		case exitCode := <-customerDone:
 			slog.Info("Customer command exited", "exit_code", exitCode)
 			shutdownServers(httpServer, srv)
 			os.Exit(exitCode)
 		}
 	} else {
-		<-quit
+		select {
+		case <-quit:
+		case <-srv.Idle():
+			slog.Info("Idle timeout reached", "idle_timeout", config.IdleTimeout)
+		}
 	}
 
 	slog.Info("Shutting down servers...")
@@ -155,13 +236,234 @@ func main() {
 
 func loadConfigFromEnv() (runtimeConfig, error) {
 	config := runtimeConfig{
-		Port:      getenvDefault("PORT", "3030"),
-		ProxyPort: getenvDefault("PROXY_PORT", "3031"),
+		Port:          getenvDefault("PORT", "3030"),
+		ProxyPort:     getenvDefault("PROXY_PORT", "3031"),
+		UDPProxyPort:  getenvDefault("UDP_PROXY_PORT", "3032"),
+		Root:          getenvDefault("SANDBOX_ROOT", server.DefaultRoot),
+		ProcessLogDir: os.Getenv("SANDBOX_PROCESS_LOG_DIR"),
+		TempDir:       os.Getenv("SANDBOX_TEMP_DIR"),
 		Auth: server.AuthConfig{
 			Mode:       server.AuthMode(strings.ToLower(os.Getenv("SANDBOX_AUTH_MODE"))),
 			Secret:     os.Getenv("SANDBOX_SECRET"),
 			SecretPath: os.Getenv("SANDBOX_SECRET_PATH"),
+			ClientAuth: server.ClientAuthMode(strings.ToLower(os.Getenv("SANDBOX_CLIENT_AUTH"))),
 		},
+		TLS: server.TLSConfig{
+			Cert:     os.Getenv("SANDBOX_TLS_CERT"),
+			Key:      os.Getenv("SANDBOX_TLS_KEY"),
+			ClientCA: os.Getenv("SANDBOX_TLS_CLIENT_CA"),
+		},
+	}
+
+	if config.TLS.Enabled() && (config.TLS.Cert == "" || config.TLS.Key == "") {
+		return runtimeConfig{}, fmt.Errorf("SANDBOX_TLS_CERT and SANDBOX_TLS_KEY must be set together")
+	}
+	if config.TLS.ClientCA != "" && !config.TLS.Enabled() {
+		return runtimeConfig{}, fmt.Errorf("SANDBOX_TLS_CLIENT_CA requires SANDBOX_TLS_CERT and SANDBOX_TLS_KEY")
+	}
+
+	if config.Auth.ClientAuth == "" {
+		config.Auth.ClientAuth = server.ClientAuthToken
+	}
+	switch config.Auth.ClientAuth {
+	case server.ClientAuthToken:
+	case server.ClientAuthMTLS, server.ClientAuthEither:
+		if config.TLS.ClientCA == "" {
+			return runtimeConfig{}, fmt.Errorf("SANDBOX_CLIENT_AUTH=%s requires SANDBOX_TLS_CLIENT_CA", config.Auth.ClientAuth)
+		}
+	default:
+		return runtimeConfig{}, fmt.Errorf("unsupported SANDBOX_CLIENT_AUTH %q", config.Auth.ClientAuth)
+	}
+
+	if value := os.Getenv("SANDBOX_PROCESS_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROCESS_TTL %q: expected a duration such as 30m", value)
+		}
+		config.ProcessTTL = ttl
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_DIAL_RETRY"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_DIAL_RETRY %q: expected a positive duration such as 2s", value)
+		}
+		config.ProxyDialRetry = window
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_IDLE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_IDLE_TIMEOUT %q: expected a duration such as 5m", value)
+		}
+		config.ProxyIdleTimeout = timeout
+	}
+
+	if value := os.Getenv("SANDBOX_IDLE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_IDLE_TIMEOUT %q: expected a duration such as 5m", value)
+		}
+		config.IdleTimeout = timeout
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_MAX_CONNECTIONS"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_MAX_CONNECTIONS %q: expected a positive integer", value)
+		}
+		config.ProxyMaxConnections = max
+	}
+
+	if value := os.Getenv("SANDBOX_UDP_MAX_SESSIONS"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_UDP_MAX_SESSIONS %q: expected a positive integer", value)
+		}
+		config.UDPMaxSessions = max
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_CONN_LOG"); value != "" {
+		mode, err := server.ParseProxyConnLog(value)
+		if err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_CONN_LOG %q: expected all, errors or off", value)
+		}
+		config.ProxyConnLog = mode
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_NO_TARGET"); value != "" {
+		policy, err := server.ParseProxyNoTarget(value)
+		if err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_NO_TARGET %q: expected reject, hold or refuse", value)
+		}
+		config.ProxyNoTarget = policy
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_RATE_LIMIT"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_PROXY_RATE_LIMIT %q: expected a positive integer", value)
+		}
+		config.ProxyRateLimit = limit
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_OUTPUT_BYTES"); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_OUTPUT_BYTES %q: expected a positive integer", value)
+		}
+		config.MaxOutputBytes = max
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_PROCESSES"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_PROCESSES %q: expected a positive integer", value)
+		}
+		config.MaxProcesses = max
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_PROCESSES_MODE"); value != "" {
+		mode, err := server.ParseProcessLimitMode(value)
+		if err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_PROCESSES_MODE %q: expected all or running", value)
+		}
+		config.MaxProcessesMode = mode
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_WATCHERS"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_WATCHERS %q: expected a positive integer", value)
+		}
+		config.MaxWatchers = max
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_UNTAR_BYTES"); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_UNTAR_BYTES %q: expected a positive integer", value)
+		}
+		config.MaxUntarBytes = max
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_REQUEST_BYTES"); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_REQUEST_BYTES %q: expected a positive integer", value)
+		}
+		config.MaxRequestBytes = max
+	}
+
+	if value := os.Getenv("SANDBOX_MAX_UPLOAD_BYTES"); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max <= 0 {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_MAX_UPLOAD_BYTES %q: expected a positive integer", value)
+		}
+		config.MaxUploadBytes = max
+	}
+
+	if value := os.Getenv("SANDBOX_RATE_LIMIT"); value != "" {
+		limit, err := server.ParseRateLimit(value)
+		if err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_RATE_LIMIT: %w", err)
+		}
+		config.RateLimit = limit
+	}
+
+	if value := os.Getenv("SANDBOX_ROUTE_RATE_LIMITS"); value != "" {
+		config.RouteRateLimits = make(map[string]server.RateLimit)
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			path, spec, ok := strings.Cut(entry, "=")
+			if !ok || !strings.HasPrefix(path, "/") {
+				return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_ROUTE_RATE_LIMITS entry %q: expected /path=RPS[:BURST]", entry)
+			}
+			limit, err := server.ParseRateLimit(spec)
+			if err != nil {
+				return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_ROUTE_RATE_LIMITS entry %q: %w", entry, err)
+			}
+			config.RouteRateLimits[path] = limit
+		}
+	}
+
+	config.Shell = os.Getenv("SANDBOX_SHELL")
+	if config.Shell == "" {
+		config.Shell = server.DefaultShell
+	}
+
+	config.AuditLog = os.Getenv("SANDBOX_AUDIT_LOG")
+	if value := os.Getenv("SANDBOX_H2C"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_H2C %q: expected true or false", value)
+		}
+		config.H2C = enabled
+	}
+
+	if value := os.Getenv("SANDBOX_AUDIT_REDACT"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(field)) {
+			case "":
+			case "commands":
+				config.Audit.RedactCommands = true
+			case "content":
+				config.Audit.RedactContent = true
+			default:
+				return runtimeConfig{}, fmt.Errorf("invalid SANDBOX_AUDIT_REDACT entry %q: expected commands or content", field)
+			}
+		}
+	}
+
+	if value := os.Getenv("SANDBOX_PROXY_ALLOWED_HOSTS"); value != "" {
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				config.ProxyAllowedHosts = append(config.ProxyAllowedHosts, host)
+			}
+		}
 	}
 
 	if config.Auth.Mode == "" {
@@ -170,10 +472,14 @@ func loadConfigFromEnv() (runtimeConfig, error) {
 
 	switch config.Auth.Mode {
 	case server.AuthModeStatic:
-		if config.Auth.Secret == "" {
+		// Client certificates alone authenticate requests in mtls mode
+		if config.Auth.Secret == "" && config.Auth.ClientAuth != server.ClientAuthMTLS {
 			return runtimeConfig{}, fmt.Errorf("SANDBOX_SECRET environment variable not set")
 		}
 	case server.AuthModePool:
+		if config.Auth.ClientAuth == server.ClientAuthMTLS {
+			return runtimeConfig{}, fmt.Errorf("SANDBOX_AUTH_MODE=pool cannot be used with SANDBOX_CLIENT_AUTH=mtls")
+		}
 		if config.Auth.Secret != "" {
 			return runtimeConfig{}, fmt.Errorf("SANDBOX_SECRET cannot be set when SANDBOX_AUTH_MODE=pool")
 		}
@@ -213,6 +519,12 @@ func shutdownServers(httpServer *http.Server, srv *server.Server) {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		slog.Error("HTTP server shutdown error", "error", err)
 	}
-	srv.StopTCPProxy()
+
+	// Give background processes their own grace period to exit on SIGTERM
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), server.DefaultTerminateGracePeriod)
+	defer drainCancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Error("Background processes killed after shutdown grace period", "error", err)
+	}
 	slog.Info("Servers stopped")
 }
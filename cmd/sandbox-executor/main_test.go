@@ -1,7 +1,9 @@
 package main
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/koyeb/sandbox-container/pkg/server"
 )
@@ -12,6 +14,8 @@ func TestLoadConfigFromEnvStaticMode(t *testing.T) {
 	t.Setenv("SANDBOX_SECRET_PATH", "")
 	t.Setenv("PORT", "")
 	t.Setenv("PROXY_PORT", "")
+	t.Setenv("UDP_PROXY_PORT", "")
+	t.Setenv("SANDBOX_ROOT", "")
 
 	config, err := loadConfigFromEnv()
 	if err != nil {
@@ -24,8 +28,11 @@ func TestLoadConfigFromEnvStaticMode(t *testing.T) {
 	if config.Auth.Secret != "static-secret" {
 		t.Fatalf("expected static secret to be preserved, got %q", config.Auth.Secret)
 	}
-	if config.Port != "3030" || config.ProxyPort != "3031" {
-		t.Fatalf("expected default ports, got port=%q proxy_port=%q", config.Port, config.ProxyPort)
+	if config.Port != "3030" || config.ProxyPort != "3031" || config.UDPProxyPort != "3032" {
+		t.Fatalf("expected default ports, got port=%q proxy_port=%q udp_proxy_port=%q", config.Port, config.ProxyPort, config.UDPProxyPort)
+	}
+	if config.Root != server.DefaultRoot {
+		t.Fatalf("expected default sandbox root %q, got %q", server.DefaultRoot, config.Root)
 	}
 }
 
@@ -67,6 +74,530 @@ func TestLoadConfigFromEnvRejectsInvalidMode(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFromEnvProcessTTL(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROCESS_TTL", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProcessTTL != 0 {
+		t.Fatalf("expected process reaping to be disabled by default, got %v", config.ProcessTTL)
+	}
+
+	t.Setenv("SANDBOX_PROCESS_TTL", "15m")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProcessTTL != 15*time.Minute {
+		t.Fatalf("expected 15m process TTL, got %v", config.ProcessTTL)
+	}
+
+	t.Setenv("SANDBOX_PROCESS_TTL", "soon")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected invalid SANDBOX_PROCESS_TTL to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyAllowedHosts(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+	t.Setenv("SANDBOX_PROXY_ALLOWED_HOSTS", " db.internal, ,10.0.0.5 ")
+
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if len(config.ProxyAllowedHosts) != 2 || config.ProxyAllowedHosts[0] != "db.internal" || config.ProxyAllowedHosts[1] != "10.0.0.5" {
+		t.Fatalf("expected two allowed hosts, got %q", config.ProxyAllowedHosts)
+	}
+}
+
+func TestLoadConfigFromEnvProxyDialRetry(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_DIAL_RETRY", "5s")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyDialRetry != 5*time.Second {
+		t.Fatalf("expected 5s dial retry, got %v", config.ProxyDialRetry)
+	}
+
+	t.Setenv("SANDBOX_PROXY_DIAL_RETRY", "0s")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a non-positive SANDBOX_PROXY_DIAL_RETRY to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyIdleTimeout(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_IDLE_TIMEOUT", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyIdleTimeout != 0 {
+		t.Fatalf("expected no idle timeout by default, got %v", config.ProxyIdleTimeout)
+	}
+
+	t.Setenv("SANDBOX_PROXY_IDLE_TIMEOUT", "10m")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyIdleTimeout != 10*time.Minute {
+		t.Fatalf("expected 10m idle timeout, got %v", config.ProxyIdleTimeout)
+	}
+
+	t.Setenv("SANDBOX_PROXY_IDLE_TIMEOUT", "-1s")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a negative SANDBOX_PROXY_IDLE_TIMEOUT to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyMaxConnections(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_MAX_CONNECTIONS", "100")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyMaxConnections != 100 {
+		t.Fatalf("expected 100 max connections, got %d", config.ProxyMaxConnections)
+	}
+
+	t.Setenv("SANDBOX_PROXY_MAX_CONNECTIONS", "lots")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_PROXY_MAX_CONNECTIONS to fail")
+	}
+}
+
+func TestLoadConfigFromEnvUDPMaxSessions(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_UDP_MAX_SESSIONS", "64")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.UDPMaxSessions != 64 {
+		t.Fatalf("expected 64 max sessions, got %d", config.UDPMaxSessions)
+	}
+
+	t.Setenv("SANDBOX_UDP_MAX_SESSIONS", "0")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_UDP_MAX_SESSIONS to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyConnLog(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_CONN_LOG", "OFF")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyConnLog != server.ProxyConnLogOff {
+		t.Fatalf("expected proxy connection logs off, got %q", config.ProxyConnLog)
+	}
+
+	t.Setenv("SANDBOX_PROXY_CONN_LOG", "verbose")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_PROXY_CONN_LOG to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyNoTarget(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_NO_TARGET", "refuse")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyNoTarget != server.ProxyNoTargetRefuse {
+		t.Fatalf("expected the refuse policy, got %q", config.ProxyNoTarget)
+	}
+
+	t.Setenv("SANDBOX_PROXY_NO_TARGET", "drop")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_PROXY_NO_TARGET to fail")
+	}
+}
+
+func TestLoadConfigFromEnvProxyRateLimit(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_PROXY_RATE_LIMIT", "131072")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.ProxyRateLimit != 131072 {
+		t.Fatalf("expected a 131072 bytes/s limit, got %d", config.ProxyRateLimit)
+	}
+
+	t.Setenv("SANDBOX_PROXY_RATE_LIMIT", "0")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a zero SANDBOX_PROXY_RATE_LIMIT to fail")
+	}
+}
+
+func TestLoadConfigFromEnvIdleTimeout(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_IDLE_TIMEOUT", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.IdleTimeout != 0 {
+		t.Fatalf("expected idle shutdown off by default, got %v", config.IdleTimeout)
+	}
+
+	t.Setenv("SANDBOX_IDLE_TIMEOUT", "30m")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.IdleTimeout != 30*time.Minute {
+		t.Fatalf("expected 30m idle timeout, got %v", config.IdleTimeout)
+	}
+
+	t.Setenv("SANDBOX_IDLE_TIMEOUT", "soon")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_IDLE_TIMEOUT to fail")
+	}
+}
+
+func TestLoadConfigFromEnvMaxProcesses(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_MAX_PROCESSES", "")
+	t.Setenv("SANDBOX_MAX_PROCESSES_MODE", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxProcesses != 0 {
+		t.Fatalf("expected no process limit by default, got %d", config.MaxProcesses)
+	}
+
+	t.Setenv("SANDBOX_MAX_PROCESSES", "50")
+	t.Setenv("SANDBOX_MAX_PROCESSES_MODE", "running")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxProcesses != 50 || config.MaxProcessesMode != server.ProcessLimitRunning {
+		t.Fatalf("expected 50 running processes, got %d %q", config.MaxProcesses, config.MaxProcessesMode)
+	}
+
+	t.Setenv("SANDBOX_MAX_PROCESSES", "0")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a zero SANDBOX_MAX_PROCESSES to fail")
+	}
+
+	t.Setenv("SANDBOX_MAX_PROCESSES", "50")
+	t.Setenv("SANDBOX_MAX_PROCESSES_MODE", "finished")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_MAX_PROCESSES_MODE to fail")
+	}
+}
+
+func TestLoadConfigFromEnvMaxOutputBytes(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_MAX_OUTPUT_BYTES", "1048576")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxOutputBytes != 1048576 {
+		t.Fatalf("expected 1048576 max output bytes, got %d", config.MaxOutputBytes)
+	}
+
+	t.Setenv("SANDBOX_MAX_OUTPUT_BYTES", "0")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a zero SANDBOX_MAX_OUTPUT_BYTES to fail")
+	}
+}
+
+func TestLoadConfigFromEnvMaxWatchers(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_MAX_WATCHERS", "4")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxWatchers != 4 {
+		t.Fatalf("expected 4 max watchers, got %d", config.MaxWatchers)
+	}
+
+	t.Setenv("SANDBOX_MAX_WATCHERS", "-1")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a negative SANDBOX_MAX_WATCHERS to fail")
+	}
+}
+
+func TestLoadConfigFromEnvMaxUntarBytes(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_MAX_UNTAR_BYTES", "1048576")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxUntarBytes != 1048576 {
+		t.Fatalf("expected 1048576 max untar bytes, got %d", config.MaxUntarBytes)
+	}
+
+	t.Setenv("SANDBOX_MAX_UNTAR_BYTES", "lots")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a non-numeric SANDBOX_MAX_UNTAR_BYTES to fail")
+	}
+}
+
+func TestLoadConfigFromEnvBodyLimits(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_MAX_REQUEST_BYTES", "1024")
+	t.Setenv("SANDBOX_MAX_UPLOAD_BYTES", "4096")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.MaxRequestBytes != 1024 || config.MaxUploadBytes != 4096 {
+		t.Fatalf("expected limits of 1024 and 4096 bytes, got %d and %d", config.MaxRequestBytes, config.MaxUploadBytes)
+	}
+
+	t.Setenv("SANDBOX_MAX_UPLOAD_BYTES", "0")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a zero SANDBOX_MAX_UPLOAD_BYTES to fail")
+	}
+}
+
+func TestLoadConfigFromEnvRateLimits(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_RATE_LIMIT", "20:40")
+	t.Setenv("SANDBOX_ROUTE_RATE_LIMITS", "/run=2, /start_process=0.5:3")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.RateLimit != (server.RateLimit{RequestsPerSecond: 20, Burst: 40}) {
+		t.Errorf("expected a global limit of 20/s with a burst of 40, got %+v", config.RateLimit)
+	}
+	want := map[string]server.RateLimit{
+		"/run":           {RequestsPerSecond: 2, Burst: 2},
+		"/start_process": {RequestsPerSecond: 0.5, Burst: 3},
+	}
+	if !reflect.DeepEqual(config.RouteRateLimits, want) {
+		t.Errorf("expected route limits %+v, got %+v", want, config.RouteRateLimits)
+	}
+
+	for name, value := range map[string]string{
+		"SANDBOX_RATE_LIMIT":        "fast",
+		"SANDBOX_ROUTE_RATE_LIMITS": "run=2",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadConfigFromEnv(); err == nil {
+				t.Fatalf("expected %s=%q to fail", name, value)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnvTLS(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_TLS_CERT", "")
+	t.Setenv("SANDBOX_TLS_KEY", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.TLS.Enabled() {
+		t.Fatal("expected TLS to be disabled by default")
+	}
+
+	t.Setenv("SANDBOX_TLS_CERT", "/etc/sandbox/cert.pem")
+	t.Setenv("SANDBOX_TLS_KEY", "/etc/sandbox/key.pem")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if !config.TLS.Enabled() || config.TLS.Cert != "/etc/sandbox/cert.pem" || config.TLS.Key != "/etc/sandbox/key.pem" {
+		t.Fatalf("expected the certificate and key paths, got %+v", config.TLS)
+	}
+
+	t.Setenv("SANDBOX_TLS_KEY", "")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a certificate without a key to fail")
+	}
+}
+
+func TestLoadConfigFromEnvClientAuth(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "")
+	t.Setenv("SANDBOX_TLS_CERT", "/etc/sandbox/cert.pem")
+	t.Setenv("SANDBOX_TLS_KEY", "/etc/sandbox/key.pem")
+	t.Setenv("SANDBOX_TLS_CLIENT_CA", "/etc/sandbox/ca.pem")
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "mTLS")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected mtls to load without a secret: %v", err)
+	}
+	if config.Auth.ClientAuth != server.ClientAuthMTLS || config.TLS.ClientCA != "/etc/sandbox/ca.pem" {
+		t.Fatalf("expected mtls with the client CA, got %q %+v", config.Auth.ClientAuth, config.TLS)
+	}
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "either")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected either mode without a secret to fail")
+	}
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+	if _, err := loadConfigFromEnv(); err != nil {
+		t.Fatalf("expected either mode to load: %v", err)
+	}
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.Auth.ClientAuth != server.ClientAuthToken {
+		t.Fatalf("expected token mode by default, got %q", config.Auth.ClientAuth)
+	}
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "certificate")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an unknown client auth mode to fail")
+	}
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "mtls")
+	t.Setenv("SANDBOX_TLS_CLIENT_CA", "")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected mtls without a client CA to fail")
+	}
+
+	t.Setenv("SANDBOX_CLIENT_AUTH", "")
+	t.Setenv("SANDBOX_TLS_CLIENT_CA", "/etc/sandbox/ca.pem")
+	t.Setenv("SANDBOX_TLS_CERT", "")
+	t.Setenv("SANDBOX_TLS_KEY", "")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected a client CA without TLS to fail")
+	}
+}
+
+func TestLoadConfigFromEnvAudit(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_AUDIT_LOG", "")
+	t.Setenv("SANDBOX_AUDIT_REDACT", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.AuditLog != "" || config.Audit.RedactCommands || config.Audit.RedactContent {
+		t.Fatalf("expected auditing to be disabled by default, got %q %+v", config.AuditLog, config.Audit)
+	}
+
+	t.Setenv("SANDBOX_AUDIT_LOG", "/var/log/sandbox-audit.log")
+	t.Setenv("SANDBOX_AUDIT_REDACT", "commands, Content")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.AuditLog != "/var/log/sandbox-audit.log" || !config.Audit.RedactCommands || !config.Audit.RedactContent {
+		t.Fatalf("expected the audit sink and both redactions, got %q %+v", config.AuditLog, config.Audit)
+	}
+
+	t.Setenv("SANDBOX_AUDIT_REDACT", "env")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an unknown redaction to fail")
+	}
+}
+
+func TestLoadConfigFromEnvShell(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_SHELL", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.Shell != server.DefaultShell {
+		t.Fatalf("expected the default shell, got %q", config.Shell)
+	}
+
+	t.Setenv("SANDBOX_SHELL", "bash")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.Shell != "bash" {
+		t.Fatalf("expected bash, got %q", config.Shell)
+	}
+}
+
+func TestLoadConfigFromEnvH2C(t *testing.T) {
+	t.Setenv("SANDBOX_AUTH_MODE", "")
+	t.Setenv("SANDBOX_SECRET", "static-secret")
+
+	t.Setenv("SANDBOX_H2C", "")
+	config, err := loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if config.H2C {
+		t.Fatal("expected h2c to be disabled by default")
+	}
+
+	t.Setenv("SANDBOX_H2C", "true")
+	config, err = loadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected config to load: %v", err)
+	}
+	if !config.H2C {
+		t.Fatal("expected h2c to be enabled")
+	}
+
+	t.Setenv("SANDBOX_H2C", "sometimes")
+	if _, err := loadConfigFromEnv(); err == nil {
+		t.Fatal("expected an invalid SANDBOX_H2C to fail")
+	}
+}
+
 func TestExtractCustomerCommand(t *testing.T) {
 	tests := []struct {
 		name string
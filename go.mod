@@ -2,4 +2,13 @@ module github.com/koyeb/sandbox-container
 
 go 1.25
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/bmatcuk/doublestar/v4 v4.9.1
+	github.com/creack/pty v1.1.24
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/time v0.14.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -1,6 +1,13 @@
 package logger
 
-import "log/slog"
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+)
 
 // LevelTrace is a custom log level below DEBUG for very verbose logging
 const LevelTrace = slog.Level(-8)
@@ -9,3 +16,82 @@ const LevelTrace = slog.Level(-8)
 func Trace(msg string, args ...any) {
 	slog.Log(nil, LevelTrace, msg, args...)
 }
+
+// TraceContext logs a message at TRACE level with the attributes of ctx
+func TraceContext(ctx context.Context, msg string, args ...any) {
+	slog.Log(ctx, LevelTrace, msg, args...)
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose attributes are added to every record
+// logged with it, such as through slog.DebugContext
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]any)
+	return context.WithValue(ctx, attrsKey{}, append(slices.Clip(existing), args...))
+}
+
+// contextHandler adds the attributes stored by WithAttrs to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if args, ok := ctx.Value(attrsKey{}).([]any); ok {
+		r.Add(args...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel maps a LOG_LEVEL value to a level. Empty and unknown values
+// default to INFO.
+func ParseLevel(value string) slog.Level {
+	switch strings.ToUpper(value) {
+	case "TRACE":
+		return LevelTrace
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// NewHandler returns a handler writing records at level and above to w. The
+// format is "json" (the default when empty), one object per line, or "text"
+// for key=value lines. Records carry the attributes of their context, see
+// WithAttrs.
+func NewHandler(w io.Writer, level slog.Level, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		// Name the custom TRACE level instead of printing it as DEBUG-4
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey {
+				if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+					a.Value = slog.StringValue("TRACE")
+				}
+			}
+			return a
+		},
+	}
+
+	switch strings.ToLower(format) {
+	case "json", "":
+		return contextHandler{slog.NewJSONHandler(w, opts)}, nil
+	case "text":
+		return contextHandler{slog.NewTextHandler(w, opts)}, nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: expected json or text", format)
+	}
+}
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewHandlerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := NewHandler(&buf, LevelTrace, "")
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	log := slog.New(handler)
+
+	log.Info("Process started", "process_id", "abc", "target_port", 8080, "remote_addr", "10.0.0.1:1234")
+	log.Log(nil, LevelTrace, "Very verbose")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", lines[0], err)
+	}
+	for key, want := range map[string]any{
+		"level":       "INFO",
+		"msg":         "Process started",
+		"process_id":  "abc",
+		"target_port": float64(8080),
+		"remote_addr": "10.0.0.1:1234",
+	} {
+		if record[key] != want {
+			t.Errorf("expected %s=%v, got %v", key, want, record[key])
+		}
+	}
+	if _, ok := record["time"]; !ok {
+		t.Error("expected a time key")
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil || record["level"] != "TRACE" {
+		t.Errorf("expected the trace level to be named TRACE, got %q", lines[1])
+	}
+}
+
+func TestNewHandlerFormats(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := NewHandler(&buf, slog.LevelInfo, "text")
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	slog.New(handler).Debug("Hidden")
+	slog.New(handler).Info("Shown", "id", "abc")
+	if got := buf.String(); !strings.Contains(got, "msg=Shown id=abc") || strings.Contains(got, "Hidden") {
+		t.Errorf("expected a single text line at INFO, got %q", got)
+	}
+
+	if _, err := NewHandler(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"trace": LevelTrace,
+		"DEBUG": slog.LevelDebug,
+		"":      slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"ERROR": slog.LevelError,
+		"bogus": slog.LevelInfo,
+	}
+	for value, want := range tests {
+		if got := ParseLevel(value); got != want {
+			t.Errorf("ParseLevel(%q): expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := NewHandler(&buf, slog.LevelDebug, "json")
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	log := slog.New(handler).With("component", "test")
+
+	ctx := WithAttrs(context.Background(), "request_id", "req-1")
+	ctx = WithAttrs(ctx, "process_id", "abc")
+	log.DebugContext(ctx, "With context")
+	log.Debug("Without context")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["request_id"] != "req-1" || record["process_id"] != "abc" || record["component"] != "test" {
+		t.Errorf("expected the context attributes on the record, got %v", record)
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("expected no context attributes without a context, got %q", lines[1])
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRedacted replaces redacted commands and contents in audit records
+const auditRedacted = "[REDACTED]"
+
+// maxAuditContentBytes caps the content recorded for a write
+const maxAuditContentBytes = 4096
+
+// AuditConfig enables the audit log of privileged operations
+type AuditConfig struct {
+	// Writer receives one JSON record per line. Nil disables the audit log.
+	Writer io.Writer
+	// RedactCommands records commands as "[REDACTED]"
+	RedactCommands bool
+	// RedactContent records written content as "[REDACTED]"
+	RedactContent bool
+}
+
+// OpenAuditSink returns the writer for an audit log sink: "stdout",
+// "stderr", or the path of a file that is appended to
+func OpenAuditSink(sink string) (io.Writer, error) {
+	switch sink {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	file, err := os.OpenFile(sink, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return file, nil
+}
+
+// AuditRecord is written for every request to an audited route, including
+// rejected ones
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id"`
+	Operation  string    `json:"operation"`
+	// Target is the command, path or process id operated on, empty when the
+	// request was rejected before it was parsed
+	Target string `json:"target"`
+	// Content is what a write put in the file, cut at maxAuditContentBytes
+	Content          string `json:"content,omitempty"`
+	ContentTruncated bool   `json:"content_truncated,omitempty"`
+	// Outcome is "success", or "error" when the response status is 400 or
+	// above
+	Outcome string `json:"outcome"`
+	Status  int    `json:"status"`
+}
+
+// auditLogger writes audit records as JSON lines
+type auditLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	config AuditConfig
+}
+
+func newAuditLogger(config AuditConfig) *auditLogger {
+	if config.Writer == nil {
+		return nil
+	}
+	return &auditLogger{w: config.Writer, config: config}
+}
+
+func (a *auditLogger) write(record *AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode audit record", "error", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write audit record", "operation", record.Operation, "error", err)
+	}
+}
+
+// pendingAudit is the record of the request being handled, filled in by the
+// handler through auditTarget, auditCommand and auditContent
+type pendingAudit struct {
+	record AuditRecord
+	config AuditConfig
+}
+
+type auditKey struct{}
+
+func pendingAuditFromContext(ctx context.Context) *pendingAudit {
+	pending, _ := ctx.Value(auditKey{}).(*pendingAudit)
+	return pending
+}
+
+// auditTarget records the path or process id a request operates on
+func auditTarget(ctx context.Context, target string) {
+	if pending := pendingAuditFromContext(ctx); pending != nil {
+		pending.record.Target = target
+	}
+}
+
+// auditCommand records the command a request runs, given as a shell command
+// or an argument vector
+func auditCommand(ctx context.Context, cmd string, argv []string) {
+	pending := pendingAuditFromContext(ctx)
+	if pending == nil {
+		return
+	}
+	if pending.config.RedactCommands {
+		pending.record.Target = auditRedacted
+		return
+	}
+	if cmd == "" {
+		cmd = strings.Join(argv, " ")
+	}
+	pending.record.Target = cmd
+}
+
+// auditContent records the content a request writes
+func auditContent(ctx context.Context, content string) {
+	pending := pendingAuditFromContext(ctx)
+	if pending == nil {
+		return
+	}
+	if pending.config.RedactContent {
+		pending.record.Content = auditRedacted
+		return
+	}
+	if len(content) > maxAuditContentBytes {
+		content = content[:maxAuditContentBytes]
+		pending.record.ContentTruncated = true
+	}
+	pending.record.Content = content
+}
+
+// auditResponseWriter captures the status of a response. It passes flushes
+// and hijacks through so streaming and WebSocket routes can be audited.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// auditMiddleware writes an audit record for every request to next once it
+// was handled. It wraps authMiddleware so rejected requests are recorded too.
+func (s *Server) auditMiddleware(operation string, next http.Handler) http.Handler {
+	if s.audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pending := &pendingAudit{
+			record: AuditRecord{
+				Time:       time.Now().UTC(),
+				RemoteAddr: r.RemoteAddr,
+				RequestID:  RequestIDFromContext(r.Context()),
+				Operation:  operation,
+			},
+			config: s.audit.config,
+		}
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, pending)))
+
+		pending.record.Status = recorder.status
+		if pending.record.Status == 0 {
+			pending.record.Status = http.StatusOK
+		}
+		pending.record.Outcome = "success"
+		if pending.record.Status >= http.StatusBadRequest {
+			pending.record.Outcome = "error"
+		}
+		s.audit.write(&pending.record)
+	})
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// auditBuffer collects the audit records written by a server under test
+type auditBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *auditBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(data)
+}
+
+func (b *auditBuffer) records(t *testing.T) []AuditRecord {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid audit record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditRecordsRunAndDelete(t *testing.T) {
+	sink := &auditBuffer{}
+	_, mux := newTestServerWithConfig(t, Config{Audit: AuditConfig{Writer: sink}})
+
+	req := newAuthRequest(http.MethodPost, "/run", []byte(`{"cmd":"echo audited"}`))
+	req.Header.Set(RequestIDHeader, "run-request")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected /run to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "audited.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"path": path})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_file", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected /delete_file to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_file", body))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected deleting a missing file to fail, got %d", w.Code)
+	}
+
+	// Reads are not audited
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes", nil))
+
+	records := sink.records(t)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d: %+v", len(records), records)
+	}
+
+	run := records[0]
+	if run.Operation != "run" || run.Target != "echo audited" || run.Outcome != "success" || run.Status != http.StatusOK {
+		t.Errorf("unexpected /run record: %+v", run)
+	}
+	if run.RequestID != "run-request" || run.RemoteAddr == "" || run.Time.IsZero() {
+		t.Errorf("expected the request id, remote address and time to be recorded: %+v", run)
+	}
+
+	deleted := records[1]
+	if deleted.Operation != "delete_file" || deleted.Target != path || deleted.Outcome != "success" {
+		t.Errorf("unexpected /delete_file record: %+v", deleted)
+	}
+	if deleted.RequestID == "" {
+		t.Error("expected the generated request id to be recorded")
+	}
+
+	missing := records[2]
+	if missing.Operation != "delete_file" || missing.Outcome != "error" || missing.Status != http.StatusNotFound {
+		t.Errorf("unexpected record for the failed delete: %+v", missing)
+	}
+}
+
+func TestAuditRecordsUnauthorizedRequests(t *testing.T) {
+	sink := &auditBuffer{}
+	_, mux := newTestServerWithConfig(t, Config{Audit: AuditConfig{Writer: sink}})
+
+	req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"cmd":"id"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	records := sink.records(t)
+	if len(records) != 1 || records[0].Outcome != "error" || records[0].Status != http.StatusUnauthorized || records[0].Target != "" {
+		t.Fatalf("expected the rejected request to be recorded without a target, got %+v", records)
+	}
+}
+
+func TestAuditRedaction(t *testing.T) {
+	sink := &auditBuffer{}
+	_, mux := newTestServerWithConfig(t, Config{Audit: AuditConfig{Writer: sink, RedactCommands: true, RedactContent: true}})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", []byte(`{"cmd":"echo s3cr3t"}`)))
+
+	path := filepath.Join(t.TempDir(), "secret.txt")
+	body, _ := json.Marshal(map[string]string{"path": path, "content": "s3cr3t"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected /write_file to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	records := sink.records(t)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %+v", records)
+	}
+	if records[0].Target != auditRedacted {
+		t.Errorf("expected the command to be redacted, got %q", records[0].Target)
+	}
+	if records[1].Target != path || records[1].Content != auditRedacted {
+		t.Errorf("expected the path to be kept and the content redacted, got %+v", records[1])
+	}
+
+	sink = &auditBuffer{}
+	_, mux = newTestServerWithConfig(t, Config{Audit: AuditConfig{Writer: sink}})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", body))
+	if records := sink.records(t); len(records) != 1 || records[0].Content != "s3cr3t" {
+		t.Errorf("expected the content to be recorded without redaction, got %+v", records)
+	}
+}
+
+func TestAuditRecordsFileAndProcessChanges(t *testing.T) {
+	sink := &auditBuffer{}
+	_, mux := newTestServerWithConfig(t, Config{Audit: AuditConfig{Writer: sink}})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/init", []byte(`{"path":"`+filepath.Join(dir, "chunked.txt")+`"}`)))
+	var upload UploadStatusResponse
+	json.NewDecoder(w.Body).Decode(&upload)
+
+	tests := []struct {
+		path      string
+		body      string
+		operation string
+		target    string
+	}{
+		{"/make_dir", `{"path":"` + filepath.Join(dir, "sub") + `"}`, "make_dir", filepath.Join(dir, "sub")},
+		{"/touch", `{"path":"` + file + `"}`, "touch", file},
+		{"/truncate", `{"path":"` + file + `","size":1}`, "truncate", file},
+		{"/symlink", `{"target":"file.txt","link_path":"` + filepath.Join(dir, "link") + `"}`, "symlink", filepath.Join(dir, "link")},
+		{"/copy_stream", `{"source":"` + file + `","destination":"` + filepath.Join(dir, "copy.txt") + `"}`, "copy_stream", file + " -> " + filepath.Join(dir, "copy.txt")},
+		{"/move", `{"source":"` + file + `","destination":"` + filepath.Join(dir, "moved.txt") + `"}`, "move", file + " -> " + filepath.Join(dir, "moved.txt")},
+		{"/upload/chunk?offset=0&id=" + upload.UploadID, "chunk", "upload", filepath.Join(dir, "chunked.txt")},
+		{"/restart_process", `{"id":"missing"}`, "restart_process", "missing"},
+		{"/remove_process", `{"id":"missing"}`, "remove_process", "missing"},
+		{"/processes/prune", `{}`, "prune_processes", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.path, []byte(tt.body)))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/mktemp", []byte(`{}`)))
+	var temp MkTempResponse
+	json.NewDecoder(w.Body).Decode(&temp)
+	defer os.Remove(temp.Path)
+
+	records := sink.records(t)
+	if len(records) != len(tests)+2 {
+		t.Fatalf("expected %d audit records, got %d: %+v", len(tests)+2, len(records), records)
+	}
+	for i, tt := range tests {
+		record := records[i+1]
+		if record.Operation != tt.operation || record.Target != tt.target {
+			t.Errorf("%s: expected operation %q on %q, got %+v", tt.path, tt.operation, tt.target, record)
+		}
+	}
+	if mktemp := records[len(records)-1]; mktemp.Operation != "mktemp" || mktemp.Target == "" || mktemp.Target != temp.Path {
+		t.Errorf("expected /mktemp to record the created path %q, got %+v", temp.Path, mktemp)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -20,15 +21,31 @@ const (
 	DefaultPoolSecretPath = "/var/lib/sandbox-container/sandbox-secret"
 )
 
+// ClientAuthMode selects which credentials a request may authenticate with
+type ClientAuthMode string
+
+const (
+	// ClientAuthToken requires the bearer token
+	ClientAuthToken ClientAuthMode = "token"
+	// ClientAuthMTLS requires a client certificate signed by the client CA
+	ClientAuthMTLS ClientAuthMode = "mtls"
+	// ClientAuthEither accepts a verified client certificate or the token
+	ClientAuthEither ClientAuthMode = "either"
+)
+
 type AuthConfig struct {
 	Mode       AuthMode
 	Secret     string
 	SecretPath string
+	// ClientAuth defaults to ClientAuthToken. The other modes need the API
+	// to be served over TLS with a client CA
+	ClientAuth ClientAuthMode
 }
 
 type authState struct {
 	mu          sync.Mutex
 	mode        AuthMode
+	clientAuth  ClientAuthMode
 	secret      string
 	secretPath  string
 	initialized bool
@@ -40,11 +57,29 @@ func newAuthState(config AuthConfig) (*authState, error) {
 		mode = AuthModeStatic
 	}
 
+	clientAuth := config.ClientAuth
+	if clientAuth == "" {
+		clientAuth = ClientAuthToken
+	}
+
 	state := &authState{
 		mode:       mode,
+		clientAuth: clientAuth,
 		secretPath: config.SecretPath,
 	}
 
+	switch clientAuth {
+	case ClientAuthToken, ClientAuthEither:
+	case ClientAuthMTLS:
+		// Tokens are not used, so no secret is required
+		if mode == AuthModePool {
+			return nil, fmt.Errorf("SANDBOX_AUTH_MODE=pool cannot be used with SANDBOX_CLIENT_AUTH=mtls")
+		}
+		return state, nil
+	default:
+		return nil, fmt.Errorf("unsupported SANDBOX_CLIENT_AUTH %q", clientAuth)
+	}
+
 	switch mode {
 	case AuthModeStatic:
 		if config.Secret == "" {
@@ -80,7 +115,20 @@ func newAuthState(config AuthConfig) (*authState, error) {
 	return state, nil
 }
 
+// authorizeCertificate reports whether the client certificate verified during
+// the TLS handshake authenticates the request on its own
+func (a *authState) authorizeCertificate(state *tls.ConnectionState) bool {
+	if a.clientAuth == ClientAuthToken || state == nil {
+		return false
+	}
+	return len(state.VerifiedChains) > 0
+}
+
 func (a *authState) authorize(authHeader string) (authorized bool, bootstrapped bool, err error) {
+	if a.clientAuth == ClientAuthMTLS {
+		return false, false, nil
+	}
+
 	secret, ok := extractBearerSecret(authHeader)
 	if !ok {
 		return false, false, nil
@@ -108,6 +156,20 @@ func (a *authState) authorize(authHeader string) (authorized bool, bootstrapped
 	return true, true, nil
 }
 
+// currentSecret returns the bearer secret, or "" while a pool sandbox has not
+// received it yet or when only client certificates are accepted
+func (a *authState) currentSecret() string {
+	if a.clientAuth == ClientAuthMTLS {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.initialized {
+		return ""
+	}
+	return a.secret
+}
+
 func extractBearerSecret(header string) (string, bool) {
 	const prefix = "Bearer "
 
@@ -13,9 +13,11 @@ import (
 func newPoolTestServer(t *testing.T, secretPath string) (*Server, http.Handler) {
 	t.Helper()
 
-	srv, err := New(AuthConfig{
-		Mode:       AuthModePool,
-		SecretPath: secretPath,
+	srv, err := New(Config{
+		Auth: AuthConfig{
+			Mode:       AuthModePool,
+			SecretPath: secretPath,
+		},
 	})
 	if err != nil {
 		t.Fatalf("failed to create pool test server: %v", err)
@@ -43,6 +45,49 @@ func TestStaticAuthRejectsMissingBearerToken(t *testing.T) {
 	}
 }
 
+func TestStaticAuthAcceptsValidTokenAndRejectsWrongOne(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantStatus int
+	}{
+		{"valid token", "Bearer test-secret", http.StatusOK},
+		{"wrong token same length", "Bearer test-secreT", http.StatusUnauthorized},
+		{"wrong token shorter", "Bearer test", http.StatusUnauthorized},
+		{"wrong token longer", "Bearer test-secret-and-more", http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"missing scheme", "test-secret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthHeaderRequest(http.MethodGet, "/list_processes", tt.authHeader))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestSecretsEqual(t *testing.T) {
+	if !secretsEqual("test-secret", "test-secret") {
+		t.Error("expected identical secrets to match")
+	}
+	if secretsEqual("test-secret", "test-secreT") {
+		t.Error("expected secrets differing in the last byte to mismatch")
+	}
+	if secretsEqual("test", "test-secret") {
+		t.Error("expected a prefix of the secret to mismatch")
+	}
+	if secretsEqual("", "test-secret") {
+		t.Error("expected an empty secret to mismatch")
+	}
+}
+
 func TestPoolAuthBootstrapsOnFirstAuthenticatedRequest(t *testing.T) {
 	secretPath := filepath.Join(t.TempDir(), "sandbox-secret")
 	_, mux := newPoolTestServer(t, secretPath)
@@ -199,9 +244,11 @@ func TestPoolAuthFailsToStartWithInvalidSecretFile(t *testing.T) {
 			t.Fatalf("failed to create empty secret file: %v", err)
 		}
 
-		_, err := New(AuthConfig{
-			Mode:       AuthModePool,
-			SecretPath: secretPath,
+		_, err := New(Config{
+			Auth: AuthConfig{
+				Mode:       AuthModePool,
+				SecretPath: secretPath,
+			},
 		})
 		if err == nil {
 			t.Fatal("expected error when pool secret file is empty")
@@ -214,9 +261,11 @@ func TestPoolAuthFailsToStartWithInvalidSecretFile(t *testing.T) {
 			t.Fatalf("failed to create secret path directory: %v", err)
 		}
 
-		_, err := New(AuthConfig{
-			Mode:       AuthModePool,
-			SecretPath: secretPath,
+		_, err := New(Config{
+			Auth: AuthConfig{
+				Mode:       AuthModePool,
+				SecretPath: secretPath,
+			},
 		})
 		if err == nil {
 			t.Fatal("expected error when pool secret path is unreadable as a file")
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// maxBatchOperations caps the operations of a /batch request
+const maxBatchOperations = 1000
+
+// BatchOperation is one step of a /batch request
+type BatchOperation struct {
+	// Op names the operation, the route without its leading slash
+	Op string `json:"op"`
+	// Args is the request body of the standalone route
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+	// StopOnError skips the operations after the first one that fails
+	StopOnError bool `json:"stop_on_error,omitempty"`
+}
+
+// BatchResult is the outcome of one operation: the response the standalone
+// route would have given
+type BatchResult struct {
+	Op     string          `json:"op"`
+	Status int             `json:"status,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *ErrorDetail    `json:"error,omitempty"`
+	// Skipped is set for operations not run because of stop_on_error
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+type BatchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+}
+
+// batchOperation returns the handler of a file operation /batch can run, or
+// nil. Only routes with a JSON request and response qualify.
+func (s *Server) batchOperation(op string) http.HandlerFunc {
+	switch op {
+	case "write_file":
+		return s.writeFileHandler
+	case "read_file":
+		return s.readFileHandler
+	case "delete_file":
+		return s.deleteFileHandler
+	case "delete_dir":
+		return s.deleteDirHandler
+	case "make_dir":
+		return s.makeDirHandler
+	case "touch":
+		return s.touchHandler
+	case "truncate":
+		return s.truncateHandler
+	case "stat":
+		return s.statHandler
+	case "symlink":
+		return s.symlinkHandler
+	case "list_dir":
+		return s.listDirHandler
+	case "move":
+		return s.moveHandler
+	case "checksum":
+		return s.checksumHandler
+	}
+	return nil
+}
+
+// batchResponseWriter buffers the response of an operation run by /batch
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// runBatchOperation serves op through its standalone handler, audited like a
+// request to its route
+func (s *Server) runBatchOperation(r *http.Request, op BatchOperation) BatchResult {
+	args := op.Args
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "/"+op.Op, bytes.NewReader(args))
+	if err != nil {
+		return BatchResult{Op: op.Op, Status: http.StatusInternalServerError, Error: &ErrorDetail{Code: ErrorCodeInternal, Message: err.Error()}}
+	}
+	req.RemoteAddr = r.RemoteAddr
+	req.Header.Set("Content-Type", "application/json")
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	s.auditMiddleware(op.Op, s.batchOperation(op.Op)).ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	result := BatchResult{Op: op.Op, Status: w.status}
+	if w.status >= http.StatusBadRequest {
+		var errResp ErrorResponse
+		if err := json.Unmarshal(w.body.Bytes(), &errResp); err != nil {
+			errResp.Error = ErrorDetail{Code: ErrorCodeInternal, Message: w.body.String()}
+		}
+		result.Error = &errResp.Error
+		return result
+	}
+	result.Result = json.RawMessage(bytes.TrimSpace(w.body.Bytes()))
+	return result
+}
+
+// batchHandler runs file operations in order and reports the result of each,
+// saving a round-trip per operation
+func (s *Server) batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req BatchRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if len(req.Operations) == 0 {
+		writeMissingField(w, "operations")
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("A batch holds at most %d operations", maxBatchOperations))
+		return
+	}
+	// Reject unknown operations before running any
+	for i, op := range req.Operations {
+		if s.batchOperation(op.Op) == nil {
+			writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Unsupported operation: %q", op.Op), map[string]interface{}{"index": i})
+			return
+		}
+	}
+
+	slog.DebugContext(r.Context(), "Batch request", "operations", len(req.Operations), "stop_on_error", req.StopOnError)
+
+	resp := BatchResponse{Results: make([]BatchResult, 0, len(req.Operations))}
+	for _, op := range req.Operations {
+		if req.StopOnError && resp.Failed > 0 {
+			resp.Results = append(resp.Results, BatchResult{Op: op.Op, Skipped: true})
+			resp.Skipped++
+			continue
+		}
+		result := s.runBatchOperation(r, op)
+		if result.Error != nil {
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	slog.DebugContext(r.Context(), "Batch completed", "succeeded", resp.Succeeded, "failed", resp.Failed, "skipped", resp.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
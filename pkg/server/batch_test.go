@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func runBatch(t *testing.T, mux http.Handler, req BatchRequest) BatchResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/batch", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func batchOp(op string, args interface{}) BatchOperation {
+	data, _ := json.Marshal(args)
+	return BatchOperation{Op: op, Args: data}
+}
+
+func TestBatchRunsOperationsInOrder(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "project")
+
+	ops := []BatchOperation{batchOp("make_dir", MakeDirRequest{Path: filepath.Join(dir, "src")})}
+	for i := range 3 {
+		ops = append(ops, batchOp("write_file", WriteFileRequest{Path: filepath.Join(dir, "src", fmt.Sprintf("file%d.txt", i)), Content: fmt.Sprintf("content %d", i)}))
+	}
+	ops = append(ops,
+		batchOp("delete_file", DeleteFileRequest{Path: filepath.Join(dir, "src", "file1.txt")}),
+		batchOp("delete_file", DeleteFileRequest{Path: filepath.Join(dir, "missing.txt")}),
+		batchOp("read_file", ReadFileRequest{Path: filepath.Join(dir, "src", "file2.txt")}),
+	)
+
+	resp := runBatch(t, mux, BatchRequest{Operations: ops})
+	if len(resp.Results) != len(ops) || resp.Succeeded != 6 || resp.Failed != 1 || resp.Skipped != 0 {
+		t.Fatalf("expected 6 successes and 1 failure, got %+v", resp)
+	}
+	for i, result := range resp.Results {
+		if result.Op != ops[i].Op {
+			t.Errorf("result %d: expected op %s, got %s", i, ops[i].Op, result.Op)
+		}
+	}
+	if resp.Results[0].Status != http.StatusOK || resp.Results[0].Error != nil {
+		t.Errorf("expected make_dir to succeed, got %+v", resp.Results[0])
+	}
+
+	missing := resp.Results[5]
+	if missing.Status != http.StatusNotFound || missing.Error == nil || missing.Error.Code != ErrorCodeNotFound || missing.Result != nil {
+		t.Errorf("expected deleting a missing file to report the standalone 404, got %+v", missing)
+	}
+
+	var read ReadFileResponse
+	if err := json.Unmarshal(resp.Results[6].Result, &read); err != nil || read.Content != "content 2" {
+		t.Errorf("expected the read to return the written content, got %s (%v)", resp.Results[6].Result, err)
+	}
+
+	for i, want := range []bool{true, false, true} {
+		_, err := os.Stat(filepath.Join(dir, "src", fmt.Sprintf("file%d.txt", i)))
+		if exists := err == nil; exists != want {
+			t.Errorf("file%d.txt: expected exists=%v, got %v", i, want, exists)
+		}
+	}
+}
+
+func TestBatchStopOnError(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	resp := runBatch(t, mux, BatchRequest{
+		StopOnError: true,
+		Operations: []BatchOperation{
+			batchOp("write_file", WriteFileRequest{Path: filepath.Join(dir, "a.txt"), Content: "a"}),
+			batchOp("delete_file", DeleteFileRequest{Path: filepath.Join(dir, "missing")}),
+			batchOp("write_file", WriteFileRequest{Path: filepath.Join(dir, "b.txt"), Content: "b"}),
+		},
+	})
+	if resp.Succeeded != 1 || resp.Failed != 1 || resp.Skipped != 1 || !resp.Results[2].Skipped {
+		t.Fatalf("expected the operation after the failure to be skipped, got %+v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); !os.IsNotExist(err) {
+		t.Error("expected the skipped write not to run")
+	}
+}
+
+func TestBatchRejectsUnsupportedOperations(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "never.txt")
+
+	body, _ := json.Marshal(BatchRequest{Operations: []BatchOperation{
+		batchOp("write_file", WriteFileRequest{Path: path, Content: "x"}),
+		batchOp("run", RunRequest{Cmd: "true"}),
+	}})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/batch", body))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if detail := decodeError(t, w); detail.Details["index"] != float64(1) {
+		t.Errorf("expected the index of the unsupported operation, got %+v", detail)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected no operation to run")
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// DefaultMaxRequestBytes caps request bodies, such as the JSON of
+// /write_file
+const DefaultMaxRequestBytes = 32 << 20
+
+// DefaultMaxUploadBytes caps the bodies of the routes streaming files to disk
+const DefaultMaxUploadBytes = 5 << 30
+
+// uploadRoutes stream their body, to disk or to the stdin of a command, and
+// get the upload limit
+var uploadRoutes = map[string]bool{
+	"/upload":           true,
+	"/upload/chunk":     true,
+	"/untar":            true,
+	"/exec/interactive": true,
+}
+
+// bodyLimitMiddleware caps request bodies at the request limit, or the upload
+// limit for uploadRoutes. Bodies declared too large are rejected with 413
+// before being read; handlers report the error for bodies that turn out too
+// large while streaming.
+func (s *Server) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.maxRequestBytes
+		if uploadRoutes[r.URL.Path] {
+			limit = s.maxUploadBytes
+		}
+
+		if r.ContentLength > limit {
+			slog.DebugContext(r.Context(), "Request body too large", "path", r.URL.Path, "content_length", r.ContentLength, "limit", limit)
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether err comes from reading past the body limit
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// streamingRequest sends body without a Content-Length, so only reading it
+// reveals its size
+func streamingRequest(method, path string, body []byte) *http.Request {
+	req := newAuthRequest(method, path, nil)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = -1
+	return req
+}
+
+func TestBodyLimitRejectsOversizedJSON(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{MaxRequestBytes: 1024})
+	path := filepath.Join(t.TempDir(), "big.txt")
+	body, _ := json.Marshal(WriteFileRequest{Path: path, Content: strings.Repeat("x", 2048)})
+
+	for name, req := range map[string]*http.Request{
+		"content length": newAuthRequest(http.MethodPost, "/write_file", body),
+		"streamed":       streamingRequest(http.MethodPost, "/write_file", body),
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	small, _ := json.Marshal(WriteFileRequest{Path: path, Content: "small"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", small))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a body under the limit to be accepted, got %d", w.Code)
+	}
+}
+
+func TestBodyLimitUploadsUseUploadLimit(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{MaxRequestBytes: 1024, MaxUploadBytes: 4096})
+	path := url.QueryEscape(filepath.Join(t.TempDir(), "upload.bin"))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, streamingRequest(http.MethodPost, "/upload?path="+path, bytes.Repeat([]byte("x"), 2048)))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected an upload over the request limit but under the upload limit to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, streamingRequest(http.MethodPost, "/upload?path="+path, bytes.Repeat([]byte("x"), 8192)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an upload over the upload limit, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// ResourceLimits bounds the resources a command may use. Zero fields are not
+// limited.
+type ResourceLimits struct {
+	// MaxMemoryBytes caps the virtual address space of each process
+	MaxMemoryBytes uint64 `json:"max_memory_bytes,omitempty"`
+	// MaxCPUSeconds caps the CPU time of each process
+	MaxCPUSeconds uint64 `json:"max_cpu_seconds,omitempty"`
+	// MaxOpenFiles caps the number of open file descriptors of each process
+	MaxOpenFiles uint64 `json:"max_open_files,omitempty"`
+}
+
+// Reasons reported when a command was terminated for exceeding a limit
+const (
+	LimitReasonMemory = "memory"
+	LimitReasonCPU    = "cpu"
+)
+
+// CommandOptions describes a command to run, either a shell command line or
+// an argument vector executed directly
+type CommandOptions struct {
+	Command string
+	Cwd     string
+	Env     map[string]string
+	Limits  *ResourceLimits
+	// Argv runs Argv[0] with the remaining arguments, without a shell. It
+	// takes precedence over Command.
+	Argv []string
+	// TTY runs the command under a pseudo-terminal, which merges stdout and
+	// stderr
+	TTY bool
+	// User runs the command as this user, given as a name or a uid, instead
+	// of the executor's own user. See lookupCommandUser.
+	User string
+	// Shell runs Command with -c, DefaultShell when empty. It is not used
+	// for Argv.
+	Shell string
+	// CleanEnv starts the command with only Env and a PATH of cleanEnvPath,
+	// instead of inheriting the executor's environment
+	CleanEnv bool
+	// Nice runs the command at this niceness, see applyNice. Zero keeps the
+	// executor's.
+	Nice int
+}
+
+// cleanEnvPath is the PATH of commands run with CleanEnv, unless their Env
+// sets one
+const cleanEnvPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// DefaultShell runs shell commands unless a request or the server
+// configuration picks another
+const DefaultShell = "sh"
+
+// validateShell checks that shell names an executable, as a path or a
+// program in PATH
+func validateShell(shell string) error {
+	if _, err := exec.LookPath(shell); err != nil {
+		return fmt.Errorf("shell not found: %s", shell)
+	}
+	return nil
+}
+
+// invalidCwdError reports a working directory a command cannot run in
+type invalidCwdError struct {
+	message string
+}
+
+func (e invalidCwdError) Error() string {
+	return e.message
+}
+
+// validateCwd checks that a command's working directory exists and is a
+// directory, since exec would otherwise only fail with a bare chdir error
+func validateCwd(cwd string) error {
+	if cwd == "" {
+		return nil
+	}
+	info, err := os.Stat(cwd)
+	if os.IsNotExist(err) {
+		return invalidCwdError{fmt.Sprintf("cwd does not exist: %s", cwd)}
+	}
+	if err != nil {
+		return invalidCwdError{fmt.Sprintf("cwd is not accessible: %v", err)}
+	}
+	if !info.IsDir() {
+		return invalidCwdError{fmt.Sprintf("cwd is not a directory: %s", cwd)}
+	}
+	return nil
+}
+
+// errUserSwitchNotPermitted is returned for a user the executor cannot run
+// commands as, because it does not run as root
+var errUserSwitchNotPermitted = errors.New("the executor is not permitted to switch users")
+
+// commandUser is the account a command runs as
+type commandUser struct {
+	name string
+	home string
+	// credential is nil when the user is the executor's own
+	credential *syscall.Credential
+}
+
+// lookupCommandUser resolves a user name or uid with os/user. A numeric uid
+// without an entry in the user database is accepted too, with the group of
+// the same id and / as home, since containers often run arbitrary uids.
+// Only root may switch to another user.
+func lookupCommandUser(spec string) (*commandUser, error) {
+	account, err := user.Lookup(spec)
+	if err != nil {
+		account, err = user.LookupId(spec)
+	}
+	if err != nil {
+		if _, convErr := strconv.ParseUint(spec, 10, 32); convErr != nil {
+			return nil, fmt.Errorf("unknown user %q", spec)
+		}
+		account = &user.User{Uid: spec, Gid: spec, Username: spec, HomeDir: "/"}
+	}
+
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has a non-numeric uid %q", spec, account.Uid)
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has a non-numeric gid %q", spec, account.Gid)
+	}
+
+	resolved := &commandUser{name: account.Username, home: account.HomeDir}
+	if int(uid) == os.Geteuid() && int(gid) == os.Getegid() {
+		return resolved, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("cannot run as user %q: %w", spec, errUserSwitchNotPermitted)
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	// Keep the user's supplementary groups; without any, the executor's own
+	// are dropped
+	if groups, err := account.GroupIds(); err == nil {
+		for _, group := range groups {
+			if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+	resolved.credential = credential
+	return resolved, nil
+}
+
+// DefaultMaxOutputBytes is how much of each output stream /run returns
+// before the command is killed
+const DefaultMaxOutputBytes = 16 * 1024 * 1024
+
+// Size of a pseudo-terminal until the client resizes it
+const (
+	defaultTTYRows = 24
+	defaultTTYCols = 80
+)
+
+// String returns the command line shown in logs and process listings
+func (o CommandOptions) String() string {
+	if len(o.Argv) > 0 {
+		return strings.Join(o.Argv, " ")
+	}
+	return o.Command
+}
+
+// newCommand builds an exec.Cmd that runs opts.Argv, or opts.Command through
+// opts.Shell -c. If opts.User cannot be resolved, starting the command fails.
+func newCommand(ctx context.Context, opts CommandOptions) *exec.Cmd {
+	var cmd *exec.Cmd
+	if len(opts.Argv) > 0 {
+		name, args := argvArgs(opts.Argv, opts.Limits)
+		cmd = exec.CommandContext(ctx, name, args...)
+	} else {
+		shell := opts.Shell
+		if shell == "" {
+			shell = DefaultShell
+		}
+		name, args := shellArgs(shell, opts.Command, opts.Limits)
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+
+	// Run the command in its own process group so that cancelling ctx also
+	// kills the processes it started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+
+	// Set working directory if provided
+	if opts.Cwd != "" {
+		cmd.Dir = opts.Cwd
+	}
+
+	// A nil cmd.Env inherits the executor's environment
+	if opts.CleanEnv {
+		cmd.Env = []string{"PATH=" + cleanEnvPath}
+	}
+
+	if opts.User != "" {
+		account, err := lookupCommandUser(opts.User)
+		if err != nil {
+			cmd.Err = err
+			return cmd
+		}
+		cmd.SysProcAttr.Credential = account.credential
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		// The user's environment comes first so the request's can override it
+		cmd.Env = append(cmd.Env, "HOME="+account.home, "USER="+account.name, "LOGNAME="+account.name)
+	}
+
+	// Set environment variables if provided
+	if len(opts.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		for key, value := range opts.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+
+	return cmd
+}
+
+// killProcessGroup kills the process group led by cmd
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// startTTY starts cmd attached to a new pseudo-terminal and returns the
+// master side, which carries the command's output and accepts its input
+func startTTY(cmd *exec.Cmd) (*os.File, error) {
+	// The terminal's new session is also a new process group, and a
+	// session leader can't change its group
+	if cmd.SysProcAttr != nil {
+		cmd.SysProcAttr.Setpgid = false
+	}
+	return pty.StartWithSize(cmd, &pty.Winsize{Rows: defaultTTYRows, Cols: defaultTTYCols})
+}
+
+// ttyOutput reads a PTY master. Linux fails reads with EIO once every
+// process holding the terminal has exited, which is reported as io.EOF.
+type ttyOutput struct {
+	*os.File
+}
+
+func (t ttyOutput) Read(p []byte) (int, error) {
+	n, err := t.File.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readCapped reads r until EOF or max bytes. If r has more, it calls stop
+// and closes r, so a writer that outlives stop fails with SIGPIPE instead of
+// blocking, and reports the output as truncated.
+func readCapped(r io.ReadCloser, max int64, stop func()) ([]byte, bool) {
+	data, _ := io.ReadAll(io.LimitReader(r, max+1))
+	if int64(len(data)) <= max {
+		return data, false
+	}
+	stop()
+	r.Close()
+	return data[:max], true
+}
+
+// shellArgs returns the program and arguments that run command with shell.
+// Go has no pre-exec hook, so limits are applied with ulimit in a sh wrapper
+// that then execs the shell; the shell and command are passed as positional
+// arguments and never interpolated into the wrapper script.
+func shellArgs(shell, command string, limits *ResourceLimits) (string, []string) {
+	steps := limitSteps(limits)
+	if len(steps) == 0 {
+		return shell, []string{"-c", command}
+	}
+
+	script := strings.Join(append(steps, `exec "$1" -c "$2"`), " && ")
+	return "sh", []string{"-c", script, "sh", shell, command}
+}
+
+// argvArgs returns the program and arguments that run argv. With limits,
+// argv is exec'd by the same ulimit wrapper as shell commands, from its
+// positional arguments.
+func argvArgs(argv []string, limits *ResourceLimits) (string, []string) {
+	steps := limitSteps(limits)
+	if len(steps) == 0 {
+		return argv[0], argv[1:]
+	}
+
+	script := strings.Join(append(steps, `exec "$@"`), " && ")
+	return "sh", append([]string{"-c", script, "sh"}, argv...)
+}
+
+// limitSteps returns the ulimit commands that apply limits
+func limitSteps(limits *ResourceLimits) []string {
+	if limits == nil {
+		return nil
+	}
+
+	var steps []string
+	if limits.MaxMemoryBytes > 0 {
+		// ulimit -v takes kilobytes
+		steps = append(steps, fmt.Sprintf("ulimit -v %d", (limits.MaxMemoryBytes+1023)/1024))
+	}
+	if limits.MaxCPUSeconds > 0 {
+		// Keep the hard limit a second above the soft one so the kernel
+		// sends SIGXCPU, which tells a CPU limit apart from a plain SIGKILL
+		steps = append(steps,
+			fmt.Sprintf("ulimit -S -t %d", limits.MaxCPUSeconds),
+			fmt.Sprintf("ulimit -H -t %d", limits.MaxCPUSeconds+1))
+	}
+	if limits.MaxOpenFiles > 0 {
+		steps = append(steps, fmt.Sprintf("ulimit -n %d", limits.MaxOpenFiles))
+	}
+	return steps
+}
+
+// terminatingSignal returns the name of the signal that terminated a
+// command, or "" if it exited on its own. A shell reporting a child killed
+// by a signal, with an exit status above 128, exited on its own.
+func terminatingSignal(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	return signalName(status.Signal())
+}
+
+// limitReason reports which limit most likely terminated a command, or "" if
+// it did not die from one. Only a terminating signal counts: the ulimit
+// wrapper execs the command, so nothing sits in between to turn a signal
+// into an exit status, and a command exiting with 128+N did so on its own.
+func limitReason(state *os.ProcessState, limits *ResourceLimits) string {
+	if state == nil || limits == nil {
+		return ""
+	}
+
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		if limits.MaxCPUSeconds > 0 {
+			return LimitReasonCPU
+		}
+	case syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGABRT:
+		// Failed allocations under RLIMIT_AS commonly end in one of these
+		if limits.MaxMemoryBytes > 0 {
+			return LimitReasonMemory
+		}
+	}
+	return ""
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellArgs(t *testing.T) {
+	if name, got := shellArgs("sh", "echo hi", nil); name != "sh" || strings.Join(got, "|") != "-c|echo hi" {
+		t.Errorf("expected plain sh -c without limits, got %s %q", name, got)
+	}
+	if name, got := shellArgs("bash", "echo hi", &ResourceLimits{}); name != "bash" || strings.Join(got, "|") != "-c|echo hi" {
+		t.Errorf("expected plain bash -c with empty limits, got %s %q", name, got)
+	}
+
+	name, got := shellArgs("bash", "echo $HOME; rm -rf x", &ResourceLimits{MaxMemoryBytes: 1000, MaxCPUSeconds: 5, MaxOpenFiles: 32})
+	if name != "sh" || len(got) != 5 || got[0] != "-c" || got[2] != "sh" || got[3] != "bash" || got[4] != "echo $HOME; rm -rf x" {
+		t.Fatalf("expected the shell and command to be passed as positional arguments, got %s %q", name, got)
+	}
+	for _, step := range []string{"ulimit -v 1", "ulimit -S -t 5", "ulimit -H -t 6", "ulimit -n 32", `exec "$1" -c "$2"`} {
+		if !strings.Contains(got[1], step) {
+			t.Errorf("expected wrapper script to contain %q, got %q", step, got[1])
+		}
+	}
+}
+
+func TestArgvArgs(t *testing.T) {
+	argv := []string{"/bin/my prog", "$HOME; rm -rf x"}
+
+	name, args := argvArgs(argv, nil)
+	if name != argv[0] || strings.Join(args, "|") != argv[1] {
+		t.Errorf("expected argv to run directly without limits, got %q %q", name, args)
+	}
+
+	name, args = argvArgs(argv, &ResourceLimits{MaxOpenFiles: 32})
+	if name != "sh" || len(args) != 5 || args[3] != argv[0] || args[4] != argv[1] {
+		t.Fatalf("expected argv to be passed as positional arguments, got %q %q", name, args)
+	}
+	if !strings.HasSuffix(args[1], `ulimit -n 32 && exec "$@"`) {
+		t.Errorf("expected wrapper script to exec its arguments, got %q", args[1])
+	}
+}
+
+func waitForProcess(t *testing.T, process *Process) {
+	t.Helper()
+	select {
+	case <-process.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Process did not exit")
+	}
+}
+
+func TestProcessMemoryLimit(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{
+			Command: `python3 -c "b = bytearray(512 * 1024 * 1024)"`,
+			Limits:  &ResourceLimits{MaxMemoryBytes: 128 * 1024 * 1024},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	waitForProcess(t, process)
+
+	process.mu.RLock()
+	status := process.Status
+	process.mu.RUnlock()
+	if status == ProcessStatusCompleted {
+		t.Fatal("Expected allocation past the memory limit to fail")
+	}
+
+	found := false
+	for _, entry := range process.stderr.GetAll() {
+		if strings.Contains(entry.Data, "MemoryError") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a MemoryError on stderr, got %v", process.stderr.GetAll())
+	}
+}
+
+func TestProcessMemoryLimitCrashReason(t *testing.T) {
+	pm := NewProcessManager()
+
+	// Allocation failures in native programs typically end in SIGSEGV/SIGABRT
+	process, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{
+			Command: "kill -SEGV $$",
+			Limits:  &ResourceLimits{MaxMemoryBytes: 64 * 1024 * 1024},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	waitForProcess(t, process)
+
+	details := process.ToJSON()
+	if details["status"] != ProcessStatusKilled || details["reason"] != LimitReasonMemory {
+		t.Errorf("Expected killed with reason memory, got %v", details)
+	}
+}
+
+func TestLimitReasonIgnoresExitStatus(t *testing.T) {
+	pm := NewProcessManager()
+
+	// Exit statuses that look like 128+SIGSEGV and 128+SIGXCPU
+	for _, tt := range []struct {
+		command string
+		limits  *ResourceLimits
+	}{
+		{"exit 139", &ResourceLimits{MaxMemoryBytes: 64 * 1024 * 1024}},
+		{"exit 152", &ResourceLimits{MaxCPUSeconds: 10}},
+	} {
+		process, err := pm.StartProcessWithOptions(ProcessOptions{
+			CommandOptions: CommandOptions{Command: tt.command, Limits: tt.limits},
+		})
+		if err != nil {
+			t.Fatalf("Failed to start process: %v", err)
+		}
+		waitForProcess(t, process)
+
+		details := process.ToJSON()
+		if details["status"] != ProcessStatusFailed || details["reason"] != nil {
+			t.Errorf("%s: expected a plain failure without a reason, got %v", tt.command, details)
+		}
+	}
+}
+
+func TestProcessCPULimit(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{
+			Command: "while :; do :; done",
+			Limits:  &ResourceLimits{MaxCPUSeconds: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(process.ID)
+	waitForProcess(t, process)
+
+	details := process.ToJSON()
+	if details["status"] != ProcessStatusKilled || details["reason"] != LimitReasonCPU {
+		t.Errorf("Expected killed with reason cpu, got %v", details)
+	}
+}
+
+func TestRunAppliesLimits(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	reqBody, _ := json.Marshal(RunRequest{
+		Cmd:    "ulimit -n",
+		Limits: &ResourceLimits{MaxOpenFiles: 64},
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp RunResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if strings.TrimSpace(resp.Stdout) != "64" || resp.Reason != "" {
+		t.Errorf("expected open files limit of 64 and no reason, got %+v", resp)
+	}
+}
+
+func TestRunAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires running as root")
+	}
+	_, mux := newTestServer(t)
+
+	run := func(user string) (int, RunResponse) {
+		reqBody, _ := json.Marshal(RunRequest{Argv: []string{"sh", "-c", "id -u; echo $USER"}, User: user})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+		var resp RunResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	// A uid without an entry in the user database is accepted too
+	for _, tc := range []struct{ user, uid, name string }{
+		{"nobody", "65534", "nobody"},
+		{"65534", "65534", "nobody"},
+		{"4242", "4242", "4242"},
+	} {
+		if tc.user == "nobody" {
+			if _, err := user.Lookup("nobody"); err != nil {
+				continue
+			}
+		}
+		code, resp := run(tc.user)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200 running as %s, got %d", tc.user, code)
+		}
+		if got := strings.Fields(resp.Stdout); len(got) != 2 || got[0] != tc.uid || got[1] != tc.name {
+			t.Errorf("expected uid %s and USER %s running as %s, got %q (stderr %q)", tc.uid, tc.name, tc.user, resp.Stdout, resp.Stderr)
+		}
+	}
+
+	if code, _ := run("no-such-user"); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown user to be rejected with 400, got %d", code)
+	}
+}
+
+func TestRunWithShell(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	_, mux := newTestServer(t)
+
+	// Arrays and [[ ]] are bash features
+	const script = `arr=(a b c); [[ ${arr[1]} == b ]] && echo "${arr[2]}"`
+	run := func(req RunRequest) (int, RunResponse) {
+		reqBody, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+		var resp RunResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := run(RunRequest{Cmd: script, Shell: "bash"})
+	if code != http.StatusOK || resp.Code != 0 || strings.TrimSpace(resp.Stdout) != "c" {
+		t.Errorf("expected bash to run the script, got %d %+v", code, resp)
+	}
+
+	// Through the ulimit wrapper too
+	code, resp = run(RunRequest{Cmd: script, Shell: "bash", Limits: &ResourceLimits{MaxOpenFiles: 64}})
+	if code != http.StatusOK || resp.Code != 0 || strings.TrimSpace(resp.Stdout) != "c" {
+		t.Errorf("expected bash to run the script with limits, got %d %+v", code, resp)
+	}
+
+	if target, _ := filepath.EvalSymlinks("/bin/sh"); filepath.Base(target) != "bash" {
+		code, resp = run(RunRequest{Cmd: script})
+		if code != http.StatusOK || resp.Code == 0 {
+			t.Errorf("expected the default sh to fail on bash syntax, got %d %+v", code, resp)
+		}
+	}
+
+	if code, _ := run(RunRequest{Cmd: "true", Shell: "no-such-shell"}); code != http.StatusBadRequest {
+		t.Errorf("expected a missing shell to be rejected with 400, got %d", code)
+	}
+}
+
+func TestRunWithCleanEnv(t *testing.T) {
+	t.Setenv("SANDBOX_TEST_PARENT", "leaked")
+	_, mux := newTestServer(t)
+
+	const script = `echo "parent=$SANDBOX_TEST_PARENT own=$OWN path=$PATH"`
+	run := func(cleanEnv bool) string {
+		t.Helper()
+		reqBody, _ := json.Marshal(RunRequest{Cmd: script, Env: map[string]string{"OWN": "1"}, CleanEnv: cleanEnv})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp RunResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return strings.TrimSpace(resp.Stdout)
+	}
+
+	if out := run(false); !strings.HasPrefix(out, "parent=leaked own=1 ") {
+		t.Errorf("expected the executor's environment to be inherited, got %q", out)
+	}
+	if out, want := run(true), "parent= own=1 path="+cleanEnvPath; out != want {
+		t.Errorf("expected only the request's environment and the default PATH, got %q, want %q", out, want)
+	}
+
+	// The request can still set its own PATH
+	reqBody, _ := json.Marshal(StartProcessRequest{Cmd: `echo "$PATH $SANDBOX_TEST_PARENT"`, Env: map[string]string{"PATH": "/bin"}, CleanEnv: true})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var started StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&started)
+	wait, _ := json.Marshal(WaitProcessRequest{ID: started.ID, TimeoutMs: 5000})
+	mux.ServeHTTP(httptest.NewRecorder(), newAuthRequest(http.MethodPost, "/wait_process", wait))
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs?id="+started.ID, nil))
+	var logs ProcessLogsResponse
+	json.NewDecoder(w.Body).Decode(&logs)
+	if len(logs.Entries) != 1 || logs.Entries[0].Data != "/bin " {
+		t.Errorf("expected the process to see only its own PATH, got %+v", logs.Entries)
+	}
+}
+
+func TestNewRejectsMissingDefaultShell(t *testing.T) {
+	_, err := New(Config{Auth: AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"}, Shell: "no-such-shell"})
+	if err == nil {
+		t.Fatal("expected a missing default shell to be rejected")
+	}
+}
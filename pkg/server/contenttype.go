@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how many leading bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// detectContentType returns the MIME type of a file named name starting with
+// head. Sniffing the content is trusted first, as it recognizes images,
+// archives and other binary formats whatever the file is called. It cannot
+// tell JSON, CSV or source code from any other text, though, so when it only
+// finds generic text or binary data, a type known for the extension wins.
+func detectContentType(name string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+		return byExtension
+	}
+	return sniffed
+}
+
+// readerContentType detects the content type of a file from its first bytes,
+// read at offset zero so the position of a file being served is unchanged
+func readerContentType(name string, r io.ReaderAt) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return detectContentType(name, head[:n]), nil
+}
+
+// fileContentType detects the content type of the file at path
+func fileContentType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readerContentType(path, file)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentTypeDetection(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	// The PNG signature is recognized whatever the file is called
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 32)...)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"data.json", []byte(`{"name": "sandbox", "ports": [8080]}`), "application/json"},
+		{"image.png", png, "image/png"},
+		{"image.bin", png, "image/png"},
+		{"notes", []byte("just some plain text\n"), "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := json.Marshal(StatRequest{Path: path})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/stat", body))
+		var stat StatResponse
+		json.NewDecoder(w.Body).Decode(&stat)
+		if w.Code != http.StatusOK || stat.ContentType != tt.want {
+			t.Errorf("%s: expected /stat content_type %q, got %d %q", tt.name, tt.want, w.Code, stat.ContentType)
+		}
+
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/download?path="+url.QueryEscape(path), nil))
+		if got := w.Header().Get("Content-Type"); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%s: expected /download Content-Type %q, got %d %q", tt.name, tt.want, w.Code, got)
+		}
+
+		body, _ = json.Marshal(ReadFileRequest{Path: path, Encoding: "base64", DetectContentType: true})
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", body))
+		var read ReadFileResponse
+		json.NewDecoder(w.Body).Decode(&read)
+		if w.Code != http.StatusOK || read.ContentType != tt.want {
+			t.Errorf("%s: expected /read_file content_type %q, got %d %q", tt.name, tt.want, w.Code, read.ContentType)
+		}
+
+		// A window past the header is still typed from the start of the file
+		body, _ = json.Marshal(ReadFileRequest{Path: path, Offset: 4, Length: 4, Encoding: "base64", DetectContentType: true})
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", body))
+		read = ReadFileResponse{}
+		json.NewDecoder(w.Body).Decode(&read)
+		if read.ContentType != tt.want {
+			t.Errorf("%s: expected a window to keep content_type %q, got %q", tt.name, tt.want, read.ContentType)
+		}
+	}
+
+	// Without the flag, /read_file leaves the type out; directories have none
+	path := filepath.Join(dir, "data.json")
+	body, _ := json.Marshal(ReadFileRequest{Path: path})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", body))
+	var read map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&read)
+	if _, ok := read["content_type"]; ok {
+		t.Errorf("expected no content_type unless requested, got %v", read)
+	}
+
+	body, _ = json.Marshal(StatRequest{Path: dir})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/stat", body))
+	var stat map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&stat)
+	if _, ok := stat["content_type"]; ok {
+		t.Errorf("expected no content_type for a directory, got %v", stat)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+type CopyStreamRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Overwrite replaces an existing destination file instead of failing
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+type CopyStreamResponse struct {
+	Success     bool   `json:"success"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// copyStreamHandler copies a file to another path on the server, so large
+// files never travel through the client. The copy streams from one file to
+// the other: on Linux io.Copy between two *os.File hands it to the kernel
+// with copy_file_range where the filesystem supports it, so the bytes are not
+// even copied through userspace. The destination is written to a temp file
+// and renamed into place, with the permissions of the source.
+func (s *Server) copyStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req CopyStreamRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Source == "" {
+		writeMissingField(w, "source")
+		return
+	}
+	if req.Destination == "" {
+		writeMissingField(w, "destination")
+		return
+	}
+
+	source, ok := s.sandboxPath(w, req.Source)
+	if !ok {
+		return
+	}
+	destination, ok := s.sandboxPath(w, req.Destination)
+	if !ok {
+		return
+	}
+	if source == destination {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Source and destination are the same file")
+		return
+	}
+
+	auditTarget(r.Context(), req.Source+" -> "+req.Destination)
+
+	slog.DebugContext(r.Context(), "Copying file", "source", req.Source, "destination", req.Destination, "overwrite", req.Overwrite)
+
+	// Writes to the source wait for the copy, so it is never torn
+	defer s.pathLocks.lock(source, destination)()
+
+	file, err := os.Open(source)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Copy source unavailable", "source", req.Source, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Source is not a regular file: %s", req.Source))
+		return
+	}
+
+	if existing, err := os.Lstat(destination); err == nil {
+		if existing.IsDir() {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Destination is a directory: %s", req.Destination))
+			return
+		}
+		if !req.Overwrite {
+			writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("destination exists: %s", req.Destination), map[string]interface{}{"reason": "destination_exists"})
+			return
+		}
+	}
+
+	written, err := writeFileAtomic(destination, file, info.Mode().Perm())
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to copy file", "source", req.Source, "destination", req.Destination, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "File copied", "source", req.Source, "destination", req.Destination, "bytes", written)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CopyStreamResponse{Success: true, Source: req.Source, Destination: req.Destination, Bytes: written})
+}
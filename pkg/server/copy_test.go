@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRandomFile creates a file of size pseudo-random bytes and returns
+// their SHA-256
+func writeRandomFile(t testing.TB, path string, size int64, mode os.FileMode) [32]byte {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	random := rand.NewChaCha8([32]byte{1})
+	if _, err := io.CopyN(io.MultiWriter(file, h), random, size); err != nil {
+		t.Fatal(err)
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func copyStream(mux http.Handler, req CopyStreamRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/copy_stream", body))
+	return w
+}
+
+func TestCopyStreamLargeFile(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "large.bin")
+	destination := filepath.Join(dir, "copy.bin")
+
+	const size = 64 << 20
+	sum := writeRandomFile(t, source, size, 0o640)
+
+	body, _ := json.Marshal(CopyStreamRequest{Source: source, Destination: destination})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/copy_stream", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The content never travels through the client: both bodies are a few
+	// bytes of JSON
+	if len(body) > 1024 || w.Body.Len() > 1024 {
+		t.Errorf("expected small request and response bodies, got %d and %d bytes", len(body), w.Body.Len())
+	}
+
+	var resp CopyStreamResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Success || resp.Bytes != size {
+		t.Errorf("expected %d bytes copied, got %+v", size, resp)
+	}
+
+	copied, err := os.Open(destination)
+	if err != nil {
+		t.Fatalf("expected the destination to exist: %v", err)
+	}
+	defer copied.Close()
+	h := sha256.New()
+	if n, _ := io.Copy(h, copied); n != size || !bytes.Equal(h.Sum(nil), sum[:]) {
+		t.Errorf("expected an identical copy, got %d bytes", n)
+	}
+	if info, _ := copied.Stat(); info.Mode().Perm() != 0o640 {
+		t.Errorf("expected the source permissions, got %s", info.Mode().Perm())
+	}
+}
+
+func TestCopyStreamDestinationRules(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	destination := filepath.Join(dir, "destination.txt")
+	os.WriteFile(source, []byte("new"), 0o644)
+	os.WriteFile(destination, []byte("old"), 0o644)
+
+	w := copyStream(mux, CopyStreamRequest{Source: source, Destination: destination})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for an existing destination, got %d", w.Code)
+	}
+	if got, _ := os.ReadFile(destination); string(got) != "old" {
+		t.Errorf("expected the destination untouched, got %q", got)
+	}
+
+	if w := copyStream(mux, CopyStreamRequest{Source: source, Destination: destination, Overwrite: true}); w.Code != http.StatusOK {
+		t.Fatalf("expected overwrite to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if got, _ := os.ReadFile(destination); string(got) != "new" {
+		t.Errorf("expected the destination replaced, got %q", got)
+	}
+
+	tests := []struct {
+		name string
+		req  CopyStreamRequest
+		want int
+	}{
+		{"missing source", CopyStreamRequest{Source: filepath.Join(dir, "missing"), Destination: filepath.Join(dir, "x")}, http.StatusNotFound},
+		{"directory source", CopyStreamRequest{Source: dir, Destination: filepath.Join(dir, "x")}, http.StatusBadRequest},
+		{"directory destination", CopyStreamRequest{Source: source, Destination: dir, Overwrite: true}, http.StatusBadRequest},
+		{"same file", CopyStreamRequest{Source: source, Destination: source}, http.StatusBadRequest},
+		{"missing destination", CopyStreamRequest{Source: source}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := copyStream(mux, tt.req); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func BenchmarkCopyStream(b *testing.B) {
+	srv, err := New(Config{Auth: AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	mux := srv.RegisterRoutes()
+	dir := b.TempDir()
+	source := filepath.Join(dir, "large.bin")
+
+	const size = 64 << 20
+	writeRandomFile(b, source, size, 0o644)
+	b.SetBytes(size)
+
+	for b.Loop() {
+		w := copyStream(mux, CopyStreamRequest{Source: source, Destination: filepath.Join(dir, "copy.bin"), Overwrite: true})
+		if w.Code != http.StatusOK {
+			b.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
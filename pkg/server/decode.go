@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Reasons given in the details of 400 responses to request bodies that could
+// not be decoded, or lack a required field
+const (
+	decodeReasonEmptyBody     = "empty_body"
+	decodeReasonMalformedJSON = "malformed_json"
+	decodeReasonUnknownField  = "unknown_field"
+	decodeReasonTypeMismatch  = "type_mismatch"
+	decodeReasonInvalidValue  = "invalid_value"
+	decodeReasonMissingField  = "missing_field"
+)
+
+// decodeJSON decodes a JSON request body into v. Fields v does not have are
+// rejected, so a misspelled option fails instead of being silently ignored.
+func decodeJSON(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeDecodeError answers a request whose JSON body could not be decoded:
+// 413 when it exceeded the body limit, 400 otherwise. The 400 details give
+// the reason and, when the problem is a single field, the field's name.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request: the body is empty, expected a JSON object",
+			map[string]interface{}{"reason": decodeReasonEmptyBody})
+	case errors.As(err, &syntaxErr):
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid request: malformed JSON at byte %d: %s", syntaxErr.Offset, strings.TrimPrefix(syntaxErr.Error(), "json: ")),
+			map[string]interface{}{"reason": decodeReasonMalformedJSON, "offset": syntaxErr.Offset})
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request: malformed JSON: unexpected end of the body",
+			map[string]interface{}{"reason": decodeReasonMalformedJSON})
+	case errors.As(err, &typeErr):
+		expected := jsonTypeName(typeErr.Type)
+		if typeErr.Field == "" {
+			writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid request: the body must be %s, got %s", expected, typeErr.Value),
+				map[string]interface{}{"reason": decodeReasonTypeMismatch, "expected": expected, "got": typeErr.Value})
+			return
+		}
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid request: field %q must be %s, got %s", typeErr.Field, expected, typeErr.Value),
+			map[string]interface{}{"reason": decodeReasonTypeMismatch, "field": typeErr.Field, "expected": expected, "got": typeErr.Value})
+	default:
+		// The decoder has no error type for unknown fields
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field = strings.Trim(field, `"`)
+			writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid request: unknown field %q", field),
+				map[string]interface{}{"reason": decodeReasonUnknownField, "field": field})
+			return
+		}
+		// Values rejected by a field's own decoding, such as an invalid mode
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid request: %s", strings.TrimPrefix(err.Error(), "json: ")),
+			map[string]interface{}{"reason": decodeReasonInvalidValue})
+	}
+}
+
+// writeMissingField answers 400 for a request without the required field
+func writeMissingField(w http.ResponseWriter, field string) {
+	writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("%s is required", field),
+		map[string]interface{}{"reason": decodeReasonMissingField, "field": field})
+}
+
+// jsonTypeName names the JSON type that decodes into t
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	}
+	return t.String()
+}
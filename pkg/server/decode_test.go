@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeErrorsAreDistinct(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		reason  string
+		field   string
+		message string
+	}{
+		{"empty body", "/read_file", "", decodeReasonEmptyBody, "", "body is empty"},
+		{"malformed JSON", "/read_file", `{"path": "/tmp/x",}`, decodeReasonMalformedJSON, "", "malformed JSON at byte"},
+		{"truncated JSON", "/read_file", `{"path": "/tmp/x"`, decodeReasonMalformedJSON, "", "unexpected end"},
+		{"missing path", "/read_file", `{"encoding": "base64"}`, decodeReasonMissingField, "path", "path is required"},
+		{"missing path to write", "/write_file", `{"content": "hello"}`, decodeReasonMissingField, "path", "path is required"},
+		{"missing destination", "/move", `{"source": "/tmp/x"}`, decodeReasonMissingField, "destination", "destination is required"},
+		{"unknown field", "/read_file", `{"path": "/tmp/x", "encodng": "base64"}`, decodeReasonUnknownField, "encodng", `unknown field "encodng"`},
+		{"type mismatch", "/read_file", `{"path": 42}`, decodeReasonTypeMismatch, "path", `field "path" must be a string, got number`},
+		{"nested type mismatch", "/run", `{"cmd": "true", "limits": {"max_memory_bytes": "1G"}}`, decodeReasonTypeMismatch, "limits.max_memory_bytes", "must be an integer, got string"},
+		{"not an object", "/read_file", `["/tmp/x"]`, decodeReasonTypeMismatch, "", "body must be an object, got array"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.path, []byte(tt.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+
+		detail := decodeError(t, w)
+		if detail.Code != ErrorCodeInvalidRequest {
+			t.Errorf("%s: expected code %q, got %q", tt.name, ErrorCodeInvalidRequest, detail.Code)
+		}
+		if detail.Details["reason"] != tt.reason {
+			t.Errorf("%s: expected reason %q, got %v", tt.name, tt.reason, detail.Details)
+		}
+		if field, _ := detail.Details["field"].(string); field != tt.field {
+			t.Errorf("%s: expected field %q, got %q", tt.name, tt.field, field)
+		}
+		if !strings.Contains(detail.Message, tt.message) {
+			t.Errorf("%s: expected the message to mention %q, got %q", tt.name, tt.message, detail.Message)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+)
+
+// errDiskUsageUnsupported is returned on platforms without statfs
+var errDiskUsageUnsupported = errors.New("disk usage is only supported on Linux")
+
+// DiskUsage describes the filesystem containing a path
+type DiskUsage struct {
+	Path       string `json:"path"`
+	TotalBytes uint64 `json:"total_bytes"`
+	UsedBytes  uint64 `json:"used_bytes"`
+	// FreeBytes is the space available to unprivileged users, which can be
+	// less than TotalBytes-UsedBytes on filesystems that reserve blocks
+	FreeBytes uint64 `json:"free_bytes"`
+	// DirectoryBytes is the total size of the files under Path, when asked
+	DirectoryBytes *uint64 `json:"directory_bytes,omitempty"`
+}
+
+type DiskUsageRequest struct {
+	// Path selects the filesystem to report on (default the sandbox root)
+	Path string `json:"path,omitempty"`
+	// DirectorySize also sums the sizes of the files under Path
+	DirectorySize bool `json:"directory_size,omitempty"`
+}
+
+// directorySize sums the sizes of the regular files under root. Symlinks are
+// not followed and unreadable entries are skipped.
+func directorySize(ctx context.Context, root string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			slog.DebugContext(ctx, "Skipping unreadable path in directory size", "path", path, "error", err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	return size, err
+}
+
+func (s *Server) diskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req DiskUsageRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Path == "" {
+		req.Path = s.root
+	}
+
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Reading disk usage", "path", req.Path, "directory_size", req.DirectorySize)
+
+	usage, err := readDiskUsage(path)
+	if err == nil && req.DirectorySize {
+		var size uint64
+		size, err = directorySize(r.Context(), path)
+		usage.DirectoryBytes = &size
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to read disk usage", "path", req.Path, "error", err)
+		if errors.Is(err, errDiskUsageUnsupported) {
+			writeError(w, http.StatusNotImplemented, ErrorCodeNotImplemented, err.Error())
+		} else {
+			writeFileError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
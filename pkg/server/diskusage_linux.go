@@ -0,0 +1,21 @@
+//go:build linux
+
+package server
+
+import "syscall"
+
+// readDiskUsage reports the size and usage of the filesystem containing path
+func readDiskUsage(path string) (*DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return &DiskUsage{
+		Path:       path,
+		TotalBytes: stat.Blocks * blockSize,
+		UsedBytes:  (stat.Blocks - stat.Bfree) * blockSize,
+		FreeBytes:  stat.Bavail * blockSize,
+	}, nil
+}
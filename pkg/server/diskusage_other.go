@@ -0,0 +1,7 @@
+//go:build !linux
+
+package server
+
+func readDiskUsage(path string) (*DiskUsage, error) {
+	return nil, errDiskUsageUnsupported
+}
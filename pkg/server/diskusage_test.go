@@ -0,0 +1,71 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func diskUsage(t *testing.T, mux http.Handler, req DiskUsageRequest) (int, DiskUsage) {
+	t.Helper()
+
+	reqBody, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/disk_usage", reqBody))
+
+	var usage DiskUsage
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&usage); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return w.Code, usage
+}
+
+func TestDiskUsageHandler(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+
+	code, usage := diskUsage(t, mux, DiskUsageRequest{Path: dir})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if usage.TotalBytes == 0 || usage.FreeBytes >= usage.TotalBytes || usage.UsedBytes > usage.TotalBytes {
+		t.Errorf("expected 0 < free < total and used <= total, got %+v", usage)
+	}
+	if usage.DirectoryBytes != nil {
+		t.Errorf("expected no directory size unless asked, got %d", *usage.DirectoryBytes)
+	}
+
+	_, before := diskUsage(t, mux, DiskUsageRequest{Path: dir, DirectorySize: true})
+	if before.DirectoryBytes == nil || *before.DirectoryBytes != 5 {
+		t.Fatalf("expected a directory size of 5, got %+v", before.DirectoryBytes)
+	}
+
+	writeTree(t, dir, map[string]string{"sub/b.txt": strings.Repeat("x", 1000)})
+	_, after := diskUsage(t, mux, DiskUsageRequest{Path: dir, DirectorySize: true})
+	if after.DirectoryBytes == nil || *after.DirectoryBytes != 1005 {
+		t.Errorf("expected the directory size to grow to 1005, got %+v", after.DirectoryBytes)
+	}
+
+	if code, _ := diskUsage(t, mux, DiskUsageRequest{Path: filepath.Join(dir, "missing")}); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing path, got %d", code)
+	}
+}
+
+func TestDiskUsageConfinedToRoot(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+
+	if code, _ := diskUsage(t, mux, DiskUsageRequest{}); code != http.StatusOK {
+		t.Errorf("expected 200 for the default path, got %d", code)
+	}
+	if code, _ := diskUsage(t, mux, DiskUsageRequest{Path: t.TempDir()}); code != http.StatusForbidden {
+		t.Errorf("expected 403 outside the sandbox root, got %d", code)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// EnvResponse is the environment commands inherit from the executor
+type EnvResponse struct {
+	Env map[string]string `json:"env"`
+	// Redacted is set when the values of secret-looking variables were
+	// replaced with "[REDACTED]"
+	Redacted bool `json:"redacted"`
+}
+
+type SetEnvRequest struct {
+	// Env sets or overrides variables
+	Env map[string]string `json:"env,omitempty"`
+	// Unset removes variables, after Env is applied
+	Unset []string `json:"unset,omitempty"`
+}
+
+type SetEnvResponse struct {
+	Success bool     `json:"success"`
+	Set     []string `json:"set"`
+	Unset   []string `json:"unset"`
+}
+
+// executorEnv returns the executor's environment as a map
+func executorEnv() map[string]string {
+	return envMap(os.Environ())
+}
+
+// envMap turns "KEY=value" entries into a map. Later entries win, as they do
+// for a command started with them.
+func envMap(entries []string) map[string]string {
+	env := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// How ExpandEnv treats a variable the executor does not have
+const (
+	// UnknownEnvEmpty expands it to an empty string, as a shell does
+	UnknownEnvEmpty = "empty"
+	// UnknownEnvError rejects the request
+	UnknownEnvError = "error"
+)
+
+// expandEnv resolves the variable references in the values of req.Env and
+// in req.Cwd against the executor's environment, when req.ExpandEnv is set.
+// Values of req.Env cannot refer to each other.
+func (req *RunRequest) expandEnv() (err error) {
+	req.Env, req.Cwd, err = expandEnv(req.ExpandEnv, req.UnknownEnv, req.Env, req.Cwd)
+	return err
+}
+
+// expandEnv resolves the variable references in the values of req.Env and
+// in req.Cwd, like RunRequest.expandEnv
+func (req *StartProcessRequest) expandEnv() (err error) {
+	req.Env, req.Cwd, err = expandEnv(req.ExpandEnv, req.UnknownEnv, req.Env, req.Cwd)
+	return err
+}
+
+// expandEnv returns env and cwd with their variable references resolved when
+// expand is set, and unchanged otherwise. unknownEnv is validated either way.
+func expandEnv(expand bool, unknownEnv string, env map[string]string, cwd string) (map[string]string, string, error) {
+	switch unknownEnv {
+	case "", UnknownEnvEmpty, UnknownEnvError:
+	default:
+		return env, cwd, fmt.Errorf("unknown_env must be %q or %q", UnknownEnvEmpty, UnknownEnvError)
+	}
+	if !expand {
+		return env, cwd, nil
+	}
+
+	var unknown []string
+	lookup := func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(unknown, name) {
+			unknown = append(unknown, name)
+		}
+		return value
+	}
+
+	expanded := make(map[string]string, len(env))
+	for key, value := range env {
+		expanded[key] = os.Expand(value, lookup)
+	}
+	expandedCwd := os.Expand(cwd, lookup)
+
+	if len(unknown) > 0 && unknownEnv == UnknownEnvError {
+		slices.Sort(unknown)
+		return env, cwd, fmt.Errorf("unknown variables: %s", strings.Join(unknown, ", "))
+	}
+	return expanded, expandedCwd, nil
+}
+
+// validEnvName rejects names the environment cannot hold
+func validEnvName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "=\x00")
+}
+
+// envHandler returns the executor's environment, which every command
+// inherits. Secret-looking values are redacted unless ?redact=false.
+func (s *Server) envHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	redact := true
+	switch r.URL.Query().Get("redact") {
+	case "", "true":
+	case "false":
+		redact = false
+	default:
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "redact must be true or false")
+		return
+	}
+
+	env := executorEnv()
+	if redact {
+		env = redactEnv(env)
+	}
+
+	slog.DebugContext(r.Context(), "Env request", "variables", len(env), "redact", redact)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EnvResponse{Env: env, Redacted: redact})
+}
+
+// setEnvHandler changes the executor's own environment, so commands started
+// afterwards inherit the change
+func (s *Server) setEnvHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req SetEnvRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	set := make([]string, 0, len(req.Env))
+	for key := range req.Env {
+		set = append(set, key)
+	}
+	slices.Sort(set)
+	unset := slices.Clone(req.Unset)
+	if unset == nil {
+		unset = []string{}
+	}
+	// Only names are audited, values may be secrets
+	auditTarget(r.Context(), strings.Join(append(slices.Clone(set), unset...), ","))
+
+	if len(set) == 0 && len(unset) == 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "env or unset is required")
+		return
+	}
+	// Validate everything first so a bad name leaves the environment as is
+	for _, key := range append(slices.Clone(set), unset...) {
+		if !validEnvName(key) {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid variable name: %q", key))
+			return
+		}
+	}
+
+	slog.DebugContext(r.Context(), "Set env request", "set", set, "unset", unset)
+
+	for _, key := range set {
+		if err := os.Setenv(key, req.Env[key]); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to set %s: %v", key, err))
+			return
+		}
+	}
+	for _, key := range unset {
+		if err := os.Unsetenv(key); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to unset %s: %v", key, err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SetEnvResponse{Success: true, Set: set, Unset: unset})
+}
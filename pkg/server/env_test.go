@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func getEnv(t *testing.T, mux http.Handler, query string) EnvResponse {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/env"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp EnvResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestEnvListsAndRedacts(t *testing.T) {
+	_, mux := newTestServer(t)
+	t.Setenv("SANDBOX_TEST_PLAIN", "visible")
+	t.Setenv("SANDBOX_TEST_API_TOKEN", "hidden")
+
+	resp := getEnv(t, mux, "")
+	if !resp.Redacted || resp.Env["SANDBOX_TEST_PLAIN"] != "visible" || resp.Env["SANDBOX_TEST_API_TOKEN"] != redactedValue {
+		t.Errorf("expected secret values to be redacted by default, got redacted=%v plain=%q token=%q", resp.Redacted, resp.Env["SANDBOX_TEST_PLAIN"], resp.Env["SANDBOX_TEST_API_TOKEN"])
+	}
+	if resp.Env["PATH"] != os.Getenv("PATH") {
+		t.Errorf("expected the executor's PATH, got %q", resp.Env["PATH"])
+	}
+
+	resp = getEnv(t, mux, "?redact=false")
+	if resp.Redacted || resp.Env["SANDBOX_TEST_API_TOKEN"] != "hidden" {
+		t.Errorf("expected raw values with redact=false, got redacted=%v token=%q", resp.Redacted, resp.Env["SANDBOX_TEST_API_TOKEN"])
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/env?redact=maybe", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid redact value to be rejected, got %d", w.Code)
+	}
+}
+
+func TestSetEnvIsInheritedByRun(t *testing.T) {
+	_, mux := newTestServer(t)
+	// Restore the variables once the test is done
+	t.Setenv("SANDBOX_TEST_SETENV", "")
+	t.Setenv("SANDBOX_TEST_UNSET", "present")
+
+	body, _ := json.Marshal(SetEnvRequest{Env: map[string]string{"SANDBOX_TEST_SETENV": "from-setenv"}, Unset: []string{"SANDBOX_TEST_UNSET"}})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/setenv", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ = json.Marshal(RunRequest{Cmd: `echo "$SANDBOX_TEST_SETENV:${SANDBOX_TEST_UNSET-unset}"`})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", body))
+	var resp RunResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if strings.TrimSpace(resp.Stdout) != "from-setenv:unset" {
+		t.Errorf("expected the command to inherit the change, got %q", resp.Stdout)
+	}
+
+	if env := getEnv(t, mux, ""); env.Env["SANDBOX_TEST_SETENV"] != "from-setenv" {
+		t.Errorf("expected /env to report the new value, got %q", env.Env["SANDBOX_TEST_SETENV"])
+	}
+}
+
+func TestSetEnvRejectsInvalidNames(t *testing.T) {
+	_, mux := newTestServer(t)
+	t.Setenv("SANDBOX_TEST_SETENV", "before")
+
+	body, _ := json.Marshal(SetEnvRequest{Env: map[string]string{"SANDBOX_TEST_SETENV": "after", "BAD=NAME": "x"}})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/setenv", body))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if got := os.Getenv("SANDBOX_TEST_SETENV"); got != "before" {
+		t.Errorf("expected a rejected request to change nothing, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/setenv", []byte(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an empty request to be rejected, got %d", w.Code)
+	}
+}
+
+func TestRunExpandsEnvReferences(t *testing.T) {
+	_, mux := newTestServer(t)
+	t.Setenv("SANDBOX_TEST_TOKEN", "s3cret")
+	t.Setenv("SANDBOX_TEST_DIR", t.TempDir())
+
+	run := func(req RunRequest) (int, RunResponse) {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", body))
+		var resp RunResponse
+		if w.Code == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&resp)
+		}
+		return w.Code, resp
+	}
+
+	env := map[string]string{"AUTH": "Bearer ${SANDBOX_TEST_TOKEN}", "MISSING": "[${SANDBOX_TEST_MISSING}]"}
+	code, resp := run(RunRequest{Cmd: `echo "$AUTH $MISSING $PWD"`, Env: env, Cwd: "${SANDBOX_TEST_DIR}", ExpandEnv: true})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if want := "Bearer s3cret [] " + os.Getenv("SANDBOX_TEST_DIR"); strings.TrimSpace(resp.Stdout) != want {
+		t.Errorf("expected %q, got %q", want, resp.Stdout)
+	}
+
+	// Without the flag, values are passed as-is
+	_, resp = run(RunRequest{Cmd: `echo "$AUTH"`, Env: env})
+	if strings.TrimSpace(resp.Stdout) != "Bearer ${SANDBOX_TEST_TOKEN}" {
+		t.Errorf("expected the literal value without expand_env, got %q", resp.Stdout)
+	}
+
+	if code, _ := run(RunRequest{Cmd: "true", Env: env, ExpandEnv: true, UnknownEnv: UnknownEnvError}); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown variable to be rejected with unknown_env=error, got %d", code)
+	}
+	if code, _ := run(RunRequest{Cmd: "true", ExpandEnv: true, UnknownEnv: "ignore"}); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid unknown_env to be rejected, got %d", code)
+	}
+}
+
+func TestStartProcessExpandsEnvReferences(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Setenv("SANDBOX_TEST_TOKEN", "s3cret")
+	t.Setenv("SANDBOX_TEST_DIR", t.TempDir())
+
+	start := func(req StartProcessRequest) (int, StartProcessResponse) {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		var resp StartProcessResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, started := start(StartProcessRequest{
+		Cmd:       `echo "$AUTH $PWD"`,
+		Env:       map[string]string{"AUTH": "Bearer ${SANDBOX_TEST_TOKEN}"},
+		Cwd:       "$SANDBOX_TEST_DIR",
+		ExpandEnv: true,
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	process, _ := srv.processManager.GetProcess(started.ID)
+	waitForProcess(t, process)
+	logs, _ := srv.processManager.GetProcessLogs(started.ID)
+	if want := "Bearer s3cret " + os.Getenv("SANDBOX_TEST_DIR"); len(logs) != 1 || logs[0].Data != want {
+		t.Errorf("expected %q, got %+v", want, logs)
+	}
+
+	if code, _ := start(StartProcessRequest{Cmd: "true", Cwd: "${SANDBOX_TEST_MISSING}", ExpandEnv: true, UnknownEnv: UnknownEnvError}); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown variable to be rejected with unknown_env=error, got %d", code)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes identify the kind of failure in error responses. They are
+// stable, so clients can match on them instead of on messages.
+const (
+	ErrorCodeInvalidRequest     = "invalid_request"
+	ErrorCodeUnauthorized       = "unauthorized"
+	ErrorCodeForbidden          = "forbidden"
+	ErrorCodeNotFound           = "not_found"
+	ErrorCodeMethodNotAllowed   = "method_not_allowed"
+	ErrorCodeConflict           = "conflict"
+	ErrorCodePreconditionFailed = "precondition_failed"
+	ErrorCodePayloadTooLarge    = "payload_too_large"
+	ErrorCodeRateLimited        = "rate_limited"
+	ErrorCodeInternal           = "internal"
+	ErrorCodeNotImplemented     = "not_implemented"
+)
+
+// ErrorDetail describes why a request failed
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details carries extra context for some errors, such as the target a
+	// port is already bound to
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// writeError answers with status and a JSON ErrorResponse
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails is writeError with extra context in the error's details
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorDetail{Code: code, Message: message, Details: details}})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// decodeError decodes a standard error body and fails the test when the
+// response does not have the expected shape
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) ErrorDetail {
+	t.Helper()
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got Content-Type %q: %s", ct, w.Body.String())
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if resp.Error.Code == "" || resp.Error.Message == "" {
+		t.Fatalf("expected an error code and message, got %+v", resp.Error)
+	}
+	return resp.Error
+}
+
+func TestErrorResponseShape(t *testing.T) {
+	srv, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+
+	missing, _ := json.Marshal(ReadFileRequest{Path: filepath.Join(srv.root, "missing")})
+	outside, _ := json.Marshal(ReadFileRequest{Path: "/etc/passwd"})
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"invalid json", newAuthRequest(http.MethodPost, "/read_file", []byte("{")), http.StatusBadRequest, ErrorCodeInvalidRequest},
+		{"missing file", newAuthRequest(http.MethodPost, "/read_file", missing), http.StatusNotFound, ErrorCodeNotFound},
+		{"outside root", newAuthRequest(http.MethodPost, "/read_file", outside), http.StatusForbidden, ErrorCodeForbidden},
+		{"wrong method", newAuthRequest(http.MethodGet, "/checksum", nil), http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed},
+		{"no token", httptest.NewRequest(http.MethodPost, "/run", nil), http.StatusUnauthorized, ErrorCodeUnauthorized},
+		{"unknown process", newAuthRequest(http.MethodGet, "/get_process?id=missing", nil), http.StatusNotFound, ErrorCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, tt.req)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if detail := decodeError(t, w); detail.Code != tt.code {
+				t.Errorf("expected code %q, got %+v", tt.code, detail)
+			}
+		})
+	}
+}
+
+func TestErrorResponseDetails(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	body := []byte(`{"port":"8080"}`)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_port", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the first bind to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_port", []byte(`{"port":"9090"}`)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second bind, got %d: %s", w.Code, w.Body.String())
+	}
+	detail := decodeError(t, w)
+	if detail.Code != ErrorCodeConflict {
+		t.Errorf("expected a conflict error, got %+v", detail)
+	}
+	if detail.Details["current_port"] != "8080" {
+		t.Errorf("expected the current port in the details, got %+v", detail.Details)
+	}
+}
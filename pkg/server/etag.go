@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// contentETag is the version of file content: its hex SHA-256, the same
+// value /checksum returns
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileETag returns the etag of the file at path, or "" if it does not exist
+func fileETag(path string) (string, error) {
+	_, sum, err := checksumFile(path, sha256.New())
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return sum, err
+}
+
+// setETagHeader sets the ETag response header, quoted as HTTP requires
+func setETagHeader(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", `"`+etag+`"`)
+}
+
+// writePrecondition is the condition a write puts on the current version of
+// its file. Its zero value allows every write.
+type writePrecondition struct {
+	// ifMatch lists the etags, or "*", the file must currently have
+	ifMatch []string
+	// ifNoneMatch requires the file not to exist
+	ifNoneMatch bool
+}
+
+// parseWritePrecondition reads the if_match and if_none_match of a request,
+// falling back to its If-Match and If-None-Match headers. Values are etags
+// separated by commas, quoted or not; if_none_match only accepts "*".
+func parseWritePrecondition(ifMatch, ifNoneMatch string, header http.Header) (writePrecondition, error) {
+	if ifMatch == "" {
+		ifMatch = header.Get("If-Match")
+	}
+	if ifNoneMatch == "" {
+		ifNoneMatch = header.Get("If-None-Match")
+	}
+
+	var cond writePrecondition
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.Trim(strings.TrimSpace(etag), `"`)
+		if etag != "" {
+			cond.ifMatch = append(cond.ifMatch, etag)
+		}
+	}
+	switch strings.Trim(strings.TrimSpace(ifNoneMatch), `"`) {
+	case "":
+	case "*":
+		cond.ifNoneMatch = true
+	default:
+		return writePrecondition{}, fmt.Errorf("if_none_match only accepts \"*\"")
+	}
+	if len(cond.ifMatch) > 0 && cond.ifNoneMatch {
+		return writePrecondition{}, fmt.Errorf("if_match and if_none_match are mutually exclusive")
+	}
+	return cond, nil
+}
+
+func (c writePrecondition) active() bool {
+	return len(c.ifMatch) > 0 || c.ifNoneMatch
+}
+
+// allows reports whether a file whose etag is current, "" when it does not
+// exist, may be written
+func (c writePrecondition) allows(current string) bool {
+	if c.ifNoneMatch {
+		return current == ""
+	}
+	if len(c.ifMatch) == 0 {
+		return true
+	}
+	if current == "" {
+		return false
+	}
+	for _, etag := range c.ifMatch {
+		if etag == "*" || strings.EqualFold(etag, current) {
+			return true
+		}
+	}
+	return false
+}
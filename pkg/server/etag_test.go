@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConditionalWriteFile(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "notes.txt")
+
+	write := func(req WriteFileRequest, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req.Path = path
+		body, _ := json.Marshal(req)
+		httpReq := newAuthRequest(http.MethodPost, "/write_file", body)
+		for key, values := range header {
+			httpReq.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httpReq)
+		return w
+	}
+	read := func(req ReadFileRequest) ReadFileResponse {
+		t.Helper()
+		req.Path = path
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp ReadFileResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if got := w.Header().Get("ETag"); got != `"`+resp.ETag+`"` {
+			t.Errorf("expected the ETag header to carry %q, got %q", resp.ETag, got)
+		}
+		return resp
+	}
+
+	// Create if absent
+	w := write(WriteFileRequest{Content: "v1", IfNoneMatch: "*"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected creating a missing file to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var written WriteFileResponse
+	json.NewDecoder(w.Body).Decode(&written)
+	if !written.Success || written.ETag != contentETag([]byte("v1")) {
+		t.Errorf("expected the etag of the written content, got %+v", written)
+	}
+	if w := write(WriteFileRequest{Content: "other", IfNoneMatch: "*"}, nil); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected creating an existing file to fail with 412, got %d", w.Code)
+	}
+
+	v1 := read(ReadFileRequest{})
+	if v1.ETag != written.ETag {
+		t.Errorf("expected the read etag %q to match the write, got %q", written.ETag, v1.ETag)
+	}
+	if window := read(ReadFileRequest{Offset: 1}); window.ETag != v1.ETag {
+		t.Errorf("expected a window to report the etag of the whole file, got %q", window.ETag)
+	}
+
+	// Matching write
+	if w := write(WriteFileRequest{Content: "v2", IfMatch: v1.ETag}, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected a write at the current version to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Stale write, from the body and from the header
+	for _, header := range []http.Header{nil, {"If-Match": {`"` + v1.ETag + `"`}}} {
+		req := WriteFileRequest{Content: "lost update"}
+		if header == nil {
+			req.IfMatch = v1.ETag
+		}
+		w := write(req, header)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("expected a stale write to fail with 412, got %d: %s", w.Code, w.Body.String())
+		}
+		errResp := decodeError(t, w)
+		if errResp.Code != ErrorCodePreconditionFailed || errResp.Details["etag"] != contentETag([]byte("v2")) {
+			t.Errorf("expected the current etag in the error, got %+v", errResp)
+		}
+	}
+	if content, _ := os.ReadFile(path); string(content) != "v2" {
+		t.Errorf("expected the stale writes to leave the file alone, got %q", content)
+	}
+
+	if w := write(WriteFileRequest{Content: "v3"}, http.Header{"If-Match": {"*"}}); w.Code != http.StatusOK {
+		t.Errorf("expected If-Match: * to accept an existing file, got %d", w.Code)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if w := write(WriteFileRequest{Content: "v4", IfMatch: "*"}, nil); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected if_match to fail for a missing file, got %d", w.Code)
+	}
+	if w := write(WriteFileRequest{Content: "v4", IfNoneMatch: "abc"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected if_none_match other than * to be rejected, got %d", w.Code)
+	}
+}
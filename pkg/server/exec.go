@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// execFrameRequest is the type of the first frame of an /exec/interactive
+// request body, carrying the JSON RunRequest. The other frame types are those
+// of /run_ws.
+const execFrameRequest byte = 6
+
+// execFrameHeaderLen is the size of the type byte and big-endian payload
+// length that precede every /exec/interactive frame
+const execFrameHeaderLen = 5
+
+// maxExecFramePayload bounds the frames read from clients, so a corrupt
+// length cannot make the server allocate gigabytes
+const maxExecFramePayload = 1 << 20
+
+// ExecExit is the payload of the exit frame ending an /exec/interactive
+// response or a /run_ws session
+type ExecExit struct {
+	Code     int    `json:"code"`
+	Signaled bool   `json:"signaled,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// writeExecFrame writes one frame of frameType to w
+func writeExecFrame(w io.Writer, frameType byte, payload []byte) error {
+	frame := make([]byte, execFrameHeaderLen+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:execFrameHeaderLen], uint32(len(payload)))
+	copy(frame[execFrameHeaderLen:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readExecFrame reads the next frame from r. It returns io.EOF when r ends
+// between frames, and io.ErrUnexpectedEOF when it ends inside one.
+func readExecFrame(r io.Reader, maxPayload int) (byte, []byte, error) {
+	var header [execFrameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if int64(length) > int64(maxPayload) {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds %d bytes", length, maxPayload)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// execFrameReader is the frameSource of an /exec/interactive request body
+type execFrameReader struct {
+	io.Reader
+}
+
+func (r execFrameReader) readFrame() (byte, []byte, error) {
+	return readExecFrame(r.Reader, maxExecFramePayload)
+}
+
+// execStream writes response frames, flushing each one so output reaches the
+// client while the request body is still being read
+type execStream struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *execStream) writeFrame(frameType byte, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeExecFrame(s.w, frameType, payload); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// execInteractiveHandler runs a command interactively over a single
+// full-duplex request, for clients that cannot open a websocket. The request
+// body is a stream of frames starting with the run request, followed by stdin
+// and resize frames; the response body streams stdout and stderr frames and
+// ends with an exit frame. HTTP/2 carries both directions natively; over
+// HTTP/1.1 the body must be sent chunked, and only clients reading the
+// response while still writing it get interactive I/O.
+func (s *Server) execInteractiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Without full duplex, an HTTP/1.1 client can still send its whole input
+	// up front, like a script piped to the command
+	rc := http.NewResponseController(w)
+	if r.ProtoMajor == 1 {
+		if err := rc.EnableFullDuplex(); err != nil {
+			slog.DebugContext(r.Context(), "Failed to enable full-duplex streaming", "error", err)
+		}
+	}
+
+	var req RunRequest
+	frameType, payload, err := readExecFrame(r.Body, maxExecFramePayload)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			writeDecodeError(w, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request: the body must start with a run request frame")
+		return
+	}
+	if frameType != execFrameRequest {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request: the first frame must be a JSON run request with a cmd or argv")
+		return
+	}
+	if err := decodeJSON(bytes.NewReader(payload), &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Cmd == "" && len(req.Argv) == 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request: the first frame must be a JSON run request with a cmd or argv")
+		return
+	}
+	auditCommand(r.Context(), req.Cmd, req.Argv)
+	if rejectOutputFiles(w, req.OutputFiles) {
+		return
+	}
+
+	if err := req.expandEnv(); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateCwd(req.Cwd); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if !checkCommandUser(w, req.User) {
+		return
+	}
+	shell, ok := s.commandShell(w, req.Shell)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Executing interactive command", "cmd", req.Cmd, "argv", req.Argv, "cwd", req.Cwd, "env", req.Env, "limits", req.Limits, "tty", req.TTY, "user", req.User, "shell", shell, "clean_env", req.CleanEnv, "nice", req.Nice, "proto", r.Proto)
+
+	// The command is killed if the client goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	command, err := startInteractive(ctx, CommandOptions{
+		Command:  req.Cmd,
+		Cwd:      req.Cwd,
+		Env:      req.Env,
+		Limits:   req.Limits,
+		Argv:     req.Argv,
+		User:     req.User,
+		Shell:    shell,
+		CleanEnv: req.CleanEnv,
+		Nice:     req.Nice,
+	}, req.TTY)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to start interactive command", "cmd", req.Cmd, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to start command: %v", err))
+		return
+	}
+
+	// Send the headers now: the client may wait for them before writing stdin
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	stream := &execStream{w: w, rc: rc}
+	if err := rc.Flush(); err != nil {
+		slog.DebugContext(r.Context(), "Failed to flush interactive response", "error", err)
+	}
+
+	exit := command.run(r.Context(), execFrameReader{r.Body}, stream, cancel)
+
+	slog.DebugContext(r.Context(), "Interactive command completed", "cmd", req.Cmd, "exit_code", exit.Code, "signal", exit.Signal)
+
+	data, _ := json.Marshal(exit)
+	stream.writeFrame(wsFrameExit, data)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ExecSession is a command running on an executor over /exec/interactive.
+// Write sends stdin while Wait reads the output, so they are typically used
+// from different goroutines.
+type ExecSession struct {
+	resp *http.Response
+
+	mu    sync.Mutex
+	input *io.PipeWriter
+}
+
+// ExecInteractive starts req on the executor at baseURL, e.g.
+// "https://sandbox:3030", authenticating with token. The client must be able
+// to stream a request body while reading the response: any client speaking
+// HTTP/2, which http.Client does over TLS, or over cleartext with
+// Transport.Protocols allowing unencrypted HTTP/2.
+func ExecInteractive(ctx context.Context, client *http.Client, baseURL, token string, req RunRequest) (*ExecSession, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var first bytes.Buffer
+	writeExecFrame(&first, execFrameRequest, payload)
+
+	// The run request goes first, so the server can answer without waiting
+	// for stdin
+	bodyReader, bodyWriter := io.Pipe()
+	body := io.MultiReader(&first, bodyReader)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/exec/interactive", body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		bodyWriter.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyWriter.Close()
+		var errResp ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error.Message != "" {
+			return nil, fmt.Errorf("exec failed with %d: %s", resp.StatusCode, errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("exec failed with %d", resp.StatusCode)
+	}
+
+	return &ExecSession{resp: resp, input: bodyWriter}, nil
+}
+
+// Write sends p to the stdin of the command
+func (s *ExecSession) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := s.writeFrame(wsFrameStdin, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseStdin closes the stdin of the command, or sends end-of-file on a
+// terminal
+func (s *ExecSession) CloseStdin() error {
+	return s.writeFrame(wsFrameStdin, nil)
+}
+
+// Resize sets the terminal size of a command started with TTY
+func (s *ExecSession) Resize(rows, cols uint16) error {
+	payload, _ := json.Marshal(wsResize{Rows: rows, Cols: cols})
+	return s.writeFrame(wsFrameResize, payload)
+}
+
+func (s *ExecSession) writeFrame(frameType byte, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeExecFrame(s.input, frameType, payload)
+}
+
+// Wait copies the output of the command to stdout and stderr until it exits,
+// and returns how it exited. Either writer may be nil to discard the stream.
+func (s *ExecSession) Wait(stdout, stderr io.Writer) (ExecExit, error) {
+	defer s.Close()
+
+	for {
+		// The response comes from the executor, which bounds frames by the
+		// size of its read buffer
+		frameType, payload, err := readExecFrame(s.resp.Body, 1<<20)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return ExecExit{}, fmt.Errorf("reading exec output: %w", err)
+		}
+
+		switch frameType {
+		case wsFrameStdout:
+			if stdout != nil {
+				stdout.Write(payload)
+			}
+		case wsFrameStderr:
+			if stderr != nil {
+				stderr.Write(payload)
+			}
+		case wsFrameExit:
+			var exit ExecExit
+			if err := json.Unmarshal(payload, &exit); err != nil {
+				return ExecExit{}, fmt.Errorf("invalid exit frame: %w", err)
+			}
+			return exit, nil
+		}
+	}
+}
+
+// Close ends the session. A command still running is killed.
+func (s *ExecSession) Close() error {
+	s.input.Close()
+	return s.resp.Body.Close()
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startHTTP2Server serves mux over TLS with HTTP/2 and returns its URL and a
+// client negotiating HTTP/2 with it
+func startHTTP2Server(t *testing.T, mux http.Handler) (string, *http.Client) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(mux)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL, ts.Client()
+}
+
+func TestExecInteractiveOverHTTP2(t *testing.T) {
+	_, mux := newTestServer(t)
+	url, client := startHTTP2Server(t, mux)
+
+	session, err := ExecInteractive(t.Context(), client, url, "test-secret", RunRequest{
+		Cmd: `while read line; do echo "got $line"; echo "err $line" >&2; done; exit 3`,
+	})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if session.resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", session.resp.Proto)
+	}
+
+	stdout, stdoutWriter := io.Pipe()
+	var stderr bytes.Buffer
+	type result struct {
+		exit ExecExit
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		exit, err := session.Wait(stdoutWriter, &stderr)
+		stdoutWriter.Close()
+		done <- result{exit, err}
+	}()
+
+	// Each answer arrives while the request body is still open, so the
+	// output is read while stdin is still being written
+	lines := bufio.NewReader(stdout)
+	for _, input := range []string{"hello", "world"} {
+		if _, err := session.Write([]byte(input + "\n")); err != nil {
+			t.Fatalf("failed to write stdin: %v", err)
+		}
+		answer := make(chan string, 1)
+		go func() {
+			line, _ := lines.ReadString('\n')
+			answer <- line
+		}()
+		select {
+		case line := <-answer:
+			if line != "got "+input+"\n" {
+				t.Fatalf("expected an answer to %q, got %q", input, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no answer to %q before stdin was closed", input)
+		}
+	}
+
+	if err := session.CloseStdin(); err != nil {
+		t.Fatalf("failed to close stdin: %v", err)
+	}
+	go io.Copy(io.Discard, stdout)
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("expected the session to end with an exit frame: %v", res.err)
+		}
+		if res.exit.Code != 3 {
+			t.Errorf("expected exit code 3, got %+v", res.exit)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the command to exit once stdin was closed")
+	}
+	if got := stderr.String(); got != "err hello\nerr world\n" {
+		t.Errorf("expected stderr frames, got %q", got)
+	}
+}
+
+func TestExecInteractiveOverCleartextHTTP2(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+
+	session, err := ExecInteractive(t.Context(), client, ts.URL, "test-secret", RunRequest{Cmd: "cat"})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if session.resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 without TLS, got %s", session.resp.Proto)
+	}
+	session.Write([]byte("echo"))
+	session.CloseStdin()
+
+	var output strings.Builder
+	if exit, err := session.Wait(&output, nil); err != nil || exit.Code != 0 || output.String() != "echo" {
+		t.Errorf("expected cat to echo its input, got %q, %+v (%v)", output.String(), exit, err)
+	}
+}
+
+func TestExecInteractiveTTYResize(t *testing.T) {
+	_, mux := newTestServer(t)
+	url, client := startHTTP2Server(t, mux)
+
+	session, err := ExecInteractive(t.Context(), client, url, "test-secret", RunRequest{
+		Cmd: "read line; stty size",
+		TTY: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if err := session.Resize(33, 101); err != nil {
+		t.Fatal(err)
+	}
+	session.Write([]byte("go\n"))
+
+	var output strings.Builder
+	exit, err := session.Wait(&output, nil)
+	if err != nil || exit.Code != 0 {
+		t.Fatalf("expected a clean exit, got %+v (%v)", exit, err)
+	}
+	if !strings.Contains(output.String(), "33 101") {
+		t.Errorf("expected the resized terminal, got %q", output.String())
+	}
+}
+
+func TestExecInteractiveRejectsInvalidRequests(t *testing.T) {
+	_, mux := newTestServer(t)
+	url, client := startHTTP2Server(t, mux)
+
+	if _, err := ExecInteractive(t.Context(), client, url, "test-secret", RunRequest{}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected 400 without a command, got %v", err)
+	}
+	if _, err := ExecInteractive(t.Context(), client, url, "wrong-secret", RunRequest{Cmd: "true"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 with a wrong token, got %v", err)
+	}
+
+	// A body that does not start with a request frame
+	var body bytes.Buffer
+	writeExecFrame(&body, wsFrameStdin, []byte("echo hi\n"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/exec/interactive", body.Bytes()))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a missing request frame, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Unknown fields are rejected like on the other routes
+	body.Reset()
+	writeExecFrame(&body, execFrameRequest, []byte(`{"cmd":"true","cwdd":"/tmp"}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/exec/interactive", body.Bytes()))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown field, got %d: %s", w.Code, w.Body.String())
+	}
+	if errResp := decodeError(t, w); errResp.Details["reason"] != decodeReasonUnknownField || errResp.Details["field"] != "cwdd" {
+		t.Errorf("expected the unknown field to be named, got %+v", errResp)
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// fileErrorStatus is the status file handlers answer with when an operation
+// fails: 404 for a missing path, 403 when permission is denied, 409 when the
+// path already exists, 500 otherwise
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, fs.ErrExist):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeFileError answers a failed file operation with the status from
+// fileErrorStatus and the matching error code
+func writeFileError(w http.ResponseWriter, err error) {
+	status := fileErrorStatus(err)
+	code := ErrorCodeInternal
+	switch status {
+	case http.StatusNotFound:
+		code = ErrorCodeNotFound
+	case http.StatusForbidden:
+		code = ErrorCodeForbidden
+	case http.StatusConflict:
+		code = ErrorCodeConflict
+	}
+	writeError(w, status, code, err.Error())
+}
+
+// parseFileMode parses octal permission bits such as "0755"
+func parseFileMode(value string) (fs.FileMode, error) {
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if parsed > 0o7777 {
+		return 0, fmt.Errorf("mode out of range: %s", value)
+	}
+	return fs.FileMode(parsed), nil
+}
+
+// renamePath is os.Rename, swappable in tests to simulate cross-device moves
+var renamePath = os.Rename
+
+// movePath renames source to destination, falling back to copy-then-delete
+// when the two paths live on different filesystems
+func movePath(source, destination string) error {
+	err := renamePath(source, destination)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyPath(source, destination); err != nil {
+		os.RemoveAll(destination)
+		return fmt.Errorf("cross-device copy failed: %w", err)
+	}
+
+	if err := os.RemoveAll(source); err != nil {
+		return fmt.Errorf("failed to remove source after copy: %w", err)
+	}
+
+	return nil
+}
+
+// copyPath recursively copies files, directories and symlinks from source to
+// destination, preserving permission bits
+func copyPath(source, destination string) error {
+	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("unsupported file type: %s", path)
+		}
+	})
+}
+
+// copyFile copies a single regular file, creating destination with mode
+func copyFile(source, destination string, mode fs.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// touchFile creates path as an empty file if it does not exist, then sets its
+// access and modification times to mtime. Existing directories are accepted
+func touchFile(path string, mtime time.Time) error {
+	// Opening a directory with O_CREATE fails, so only create what is missing
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
+
+// readFileRange reads length bytes of the file at path starting at offset, or
+// up to the end of the file when length is zero. Reading past the end returns
+// a short or empty result. The total file size is returned alongside
+func readFileRange(path string, offset, length int64) ([]byte, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		return nil, 0, fmt.Errorf("read %s: is a directory", path)
+	}
+
+	// The section is read with ReadAt. Its limit is not taken from the
+	// reported size, which is zero for files under /proc
+	limit := length
+	if limit == 0 {
+		limit = math.MaxInt64 - offset
+	}
+	content, err := io.ReadAll(io.NewSectionReader(file, offset, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	if end := offset + int64(len(content)); len(content) > 0 && end > size {
+		size = end
+	}
+	return content, size, nil
+}
+
+// writeFileAtomic streams content into a temp file next to path and renames
+// it into place, so readers never observe a partially written file
+func writeFileAtomic(path string, content io.Reader, mode fs.FileMode) (int64, error) {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	tmpPath := tmpFile.Name()
+	keepTemp := false
+	defer func() {
+		if keepTemp {
+			return
+		}
+		_ = os.Remove(tmpPath)
+	}()
+
+	written, err := io.Copy(tmpFile, content)
+	if err != nil {
+		_ = tmpFile.Close()
+		return written, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := tmpFile.Chmod(mode); err != nil {
+		_ = tmpFile.Close()
+		return written, fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return written, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return written, fmt.Errorf("failed to rename temp file into place: %w", err)
+	}
+
+	keepTemp = true
+	return written, nil
+}
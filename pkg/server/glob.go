@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// DefaultGlobMaxResults is the number of paths /glob returns unless the
+// request asks for another limit
+const DefaultGlobMaxResults = 1000
+
+// MaxGlobResultsLimit is the largest max_results a request may ask for
+const MaxGlobResultsLimit = 100000
+
+// errGlobLimitReached stops the walk once enough paths matched
+var errGlobLimitReached = errors.New("glob result limit reached")
+
+type GlobRequest struct {
+	Root string `json:"root"`
+	// Pattern is matched against paths relative to Root, with ** matching
+	// any number of directories
+	Pattern string `json:"pattern"`
+	// MaxResults caps the paths returned (default DefaultGlobMaxResults)
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+type GlobResponse struct {
+	Matches []string `json:"matches"`
+	// Truncated is set when the walk stopped at MaxResults
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// globFiles returns up to maxResults paths under root matching pattern.
+// Symlinks are not followed, and matches that resolve outside the sandbox
+// root are dropped.
+func (s *Server) globFiles(root, pattern string, maxResults int) ([]string, bool, error) {
+	matches := []string{}
+	err := doublestar.GlobWalk(os.DirFS(root), pattern, func(match string, d fs.DirEntry) error {
+		path := filepath.Join(root, filepath.FromSlash(match))
+		if _, err := s.resolvePath(path); err != nil {
+			return nil
+		}
+		matches = append(matches, path)
+		if len(matches) >= maxResults {
+			return errGlobLimitReached
+		}
+		return nil
+	}, doublestar.WithNoFollow())
+	if errors.Is(err, errGlobLimitReached) {
+		return matches, true, nil
+	}
+	return matches, false, err
+}
+
+// globHandler lists the paths under a directory matching a glob pattern
+func (s *Server) globHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req GlobRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.Root == "" || req.Pattern == "" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "root and pattern are required")
+		return
+	}
+	if !doublestar.ValidatePattern(req.Pattern) || filepath.IsAbs(req.Pattern) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid pattern: %s", req.Pattern))
+		return
+	}
+	if req.MaxResults < 0 || req.MaxResults > MaxGlobResultsLimit {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("max_results must be between 0 and %d", MaxGlobResultsLimit))
+		return
+	}
+	maxResults := req.MaxResults
+	if maxResults == 0 {
+		maxResults = DefaultGlobMaxResults
+	}
+
+	root, ok := s.sandboxPath(w, req.Root)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Matching glob", "root", req.Root, "pattern", req.Pattern, "max_results", maxResults)
+
+	matches, truncated, err := s.globFiles(root, req.Pattern, maxResults)
+	resp := GlobResponse{Matches: matches, Truncated: truncated}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to match glob", "root", req.Root, "pattern", req.Pattern, "error", err)
+		resp.Error = err.Error()
+	} else {
+		slog.DebugContext(r.Context(), "Glob matched", "root", req.Root, "pattern", req.Pattern, "matches", len(matches), "truncated", truncated)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
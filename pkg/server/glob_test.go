@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func glob(t *testing.T, mux http.Handler, req GlobRequest) (int, GlobResponse) {
+	t.Helper()
+
+	reqBody, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/glob", reqBody))
+
+	var resp GlobResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestGlob(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt":               "",
+		"b.txt":               "",
+		"c.json":              "",
+		"config/app.json":     "",
+		"config/env/dev.json": "",
+		"config/notes.txt":    "",
+	})
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.txt", []string{"a.txt", "b.txt"}},
+		{"**/*.json", []string{"c.json", "config/app.json", "config/env/dev.json"}},
+		{"config/*/*.json", []string{"config/env/dev.json"}},
+		{"*.yaml", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			code, resp := glob(t, mux, GlobRequest{Root: root, Pattern: tt.pattern})
+			if code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", code)
+			}
+			if resp.Matches == nil || resp.Error != "" {
+				t.Fatalf("expected a list of matches and no error, got %+v", resp)
+			}
+
+			want := make([]string, len(tt.want))
+			for i, name := range tt.want {
+				want[i] = filepath.Join(root, name)
+			}
+			got := slices.Sorted(slices.Values(resp.Matches))
+			if !slices.Equal(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestGlobMaxResults(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "", "b.txt": "", "c.txt": ""})
+
+	_, resp := glob(t, mux, GlobRequest{Root: root, Pattern: "*.txt", MaxResults: 2})
+	if len(resp.Matches) != 2 || !resp.Truncated {
+		t.Errorf("expected 2 truncated matches, got %q truncated=%v", resp.Matches, resp.Truncated)
+	}
+
+	for _, req := range []GlobRequest{
+		{Root: root, Pattern: "[*.txt"},
+		{Root: root, Pattern: "/etc/*"},
+		{Root: root, Pattern: "*.txt", MaxResults: -1},
+	} {
+		if code, _ := glob(t, mux, req); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %+v, got %d", req, code)
+		}
+	}
+}
+
+func TestGlobConfinedToRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeTree(t, root, map[string]string{"inside.txt": ""})
+	writeTree(t, outside, map[string]string{"outside.txt": ""})
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	for _, pattern := range []string{"**/*.txt", "escape/*.txt"} {
+		_, resp := glob(t, mux, GlobRequest{Root: ".", Pattern: pattern})
+		for _, match := range resp.Matches {
+			if filepath.Base(match) == "outside.txt" {
+				t.Errorf("pattern %q matched %q outside the root", pattern, match)
+			}
+		}
+	}
+
+	if code, _ := glob(t, mux, GlobRequest{Root: outside, Pattern: "*"}); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a root outside the sandbox, got %d", code)
+	}
+}
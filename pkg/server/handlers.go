@@ -3,15 +3,29 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/koyeb/sandbox-container/pkg/logger"
 )
@@ -21,6 +35,7 @@ type sseWriter struct {
 	w       http.ResponseWriter
 	mu      sync.Mutex
 	flusher http.Flusher
+	seq     uint64
 }
 
 func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
@@ -41,6 +56,24 @@ func (s *sseWriter) writeEvent(event, data string) {
 	s.flusher.Flush()
 }
 
+// writeEventWithID writes an event with an SSE id line so that clients can
+// resume with the Last-Event-ID header
+func (s *sseWriter) writeEventWithID(id uint64, event, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+	s.flusher.Flush()
+}
+
+// writeSequencedEvent writes an event with the next per-stream id
+func (s *sseWriter) writeSequencedEvent(event, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.seq, event, data)
+	s.flusher.Flush()
+}
+
 func (s *sseWriter) writeEventf(event, format string, args ...interface{}) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -51,30 +84,111 @@ func (s *sseWriter) writeEventf(event, format string, args ...interface{}) {
 }
 
 type RunRequest struct {
-	Cmd string            `json:"cmd"`
-	Cwd string            `json:"cwd,omitempty"`
-	Env map[string]string `json:"env,omitempty"`
+	Cmd    string            `json:"cmd"`
+	Cwd    string            `json:"cwd,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	Limits *ResourceLimits   `json:"limits,omitempty"`
+	// Argv runs a program with arguments directly, without a shell, and
+	// takes precedence over Cmd
+	Argv []string `json:"argv,omitempty"`
+	// TTY runs the command under a pseudo-terminal; its output is all
+	// reported as stdout
+	TTY bool `json:"tty,omitempty"`
+	// User runs the command as this user, a name or a uid
+	User string `json:"user,omitempty"`
+	// Shell runs Cmd instead of the server's default shell. Ignored for Argv
+	Shell string `json:"shell,omitempty"`
+	// CleanEnv runs the command with only Env and a default PATH instead of
+	// the executor's environment
+	CleanEnv bool `json:"clean_env,omitempty"`
+	// Nice runs the command at a lower (1..19) or higher (-20..-1) priority.
+	// Values are clamped, and a higher priority needs privileges the
+	// executor may not have; see applyNice.
+	Nice int `json:"nice,omitempty"`
+	// ExpandEnv replaces ${VAR} and $VAR in the values of Env and in Cwd with
+	// the executor's variables. Off by default so a literal $ is kept.
+	ExpandEnv bool `json:"expand_env,omitempty"`
+	// UnknownEnv is what ExpandEnv does with a variable the executor does
+	// not have: UnknownEnvEmpty (default) or UnknownEnvError
+	UnknownEnv string `json:"unknown_env,omitempty"`
+	// OutputFiles writes the output to files instead of the response. Only
+	// /run supports it, the other endpoints stream the output.
+	OutputFiles
 }
 
+// RunResponse is the result of /run. A command that ran reports how it ended
+// in ExitCode and Signal, whatever its exit status; Error is reserved for
+// problems on the executor's side.
 type RunResponse struct {
 	Stdout string `json:"stdout"`
 	Stderr string `json:"stderr"`
-	Error  string `json:"error,omitempty"`
-	Code   int    `json:"code"`
+	// Started is set once the command was started, whether or not it then
+	// succeeded
+	Started bool `json:"started"`
+	// ExitCode is the exit code, or -1 when the command was terminated by a
+	// signal or did not start
+	ExitCode int `json:"exit_code"`
+	// Code is ExitCode, kept for clients of earlier versions
+	Code int `json:"code"`
+	// Error is set when the executor failed the command: it could not be
+	// started, or its output was truncated
+	Error string `json:"error,omitempty"`
+	// Signaled is set when the command was terminated by Signal, such as
+	// "SIGKILL", rather than exiting on its own
+	Signaled bool   `json:"signaled"`
+	Signal   string `json:"signal,omitempty"`
+	// Reason names the resource limit that terminated the command, if any
+	Reason string `json:"reason,omitempty"`
+	// Truncated is set when a stream exceeded the output cap, in which case
+	// the command was killed
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 type WriteFileRequest struct {
 	Path    string `json:"path"`
 	Content string `json:"content"`
+	// Mode is the octal permission bits for the file (default "0644")
+	Mode string `json:"mode,omitempty"`
+	// Atomic writes to a temp file and renames it into place, so the file is
+	// never observed half-written
+	Atomic bool `json:"atomic,omitempty"`
+	// IfMatch only writes if the file exists with this etag, or one of a
+	// comma-separated list, as returned by reads and writes. "*" matches any
+	// existing file. Defaults to the If-Match header.
+	IfMatch string `json:"if_match,omitempty"`
+	// IfNoneMatch set to "*" only writes if the file does not exist.
+	// Defaults to the If-None-Match header.
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+}
+
+type WriteFileResponse struct {
+	Success bool `json:"success"`
+	// ETag is the SHA-256 of the written content
+	ETag string `json:"etag"`
 }
 
 type ReadFileRequest struct {
 	Path string `json:"path"`
+	// Offset and Length select a window of the file. A zero Length reads to
+	// the end of the file
+	Offset int64 `json:"offset,omitempty"`
+	Length int64 `json:"length,omitempty"`
+	// Encoding is "utf-8" (default) or "base64" for binary content
+	Encoding string `json:"encoding,omitempty"`
+	// DetectContentType adds the MIME type of the file to the response
+	DetectContentType bool `json:"detect_content_type,omitempty"`
 }
 
 type ReadFileResponse struct {
-	Content string `json:"content,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Content  string `json:"content,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// ContentType is the MIME type of the whole file, when requested
+	ContentType string `json:"content_type,omitempty"`
+	// Size is the total size of the file, which is larger than the content
+	// when a window was read
+	Size int64 `json:"size"`
+	// ETag is the SHA-256 of the whole file, to pass as if_match to a write
+	ETag string `json:"etag"`
 }
 
 type DeleteFileRequest struct {
@@ -83,158 +197,487 @@ type DeleteFileRequest struct {
 
 type DeleteDirRequest struct {
 	Path string `json:"path"`
+	// OnlyIfEmpty removes the directory only when it has no entries, instead
+	// of deleting its whole tree
+	OnlyIfEmpty bool `json:"only_if_empty,omitempty"`
 }
 
 type MakeDirRequest struct {
 	Path string `json:"path"`
 }
 
+type TouchRequest struct {
+	Path string `json:"path"`
+	// Mtime sets the access and modification times instead of the current time
+	Mtime *time.Time `json:"mtime,omitempty"`
+}
+
+type TouchResponse struct {
+	Success bool      `json:"success"`
+	Path    string    `json:"path"`
+	Mtime   time.Time `json:"mtime"`
+}
+
+type TruncateRequest struct {
+	Path string `json:"path"`
+	// Size is the new length in bytes. Growing a file zero-fills the new space
+	Size int64 `json:"size"`
+}
+
+type StatRequest struct {
+	Path string `json:"path"`
+}
+
+type StatResponse struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Mode is the permission bits in octal, e.g. "0644"
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+	// IsSymlink reports the path itself is a symlink, which is not followed
+	IsSymlink bool `json:"is_symlink"`
+	// ContentType is the MIME type of a regular file, detected from its first
+	// bytes and its extension
+	ContentType string `json:"content_type,omitempty"`
+}
+
+type SymlinkRequest struct {
+	// Target is the path the link points to, stored as given. Relative
+	// targets are resolved from the directory containing the link
+	Target   string `json:"target"`
+	LinkPath string `json:"link_path"`
+}
+
+type ReadlinkRequest struct {
+	Path string `json:"path"`
+	// Resolve also follows every link in the chain to the final path
+	Resolve bool `json:"resolve,omitempty"`
+}
+
+type ReadlinkResponse struct {
+	Path string `json:"path"`
+	// Target is the link's content, exactly as stored
+	Target string `json:"target"`
+	// Resolved is the path with all symlinks followed, set when Resolve was
+	// requested
+	Resolved string `json:"resolved,omitempty"`
+}
+
 type ListDirRequest struct {
 	Path string `json:"path"`
+	// Limit pages the listing, returning at most this many entries in name
+	// order. It can also be given as ?limit=.
+	Limit int `json:"limit,omitempty"`
+	// Cursor resumes a paginated listing where the previous page ended. It
+	// can also be given as ?cursor=.
+	Cursor string `json:"cursor,omitempty"`
 }
 
 type ListDirResponse struct {
 	Entries []string `json:"entries,omitempty"`
-	Error   string   `json:"error,omitempty"`
+	// NextCursor fetches the next page, and is only set when more entries
+	// follow
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Trace("Health check request", "method", r.Method, "remote_addr", r.RemoteAddr)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+type MoveRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
 }
 
-func (s *Server) runHandler(w http.ResponseWriter, r *http.Request) {
-	var req RunRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+type ChecksumRequest struct {
+	Path string `json:"path"`
+	// Algorithm is md5, sha1 or sha256 (default)
+	Algorithm string `json:"algorithm,omitempty"`
+}
 
-	if req.Cwd != "" {
-		if info, err := os.Stat(req.Cwd); err != nil || !info.IsDir() {
-			http.Error(w, fmt.Sprintf("Invalid working directory: %s", req.Cwd), http.StatusBadRequest)
-			return
-		}
+type ChecksumResponse struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// checksumAlgorithms are the hashes /checksum supports
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+type HealthResponse struct {
+	// Status is "ok", or "degraded" while the TCP proxy is not listening
+	Status string `json:"status"`
+	// TCPProxyListening is set once the TCP proxy accepts connections
+	TCPProxyListening bool `json:"tcp_proxy_listening"`
+	// RunningProcesses counts the background processes still running
+	RunningProcesses int     `json:"running_processes"`
+	UptimeSeconds    float64 `json:"uptime_seconds"`
+}
+
+// health reports the state of the server's subsystems
+func (s *Server) health() HealthResponse {
+	listening := s.tcpProxy.Listening()
+	status := "ok"
+	if !listening {
+		status = "degraded"
 	}
+	return HealthResponse{
+		Status:            status,
+		TCPProxyListening: listening,
+		RunningProcesses:  s.processManager.RunningCount(),
+		UptimeSeconds:     time.Since(s.startedAt).Seconds(),
+	}
+}
 
-	slog.Debug("Executing command", "cmd", req.Cmd, "cwd", req.Cwd, "env", req.Env)
+// healthHandler always answers 200 while the server is up, with the state of
+// its subsystems in the body
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	logger.TraceContext(r.Context(), "Health check request", "method", r.Method, "remote_addr", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.health())
+}
 
-	cmd := exec.Command("sh", "-c", req.Cmd)
+// readyHandler answers 503 until the TCP proxy is listening, so traffic is
+// only routed to the sandbox once it can be forwarded
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	health := s.health()
+	logger.TraceContext(r.Context(), "Readiness check request", "method", r.Method, "remote_addr", r.RemoteAddr, "ready", health.TCPProxyListening)
+	w.Header().Set("Content-Type", "application/json")
+	if !health.TCPProxyListening {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
 
-	// Set working directory if provided
-	if req.Cwd != "" {
-		cmd.Dir = req.Cwd
+// commandShell returns the shell to run a request's command with, answering
+// 400 when the requested one does not exist
+func (s *Server) commandShell(w http.ResponseWriter, shell string) (string, bool) {
+	if shell == "" {
+		return s.shell, true
 	}
+	if err := validateShell(shell); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return "", false
+	}
+	return shell, true
+}
 
-	// Set environment variables if provided
-	if len(req.Env) > 0 {
-		cmd.Env = os.Environ()
-		for key, value := range req.Env {
-			cmd.Env = append(cmd.Env, key+"="+value)
+// checkCommandUser reports whether commands can run as name, answering 400
+// for an unknown user and 403 when the executor may not switch to it
+func checkCommandUser(w http.ResponseWriter, name string) bool {
+	if name == "" {
+		return true
+	}
+	if _, err := lookupCommandUser(name); err != nil {
+		if errors.Is(err, errUserSwitchNotPermitted) {
+			writeError(w, http.StatusForbidden, ErrorCodeForbidden, err.Error())
+		} else {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid user: %s", err))
 		}
+		return false
 	}
+	return true
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		slog.Debug("Failed to get stdout pipe", "error", err)
-		http.Error(w, "Failed to get stdout", http.StatusInternalServerError)
+func (s *Server) runHandler(w http.ResponseWriter, r *http.Request) {
+	var req RunRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		slog.Debug("Failed to get stderr pipe", "error", err)
-		http.Error(w, "Failed to get stderr", http.StatusInternalServerError)
+	auditCommand(r.Context(), req.Cmd, req.Argv)
+
+	if err := req.expandEnv(); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateCwd(req.Cwd); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if !checkCommandUser(w, req.User) {
+		return
+	}
+	shell, ok := s.commandShell(w, req.Shell)
+	if !ok {
 		return
 	}
-	if err := cmd.Start(); err != nil {
-		slog.Debug("Failed to start command", "cmd", req.Cmd, "error", err)
-		http.Error(w, "Failed to start command", http.StatusInternalServerError)
+	files, ok := s.resolveOutputFiles(w, req.OutputFiles, req.TTY)
+	if !ok {
 		return
 	}
-	outBytes, _ := io.ReadAll(stdout)
-	errBytes, _ := io.ReadAll(stderr)
+
+	slog.DebugContext(r.Context(), "Executing command", "cmd", req.Cmd, "argv", req.Argv, "cwd", req.Cwd, "env", req.Env, "limits", req.Limits, "tty", req.TTY, "user", req.User, "shell", shell, "clean_env", req.CleanEnv, "nice", req.Nice, "stdout_file", files.StdoutFile, "stderr_file", files.StderrFile, "append_output", files.AppendOutput)
+
+	// The command is killed if the client goes away
+	cmd := newCommand(r.Context(), CommandOptions{
+		Command:  req.Cmd,
+		Cwd:      req.Cwd,
+		Env:      req.Env,
+		Limits:   req.Limits,
+		Argv:     req.Argv,
+		User:     req.User,
+		Shell:    shell,
+		CleanEnv: req.CleanEnv,
+		Nice:     req.Nice,
+	})
+
+	var stdout, stderr io.ReadCloser
+	if req.TTY {
+		ptmx, err := startTTY(cmd)
+		if err != nil {
+			writeRunStartError(w, r, req, err)
+			return
+		}
+		defer ptmx.Close()
+		// The terminal merges both streams
+		stdout, stderr = ttyOutput{ptmx}, io.NopCloser(strings.NewReader(""))
+	} else {
+		stdoutFile, stderrFile, err := files.open()
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to open output files", "stdout_file", files.StdoutFile, "stderr_file", files.StderrFile, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		// The command has its own copies once started
+		defer closeOutputFiles(stdoutFile, stderrFile)
+
+		// A redirected stream reads as empty
+		stdout, stderr = io.NopCloser(strings.NewReader("")), io.NopCloser(strings.NewReader(""))
+		if stdoutFile != nil {
+			cmd.Stdout = stdoutFile
+		} else if stdout, err = cmd.StdoutPipe(); err != nil {
+			writeRunStartError(w, r, req, fmt.Errorf("failed to get stdout: %w", err))
+			return
+		}
+		if stderrFile != nil {
+			cmd.Stderr = stderrFile
+		} else if stderr, err = cmd.StderrPipe(); err != nil {
+			writeRunStartError(w, r, req, fmt.Errorf("failed to get stderr: %w", err))
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			writeRunStartError(w, r, req, err)
+			return
+		}
+	}
+	applyNice(r.Context(), cmd, req.Nice)
+
+	// Read both streams at once so neither blocks the command on a full pipe
+	var outBytes, errBytes []byte
+	var outTruncated, errTruncated bool
+	kill := func() { killProcessGroup(cmd) }
+	var wg sync.WaitGroup
+	wg.Go(func() { outBytes, outTruncated = readCapped(stdout, s.maxOutputBytes, kill) })
+	wg.Go(func() { errBytes, errTruncated = readCapped(stderr, s.maxOutputBytes, kill) })
+	wg.Wait()
 	cmd.Wait()
 
 	exitCode := cmd.ProcessState.ExitCode()
-	slog.Debug("Command completed",
+	signal := terminatingSignal(cmd.ProcessState)
+	slog.DebugContext(r.Context(), "Command completed",
 		"cmd", req.Cmd,
 		"exit_code", exitCode,
+		"signal", signal,
 		"stdout", string(outBytes),
 		"stderr", string(errBytes))
 
 	resp := RunResponse{
-		Stdout: string(outBytes),
-		Stderr: string(errBytes),
-		Code:   exitCode,
+		Stdout:    string(outBytes),
+		Stderr:    string(errBytes),
+		Started:   true,
+		ExitCode:  exitCode,
+		Code:      exitCode,
+		Signaled:  signal != "",
+		Signal:    signal,
+		Reason:    limitReason(cmd.ProcessState, req.Limits),
+		Truncated: outTruncated || errTruncated,
 	}
-	if exitCode != 0 {
-		resp.Error = "Non-zero exit code"
+	if resp.Truncated {
+		resp.Error = fmt.Sprintf("Output exceeded %d bytes, command killed", s.maxOutputBytes)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// writeRunStartError answers a /run whose command could not be started, such
+// as a missing program or an unusable working directory. It is reported in
+// the shape of a result, not started, so clients read every outcome the same
+// way.
+func writeRunStartError(w http.ResponseWriter, r *http.Request, req RunRequest, err error) {
+	slog.DebugContext(r.Context(), "Failed to start command", "cmd", req.Cmd, "argv", req.Argv, "error", err)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RunResponse{
+		ExitCode: -1,
+		Code:     -1,
+		Error:    fmt.Sprintf("Failed to start command: %s", err),
+	})
+}
+
 // Process management handlers
 
 type StartProcessRequest struct {
-	Cmd string            `json:"cmd"`
-	Cwd string            `json:"cwd,omitempty"`
-	Env map[string]string `json:"env,omitempty"`
+	Cmd    string            `json:"cmd"`
+	Cwd    string            `json:"cwd,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	Limits *ResourceLimits   `json:"limits,omitempty"`
+	// Argv runs a program with arguments directly, without a shell, and
+	// takes precedence over Cmd
+	Argv []string `json:"argv,omitempty"`
+	// MaxLogEntries is the number of lines kept per output stream
+	MaxLogEntries int `json:"max_log_entries,omitempty"`
+	// PersistLogs also writes the output to the server's log directory
+	PersistLogs bool `json:"persist_logs,omitempty"`
+	// Labels tag the process so it can be filtered with ?label=key=value
+	Labels map[string]string `json:"labels,omitempty"`
+	// TTY runs the process under a pseudo-terminal; its output is all
+	// logged as stdout
+	TTY bool `json:"tty,omitempty"`
+	// User runs the process as this user, a name or a uid
+	User string `json:"user,omitempty"`
+	// Shell runs Cmd instead of the server's default shell. Ignored for Argv
+	Shell string `json:"shell,omitempty"`
+	// CleanEnv runs the process with only Env and a default PATH instead of
+	// the executor's environment
+	CleanEnv bool `json:"clean_env,omitempty"`
+	// Nice runs the process at a lower (1..19) or higher (-20..-1) priority.
+	// Values are clamped, and a higher priority needs privileges the
+	// executor may not have; see applyNice.
+	Nice int `json:"nice,omitempty"`
+	// ExpandEnv and UnknownEnv resolve variable references in Env and Cwd,
+	// as for RunRequest. The expanded values are kept across restarts.
+	ExpandEnv  bool   `json:"expand_env,omitempty"`
+	UnknownEnv string `json:"unknown_env,omitempty"`
+	// NotifyURL receives a POST with a ProcessExitNotification when the
+	// process exits
+	NotifyURL string `json:"notify_url,omitempty"`
+	// Readiness tells when the process is ready to serve, see
+	// ReadinessProbe. Its outcome is reported as "ready".
+	Readiness *ReadinessProbe `json:"readiness,omitempty"`
+	// OutputFiles writes the output to files instead of the process logs
+	OutputFiles
 }
 
 type StartProcessResponse struct {
 	ID     string `json:"id"`
 	PID    int    `json:"pid"`
 	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	// LogsURL follows the output of the process from its first line
+	LogsURL string `json:"logs_url"`
+}
+
+// processLogsURL returns the path streaming the logs of a process over SSE
+func processLogsURL(id string) string {
+	return "/process_logs_streaming?id=" + url.QueryEscape(id)
 }
 
 func (s *Server) startProcessHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req StartProcessRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditCommand(r.Context(), req.Cmd, req.Argv)
+
+	if req.Cmd == "" && len(req.Argv) == 0 {
+		writeErrorDetails(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "cmd or argv is required", map[string]interface{}{"reason": decodeReasonMissingField, "field": "cmd"})
+		return
+	}
+
+	if err := req.expandEnv(); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateCwd(req.Cwd); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if !checkCommandUser(w, req.User) {
+		return
+	}
+	shell, ok := s.commandShell(w, req.Shell)
+	if !ok {
 		return
 	}
 
-	if req.Cmd == "" {
-		http.Error(w, "Command is required", http.StatusBadRequest)
+	if req.NotifyURL != "" {
+		if err := validateNotifyURL(req.NotifyURL); err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid notify_url: %v", err))
+			return
+		}
+	}
+
+	if req.MaxLogEntries < 0 || req.MaxLogEntries > MaxLogEntriesLimit {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("max_log_entries must be between 0 and %d", MaxLogEntriesLimit))
 		return
 	}
 
-	if req.Cwd != "" {
-		if info, err := os.Stat(req.Cwd); err != nil || !info.IsDir() {
-			http.Error(w, fmt.Sprintf("Invalid working directory: %s", req.Cwd), http.StatusBadRequest)
+	if req.Readiness != nil {
+		if err := req.Readiness.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid readiness probe: %v", err))
 			return
 		}
 	}
 
-	slog.Debug("Start process request", "cmd", req.Cmd, "cwd", req.Cwd, "env", req.Env)
+	files, ok := s.resolveOutputFiles(w, req.OutputFiles, req.TTY)
+	if !ok {
+		return
+	}
 
-	process, err := s.processManager.StartProcess(req.Cmd, req.Cwd, req.Env)
+	slog.DebugContext(r.Context(), "Start process request", "cmd", req.Cmd, "argv", req.Argv, "cwd", req.Cwd, "env", req.Env, "limits", req.Limits, "max_log_entries", req.MaxLogEntries, "labels", req.Labels, "tty", req.TTY, "user", req.User, "shell", shell, "clean_env", req.CleanEnv, "nice", req.Nice, "notify_url", req.NotifyURL, "readiness", req.Readiness, "stdout_file", files.StdoutFile, "stderr_file", files.StderrFile, "append_output", files.AppendOutput)
+
+	process, err := s.processManager.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{
+			Command:  req.Cmd,
+			Cwd:      req.Cwd,
+			Env:      req.Env,
+			Limits:   req.Limits,
+			Argv:     req.Argv,
+			TTY:      req.TTY,
+			User:     req.User,
+			Shell:    shell,
+			CleanEnv: req.CleanEnv,
+			Nice:     req.Nice,
+		},
+		MaxLogEntries: req.MaxLogEntries,
+		PersistLogs:   req.PersistLogs,
+		Labels:        req.Labels,
+		NotifyURL:     req.NotifyURL,
+		Readiness:     req.Readiness,
+		OutputFiles:   files,
+	})
 	if err != nil {
-		slog.Debug("Failed to start process", "cmd", req.Cmd, "error", err)
-		resp := StartProcessResponse{
-			Error: err.Error(),
+		slog.DebugContext(r.Context(), "Failed to start process", "cmd", req.Cmd, "error", err)
+		switch {
+		case errors.Is(err, errLogPersistenceDisabled) || errors.As(err, new(invalidCwdError)):
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		case errors.As(err, new(outputFileError)):
+			writeFileError(w, err)
+		case errors.Is(err, errTooManyProcesses):
+			writeError(w, http.StatusTooManyRequests, ErrorCodeRateLimited, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(resp)
 		return
 	}
 
-	slog.Debug("Process started via API", "id", process.ID, "pid", process.PID, "cmd", req.Cmd)
+	slog.DebugContext(r.Context(), "Process started via API", "id", process.ID, "pid", process.PID, "cmd", req.Cmd)
 
+	process.mu.RLock()
 	resp := StartProcessResponse{
-		ID:     process.ID,
-		PID:    process.PID,
-		Status: string(process.Status),
+		ID:      process.ID,
+		PID:     process.PID,
+		Status:  string(process.Status),
+		LogsURL: processLogsURL(process.ID),
 	}
+	process.mu.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -247,20 +690,45 @@ type ListProcessesResponse struct {
 
 func (s *Server) listProcessesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+
+	// Each ?label=key=value narrows the list; all of them must match
+	selector := make(map[string]string)
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid label filter %q: expected key=value", label))
+			return
+		}
+		selector[key] = value
+	}
+
+	status := ProcessStatus(query.Get("status"))
+	switch status {
+	case "", ProcessStatusRunning, ProcessStatusCompleted, ProcessStatusFailed, ProcessStatusKilled:
+	default:
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid status filter %q: expected running, completed, failed or killed", status))
 		return
 	}
 
-	slog.Debug("Listing processes")
+	filter := ProcessFilter{
+		Labels:          selector,
+		Status:          status,
+		CommandContains: query.Get("command_contains"),
+	}
 
-	processes := s.processManager.ListProcesses()
+	slog.DebugContext(r.Context(), "Listing processes", "labels", selector, "status", status, "command_contains", filter.CommandContains)
 
-	processesData := make([]map[string]interface{}, len(processes))
-	for i, p := range processes {
-		processesData[i] = p.ToSummaryJSON()
+	processesData := make([]map[string]interface{}, 0)
+	for _, p := range s.processManager.FilterProcesses(filter) {
+		processesData = append(processesData, p.ToSummaryJSON())
 	}
 
-	slog.Debug("Processes listed", "count", len(processes))
+	slog.DebugContext(r.Context(), "Processes listed", "count", len(processesData))
 
 	resp := ListProcessesResponse{
 		Processes: processesData,
@@ -270,6 +738,31 @@ func (s *Server) listProcessesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (s *Server) getProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	processID := r.URL.Query().Get("id")
+	if processID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Get process request", "id", processID)
+
+	process, err := s.processManager.GetProcess(processID)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to get process", "id", processID, "error", err)
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(process.ToJSON())
+}
+
 type KillProcessRequest struct {
 	ID string `json:"id"`
 }
@@ -277,42 +770,36 @@ type KillProcessRequest struct {
 type KillProcessResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
 }
 
 func (s *Server) killProcessHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req KillProcessRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
+	auditTarget(r.Context(), req.ID)
 
 	if req.ID == "" {
-		http.Error(w, "Process ID is required", http.StatusBadRequest)
+		writeMissingField(w, "id")
 		return
 	}
 
-	slog.Debug("Kill process request", "id", req.ID)
+	slog.DebugContext(r.Context(), "Kill process request", "id", req.ID)
 
 	err := s.processManager.KillProcess(req.ID)
 	if err != nil {
-		slog.Debug("Failed to kill process", "id", req.ID, "error", err)
-		resp := KillProcessResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(resp)
+		slog.DebugContext(r.Context(), "Failed to kill process", "id", req.ID, "error", err)
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 		return
 	}
 
-	slog.Debug("Process killed successfully via API", "id", req.ID)
+	slog.DebugContext(r.Context(), "Process killed successfully via API", "id", req.ID)
 
 	resp := KillProcessResponse{
 		Success: true,
@@ -323,363 +810,2067 @@ func (s *Server) killProcessHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) processLogsStreamingHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) processStatsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Get process ID from query parameter
 	processID := r.URL.Query().Get("id")
 	if processID == "" {
-		http.Error(w, "Process ID is required", http.StatusBadRequest)
+		writeMissingField(w, "id")
 		return
 	}
 
-	slog.Debug("Streaming process logs request", "id", processID)
+	slog.DebugContext(r.Context(), "Process stats request", "id", processID)
 
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Content-Type", "application/json")
 
-	writer, err := newSSEWriter(w)
+	stats, err := s.processManager.ProcessStats(processID)
 	if err != nil {
-		slog.Debug("Failed to create SSE writer for process logs", "id", processID, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.DebugContext(r.Context(), "Failed to get process stats", "id", processID, "error", err)
+		switch {
+		case errors.Is(err, errProcessNotFound):
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		case errors.Is(err, errProcessNotRunning):
+			writeError(w, http.StatusConflict, ErrorCodeConflict, err.Error())
+		case errors.Is(err, errStatsUnsupported):
+			writeError(w, http.StatusNotImplemented, ErrorCodeNotImplemented, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		}
 		return
 	}
 
-	logChan, err := s.processManager.StreamProcessLogs(processID)
-	if err != nil {
-		slog.Debug("Failed to stream process logs", "id", processID, "error", err)
-		writer.writeEventf("error", "{\"error\": \"%s\"}", err.Error())
+	json.NewEncoder(w).Encode(stats)
+}
+
+// processTreeHandler shows the OS processes a managed process started,
+// including those still running after it exited
+func (s *Server) processTreeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	slog.Debug("Started streaming process logs", "id", processID)
+	processID := r.URL.Query().Get("id")
+	if processID == "" {
+		writeMissingField(w, "id")
+		return
+	}
 
-	// Stream logs as they arrive
-	logCount := 0
-	for entry := range logChan {
-		data, _ := json.Marshal(entry)
-		writer.writeEvent("log", string(data))
-		logCount++
+	slog.DebugContext(r.Context(), "Process tree request", "id", processID)
+
+	tree, err := s.processManager.ProcessTree(processID)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to get process tree", "id", processID, "error", err)
+		switch {
+		case errors.Is(err, errProcessNotFound):
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		case errors.Is(err, errProcTreeUnsupported):
+			writeError(w, http.StatusNotImplemented, ErrorCodeNotImplemented, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		}
+		return
 	}
 
-	slog.Debug("Process logs stream ended", "id", processID, "logs_sent", logCount)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+type WaitProcessRequest struct {
+	ID string `json:"id"`
+	// TimeoutMs bounds the wait; zero waits until the process exits
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+	// Until is "exit" (the default) to wait for the process to exit, or
+	// "ready" to wait for its readiness probe to pass or give up
+	Until string `json:"until,omitempty"`
+}
 
-	// Send completion event
-	writer.writeEvent("complete", "{\"message\": \"stream ended\"}")
+type WaitProcessResponse struct {
+	ID       string        `json:"id"`
+	Status   ProcessStatus `json:"status"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	TimedOut bool          `json:"timed_out"`
+	// Ready is the readiness of processes started with a readiness probe
+	Ready *bool `json:"ready,omitempty"`
 }
 
-func (s *Server) runStreamingHandler(w http.ResponseWriter, r *http.Request) {
-	var req RunRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) waitProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	if req.Cwd != "" {
-		if info, err := os.Stat(req.Cwd); err != nil || !info.IsDir() {
-			http.Error(w, fmt.Sprintf("Invalid working directory: %s", req.Cwd), http.StatusBadRequest)
-			return
-		}
+	var req WaitProcessRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
 	}
 
-	slog.Debug("Executing streaming command", "cmd", req.Cmd, "cwd", req.Cwd, "env", req.Env)
+	if req.ID == "" {
+		writeMissingField(w, "id")
+		return
+	}
 
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	if req.TimeoutMs < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "timeout_ms must not be negative")
+		return
+	}
 
-	writer, err := newSSEWriter(w)
+	slog.DebugContext(r.Context(), "Wait process request", "id", req.ID, "timeout_ms", req.TimeoutMs, "until", req.Until)
+
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	var exited bool
+	var err error
+	switch req.Until {
+	case "", "exit":
+		exited, err = s.processManager.WaitProcess(r.Context(), req.ID, timeout)
+	case "ready":
+		exited, err = s.processManager.WaitReady(r.Context(), req.ID, timeout)
+	default:
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid until %q: expected exit or ready", req.Until))
+		return
+	}
+	if errors.Is(err, errNoReadinessProbe) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
 	if err != nil {
-		slog.Debug("Failed to create SSE writer", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
 		return
 	}
 
-	// Create context for goroutine lifecycle management
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+	process, err := s.processManager.GetProcess(req.ID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	process.mu.RLock()
+	resp := WaitProcessResponse{
+		ID:       process.ID,
+		Status:   process.Status,
+		ExitCode: process.ExitCode,
+		TimedOut: !exited,
+	}
+	process.mu.RUnlock()
+	if probe := process.readiness.Load(); probe != nil {
+		ready, _ := probe.state()
+		resp.Ready = &ready
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type SignalProcessRequest struct {
+	ID string `json:"id"`
+	// Signal is a name ("SIGTERM") or number (15)
+	Signal json.RawMessage `json:"signal"`
+}
 
-	cmd := exec.CommandContext(ctx, "sh", "-c", req.Cmd)
+type SignalProcessResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
 
-	// Set working directory if provided
-	if req.Cwd != "" {
-		cmd.Dir = req.Cwd
+func (s *Server) signalProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
 	}
 
-	// Set environment variables if provided
-	if len(req.Env) > 0 {
-		cmd.Env = os.Environ()
-		for key, value := range req.Env {
-			cmd.Env = append(cmd.Env, key+"="+value)
-		}
+	var req SignalProcessRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
 	}
+	auditTarget(r.Context(), req.ID)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		slog.Debug("Failed to get stdout pipe for streaming", "error", err)
-		writer.writeEvent("error", "{\"error\": \"Failed to get stdout\"}")
+	if req.ID == "" {
+		writeMissingField(w, "id")
 		return
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		slog.Debug("Failed to get stderr pipe for streaming", "error", err)
-		writer.writeEvent("error", "{\"error\": \"Failed to get stderr\"}")
+	if len(req.Signal) == 0 {
+		writeMissingField(w, "signal")
 		return
 	}
 
-	if err = cmd.Start(); err != nil {
-		slog.Debug("Failed to start streaming command", "cmd", req.Cmd, "error", err)
-		writer.writeEvent("error", "{\"error\": \"Failed to start command\"}")
+	// Accept both "SIGTERM" and 15
+	value := string(req.Signal)
+	var name string
+	if err := json.Unmarshal(req.Signal, &name); err == nil {
+		value = name
+	}
+	sig, err := parseSignal(value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 		return
 	}
 
-	// WaitGroup to track completion of both stdout and stderr goroutines
-	var wg sync.WaitGroup
+	slog.DebugContext(r.Context(), "Signal process request", "id", req.ID, "signal", signalName(sig))
 
-	// streamOutput emits one SSE output event per line, matching the documented
-	// behaviour. bufio.Reader.ReadString reads complete lines of any length without
-	// a hard token limit and never splits a multi-byte UTF-8 sequence across events.
-	streamOutput := func(r io.Reader, stream string) {
-		reader := bufio.NewReader(r)
-		for {
-			line, err := reader.ReadString('\n')
-			if len(line) > 0 {
-				line = strings.TrimRight(line, "\r\n")
-				slog.Debug("Command output", "cmd", req.Cmd, "stream", stream, "line", line)
-				data, _ := json.Marshal(map[string]string{"stream": stream, "data": line})
-				writer.writeEvent("output", string(data))
-			}
-			if err != nil {
-				if err != io.EOF {
-					slog.Debug("read error", "stream", stream, "error", err)
-				}
-				return
-			}
+	if err := s.processManager.SignalProcess(req.ID, sig); err != nil {
+		slog.DebugContext(r.Context(), "Failed to signal process", "id", req.ID, "error", err)
+		if errors.Is(err, errProcessNotFound) {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		} else {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 		}
+		return
 	}
 
-	// Stream stdout
-	wg.Go(func() { streamOutput(stdout, "stdout") })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SignalProcessResponse{
+		Success: true,
+		Message: fmt.Sprintf("Sent %s to process", signalName(sig)),
+	})
+}
 
-	// Stream stderr
-	wg.Go(func() { streamOutput(stderr, "stderr") })
+type TerminateProcessRequest struct {
+	ID string `json:"id"`
+	// GracePeriod is the number of seconds to wait after SIGTERM before
+	// sending SIGKILL (default 10)
+	GracePeriod *float64 `json:"grace_period,omitempty"`
+}
+
+type TerminateProcessResponse struct {
+	Success bool `json:"success"`
+	// Graceful is false when the process had to be killed with SIGKILL
+	Graceful bool   `json:"graceful"`
+	Status   string `json:"status,omitempty"`
+}
+
+func (s *Server) terminateProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req TerminateProcessRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditTarget(r.Context(), req.ID)
+
+	if req.ID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	grace := DefaultTerminateGracePeriod
+	if req.GracePeriod != nil {
+		if *req.GracePeriod < 0 {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "grace_period must not be negative")
+			return
+		}
+		grace = time.Duration(*req.GracePeriod * float64(time.Second))
+	}
+
+	slog.DebugContext(r.Context(), "Terminate process request", "id", req.ID, "grace", grace)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	graceful, err := s.processManager.TerminateProcess(req.ID, grace)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to terminate process", "id", req.ID, "error", err)
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	resp := TerminateProcessResponse{Success: true, Graceful: graceful}
+	if process, err := s.processManager.GetProcess(req.ID); err == nil {
+		process.mu.RLock()
+		resp.Status = string(process.Status)
+		process.mu.RUnlock()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type KillAllProcessesRequest struct {
+	// CommandContains limits the kill to processes whose command contains it
+	CommandContains string `json:"command_contains,omitempty"`
+}
+
+type KillAllProcessesResponse struct {
+	Killed []string          `json:"killed"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (s *Server) killAllProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// The body is optional; an empty one kills every running process
+	var req KillAllProcessesRequest
+	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	auditTarget(r.Context(), req.CommandContains)
+
+	slog.DebugContext(r.Context(), "Kill all processes request", "command_contains", req.CommandContains)
+
+	killed, failed := s.processManager.KillAll(req.CommandContains)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(KillAllProcessesResponse{
+		Killed: killed,
+		Errors: failed,
+	})
+}
+
+type RestartProcessRequest struct {
+	ID string `json:"id"`
+}
+
+func (s *Server) restartProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req RestartProcessRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.ID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	auditTarget(r.Context(), req.ID)
+
+	slog.DebugContext(r.Context(), "Restart process request", "id", req.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	process, err := s.processManager.RestartProcess(req.ID)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to restart process", "id", req.ID, "error", err)
+		switch {
+		case errors.Is(err, errProcessNotFound):
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		case errors.Is(err, errProcessRunning):
+			writeError(w, http.StatusConflict, ErrorCodeConflict, err.Error())
+		case errors.Is(err, errTooManyProcesses):
+			writeError(w, http.StatusTooManyRequests, ErrorCodeRateLimited, err.Error())
+		case errors.As(err, new(invalidCwdError)):
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		case errors.As(err, new(outputFileError)):
+			writeFileError(w, err)
+		default:
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		}
+		return
+	}
+
+	process.mu.RLock()
+	resp := StartProcessResponse{
+		ID:      process.ID,
+		PID:     process.PID,
+		Status:  string(process.Status),
+		LogsURL: processLogsURL(process.ID),
+	}
+	process.mu.RUnlock()
+
+	slog.DebugContext(r.Context(), "Process restarted via API", "id", resp.ID, "pid", resp.PID)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+type RemoveProcessRequest struct {
+	ID string `json:"id"`
+}
+
+type RemoveProcessResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *Server) removeProcessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req RemoveProcessRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.ID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	auditTarget(r.Context(), req.ID)
+
+	slog.DebugContext(r.Context(), "Remove process request", "id", req.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := s.processManager.RemoveProcess(req.ID); err != nil {
+		slog.DebugContext(r.Context(), "Failed to remove process", "id", req.ID, "error", err)
+		if errors.Is(err, errProcessRunning) {
+			writeError(w, http.StatusConflict, ErrorCodeConflict, err.Error())
+		} else {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(RemoveProcessResponse{
+		Success: true,
+		Message: "Process removed successfully",
+	})
+}
+
+type PruneProcessesRequest struct {
+	// OlderThan, when positive, only prunes processes that ended more than
+	// this many seconds ago
+	OlderThan float64 `json:"older_than,omitempty"`
+	// Status, when set, only prunes processes with this final status
+	Status ProcessStatus `json:"status,omitempty"`
+	// Labels, when set, must all be carried by the pruned processes
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type PruneProcessesResponse struct {
+	Success bool     `json:"success"`
+	Count   int      `json:"count"`
+	Removed []string `json:"removed"`
+}
+
+// pruneProcessesHandler removes the finished processes matching every given
+// criterion, so clients can bound the process table on their own schedule
+func (s *Server) pruneProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req PruneProcessesRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	switch req.Status {
+	case "", ProcessStatusCompleted, ProcessStatusFailed, ProcessStatusKilled:
+	default:
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid status %q: expected completed, failed or killed", req.Status))
+		return
+	}
+	if req.OlderThan < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "older_than must not be negative")
+		return
+	}
+
+	var endedBefore time.Time
+	if req.OlderThan > 0 {
+		endedBefore = time.Now().Add(-time.Duration(req.OlderThan * float64(time.Second)))
+	}
+
+	slog.DebugContext(r.Context(), "Prune processes request", "older_than", req.OlderThan, "status", req.Status, "labels", req.Labels)
+
+	removed := s.processManager.PruneProcesses(ProcessFilter{Labels: req.Labels, Status: req.Status}, endedBefore)
+	if removed == nil {
+		removed = []string{}
+	}
+	auditTarget(r.Context(), strings.Join(removed, ","))
+
+	slog.DebugContext(r.Context(), "Processes pruned", "count", len(removed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PruneProcessesResponse{
+		Success: true,
+		Count:   len(removed),
+		Removed: removed,
+	})
+}
+
+type ProcessLogsResponse struct {
+	Entries []LogEntry `json:"entries"`
+	// Dropped counts older lines of the requested streams that were evicted
+	// from the log buffer; non-zero means the logs are incomplete
+	Dropped uint64 `json:"dropped"`
+}
+
+// logFilter keeps the log lines containing grep, or matching it as a regular
+// expression when regex=true. A nil logFilter keeps every line.
+type logFilter func(data string) bool
+
+func parseLogFilter(query url.Values) (logFilter, error) {
+	pattern := query.Get("grep")
+	var regex bool
+	switch query.Get("regex") {
+	case "", "false":
+	case "true":
+		regex = true
+	default:
+		return nil, fmt.Errorf("regex must be true or false")
+	}
+	if pattern == "" {
+		if regex {
+			return nil, fmt.Errorf("regex requires grep")
+		}
+		return nil, nil
+	}
+	if !regex {
+		return func(data string) bool { return strings.Contains(data, pattern) }, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grep pattern: %v", err)
+	}
+	return re.MatchString, nil
+}
+
+func (f logFilter) keep(entry LogEntry) bool {
+	return f == nil || f(entry.Data)
+}
+
+func (s *Server) processLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	processID := query.Get("id")
+	if processID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	stream := query.Get("stream")
+	if stream != "" && stream != "stdout" && stream != "stderr" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Stream must be stdout or stderr")
+		return
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Limit must be a non-negative integer")
+			return
+		}
+		limit = parsed
+	}
+
+	filter, err := parseLogFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Process logs request", "id", processID, "stream", stream, "limit", limit, "grep", query.Get("grep"))
+
+	process, err := s.processManager.GetProcess(processID)
+	var logs []LogEntry
+	if err == nil {
+		logs, err = s.processManager.GetProcessLogs(processID)
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to get process logs", "id", processID, "error", err)
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	var dropped uint64
+	switch stream {
+	case "stdout":
+		dropped = process.stdout.Dropped()
+	case "stderr":
+		dropped = process.stderr.Dropped()
+	default:
+		dropped = process.DroppedLogs()
+	}
+
+	entries := make([]LogEntry, 0, len(logs))
+	for _, entry := range logs {
+		if (stream == "" || entry.Stream == stream) && filter.keep(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProcessLogsResponse{Entries: entries, Dropped: dropped})
+}
+
+func (s *Server) processLogsDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	processID := query.Get("id")
+	if processID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	stream := query.Get("stream")
+	if stream == "" {
+		stream = "stdout"
+	}
+	if stream != "stdout" && stream != "stderr" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Stream must be stdout or stderr")
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Process logs download request", "id", processID, "stream", stream)
+
+	file, err := s.processManager.OpenProcessLogFile(processID, stream)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to open process log file", "id", processID, "stream", stream, "error", err)
+		message := err.Error()
+		if errors.Is(err, os.ErrNotExist) {
+			message = fmt.Sprintf("no persisted %s log for process: %s", stream, processID)
+		}
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, message)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(processLogPath("", processID, stream)),
+	}))
+	if _, err := io.Copy(w, file); err != nil {
+		slog.DebugContext(r.Context(), "Failed to stream process log file", "id", processID, "error", err)
+	}
+}
+
+func (s *Server) processLogsStreamingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get process ID from query parameter
+	processID := r.URL.Query().Get("id")
+	if processID == "" {
+		writeMissingField(w, "id")
+		return
+	}
+
+	// Resume after a given sequence number, either explicitly or from the
+	// SSE Last-Event-ID header sent on reconnect
+	var sinceSeq uint64
+	if value := r.URL.Query().Get("since_seq"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "since_seq must be a non-negative integer")
+			return
+		}
+		sinceSeq = parsed
+	} else if value := r.Header.Get("Last-Event-ID"); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 64); err == nil {
+			sinceSeq = parsed
+		}
+	}
+
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Streaming process logs request", "id", processID, "since_seq", sinceSeq, "grep", r.URL.Query().Get("grep"))
+
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writer, err := newSSEWriter(w)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create SSE writer for process logs", "id", processID, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	logChan, err := s.processManager.StreamProcessLogsSince(r.Context(), processID, sinceSeq)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to stream process logs", "id", processID, "error", err)
+		writer.writeEventf("error", "{\"error\": \"%s\"}", err.Error())
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Started streaming process logs", "id", processID)
+
+	// Stream logs as they arrive
+	logCount := 0
+	for entry := range logChan {
+		if !filter.keep(entry) {
+			continue
+		}
+		data, _ := json.Marshal(entry)
+		writer.writeEventWithID(entry.Seq, "log", string(data))
+		logCount++
+	}
+
+	slog.DebugContext(r.Context(), "Process logs stream ended", "id", processID, "logs_sent", logCount)
+
+	// Send completion event
+	writer.writeEvent("complete", "{\"message\": \"stream ended\"}")
+}
+
+func (s *Server) runStreamingHandler(w http.ResponseWriter, r *http.Request) {
+	var req RunRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditCommand(r.Context(), req.Cmd, req.Argv)
+
+	if err := req.expandEnv(); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateCwd(req.Cwd); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if !checkCommandUser(w, req.User) {
+		return
+	}
+	shell, ok := s.commandShell(w, req.Shell)
+	if !ok {
+		return
+	}
+	if rejectOutputFiles(w, req.OutputFiles) {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Executing streaming command", "cmd", req.Cmd, "argv", req.Argv, "cwd", req.Cwd, "env", req.Env, "tty", req.TTY, "user", req.User, "shell", shell, "clean_env", req.CleanEnv, "nice", req.Nice)
+
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writer, err := newSSEWriter(w)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create SSE writer", "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Create context for goroutine lifecycle management
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	cmd := newCommand(ctx, CommandOptions{
+		Command:  req.Cmd,
+		Cwd:      req.Cwd,
+		Env:      req.Env,
+		Limits:   req.Limits,
+		Argv:     req.Argv,
+		User:     req.User,
+		Shell:    shell,
+		CleanEnv: req.CleanEnv,
+		Nice:     req.Nice,
+	})
+
+	var stdout, stderr io.Reader
+	if req.TTY {
+		ptmx, err := startTTY(cmd)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to start streaming command", "cmd", req.Cmd, "error", err)
+			writer.writeEvent("error", "{\"error\": \"Failed to start command\"}")
+			return
+		}
+		defer ptmx.Close()
+		// The terminal merges both streams
+		stdout, stderr = ttyOutput{ptmx}, strings.NewReader("")
+	} else {
+		outPipe, err := cmd.StdoutPipe()
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to get stdout pipe for streaming", "error", err)
+			writer.writeEvent("error", "{\"error\": \"Failed to get stdout\"}")
+			return
+		}
+
+		errPipe, err := cmd.StderrPipe()
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to get stderr pipe for streaming", "error", err)
+			writer.writeEvent("error", "{\"error\": \"Failed to get stderr\"}")
+			return
+		}
+
+		if err = cmd.Start(); err != nil {
+			slog.DebugContext(r.Context(), "Failed to start streaming command", "cmd", req.Cmd, "error", err)
+			writer.writeEvent("error", "{\"error\": \"Failed to start command\"}")
+			return
+		}
+		stdout, stderr = outPipe, errPipe
+	}
+	applyNice(r.Context(), cmd, req.Nice)
+
+	// WaitGroup to track completion of both stdout and stderr goroutines
+	var wg sync.WaitGroup
+
+	// streamOutput emits one SSE output event per line, matching the documented
+	// behaviour. bufio.Reader.ReadString reads complete lines of any length without
+	// a hard token limit and never splits a multi-byte UTF-8 sequence across events.
+	streamOutput := func(pipe io.Reader, stream string) {
+		reader := bufio.NewReader(pipe)
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 {
+				line = strings.TrimRight(line, "\r\n")
+				slog.DebugContext(r.Context(), "Command output", "cmd", req.Cmd, "stream", stream, "line", line)
+				data, _ := json.Marshal(map[string]string{"stream": stream, "data": line})
+				writer.writeSequencedEvent("output", string(data))
+			}
+			if err != nil {
+				if err != io.EOF {
+					slog.DebugContext(r.Context(), "read error", "stream", stream, "error", err)
+				}
+				return
+			}
+		}
+	}
+
+	// Stream stdout
+	wg.Go(func() { streamOutput(stdout, "stdout") })
+
+	// Stream stderr
+	wg.Go(func() { streamOutput(stderr, "stderr") })
 
 	// Wait for both stdout and stderr goroutines to drain the pipes before calling
 	// cmd.Wait(), which closes the pipe read-ends and would lose buffered data.
 	wg.Wait()
 
-	// Reap the process and get the exit code.
-	err = cmd.Wait()
-	exitCode := 0
-	if cmd.ProcessState != nil {
-		exitCode = cmd.ProcessState.ExitCode()
+	// Reap the process and get the exit code.
+	err = cmd.Wait()
+	exitCode := 0
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	signal := terminatingSignal(cmd.ProcessState)
+
+	slog.DebugContext(r.Context(), "Streaming command completed", "cmd", req.Cmd, "exit_code", exitCode, "signal", signal)
+
+	// Send completion event
+	complete := map[string]interface{}{
+		"code":  exitCode,
+		"error": err != nil,
+	}
+	if signal != "" {
+		complete["signaled"] = true
+		complete["signal"] = signal
+	}
+	if reason := limitReason(cmd.ProcessState, req.Limits); reason != "" {
+		complete["reason"] = reason
+	}
+	completeData, _ := json.Marshal(complete)
+	writer.writeEvent("complete", string(completeData))
+}
+
+type BindPortRequest struct {
+	// Port is the target port connections are forwarded to
+	Port string `json:"port"`
+	// TargetHost is the host to forward to (default localhost), or
+	// unix:/path to forward to a unix socket, in which case Port is unused
+	TargetHost string `json:"target_host,omitempty"`
+	// ListenPort, when set, opens an extra public port forwarding to the
+	// target instead of configuring the default proxy port
+	ListenPort string `json:"listen_port,omitempty"`
+	// RateLimit caps each direction of the forwarded connections, in bytes
+	// per second. Zero uses SANDBOX_PROXY_RATE_LIMIT.
+	RateLimit int64 `json:"rate_limit,omitempty"`
+}
+
+func (s *Server) bindPortHandler(w http.ResponseWriter, r *http.Request) {
+	var req BindPortRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	target, ok := s.proxyTarget(w, req)
+	if !ok {
+		return
+	}
+
+	if req.ListenPort != "" {
+		s.bindListenPort(w, r, req.ListenPort, target)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Binding port", "target", target)
+
+	// The check and the set are one step, so of two concurrent binds
+	// exactly one wins
+	if current, bound := s.tcpProxy.BindTarget(target); bound {
+		slog.DebugContext(r.Context(), "Port already bound", "current_target", current, "requested_target", target)
+		writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict, "Port already bound", map[string]interface{}{
+			"current_port":   current.Port,
+			"current_target": current.String(),
+		})
+		return
+	}
+	slog.DebugContext(r.Context(), "Port bound successfully", "target", target)
+
+	resp := map[string]interface{}{
+		"success": true,
+		"message": "Port binding configured",
+		"port":    req.Port,
+		"target":  target.String(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// proxyTarget builds the forwarding target of a bind request. It writes an
+// error response and returns ok=false when the target is invalid or not
+// allowed.
+func (s *Server) proxyTarget(w http.ResponseWriter, req BindPortRequest) (target ProxyTarget, ok bool) {
+	if req.RateLimit < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "rate_limit must not be negative")
+		return ProxyTarget{}, false
+	}
+
+	if socketPath, isUnix := strings.CutPrefix(req.TargetHost, unixTargetPrefix); isUnix {
+		if socketPath == "" {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Socket path is required")
+			return ProxyTarget{}, false
+		}
+		// Sockets are files, so they are confined to the sandbox root
+		resolved, ok := s.sandboxPath(w, socketPath)
+		if !ok {
+			return ProxyTarget{}, false
+		}
+		return ProxyTarget{SocketPath: resolved, RateLimit: req.RateLimit}, true
+	}
+
+	if req.Port == "" {
+		writeMissingField(w, "port")
+		return ProxyTarget{}, false
+	}
+	if !isValidPort(req.Port) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid port: %s", req.Port))
+		return ProxyTarget{}, false
+	}
+
+	target = localTarget(req.Port)
+	target.RateLimit = req.RateLimit
+	if req.TargetHost != "" {
+		target.Host = req.TargetHost
+	}
+	if err := s.tcpProxy.checkHost(target.Host); err != nil {
+		status, code := http.StatusBadRequest, ErrorCodeInvalidRequest
+		if errors.Is(err, errProxyHostNotAllowed) {
+			status, code = http.StatusForbidden, ErrorCodeForbidden
+		}
+		writeError(w, status, code, err.Error())
+		return ProxyTarget{}, false
+	}
+	return target, true
+}
+
+// bindListenPort opens listenPort and forwards it to target
+func (s *Server) bindListenPort(w http.ResponseWriter, r *http.Request, listenPort string, target ProxyTarget) {
+	if !isValidPort(listenPort) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid port: %s", listenPort))
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Binding listen port", "listen_port", listenPort, "target", target)
+
+	if err := s.tcpProxy.Bind(listenPort, target); err != nil {
+		slog.DebugContext(r.Context(), "Failed to bind listen port", "listen_port", listenPort, "error", err)
+		if errors.Is(err, errPortAlreadyBound) {
+			writeError(w, http.StatusConflict, ErrorCodeConflict, err.Error())
+		} else {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		}
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Listen port bound successfully", "listen_port", listenPort, "target", target)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     "Port binding configured",
+		"port":        target.Port,
+		"target":      target.String(),
+		"listen_port": listenPort,
+	})
+}
+
+// isValidPort reports whether port is a TCP port number between 1 and 65535
+func isValidPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+func (s *Server) proxyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Proxy stats request")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.tcpProxy.Stats())
+}
+
+// ProxyProbeResponse tells whether a proxy target accepts connections
+type ProxyProbeResponse struct {
+	Target    ProxyTarget `json:"target"`
+	Reachable bool        `json:"reachable"`
+	// LatencyMs is how long the connection took to establish, or to fail
+	LatencyMs float64 `json:"latency_ms"`
+	// Error explains why the target is unreachable
+	Error string `json:"error,omitempty"`
+}
+
+// proxyProbeHandler dials the target of the default listener, or of the
+// listen_port binding, once and reports whether it accepted. Unlike a proxied
+// connection it does not retry, so a target that is not up yet is reported
+// unreachable rather than waited for.
+func (s *Server) proxyProbeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	timeout := DefaultProxyProbeTimeout
+	if value := query.Get("timeout_ms"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms <= 0 {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "timeout_ms must be a positive integer")
+			return
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	listenPort := query.Get("listen_port")
+	target, ok := s.tcpProxy.BindingTarget(listenPort)
+	if !ok {
+		if listenPort != "" {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("%s: %s", errPortNotBound, listenPort))
+		} else {
+			writeError(w, http.StatusConflict, ErrorCodeConflict, "No target is bound")
+		}
+		return
+	}
+
+	latency, err := target.probe(timeout)
+	resp := ProxyProbeResponse{
+		Target:    target,
+		Reachable: err == nil,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	slog.DebugContext(r.Context(), "Proxy target probed", "target", target, "reachable", resp.Reachable, "latency", latency, "error", err)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type BindUDPRequest struct {
+	// Port is the target UDP port datagrams are forwarded to
+	Port string `json:"port"`
+	// TargetHost is the host to forward to, defaults to localhost
+	TargetHost string `json:"target_host,omitempty"`
+}
+
+func (s *Server) bindUDPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req BindUDPRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if strings.HasPrefix(req.TargetHost, unixTargetPrefix) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Unix sockets are not supported for UDP")
+		return
+	}
+	target, ok := s.proxyTarget(w, BindPortRequest{Port: req.Port, TargetHost: req.TargetHost})
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Binding UDP port", "target", target)
+
+	if current, bound := s.udpProxy.GetTarget(); bound {
+		slog.DebugContext(r.Context(), "UDP port already bound", "current_target", current, "requested_target", target)
+		writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict, "UDP port already bound", map[string]interface{}{
+			"current_port":   current.Port,
+			"current_target": current.String(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	s.udpProxy.SetTarget(target)
+	slog.DebugContext(r.Context(), "UDP port bound successfully", "target", target)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "UDP port binding configured",
+		"port":    req.Port,
+		"target":  target.String(),
+	})
+}
+
+func (s *Server) unbindUDPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	current, _ := s.udpProxy.GetTarget()
+	slog.DebugContext(r.Context(), "Unbinding UDP port", "current_target", current)
+
+	s.udpProxy.ClearTarget()
+	slog.DebugContext(r.Context(), "UDP port unbound successfully")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "UDP port binding removed",
+	})
+}
+
+type UnbindPortRequest struct {
+	// ListenPort selects an extra binding created with listen_port. When
+	// empty the default proxy port is unbound.
+	ListenPort string `json:"listen_port,omitempty"`
+}
+
+func (s *Server) unbindPortHandler(w http.ResponseWriter, r *http.Request) {
+	// The body is optional for backward compatibility
+	var req UnbindPortRequest
+	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.ListenPort != "" {
+		slog.DebugContext(r.Context(), "Unbinding listen port", "listen_port", req.ListenPort)
+
+		if err := s.tcpProxy.Unbind(req.ListenPort); err != nil {
+			slog.DebugContext(r.Context(), "Failed to unbind listen port", "listen_port", req.ListenPort, "error", err)
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Port binding removed",
+		})
+		return
+	}
+
+	previous, _ := s.tcpProxy.ClearTarget()
+	slog.DebugContext(r.Context(), "Port unbound successfully", "previous_target", previous)
+
+	resp := map[string]interface{}{
+		"success": true,
+		"message": "Port binding removed",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) deleteDirHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeleteDirRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditTarget(r.Context(), req.Path)
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+	// A "." or "dir/.." path resolves to the root too: a client bug must
+	// not wipe the whole sandbox
+	if path == s.root {
+		writeError(w, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("Refusing to delete the sandbox root: %s", req.Path))
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Deleting directory", "path", req.Path, "only_if_empty", req.OnlyIfEmpty)
+
+	defer s.pathLocks.lock(path)()
+
+	if req.OnlyIfEmpty {
+		info, err := os.Lstat(path)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to stat directory", "path", req.Path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		if !info.IsDir() {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Path is not a directory: %s", req.Path))
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			slog.DebugContext(r.Context(), "Failed to delete directory", "path", req.Path, "error", err)
+			if errors.Is(err, syscall.ENOTEMPTY) {
+				writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("Directory is not empty: %s", req.Path), map[string]interface{}{"reason": "not_empty"})
+				return
+			}
+			writeFileError(w, err)
+			return
+		}
+	} else if err := os.RemoveAll(path); err != nil {
+		slog.DebugContext(r.Context(), "Failed to delete directory", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Directory deleted successfully", "path", req.Path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func (s *Server) makeDirHandler(w http.ResponseWriter, r *http.Request) {
+	var req MakeDirRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	auditTarget(r.Context(), req.Path)
+
+	slog.DebugContext(r.Context(), "Creating directory", "path", req.Path)
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		slog.DebugContext(r.Context(), "Failed to create directory", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Directory created successfully", "path", req.Path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func (s *Server) touchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req TouchRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	mtime := time.Now()
+	if req.Mtime != nil {
+		mtime = *req.Mtime
+	}
+
+	auditTarget(r.Context(), req.Path)
+
+	slog.DebugContext(r.Context(), "Touching file", "path", req.Path, "mtime", mtime)
+
+	if err := touchFile(path, mtime); err != nil {
+		slog.DebugContext(r.Context(), "Failed to touch file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TouchResponse{Success: true, Path: req.Path, Mtime: mtime})
+}
+
+func (s *Server) truncateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req TruncateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	if req.Size < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid size: %d", req.Size))
+		return
+	}
+
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	auditTarget(r.Context(), req.Path)
+
+	slog.DebugContext(r.Context(), "Truncating file", "path", req.Path, "size", req.Size)
+
+	defer s.pathLocks.lock(path)()
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Path is a directory: %s", req.Path))
+		return
+	}
+	if err := os.Truncate(path, req.Size); err != nil {
+		slog.DebugContext(r.Context(), "Failed to truncate file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "path": req.Path, "size": req.Size})
+}
+
+func (s *Server) statHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req StatRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to stat path", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	resp := StatResponse{
+		Path:      req.Path,
+		Name:      info.Name(),
+		Size:      info.Size(),
+		Mode:      fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime:   info.ModTime(),
+		IsDir:     info.IsDir(),
+		IsSymlink: info.Mode()&fs.ModeSymlink != 0,
+	}
+	if info.Mode().IsRegular() {
+		// A file that cannot be read is still stat'ed, without a type
+		if resp.ContentType, err = fileContentType(path); err != nil {
+			slog.DebugContext(r.Context(), "Failed to detect content type", "path", req.Path, "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) symlinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req SymlinkRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Target == "" {
+		writeMissingField(w, "target")
+		return
+	}
+	if req.LinkPath == "" {
+		writeMissingField(w, "link_path")
+		return
+	}
+
+	linkPath, ok := s.sandboxPath(w, req.LinkPath)
+	if !ok {
+		return
+	}
+	// The target is confined as the link would resolve it, so a link cannot
+	// be used to reach outside the root
+	target := req.Target
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
+	}
+	if _, ok := s.sandboxPath(w, target); !ok {
+		return
+	}
+
+	auditTarget(r.Context(), req.LinkPath)
+
+	slog.DebugContext(r.Context(), "Creating symlink", "target", req.Target, "link_path", req.LinkPath)
+
+	if err := os.Symlink(req.Target, linkPath); err != nil {
+		slog.DebugContext(r.Context(), "Failed to create symlink", "target", req.Target, "link_path", req.LinkPath, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "target": req.Target, "link_path": req.LinkPath})
+}
+
+// readlinkHandler returns the target of a symlink and, on request, the path
+// it finally resolves to. Links that resolve outside the sandbox root are
+// rejected by sandboxPath, like any other path.
+func (s *Server) readlinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ReadlinkRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Reading symlink", "path", req.Path, "resolve", req.Resolve)
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to stat path", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Path is not a symlink: %s", req.Path))
+		return
 	}
 
-	slog.Debug("Streaming command completed", "cmd", req.Cmd, "exit_code", exitCode)
+	target, err := os.Readlink(path)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to read symlink", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
 
-	// Send completion event
-	completeData, _ := json.Marshal(map[string]interface{}{
-		"code":  exitCode,
-		"error": err != nil,
-	})
-	writer.writeEvent("complete", string(completeData))
-}
+	resp := ReadlinkResponse{Path: req.Path, Target: target}
+	if req.Resolve {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// Dangling links and loops end up here
+			slog.DebugContext(r.Context(), "Failed to resolve symlink", "path", req.Path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		if !isWithinRoot(s.root, resolved) {
+			writeError(w, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("Forbidden: %s: %s", errPathOutsideRoot, req.Path))
+			return
+		}
+		resp.Resolved = resolved
+	}
 
-type BindPortRequest struct {
-	Port string `json:"port"`
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) bindPortHandler(w http.ResponseWriter, r *http.Request) {
-	var req BindPortRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) listDirHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListDirRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 
-	if req.Port == "" {
-		http.Error(w, "Port is required", http.StatusBadRequest)
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
 		return
 	}
 
-	slog.Debug("Binding port", "port", req.Port)
-
-	// Check if a port is already bound
-	currentPort := s.tcpProxy.GetTargetPort()
-	if currentPort != "" {
-		slog.Debug("Port already bound", "current_port", currentPort, "requested_port", req.Port)
-		resp := map[string]interface{}{
-			"success":      false,
-			"error":        "Port already bound",
-			"current_port": currentPort,
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "limit must be a positive integer")
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(resp)
+		req.Limit = parsed
+	}
+	if value := query.Get("cursor"); value != "" {
+		req.Cursor = value
+	}
+	if req.Limit < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "limit must be a positive integer")
+		return
+	}
+
+	if req.Limit > 0 {
+		s.listDirPage(w, r, req, path)
+		return
+	}
+	if req.Cursor != "" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "cursor requires a limit")
 		return
 	}
 
-	s.tcpProxy.SetTargetPort(req.Port)
-	slog.Debug("Port bound successfully", "port", req.Port)
+	slog.DebugContext(r.Context(), "Listing directory", "path", req.Path)
 
-	resp := map[string]interface{}{
-		"success": true,
-		"message": "Port binding configured",
-		"port":    req.Port,
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to list directory", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	resp := ListDirResponse{Entries: make([]string, len(entries))}
+	for i, entry := range entries {
+		resp.Entries[i] = entry.Name()
 	}
+	slog.DebugContext(r.Context(), "Directory listed successfully", "path", req.Path, "entries", len(entries))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) unbindPortHandler(w http.ResponseWriter, r *http.Request) {
-	currentPort := s.tcpProxy.GetTargetPort()
-	slog.Debug("Unbinding port", "current_port", currentPort)
+// listDirPage answers a paginated /list_dir
+func (s *Server) listDirPage(w http.ResponseWriter, r *http.Request, req ListDirRequest, path string) {
+	var after string
+	if req.Cursor != "" {
+		var err error
+		if after, err = decodeListDirCursor(req.Cursor); err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid cursor: pass back the next_cursor of a previous page")
+			return
+		}
+	}
 
-	s.tcpProxy.ClearTargetPort()
-	slog.Debug("Port unbound successfully")
+	slog.DebugContext(r.Context(), "Listing directory page", "path", req.Path, "limit", req.Limit, "after", after)
 
-	resp := map[string]interface{}{
-		"success": true,
-		"message": "Port binding removed",
+	entries, more, err := readDirPage(path, after, req.Limit)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to list directory", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	resp := ListDirResponse{Entries: entries}
+	if more {
+		resp.NextCursor = encodeListDirCursor(entries[len(entries)-1])
 	}
+	slog.DebugContext(r.Context(), "Directory page listed successfully", "path", req.Path, "entries", len(entries), "more", more)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) deleteDirHandler(w http.ResponseWriter, r *http.Request) {
-	var req DeleteDirRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) writeFileHandler(w http.ResponseWriter, r *http.Request) {
+	var req WriteFileRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
+	auditTarget(r.Context(), req.Path)
+	auditContent(r.Context(), req.Content)
+
+	mode := fs.FileMode(0o644)
+	if req.Mode != "" {
+		parsed, err := parseFileMode(req.Mode)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid mode: %s", req.Mode))
+			return
+		}
+		mode = parsed
+	}
 
-	slog.Debug("Deleting directory", "path", req.Path)
-
-	err := os.RemoveAll(req.Path)
-	resp := map[string]interface{}{"success": err == nil}
+	cond, err := parseWritePrecondition(req.IfMatch, req.IfNoneMatch, r.Header)
 	if err != nil {
-		slog.Debug("Failed to delete directory", "path", req.Path, "error", err)
-		resp["error"] = err.Error()
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	contentLen := len(req.Content)
+	slog.DebugContext(r.Context(), "Writing file", "path", req.Path, "content_length", contentLen, "atomic", req.Atomic, "if_match", cond.ifMatch, "if_none_match", cond.ifNoneMatch)
+
+	// Writes to a path are serialized, so two conditional writes can't both
+	// pass the check before either writes, and a plain write is never
+	// interleaved with another write or a delete
+	defer s.pathLocks.lock(path)()
+
+	if cond.active() {
+		current, err := fileETag(path)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to read file version", "path", req.Path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		if !cond.allows(current) {
+			slog.DebugContext(r.Context(), "Write precondition failed", "path", req.Path, "etag", current)
+			message := fmt.Sprintf("File changed since it was read: %s", req.Path)
+			if current == "" {
+				message = fmt.Sprintf("File does not exist: %s", req.Path)
+			} else if cond.ifNoneMatch {
+				message = fmt.Sprintf("File already exists: %s", req.Path)
+			}
+			writeErrorDetails(w, http.StatusPreconditionFailed, ErrorCodePreconditionFailed, message, map[string]interface{}{"etag": current})
+			return
+		}
+	}
+
+	if req.Atomic {
+		_, err = writeFileAtomic(path, strings.NewReader(req.Content), mode)
 	} else {
-		slog.Debug("Directory deleted successfully", "path", req.Path)
+		err = os.WriteFile(path, []byte(req.Content), mode)
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to write file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
 	}
+
+	etag := contentETag([]byte(req.Content))
+	slog.DebugContext(r.Context(), "File written successfully", "path", req.Path, "bytes", contentLen, "etag", etag)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	setETagHeader(w, etag)
+	json.NewEncoder(w).Encode(WriteFileResponse{Success: true, ETag: etag})
 }
 
-func (s *Server) makeDirHandler(w http.ResponseWriter, r *http.Request) {
-	var req MakeDirRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) readFileHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReadFileRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Offset < 0 || req.Length < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Offset and length must not be negative")
+		return
+	}
+	encoding := strings.ToLower(req.Encoding)
+	if encoding != "" && encoding != "utf-8" && encoding != "base64" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Unsupported encoding: %s (expected utf-8 or base64)", req.Encoding))
+		return
+	}
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
 		return
 	}
 
-	slog.Debug("Creating directory", "path", req.Path)
+	slog.DebugContext(r.Context(), "Reading file", "path", req.Path, "offset", req.Offset, "length", req.Length)
 
-	err := os.MkdirAll(req.Path, 0o755)
-	resp := map[string]interface{}{"success": err == nil}
+	content, size, err := readFileRange(path, req.Offset, req.Length)
 	if err != nil {
-		slog.Debug("Failed to create directory", "path", req.Path, "error", err)
-		resp["error"] = err.Error()
-	} else {
-		slog.Debug("Directory created successfully", "path", req.Path)
+		slog.DebugContext(r.Context(), "Failed to read file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+
+	// A window only covers part of the file, which is hashed separately
+	etag := contentETag(content)
+	if req.Offset > 0 || int64(len(content)) < size {
+		etag, err = fileETag(path)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to hash file", "path", req.Path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+	}
+
+	slog.DebugContext(r.Context(), "File read successfully", "path", req.Path, "bytes", len(content))
+	resp := ReadFileResponse{Content: string(content), Size: size, ETag: etag}
+	if req.DetectContentType {
+		// The type comes from the start of the file, whatever window was read
+		if req.Offset == 0 && (len(content) >= sniffLen || int64(len(content)) == size) {
+			resp.ContentType = detectContentType(path, content[:min(len(content), sniffLen)])
+		} else if resp.ContentType, err = fileContentType(path); err != nil {
+			slog.DebugContext(r.Context(), "Failed to detect content type", "path", req.Path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+	}
+	if encoding == "base64" {
+		resp.Content = base64.StdEncoding.EncodeToString(content)
+		resp.Encoding = encoding
 	}
 	w.Header().Set("Content-Type", "application/json")
+	setETagHeader(w, etag)
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) listDirHandler(w http.ResponseWriter, r *http.Request) {
-	var req ListDirRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) checksumHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ChecksumRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	algorithm := strings.ToLower(req.Algorithm)
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Unsupported algorithm: %s (expected md5, sha1 or sha256)", req.Algorithm))
+		return
+	}
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
 		return
 	}
 
-	slog.Debug("Listing directory", "path", req.Path)
+	slog.DebugContext(r.Context(), "Computing checksum", "path", req.Path, "algorithm", algorithm)
 
-	entries, err := os.ReadDir(req.Path)
-	resp := ListDirResponse{}
+	size, sum, err := checksumFile(path, newHash())
 	if err != nil {
-		slog.Debug("Failed to list directory", "path", req.Path, "error", err)
-		resp.Error = err.Error()
-	} else {
-		resp.Entries = make([]string, len(entries))
-		for i, entry := range entries {
-			resp.Entries[i] = entry.Name()
-		}
-		slog.Debug("Directory listed successfully", "path", req.Path, "entries", len(entries))
+		slog.DebugContext(r.Context(), "Failed to compute checksum", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
 	}
+
+	slog.DebugContext(r.Context(), "Checksum computed", "path", req.Path, "algorithm", algorithm, "bytes", size)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(ChecksumResponse{Algorithm: algorithm, Checksum: sum, Size: size})
 }
 
-func (s *Server) writeFileHandler(w http.ResponseWriter, r *http.Request) {
-	var req WriteFileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+// checksumFile streams the file at path through h and returns its size and
+// hex digest
+func checksumFile(path string, h hash.Hash) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *Server) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeleteFileRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
+	auditTarget(r.Context(), req.Path)
 
-	contentLen := len(req.Content)
-	slog.Debug("Writing file", "path", req.Path, "content_length", contentLen)
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	path, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
 
-	err := os.WriteFile(req.Path, []byte(req.Content), 0o644)
-	resp := map[string]interface{}{"success": err == nil}
-	if err != nil {
-		slog.Debug("Failed to write file", "path", req.Path, "error", err)
-		resp["error"] = err.Error()
-	} else {
-		slog.Debug("File written successfully", "path", req.Path, "bytes", contentLen)
+	slog.DebugContext(r.Context(), "Deleting file", "path", req.Path)
+
+	defer s.pathLocks.lock(path)()
+	if err := os.Remove(path); err != nil {
+		slog.DebugContext(r.Context(), "Failed to delete file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
 	}
+
+	slog.DebugContext(r.Context(), "File deleted successfully", "path", req.Path)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
-func (s *Server) readFileHandler(w http.ResponseWriter, r *http.Request) {
-	var req ReadFileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func (s *Server) moveHandler(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
 		return
 	}
 
-	slog.Debug("Reading file", "path", req.Path)
+	if req.Source == "" {
+		writeMissingField(w, "source")
+		return
+	}
+	if req.Destination == "" {
+		writeMissingField(w, "destination")
+		return
+	}
 
-	content, err := os.ReadFile(req.Path)
-	resp := ReadFileResponse{}
-	if err != nil {
-		slog.Debug("Failed to read file", "path", req.Path, "error", err)
-		resp.Error = err.Error()
-	} else {
-		slog.Debug("File read successfully", "path", req.Path, "bytes", len(content))
-		resp.Content = string(content)
+	source, ok := s.sandboxPath(w, req.Source)
+	if !ok {
+		return
+	}
+	destination, ok := s.sandboxPath(w, req.Destination)
+	if !ok {
+		return
+	}
+
+	auditTarget(r.Context(), req.Source+" -> "+req.Destination)
+
+	slog.DebugContext(r.Context(), "Moving path", "source", req.Source, "destination", req.Destination)
+
+	// Hold both paths so the existence checks still hold when moving
+	defer s.pathLocks.lock(source, destination)()
+
+	if _, err := os.Lstat(source); err != nil {
+		slog.DebugContext(r.Context(), "Move source unavailable", "source", req.Source, "error", err)
+		if os.IsNotExist(err) {
+			writeErrorDetails(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("source not found: %s", req.Source), map[string]interface{}{"reason": "source_not_found"})
+		} else {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		}
+		return
+	}
+
+	if _, err := os.Lstat(destination); err == nil {
+		slog.DebugContext(r.Context(), "Move destination exists", "destination", req.Destination)
+		writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("destination exists: %s", req.Destination), map[string]interface{}{"reason": "destination_exists"})
+		return
+	}
+
+	if err := movePath(source, destination); err != nil {
+		slog.DebugContext(r.Context(), "Failed to move path", "source", req.Source, "destination", req.Destination, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
 	}
+
+	slog.DebugContext(r.Context(), "Path moved successfully", "source", req.Source, "destination", req.Destination)
+
+	resp := map[string]interface{}{"success": true}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
-	var req DeleteFileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+// downloadHandler streams a file straight from disk, supporting range requests
+// so large artifacts never have to be buffered in memory
+func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+
+	resolved, ok := s.sandboxPath(w, path)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Downloading file", "path", path, "range", r.Header.Get("Range"))
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to open file for download", "path", path, "error", err)
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, "File not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to open file")
+		}
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to stat file for download", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to stat file")
+		return
+	}
+	if info.IsDir() {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Path is a directory")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	contentType, err := readerContentType(path, file)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to read file for download", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to read file")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	// ServeContent sets Content-Length and Last-Modified, and handles Range /
+	// If-Modified-Since requests
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+
+	slog.DebugContext(r.Context(), "File download served", "path", path, "size", info.Size())
+}
+
+// uploadHandler streams a raw or multipart request body to disk. The
+// destination comes from the "path" query parameter or a "path" form field
+// sent before the file part.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	auditTarget(r.Context(), path)
+
+	mode := fs.FileMode(0o644)
+	if value := r.Header.Get("X-File-Mode"); value != "" {
+		parsed, err := parseFileMode(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid X-File-Mode: %s", value))
+			return
+		}
+		mode = parsed
+	}
+
+	var content io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid multipart body")
+			return
+		}
+
+		content = nil
+		for content == nil {
+			part, err := reader.NextPart()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Multipart body has no file part")
+				return
+			}
+			if part.FileName() == "" && part.FormName() == "path" {
+				value, _ := io.ReadAll(io.LimitReader(part, 4096))
+				path = string(value)
+				continue
+			}
+			if part.FileName() != "" || part.FormName() == "file" {
+				content = part
+			}
+		}
+	}
+
+	if path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	// A multipart path must not redirect a signed upload elsewhere
+	if signed, ok := signedURLPath(r.Context()); ok && path != signed {
+		writeError(w, http.StatusForbidden, ErrorCodeForbidden, "Forbidden: the signed URL is for another path")
 		return
 	}
 
-	slog.Debug("Deleting file", "path", req.Path)
+	resolved, ok := s.sandboxPath(w, path)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Uploading file", "path", path, "mode", mode, "content_length", r.ContentLength)
 
-	err := os.Remove(req.Path)
+	// Serialized with the other writes and deletes of the path, like
+	// /write_file
+	defer s.pathLocks.lock(resolved)()
+	written, err := writeFileAtomic(resolved, content, mode)
 	resp := map[string]interface{}{"success": err == nil}
 	if err != nil {
-		slog.Debug("Failed to delete file", "path", req.Path, "error", err)
-		resp["error"] = err.Error()
-	} else {
-		slog.Debug("File deleted successfully", "path", req.Path)
+		slog.DebugContext(r.Context(), "Failed to upload file", "path", path, "error", err)
+		if isBodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, err.Error())
+		} else {
+			writeFileError(w, err)
+		}
+		return
 	}
+
+	slog.DebugContext(r.Context(), "File uploaded successfully", "path", path, "bytes", written)
+	resp["path"] = path
+	resp["bytes"] = written
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
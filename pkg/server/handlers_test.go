@@ -2,21 +2,38 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func newTestServer(t *testing.T) (*Server, http.Handler) {
 	t.Helper()
 
-	srv, err := New(AuthConfig{
-		Mode:   AuthModeStatic,
-		Secret: "test-secret",
-	})
+	return newTestServerWithConfig(t, Config{})
+}
+
+// newTestServerWithConfig is newTestServer with the given configuration;
+// authentication is always the static test secret
+func newTestServerWithConfig(t *testing.T, config Config) (*Server, http.Handler) {
+	t.Helper()
+
+	config.Auth = AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"}
+	srv, err := New(config)
 	if err != nil {
 		t.Fatalf("failed to create test server: %v", err)
 	}
@@ -56,6 +73,265 @@ func TestRunHandlerLongOutput(t *testing.T) {
 	}
 }
 
+func TestRunHandlerTTY(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for _, tty := range []bool{true, false} {
+		reqBody, _ := json.Marshal(RunRequest{Cmd: "test -t 1 && echo tty || echo notty", TTY: tty})
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp RunResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		// Terminals translate newlines to CRLF
+		want := "notty"
+		if tty {
+			want = "tty"
+		}
+		if got := strings.TrimRight(resp.Stdout, "\r\n"); got != want {
+			t.Errorf("tty=%v: expected stdout %q, got %q", tty, want, resp.Stdout)
+		}
+	}
+}
+
+func TestRunHandlerArgv(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	dir := filepath.Join(t.TempDir(), "dir with spaces")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "my file.txt")
+	if err := os.WriteFile(path, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  RunRequest
+		want string
+	}{
+		{"path with spaces", RunRequest{Argv: []string{"cat", path}}, "contents"},
+		{"with limits", RunRequest{Argv: []string{"cat", path}, Limits: &ResourceLimits{MaxOpenFiles: 64}}, "contents"},
+		{"no shell expansion", RunRequest{Argv: []string{"echo", "$HOME; echo injected"}}, "$HOME; echo injected\n"},
+		{"argv takes precedence", RunRequest{Cmd: "echo cmd", Argv: []string{"echo", "argv"}}, "argv\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.req)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp RunResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Code != 0 || resp.Stdout != tt.want {
+				t.Errorf("expected code 0 and stdout %q, got %d %q (stderr %q)", tt.want, resp.Code, resp.Stdout, resp.Stderr)
+			}
+		})
+	}
+}
+
+func TestRunHandlerReportsSignal(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		cmd      string
+		code     int
+		signaled bool
+		signal   string
+	}{
+		{"exit code", "exit 42", 42, false, ""},
+		// The shell exits on its own, reporting its child's signal as 128+N
+		{"exit code above 128", "exit 137", 137, false, ""},
+		{"killed", "kill -KILL $$", -1, true, "SIGKILL"},
+		{"terminated", "kill -TERM $$", -1, true, "SIGTERM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(RunRequest{Cmd: tt.cmd})
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp RunResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Code != tt.code || resp.Signaled != tt.signaled || resp.Signal != tt.signal {
+				t.Errorf("expected code %d, signaled %v and signal %q, got %+v", tt.code, tt.signaled, tt.signal, resp)
+			}
+		})
+	}
+}
+
+// TestRunHandlerStartFailure verifies that /run tells a command that could not
+// be started from one that ran and failed.
+func TestRunHandlerStartFailure(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		req      RunRequest
+		started  bool
+		exitCode int
+	}{
+		{"exits nonzero", RunRequest{Cmd: "echo failing >&2; exit 1"}, true, 1},
+		{"missing program", RunRequest{Argv: []string{"/nonexistent/program"}}, false, -1},
+		{"missing program under a tty", RunRequest{Argv: []string{"/nonexistent/program"}, TTY: true}, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.req)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected a JSON result, got %q", ct)
+			}
+
+			var resp RunResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Started != tt.started || resp.ExitCode != tt.exitCode || resp.Code != tt.exitCode {
+				t.Errorf("expected started %v and exit code %d, got %+v", tt.started, tt.exitCode, resp)
+			}
+			if tt.started && resp.Error != "" {
+				t.Errorf("expected no executor error for a command that ran, got %q", resp.Error)
+			}
+			if !tt.started && !strings.HasPrefix(resp.Error, "Failed to start command") {
+				t.Errorf("expected the start failure in error, got %q", resp.Error)
+			}
+		})
+	}
+}
+
+// TestRunHandlerTruncatesOutput verifies that /run stops reading and kills a
+// command whose output exceeds the cap, rather than buffering it all.
+func TestRunHandlerTruncatesOutput(t *testing.T) {
+	srv, mux := newTestServer(t)
+	srv.maxOutputBytes = 1024
+
+	tests := []struct {
+		name       string
+		cmd        string
+		wantStdout int
+		wantStderr int
+		truncated  bool
+	}{
+		{"endless stdout", "cat /dev/zero", 1024, 0, true},
+		{"endless stderr", "echo out; cat /dev/zero >&2", 4, 1024, true},
+		{"under the cap", "head -c 1024 /dev/zero", 1024, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(RunRequest{Cmd: tt.cmd})
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+
+			var resp RunResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if resp.Truncated != tt.truncated {
+				t.Errorf("expected truncated=%v, got %v", tt.truncated, resp.Truncated)
+			}
+			if len(resp.Stdout) != tt.wantStdout || len(resp.Stderr) != tt.wantStderr {
+				t.Errorf("expected %d/%d bytes of stdout/stderr, got %d/%d", tt.wantStdout, tt.wantStderr, len(resp.Stdout), len(resp.Stderr))
+			}
+		})
+	}
+}
+
+// processGone reports whether pid has exited. Exited processes may linger
+// as zombies until their parent reaps them.
+func processGone(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// The state follows the parenthesized command name
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// TestRunCancelledRequestKillsCommand verifies that a client going away kills
+// the command and the processes it started.
+func TestRunCancelledRequestKillsCommand(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for _, path := range []string{"/run", "/run_streaming"} {
+		t.Run(path, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pids")
+			reqBody, _ := json.Marshal(RunRequest{Cmd: fmt.Sprintf("sleep 30 & echo $$ $! > %s; wait", pidFile)})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				mux.ServeHTTP(httptest.NewRecorder(), newAuthRequest(http.MethodPost, path, reqBody).WithContext(ctx))
+			}()
+
+			var pids []int
+			for deadline := time.Now().Add(5 * time.Second); len(pids) < 2; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("command did not start")
+				}
+				data, _ := os.ReadFile(pidFile)
+				if !bytes.HasSuffix(data, []byte("\n")) {
+					continue
+				}
+				for _, field := range strings.Fields(string(data)) {
+					pid, _ := strconv.Atoi(field)
+					pids = append(pids, pid)
+				}
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler did not return after the request was cancelled")
+			}
+
+			for _, pid := range pids {
+				for deadline := time.Now().Add(5 * time.Second); !processGone(pid); time.Sleep(10 * time.Millisecond) {
+					if time.Now().After(deadline) {
+						t.Fatalf("process %d still running after the request was cancelled", pid)
+					}
+				}
+			}
+		})
+	}
+}
+
 // TestRunStreamingHandlerLongOutput verifies that /run_streaming handles large payloads
 // without hanging. Before the fix, the bufio.Scanner would stop reading after 64KB,
 // fill the pipe buffer, and block cmd.Wait() indefinitely.
@@ -84,6 +360,25 @@ func TestRunStreamingHandlerLongOutput(t *testing.T) {
 	}
 }
 
+func TestRunStreamingEmitsEventIDs(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	reqBody, _ := json.Marshal(RunRequest{Cmd: "echo a; echo b; echo c"})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run_streaming", reqBody))
+
+	var ids []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		}
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("expected output events with ids 1,2,3, got %v in %q", ids, w.Body.String())
+	}
+}
+
 func TestStartProcessInvalidCwd(t *testing.T) {
 	_, mux := newTestServer(t)
 
@@ -131,3 +426,1616 @@ func TestRunStreamingInvalidCwd(t *testing.T) {
 		t.Errorf("expected 400 Bad Request, got %d: %s", w.Code, w.Body.String())
 	}
 }
+
+func TestReadFileRange(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  ReadFileRequest
+		want string
+	}{
+		{"middle", ReadFileRequest{Path: path, Offset: 3, Length: 4}, "3456"},
+		{"to end", ReadFileRequest{Path: path, Offset: 7}, "789"},
+		{"past end", ReadFileRequest{Path: path, Offset: 8, Length: 10}, "89"},
+		{"beyond end", ReadFileRequest{Path: path, Offset: 20, Length: 10}, ""},
+		{"base64", ReadFileRequest{Path: path, Offset: 1, Length: 2, Encoding: "base64"}, "MTI="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", reqBody))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			var resp ReadFileResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Content != tt.want || resp.Size != 10 {
+				t.Errorf("expected %q of a 10 byte file, got %q of %d bytes", tt.want, resp.Content, resp.Size)
+			}
+		})
+	}
+
+	reqBody, _ := json.Marshal(ReadFileRequest{Path: path, Offset: -1})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/read_file", reqBody))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative offset, got %d", w.Code)
+	}
+}
+
+func TestTouchCreatesFile(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "new.lock")
+	reqBody, _ := json.Marshal(TouchRequest{Path: path})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/touch", reqBody))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected the file to be created: %v", err)
+	}
+	if info.Size() != 0 || time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("expected an empty file modified just now, got %d bytes modified at %s", info.Size(), info.ModTime())
+	}
+
+	missing, _ := json.Marshal(TouchRequest{Path: filepath.Join(t.TempDir(), "missing", "new.lock")})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/touch", missing))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when the parent is missing, got %d: %s", w.Code, w.Body.String())
+	}
+	if detail := decodeError(t, w); detail.Code != ErrorCodeNotFound {
+		t.Errorf("expected a not_found error, got %+v", detail)
+	}
+}
+
+func TestTouchUpdatesMtime(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "build.stamp")
+	if err := os.WriteFile(path, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	reqBody, _ := json.Marshal(TouchRequest{Path: path})
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/touch", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.ModTime().After(old.Add(30*time.Minute)) {
+		t.Fatalf("expected the mtime to be bumped past %s, got %v (err=%v)", old, info.ModTime(), err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "keep" {
+		t.Errorf("expected the content to be kept, got %q", content)
+	}
+
+	explicit := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	reqBody, _ = json.Marshal(TouchRequest{Path: path, Mtime: &explicit})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/touch", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(explicit) {
+		t.Errorf("expected mtime %s, got %v (err=%v)", explicit, info.ModTime(), err)
+	}
+}
+
+func TestTouchDirectory(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	dir := t.TempDir()
+	explicit := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	reqBody, _ := json.Marshal(TouchRequest{Path: dir, Mtime: &explicit})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/touch", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || !info.ModTime().Equal(explicit) {
+		t.Errorf("expected the directory to get mtime %s, got %v (err=%v)", explicit, info.ModTime(), err)
+	}
+}
+
+func TestTruncateResizesFile(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	truncate := func(size int64) {
+		t.Helper()
+		reqBody, _ := json.Marshal(TruncateRequest{Path: path, Size: size})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/truncate", reqBody))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 truncating to %d, got %d: %s", size, w.Code, w.Body.String())
+		}
+	}
+
+	truncate(4)
+	if content, _ := os.ReadFile(path); string(content) != "0123" {
+		t.Errorf("expected the file to be shrunk to %q, got %q", "0123", content)
+	}
+
+	truncate(8)
+	content, _ := os.ReadFile(path)
+	if string(content) != "0123\x00\x00\x00\x00" {
+		t.Errorf("expected the file to be grown with zeroes, got %q", content)
+	}
+}
+
+func TestTruncateErrors(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		req    TruncateRequest
+		status int
+		code   string
+	}{
+		{"directory", TruncateRequest{Path: dir, Size: 0}, http.StatusBadRequest, ErrorCodeInvalidRequest},
+		{"missing", TruncateRequest{Path: filepath.Join(dir, "missing"), Size: 0}, http.StatusNotFound, ErrorCodeNotFound},
+		{"negative size", TruncateRequest{Path: filepath.Join(dir, "missing"), Size: -1}, http.StatusBadRequest, ErrorCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/truncate", reqBody))
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if detail := decodeError(t, w); detail.Code != tt.code {
+				t.Errorf("expected code %q, got %+v", tt.code, detail)
+			}
+		})
+	}
+}
+
+func TestSymlinkReadBackViaStat(t *testing.T) {
+	srv, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+	if err := os.WriteFile(filepath.Join(srv.root, "python3"), []byte("#!/bin/sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody, _ := json.Marshal(SymlinkRequest{Target: "python3", LinkPath: "python"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/symlink", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if link, err := os.Readlink(filepath.Join(srv.root, "python")); err != nil || link != "python3" {
+		t.Fatalf("expected a link to python3, got %q (err=%v)", link, err)
+	}
+
+	reqBody, _ = json.Marshal(StatRequest{Path: "python"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/stat", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stat StatResponse
+	if err := json.NewDecoder(w.Body).Decode(&stat); err != nil {
+		t.Fatalf("failed to decode stat response: %v", err)
+	}
+	if !stat.IsSymlink || stat.IsDir || stat.Name != "python" {
+		t.Errorf("expected a symlink named python, got %+v", stat)
+	}
+
+	// Creating the same link again conflicts
+	reqBody, _ = json.Marshal(SymlinkRequest{Target: "python3", LinkPath: "python"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/symlink", reqBody))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an existing link, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSymlinkConfinesTarget(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+
+	for _, target := range []string{"/etc/passwd", "../../etc/passwd"} {
+		reqBody, _ := json.Marshal(SymlinkRequest{Target: target, LinkPath: "escape"})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/symlink", reqBody))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("target %q: expected 403, got %d: %s", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	srv, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+	root := srv.root
+	if err := os.MkdirAll(filepath.Join(root, "opt/app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "opt/app/run.sh"), []byte("#!/bin/sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// current -> opt/app, run -> current/run.sh
+	for link, target := range map[string]string{"current": "opt/app", "run": "current/run.sh", "app.sh": "opt/app/run.sh"} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readlink := func(req ReadlinkRequest) (int, ReadlinkResponse) {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/readlink", body))
+		var resp ReadlinkResponse
+		if w.Code == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&resp)
+		}
+		return w.Code, resp
+	}
+
+	// Link to a file
+	code, resp := readlink(ReadlinkRequest{Path: "app.sh"})
+	if code != http.StatusOK || resp.Target != "opt/app/run.sh" || resp.Resolved != "" {
+		t.Errorf("expected the raw target only, got %d %+v", code, resp)
+	}
+
+	// Chained links
+	code, resp = readlink(ReadlinkRequest{Path: "run", Resolve: true})
+	if code != http.StatusOK || resp.Target != "current/run.sh" || resp.Resolved != filepath.Join(root, "opt/app/run.sh") {
+		t.Errorf("expected the chain to resolve to the script, got %d %+v", code, resp)
+	}
+
+	if code, _ := readlink(ReadlinkRequest{Path: "opt/app/run.sh"}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a regular file, got %d", code)
+	}
+	if code, _ := readlink(ReadlinkRequest{Path: "missing"}); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing path, got %d", code)
+	}
+
+	// A link planted outside the API that points out of the root
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := readlink(ReadlinkRequest{Path: "escape", Resolve: true}); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a link leaving the root, got %d", code)
+	}
+}
+
+func TestMoveSameDirectoryRename(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "old.txt")
+	destination := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(source, []byte("hello"), 0o640); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	reqBody, _ := json.Marshal(MoveRequest{Source: source, Destination: destination})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/move", reqBody))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("expected source to be gone, stat err=%v", err)
+	}
+	content, err := os.ReadFile(destination)
+	if err != nil || string(content) != "hello" {
+		t.Errorf("expected destination content %q, got %q (err=%v)", "hello", content, err)
+	}
+}
+
+func TestMoveCrossDeviceFallsBackToCopy(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	originalRename := renamePath
+	renamePath = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { renamePath = originalRename })
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "src")
+	destination := filepath.Join(dir, "dst")
+	if err := os.MkdirAll(filepath.Join(source, "nested"), 0o755); err != nil {
+		t.Fatalf("failed to create source tree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "nested", "script.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	reqBody, _ := json.Marshal(MoveRequest{Source: source, Destination: destination})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/move", reqBody))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("expected source to be removed after copy, stat err=%v", err)
+	}
+	info, err := os.Stat(filepath.Join(destination, "nested", "script.sh"))
+	if err != nil {
+		t.Fatalf("expected copied file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("expected mode 0755 to be preserved, got %o", info.Mode().Perm())
+	}
+}
+
+func TestMoveStructuredErrors(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	other := filepath.Join(dir, "other.txt")
+	for _, path := range []string{existing, other} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		req        MoveRequest
+		wantStatus int
+		wantReason string
+	}{
+		{"missing source", MoveRequest{Source: filepath.Join(dir, "missing"), Destination: filepath.Join(dir, "new")}, http.StatusNotFound, "source_not_found"},
+		{"destination exists", MoveRequest{Source: existing, Destination: other}, http.StatusConflict, "destination_exists"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/move", reqBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			detail := decodeError(t, w)
+			if detail.Details["reason"] != tt.wantReason {
+				t.Errorf("expected reason %q, got %+v", tt.wantReason, detail)
+			}
+		})
+	}
+}
+
+func TestDownloadStreamsFile(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "artifact.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/download?path="+path, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "0123456789" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "10" {
+		t.Errorf("expected Content-Length 10, got %q", w.Header().Get("Content-Length"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected text/plain content type, got %q", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Error("expected Last-Modified header")
+	}
+}
+
+func TestDownloadSupportsRangeRequests(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "artifact.bin")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	req := newAuthRequest(http.MethodGet, "/download?path="+path, nil)
+	req.Header.Set("Range", "bytes=4-")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "456789" {
+		t.Errorf("expected resumed body %q, got %q", "456789", w.Body.String())
+	}
+}
+
+func TestDownloadMissingFile(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/download?path=/nonexistent/file", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestWriteFileAtomicNeverPartial(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	size := 1 << 20
+	contents := []string{strings.Repeat("a", size), strings.Repeat("b", size)}
+	if err := os.WriteFile(path, []byte(contents[0]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Best effort: a concurrent reader must only ever see a complete version
+	done := make(chan struct{})
+	partial := make(chan string, 1)
+	go func() {
+		defer close(partial)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				partial <- err.Error()
+				return
+			}
+			if len(content) != size || strings.Trim(string(content), string(content[:1])) != "" {
+				partial <- fmt.Sprintf("%d bytes", len(content))
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		reqBody, _ := json.Marshal(WriteFileRequest{Path: path, Content: contents[(i+1)%2], Mode: "0600", Atomic: true})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", reqBody))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	close(done)
+	if observed, ok := <-partial; ok {
+		t.Fatalf("reader observed a partial file: %s", observed)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != contents[0] {
+		t.Errorf("expected the last written content, got %d bytes (err=%v)", len(content), err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v (err=%v)", info.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected no temp files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestUploadRawBodyWithMode(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "run.sh")
+	req := httptest.NewRequest(http.MethodPost, "/upload?path="+path, strings.NewReader("#!/bin/sh\necho hi\n"))
+	req.Header.Set("Authorization", "Bearer test-secret")
+	req.Header.Set("X-File-Mode", "0750")
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected uploaded file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o750 {
+		t.Errorf("expected mode 0750, got %o", info.Mode().Perm())
+	}
+	if info.Size() != int64(len("#!/bin/sh\necho hi\n")) {
+		t.Errorf("unexpected uploaded size %d", info.Size())
+	}
+}
+
+func TestUploadMultipartForm(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "data.txt")
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("path", path)
+	part, _ := form.CreateFormFile("file", "data.txt")
+	part.Write([]byte("multipart content"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "multipart content" {
+		t.Errorf("expected uploaded content, got %q (err=%v)", content, err)
+	}
+}
+
+// patternReader produces an endless stream of bytes without allocating
+type patternReader struct{}
+
+func (patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte('a' + i%26)
+	}
+	return len(p), nil
+}
+
+// TestUploadLargeBodyStreamsToDisk verifies a 50MB upload is streamed rather
+// than buffered, by checking total allocations stay far below the body size
+func TestUploadLargeBodyStreamsToDisk(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	const size = 50 * 1024 * 1024
+	path := filepath.Join(t.TempDir(), "large.bin")
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?path="+path, io.LimitReader(patternReader{}, size))
+	req.Header.Set("Authorization", "Bearer test-secret")
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	runtime.ReadMemStats(&after)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != size {
+		t.Fatalf("expected %d bytes on disk, got %v (err=%v)", size, info, err)
+	}
+
+	allocated := after.TotalAlloc - before.TotalAlloc
+	if allocated > 8*1024*1024 {
+		t.Errorf("expected streaming upload to allocate well under the body size, allocated %d bytes", allocated)
+	}
+}
+
+func TestListProcessesFiltersByLabel(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	start := func(labels map[string]string) string {
+		t.Helper()
+		body, _ := json.Marshal(StartProcessRequest{Cmd: "true", Labels: labels})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp StartProcessResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.ID
+	}
+	dbID := start(map[string]string{"role": "db", "env": "test"})
+	start(map[string]string{"role": "web"})
+	start(nil)
+
+	list := func(query string) []map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp ListProcessesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.Processes
+	}
+
+	if got := list(""); len(got) != 3 {
+		t.Errorf("expected 3 processes without a filter, got %d", len(got))
+	}
+
+	got := list("?label=role=db&label=env=test")
+	if len(got) != 1 || got[0]["id"] != dbID {
+		t.Fatalf("expected only the db process, got %v", got)
+	}
+	labels, _ := got[0]["labels"].(map[string]interface{})
+	if labels["role"] != "db" || labels["env"] != "test" {
+		t.Errorf("expected labels in the summary, got %v", got[0])
+	}
+
+	if got := list("?label=role=cache"); len(got) != 0 {
+		t.Errorf("expected no process for a non-matching label, got %v", got)
+	}
+	if got := list("?label=role=db&label=env=prod"); len(got) != 0 {
+		t.Errorf("expected all labels to have to match, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes?label=role", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed label filter, got %d", w.Code)
+	}
+}
+
+func TestListProcessesFiltersByStatusAndCommand(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	start := func(cmd string, labels map[string]string) *Process {
+		t.Helper()
+		process, err := srv.processManager.StartProcessWithOptions(ProcessOptions{
+			CommandOptions: CommandOptions{Command: cmd},
+			Labels:         labels,
+		})
+		if err != nil {
+			t.Fatalf("failed to start %q: %v", cmd, err)
+		}
+		return process
+	}
+	completed := start("echo worker-a", map[string]string{"role": "worker"})
+	failed := start("echo worker-b; exit 2", map[string]string{"role": "worker"})
+	killed := start("sleep 30 # server", nil)
+	running := start("sleep 30 # worker-c", map[string]string{"role": "worker"})
+	defer srv.processManager.KillProcess(running.ID)
+
+	<-completed.done
+	<-failed.done
+	srv.processManager.KillProcess(killed.ID)
+	<-killed.done
+
+	list := func(query string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %q, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp ListProcessesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		ids := make([]string, 0, len(resp.Processes))
+		for _, p := range resp.Processes {
+			ids = append(ids, p["id"].(string))
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	ids := func(processes ...*Process) []string {
+		result := make([]string, 0, len(processes))
+		for _, p := range processes {
+			result = append(result, p.ID)
+		}
+		slices.Sort(result)
+		return result
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?status=running", ids(running)},
+		{"?status=completed", ids(completed)},
+		{"?status=failed", ids(failed)},
+		{"?status=killed", ids(killed)},
+		{"?command_contains=worker", ids(completed, failed, running)},
+		{"?command_contains=" + url.QueryEscape("# server"), ids(killed)},
+		{"?command_contains=nothing-matches", ids()},
+		{"?status=running&command_contains=worker", ids(running)},
+		{"?status=killed&command_contains=worker", ids()},
+		{"?status=failed&label=role=worker&command_contains=worker-b", ids(failed)},
+		{"?status=completed&label=role=other", ids()},
+	}
+	for _, tt := range tests {
+		if got := list(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes?status=sleeping", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown status, got %d", w.Code)
+	}
+}
+
+func TestGetProcessReturnsDetails(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("exit 3", "/tmp", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.done
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/get_process?id="+process.ID, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp["id"] != process.ID || resp["command"] != "exit 3" || resp["cwd"] != "/tmp" {
+		t.Errorf("unexpected process details: %v", resp)
+	}
+	if resp["exit_code"] != float64(3) {
+		t.Errorf("expected exit_code 3, got %v", resp["exit_code"])
+	}
+	if _, ok := resp["start_time"]; !ok {
+		t.Error("expected start_time in details")
+	}
+	if _, ok := resp["end_time"]; !ok {
+		t.Error("expected end_time in details")
+	}
+}
+
+func TestGetProcessReturnsRedactedEnv(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	env := map[string]string{"APP_MODE": "debug", "DB_PASSWORD": "hunter2", "github_token": "ghp_x"}
+	process, err := srv.processManager.StartProcess("true", "", env)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.done
+	env["APP_MODE"] = "changed"
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/get_process?id="+process.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Env map[string]string `json:"env"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := map[string]string{"APP_MODE": "debug", "DB_PASSWORD": redactedValue, "github_token": redactedValue}
+	if len(resp.Env) != len(want) {
+		t.Fatalf("expected env %v, got %v", want, resp.Env)
+	}
+	for key, value := range want {
+		if resp.Env[key] != value {
+			t.Errorf("expected %s=%q, got %q", key, value, resp.Env[key])
+		}
+	}
+}
+
+func TestGetProcessUnknownID(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/get_process?id=does-not-exist", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected JSON error body: %v", err)
+	}
+	if resp["error"] == nil {
+		t.Errorf("expected error field, got %v", resp)
+	}
+}
+
+func TestProcessLogsFiltersAndLimits(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("echo out1; echo err1 >&2; echo out2; echo out3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.done
+
+	fetch := func(query string) ProcessLogsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs?id="+process.ID+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %q, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp ProcessLogsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	if all := fetch(""); len(all.Entries) != 4 {
+		t.Errorf("expected 4 entries, got %d: %v", len(all.Entries), all.Entries)
+	}
+
+	stderr := fetch("&stream=stderr")
+	if len(stderr.Entries) != 1 || stderr.Entries[0].Data != "err1" {
+		t.Errorf("expected only the stderr line, got %v", stderr.Entries)
+	}
+
+	limited := fetch("&stream=stdout&limit=2")
+	if len(limited.Entries) != 2 || limited.Entries[0].Data != "out2" || limited.Entries[1].Data != "out3" {
+		t.Errorf("expected the last two stdout lines, got %v", limited.Entries)
+	}
+}
+
+func TestProcessLogsReportsDropped(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	reqBody, _ := json.Marshal(StartProcessRequest{Cmd: "seq 1 5; echo err >&2", MaxLogEntries: 2})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var started StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&started)
+
+	wait, _ := json.Marshal(WaitProcessRequest{ID: started.ID, TimeoutMs: 5000})
+	mux.ServeHTTP(httptest.NewRecorder(), newAuthRequest(http.MethodPost, "/wait_process", wait))
+
+	fetch := func(query string) ProcessLogsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs?id="+started.ID+query, nil))
+		var resp ProcessLogsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := fetch("&stream=stdout"); resp.Dropped != 3 || len(resp.Entries) != 2 {
+		t.Errorf("expected 2 stdout entries and 3 dropped, got %+v", resp)
+	}
+	if resp := fetch("&stream=stderr"); resp.Dropped != 0 {
+		t.Errorf("expected nothing dropped from stderr, got %+v", resp)
+	}
+
+	reqBody, _ = json.Marshal(StartProcessRequest{Cmd: "true", MaxLogEntries: -1})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative max_log_entries, got %d", w.Code)
+	}
+}
+
+func TestProcessLogsUnknownID(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs?id=does-not-exist", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestProcessLogsStreamingResumesAfterSeq(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("echo one; echo two; echo three", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.done
+
+	logs, err := srv.processManager.GetProcessLogs(process.ID)
+	if err != nil || len(logs) != 3 {
+		t.Fatalf("expected 3 log entries, got %v (%v)", logs, err)
+	}
+
+	stream := func(query string, lastEventID string) string {
+		t.Helper()
+		req := newAuthRequest(http.MethodGet, "/process_logs_streaming?id="+process.ID+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	body := stream("&since_seq="+strconv.FormatUint(logs[0].Seq, 10), "")
+	if strings.Contains(body, `"one"`) || !strings.Contains(body, `"two"`) || !strings.Contains(body, `"three"`) {
+		t.Errorf("expected only entries after the first, got %q", body)
+	}
+
+	body = stream("", strconv.FormatUint(logs[1].Seq, 10))
+	if strings.Contains(body, `"one"`) || strings.Contains(body, `"two"`) || !strings.Contains(body, `"three"`) {
+		t.Errorf("expected only the last entry when resuming from Last-Event-ID, got %q", body)
+	}
+	if want := fmt.Sprintf("id: %d\nevent: log\n", logs[2].Seq); !strings.Contains(body, want) {
+		t.Errorf("expected log event to carry its seq as SSE id %q, got %q", want, body)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_streaming?id="+process.ID+"&since_seq=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid since_seq, got %d", w.Code)
+	}
+}
+
+func TestDeleteDirOnlyIfEmpty(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := filepath.Join(t.TempDir(), "dir")
+	os.MkdirAll(filepath.Join(dir, "nested"), 0o755)
+	os.WriteFile(filepath.Join(dir, "nested", "file.txt"), []byte("x"), 0o644)
+
+	deleteDir := func(req DeleteDirRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_dir", body))
+		return w
+	}
+
+	w := deleteDir(DeleteDirRequest{Path: dir, OnlyIfEmpty: true})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a non-empty directory, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := decodeError(t, w); resp.Details["reason"] != "not_empty" {
+		t.Errorf("expected reason not_empty, got %v", resp.Details)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nested", "file.txt")); err != nil {
+		t.Fatalf("expected the tree to be untouched: %v", err)
+	}
+
+	if w := deleteDir(DeleteDirRequest{Path: filepath.Join(dir, "nested", "file.txt"), OnlyIfEmpty: true}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a file, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := deleteDir(DeleteDirRequest{Path: filepath.Join(dir, "missing"), OnlyIfEmpty: true}); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing directory, got %d: %s", w.Code, w.Body.String())
+	}
+
+	os.Remove(filepath.Join(dir, "nested", "file.txt"))
+	if w := deleteDir(DeleteDirRequest{Path: filepath.Join(dir, "nested"), OnlyIfEmpty: true}); w.Code != http.StatusOK {
+		t.Fatalf("expected an empty directory to be deleted, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nested")); !os.IsNotExist(err) {
+		t.Errorf("expected the directory to be gone, stat err=%v", err)
+	}
+}
+
+func TestStartProcessOverLimit(t *testing.T) {
+	srv, mux := newTestServer(t)
+	srv.processManager.SetMaxProcesses(1, ProcessLimitAll)
+	t.Cleanup(func() { srv.processManager.KillAll("") })
+
+	start := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(StartProcessRequest{Cmd: "sleep 30"})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		return w
+	}
+	if w := start(); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w := start()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := decodeError(t, w); resp.Code != ErrorCodeRateLimited {
+		t.Errorf("expected code %s, got %s", ErrorCodeRateLimited, resp.Code)
+	}
+}
+
+func TestStartProcessFollowLogsURL(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	// A fast process prints most of its output before the stream subscribes,
+	// and the rest while it does
+	const lines = 500
+	for round := range 20 {
+		body, _ := json.Marshal(StartProcessRequest{Cmd: fmt.Sprintf("seq 1 %d", lines)})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var started StartProcessResponse
+		json.NewDecoder(w.Body).Decode(&started)
+		if started.LogsURL != "/process_logs_streaming?id="+started.ID {
+			t.Fatalf("expected a logs_url following the process, got %q", started.LogsURL)
+		}
+
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, started.LogsURL, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var received []string
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			var entry LogEntry
+			if json.Unmarshal([]byte(data), &entry) == nil && entry.Stream == "stdout" {
+				received = append(received, entry.Data)
+			}
+		}
+		if len(received) != lines {
+			t.Fatalf("round %d: expected %d lines exactly once, got %d", round, lines, len(received))
+		}
+		for i, data := range received {
+			if want := strconv.Itoa(i + 1); data != want {
+				t.Fatalf("round %d: line %d: expected %q, got %q", round, i, want, data)
+			}
+		}
+	}
+}
+
+func TestProcessLogsGrep(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("echo 'GET /a 200'; echo 'GET /b 500'; echo 'POST /c 503' >&2; echo done", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.done
+
+	fetch := func(query string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs?id="+process.ID+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %q, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp ProcessLogsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var lines []string
+		for _, entry := range resp.Entries {
+			lines = append(lines, entry.Data)
+		}
+		return lines
+	}
+
+	if lines := fetch("&grep=GET"); !slices.Equal(lines, []string{"GET /a 200", "GET /b 500"}) {
+		t.Errorf("expected the lines containing GET, got %q", lines)
+	}
+	// Without regex=true the pattern is a literal substring
+	if lines := fetch("&grep=" + url.QueryEscape(" 5..")); len(lines) != 0 {
+		t.Errorf("expected no literal match, got %q", lines)
+	}
+	// stdout and stderr are read concurrently, so lines of different
+	// streams may be logged in either order
+	if lines := fetch("&regex=true&grep=" + url.QueryEscape(" 5\\d\\d$")); !slices.Equal(slices.Sorted(slices.Values(lines)), []string{"GET /b 500", "POST /c 503"}) {
+		t.Errorf("expected the lines matching the regex, got %q", lines)
+	}
+	if lines := fetch("&regex=true&stream=stdout&limit=1&grep=" + url.QueryEscape("^GET")); !slices.Equal(lines, []string{"GET /b 500"}) {
+		t.Errorf("expected the filter to combine with stream and limit, got %q", lines)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_streaming?id="+process.ID+"&grep=500", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"GET /b 500"`) || strings.Contains(body, "/a") || strings.Contains(body, "/c") || strings.Contains(body, `"done"`) {
+		t.Errorf("expected only the literal match in the stream, got %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_streaming?id="+process.ID+"&regex=true&grep="+url.QueryEscape("^(POST|done)"), nil))
+	if body := w.Body.String(); !strings.Contains(body, `"POST /c 503"`) || !strings.Contains(body, `"done"`) || strings.Contains(body, "GET") {
+		t.Errorf("expected only the regex matches in the stream, got %q", body)
+	}
+
+	for _, query := range []string{"&regex=true&grep=" + url.QueryEscape("("), "&regex=yes&grep=x", "&regex=true"} {
+		for _, route := range []string{"/process_logs", "/process_logs_streaming"} {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodGet, route+"?id="+process.ID+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected 400 from %s for %q, got %d", route, query, w.Code)
+			}
+		}
+	}
+}
+
+func TestRemoveProcessHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	running, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(running.ID)
+
+	finished, err := srv.processManager.StartProcess("echo done", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-finished.done
+
+	remove := func(id string) int {
+		t.Helper()
+		body, _ := json.Marshal(RemoveProcessRequest{ID: id})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/remove_process", body))
+		return w.Code
+	}
+
+	if code := remove(running.ID); code != http.StatusConflict {
+		t.Errorf("expected 409 for a running process, got %d", code)
+	}
+	if code := remove(finished.ID); code != http.StatusOK {
+		t.Errorf("expected 200 for a finished process, got %d", code)
+	}
+	if code := remove(finished.ID); code != http.StatusNotFound {
+		t.Errorf("expected 404 once removed, got %d", code)
+	}
+}
+
+func TestPruneProcessesHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	start := func(command string) *Process {
+		t.Helper()
+		process, err := srv.processManager.StartProcess(command, "", nil)
+		if err != nil {
+			t.Fatalf("failed to start process: %v", err)
+		}
+		return process
+	}
+
+	running := start("sleep 10")
+	defer srv.processManager.KillProcess(running.ID)
+	old, recent, failed := start("true"), start("true"), start("exit 3")
+	<-old.done
+	<-recent.done
+	<-failed.done
+
+	old.mu.Lock()
+	ended := time.Now().Add(-2 * time.Hour)
+	old.EndTime = &ended
+	old.mu.Unlock()
+
+	prune := func(req PruneProcessesRequest) (int, PruneProcessesResponse) {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/processes/prune", body))
+		var resp PruneProcessesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	// By age
+	code, resp := prune(PruneProcessesRequest{OlderThan: 3600})
+	if code != http.StatusOK || resp.Count != 1 || !slices.Equal(resp.Removed, []string{old.ID}) {
+		t.Fatalf("expected only the old process to be pruned, got %d %+v", code, resp)
+	}
+
+	// By status
+	code, resp = prune(PruneProcessesRequest{Status: ProcessStatusFailed})
+	if code != http.StatusOK || !slices.Equal(resp.Removed, []string{failed.ID}) {
+		t.Fatalf("expected only the failed process to be pruned, got %d %+v", code, resp)
+	}
+
+	if code, _ := prune(PruneProcessesRequest{Status: ProcessStatusRunning}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 pruning running processes, got %d", code)
+	}
+	if code, _ := prune(PruneProcessesRequest{OlderThan: -1}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative older_than, got %d", code)
+	}
+
+	// Without criteria, every finished process goes but running ones stay
+	code, resp = prune(PruneProcessesRequest{})
+	if code != http.StatusOK || !slices.Equal(resp.Removed, []string{recent.ID}) {
+		t.Fatalf("expected the remaining finished process to be pruned, got %d %+v", code, resp)
+	}
+	if _, err := srv.processManager.GetProcess(running.ID); err != nil {
+		t.Errorf("expected the running process to be kept: %v", err)
+	}
+	if code, resp = prune(PruneProcessesRequest{}); code != http.StatusOK || resp.Count != 0 || resp.Removed == nil {
+		t.Errorf("expected an empty list once nothing is left to prune, got %d %+v", code, resp)
+	}
+}
+
+func TestKillAllProcessesHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+
+	// An empty body kills every running process
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/kill_all_processes", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp KillAllProcessesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Killed) != 1 || resp.Killed[0] != process.ID {
+		t.Errorf("expected %s to be killed, got %+v", process.ID, resp)
+	}
+}
+
+func TestSignalProcessHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(process.ID)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/signal_process", []byte(body)))
+		return w
+	}
+
+	if w := send(`{"id":"` + process.ID + `","signal":"SIGNOPE"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown signal, got %d", w.Code)
+	}
+
+	w := send(`{"id":"missing","signal":"SIGTERM"}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown process, got %d", w.Code)
+	} else if detail := decodeError(t, w); detail.Code != ErrorCodeNotFound {
+		t.Errorf("expected code %q, got %q", ErrorCodeNotFound, detail.Code)
+	}
+
+	if w := send(`{"id":"` + process.ID + `","signal":15}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case <-process.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("process did not exit after SIGTERM")
+	}
+
+	info := process.ToJSON()
+	if info["signaled"] != true || info["signal"] != "SIGTERM" {
+		t.Errorf("expected the process to be reported as terminated by SIGTERM, got signaled=%v signal=%v", info["signaled"], info["signal"])
+	}
+
+	if w := send(`{"id":"` + process.ID + `","signal":"SIGTERM"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a process that is not running, got %d", w.Code)
+	}
+}
+
+func TestTerminateProcessHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/terminate_process", []byte(`{"id":"`+process.ID+`","grace_period":1}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp TerminateProcessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !resp.Success || !resp.Graceful || resp.Status != string(ProcessStatusKilled) {
+		t.Errorf("expected sleep to stop on SIGTERM, got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/terminate_process", []byte(`{"id":"`+process.ID+`","grace_period":-1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative grace period, got %d", w.Code)
+	}
+}
+
+func TestWaitProcessHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	wait := func(id string, timeoutMs int64) WaitProcessResponse {
+		t.Helper()
+		body, _ := json.Marshal(WaitProcessRequest{ID: id, TimeoutMs: timeoutMs})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/wait_process", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp WaitProcessResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	quick, err := srv.processManager.StartProcess("sleep 0.1; exit 3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	resp := wait(quick.ID, 5000)
+	if resp.TimedOut || resp.Status != ProcessStatusFailed || resp.ExitCode == nil || *resp.ExitCode != 3 {
+		t.Errorf("expected the process to finish with exit code 3, got %+v", resp)
+	}
+
+	slow, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(slow.ID)
+
+	start := time.Now()
+	resp = wait(slow.ID, 100)
+	if !resp.TimedOut || resp.Status != ProcessStatusRunning || resp.ExitCode != nil {
+		t.Errorf("expected a timeout while still running, got %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected wait to return after the timeout, took %v", elapsed)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/wait_process", []byte(`{"id":"does-not-exist"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown process, got %d", w.Code)
+	}
+}
+
+func TestRestartProcessHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("true", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.doneChan()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/restart_process", []byte(`{"id":"`+process.ID+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp StartProcessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.ID != process.ID || resp.PID == 0 {
+		t.Errorf("expected the process to be relaunched under the same id, got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/restart_process", []byte(`{"id":"does-not-exist"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown process, got %d", w.Code)
+	}
+}
+
+func TestCommandRoutesRejectInvalidCwd(t *testing.T) {
+	srv, mux := newTestServer(t)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "bad", "path")
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range []string{"/run", "/run_streaming", "/start_process"} {
+		for cwd, want := range map[string]string{
+			missing: "cwd does not exist: " + missing,
+			file:    "cwd is not a directory: " + file,
+		} {
+			body, _ := json.Marshal(RunRequest{Cmd: "true", Cwd: cwd})
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, route, body))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("%s: expected 400 for cwd %s, got %d", route, cwd, w.Code)
+			}
+			if detail := decodeError(t, w); detail.Message != want {
+				t.Errorf("%s: expected %q, got %q", route, want, detail.Message)
+			}
+		}
+	}
+
+	// A process whose directory was removed after it ran cannot restart
+	cwd := filepath.Join(dir, "workdir")
+	if err := os.Mkdir(cwd, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	process, err := srv.processManager.StartProcess("true", cwd, nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-process.doneChan()
+	if err := os.Remove(cwd); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/restart_process", []byte(`{"id":"`+process.ID+`"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 restarting without a cwd, got %d", w.Code)
+	}
+	if detail := decodeError(t, w); detail.Message != "cwd does not exist: "+cwd {
+		t.Errorf("expected the missing cwd to be reported, got %q", detail.Message)
+	}
+}
+
+func TestChecksumHandler(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "abc.txt")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		algorithm string
+		want      string
+	}{
+		{"", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"md5", "900150983cd24fb0d6963f7d28e17f72"},
+		{"sha1", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"SHA256", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, tt := range tests {
+		reqBody, _ := json.Marshal(ChecksumRequest{Path: path, Algorithm: tt.algorithm})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/checksum", reqBody))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("algorithm %q: expected 200, got %d: %s", tt.algorithm, w.Code, w.Body.String())
+		}
+		var resp ChecksumResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Checksum != tt.want || resp.Size != 3 {
+			t.Errorf("algorithm %q: expected %s of 3 bytes, got %s of %d bytes", tt.algorithm, tt.want, resp.Checksum, resp.Size)
+		}
+	}
+
+	reqBody, _ := json.Marshal(ChecksumRequest{Path: path, Algorithm: "crc32"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/checksum", reqBody))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unsupported algorithm, got %d", w.Code)
+	}
+
+	reqBody, _ = json.Marshal(ChecksumRequest{Path: filepath.Join(t.TempDir(), "missing")})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/checksum", reqBody))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing file, got %d", w.Code)
+	}
+	if detail := decodeError(t, w); detail.Code != ErrorCodeNotFound {
+		t.Errorf("expected a not_found error, got %+v", detail)
+	}
+}
+
+func TestFileHandlersMissingPathStatus(t *testing.T) {
+	_, mux := newTestServer(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/read_file", fmt.Sprintf(`{"path": %q}`, missing)},
+		{"/write_file", fmt.Sprintf(`{"path": %q, "content": "x"}`, filepath.Join(missing, "file.txt"))},
+		{"/delete_file", fmt.Sprintf(`{"path": %q}`, missing)},
+		{"/list_dir", fmt.Sprintf(`{"path": %q}`, missing)},
+		{"/checksum", fmt.Sprintf(`{"path": %q}`, missing)},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.path, []byte(tt.body)))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", tt.path, w.Code)
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["error"] == "" {
+			t.Errorf("%s: expected a JSON error body, got %v (%v)", tt.path, resp, err)
+		}
+	}
+}
+
+func TestFileHandlersPermissionDeniedStatus(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks do not apply to root")
+	}
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o000); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/read_file", fmt.Sprintf(`{"path": %q}`, secret)},
+		{"/write_file", fmt.Sprintf(`{"path": %q, "content": "x"}`, filepath.Join(locked, "file.txt"))},
+		{"/make_dir", fmt.Sprintf(`{"path": %q}`, filepath.Join(locked, "sub"))},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.path, []byte(tt.body)))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d: %s", tt.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestFileErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&os.PathError{Op: "open", Path: "/missing", Err: syscall.ENOENT}, http.StatusNotFound},
+		{fmt.Errorf("failed to open: %w", &os.PathError{Op: "open", Path: "/x", Err: syscall.EACCES}), http.StatusForbidden},
+		{&os.PathError{Op: "unlink", Path: "/x", Err: syscall.EPERM}, http.StatusForbidden},
+		{&os.PathError{Op: "read", Path: "/x", Err: syscall.EISDIR}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := fileErrorStatus(tt.err); got != tt.want {
+			t.Errorf("fileErrorStatus(%v): expected %d, got %d", tt.err, tt.want, got)
+		}
+	}
+}
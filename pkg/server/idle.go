@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// activityTracker records when the executor was last used: by an
+// authenticated request, or by bytes going through a proxy. A nil tracker
+// records nothing, so callers need not check whether idle shutdown is on.
+type activityTracker struct {
+	// last is the time of the last activity, in Unix nanoseconds
+	last atomic.Int64
+	// inflight counts the requests being handled, which keep the executor
+	// busy however long they last
+	inflight atomic.Int64
+}
+
+func newActivityTracker() *activityTracker {
+	a := &activityTracker{}
+	a.touch()
+	return a
+}
+
+func (a *activityTracker) touch() {
+	if a == nil {
+		return
+	}
+	a.last.Store(time.Now().UnixNano())
+}
+
+// begin records the start of a request, end its completion
+func (a *activityTracker) begin() {
+	if a == nil {
+		return
+	}
+	a.inflight.Add(1)
+	a.touch()
+}
+
+func (a *activityTracker) end() {
+	if a == nil {
+		return
+	}
+	a.touch()
+	a.inflight.Add(-1)
+}
+
+// idleFor returns how long nothing happened, zero while a request is being
+// handled
+func (a *activityTracker) idleFor(now time.Time) time.Duration {
+	if a.inflight.Load() > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, a.last.Load()))
+}
+
+// watch closes idle once nothing happened for timeout, unless stop is closed
+// first
+func (a *activityTracker) watch(timeout time.Duration, idle chan<- struct{}, stop <-chan struct{}) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-timer.C:
+			idleFor := a.idleFor(now)
+			if idleFor >= timeout {
+				close(idle)
+				return
+			}
+			timer.Reset(timeout - idleFor)
+		}
+	}
+}
+
+// activityConn is a net.Conn recording activity whenever bytes are read
+type activityConn struct {
+	net.Conn
+	activity *activityTracker
+}
+
+// observeActivity wraps conn so its reads are recorded by activity. A nil
+// tracker returns conn unchanged, keeping io.Copy's zero-copy paths.
+func observeActivity(conn net.Conn, activity *activityTracker) net.Conn {
+	if activity == nil {
+		return conn
+	}
+	return &activityConn{Conn: conn, activity: activity}
+}
+
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.activity.touch()
+	}
+	return n, err
+}
+
+// Idle returns a channel closed once the executor received no authenticated
+// request and proxied no bytes for Config.IdleTimeout, so the caller can shut
+// it down. It is nil, and never ready, when idle shutdown is disabled.
+func (s *Server) Idle() <-chan struct{} {
+	return s.idle
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdleShutdownFiresAfterInactivity(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	srv, _ := newTestServerWithConfig(t, Config{IdleTimeout: timeout})
+	t.Cleanup(func() { srv.Shutdown(t.Context()) })
+
+	start := time.Now()
+	select {
+	case <-srv.Idle():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the idle channel to close")
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("expected idle shutdown after %v, fired after %v", timeout, elapsed)
+	}
+}
+
+func TestIdleShutdownDeferredByRequests(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	srv, mux := newTestServerWithConfig(t, Config{IdleTimeout: timeout})
+	t.Cleanup(func() { srv.Shutdown(t.Context()) })
+
+	// Requests every half window keep the executor busy for three windows
+	deadline := time.Now().Add(3 * timeout)
+	for time.Now().Before(deadline) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/list_processes", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		select {
+		case <-srv.Idle():
+			t.Fatal("expected requests to defer idle shutdown")
+		case <-time.After(timeout / 2):
+		}
+	}
+
+	// Unauthenticated requests are not activity
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/list_processes", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	select {
+	case <-srv.Idle():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected idle shutdown once requests stop")
+	}
+}
+
+func TestIdleShutdownDeferredByProxiedBytes(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	srv, _ := newTestServerWithConfig(t, Config{IdleTimeout: timeout})
+	t.Cleanup(func() { srv.Shutdown(t.Context()) })
+
+	client, target := net.Pipe()
+	defer client.Close()
+	conn := observeActivity(target, srv.activity)
+	go io.Copy(io.Discard, conn)
+
+	deadline := time.Now().Add(3 * timeout)
+	for time.Now().Before(deadline) {
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-srv.Idle():
+			t.Fatal("expected proxied bytes to defer idle shutdown")
+		case <-time.After(timeout / 2):
+		}
+	}
+}
+
+func TestIdleShutdownDisabled(t *testing.T) {
+	srv, _ := newTestServer(t)
+	if srv.Idle() != nil {
+		t.Error("expected no idle channel without an idle timeout")
+	}
+	if conn := observeActivity(nil, srv.activity); conn != nil {
+		t.Error("expected connections left unwrapped without an idle timeout")
+	}
+}
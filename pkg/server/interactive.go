@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/creack/pty"
+)
+
+// frameSource yields the frames a client sends to an interactive command. It
+// returns io.EOF when the client has no more input, and any other error when
+// the client is gone.
+type frameSource interface {
+	readFrame() (byte, []byte, error)
+}
+
+// frameSink sends frames to the client of an interactive command. Both
+// output streams write to it concurrently.
+type frameSink interface {
+	writeFrame(frameType byte, payload []byte) error
+}
+
+// interactiveCommand is a started command whose input and output are
+// exchanged as frames, whatever the transport: /run_ws and
+// /exec/interactive only differ in how frames travel.
+type interactiveCommand struct {
+	cmd     *exec.Cmd
+	limits  *ResourceLimits
+	stdin   io.WriteCloser
+	tty     *os.File
+	outputs []io.Reader
+}
+
+// startInteractive starts a command with opts, under a pseudo-terminal if
+// tty is set. The command is killed when ctx is done.
+func startInteractive(ctx context.Context, opts CommandOptions, tty bool) (*interactiveCommand, error) {
+	cmd := newCommand(ctx, opts)
+	c := &interactiveCommand{cmd: cmd, limits: opts.Limits}
+
+	if tty {
+		ptmx, err := startTTY(cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		// The terminal merges both streams
+		c.stdin, c.tty = ttyInput{ptmx}, ptmx
+		c.outputs = []io.Reader{ttyOutput{ptmx}}
+	} else {
+		stdin, stdinErr := cmd.StdinPipe()
+		stdout, stdoutErr := cmd.StdoutPipe()
+		stderr, stderrErr := cmd.StderrPipe()
+		if err := errors.Join(stdinErr, stdoutErr, stderrErr); err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		c.stdin = stdin
+		c.outputs = []io.Reader{stdout, stderr}
+	}
+	applyNice(ctx, cmd, opts.Nice)
+
+	return c, nil
+}
+
+// run forwards input frames from in and output frames to out until the
+// command exits, and reports how it exited. A client that goes away calls
+// cancel, which kills the command. ctx carries the request for logging.
+func (c *interactiveCommand) run(ctx context.Context, in frameSource, out frameSink, cancel context.CancelFunc) ExecExit {
+	if c.tty != nil {
+		defer c.tty.Close()
+	}
+
+	go c.forwardInput(ctx, in, cancel)
+
+	var wg sync.WaitGroup
+	for i, output := range c.outputs {
+		frameType := wsFrameStdout
+		if i == 1 {
+			frameType = wsFrameStderr
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forwardOutput(ctx, out, frameType, output)
+		}()
+	}
+	// Output must be drained before Wait closes the pipes
+	wg.Wait()
+
+	exitCode := 0
+	if err := c.cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+
+	signal := terminatingSignal(c.cmd.ProcessState)
+	return ExecExit{
+		Code:     exitCode,
+		Signaled: signal != "",
+		Signal:   signal,
+		Reason:   limitReason(c.cmd.ProcessState, c.limits),
+	}
+}
+
+// forwardInput writes stdin frames to the process. The end of the input
+// closes stdin, as a client that has no more input half-closes its side.
+// Resize frames apply to the terminal, and are ignored for commands without
+// one.
+func (c *interactiveCommand) forwardInput(ctx context.Context, in frameSource, cancel context.CancelFunc) {
+	defer c.stdin.Close()
+
+	for {
+		frameType, payload, err := in.readFrame()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			slog.DebugContext(ctx, "Interactive input closed", "error", err)
+			cancel()
+			return
+		}
+
+		switch frameType {
+		case wsFrameStdin:
+			if len(payload) == 0 {
+				c.stdin.Close()
+				continue
+			}
+			if _, err := c.stdin.Write(payload); err != nil {
+				slog.DebugContext(ctx, "Failed to write interactive input to stdin", "error", err)
+			}
+		case wsFrameResize:
+			if c.tty == nil {
+				continue
+			}
+			var size wsResize
+			if err := json.Unmarshal(payload, &size); err != nil {
+				slog.DebugContext(ctx, "Invalid interactive resize frame", "error", err)
+				continue
+			}
+			if err := pty.Setsize(c.tty, &pty.Winsize{Rows: size.Rows, Cols: size.Cols}); err != nil {
+				slog.DebugContext(ctx, "Failed to resize terminal", "error", err)
+			}
+		default:
+			slog.DebugContext(ctx, "Ignoring unknown interactive frame", "type", frameType)
+		}
+	}
+}
+
+// forwardOutput sends everything read from r as frames of frameType. Output
+// is forwarded as it arrives rather than by line so prompts without a
+// trailing newline show up.
+func forwardOutput(ctx context.Context, out frameSink, frameType byte, r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := out.writeFrame(frameType, buf[:n]); werr != nil {
+				// Keep draining so the process doesn't block on a full pipe
+				slog.DebugContext(ctx, "Failed to write interactive output", "error", werr)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ttyInput writes client input to a PTY master. Closing it sends the
+// terminal's end-of-file character rather than closing the master, which
+// still carries the output.
+type ttyInput struct {
+	*os.File
+}
+
+func (t ttyInput) Close() error {
+	_, err := t.Write([]byte{0x04})
+	return err
+}
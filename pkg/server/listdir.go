@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"slices"
+)
+
+// listDirBatchSize is how many entries a paginated listing reads at a time
+const listDirBatchSize = 1024
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeListDirCursor makes the token resuming a listing after name. It is
+// opaque to clients, who only pass it back.
+func encodeListDirCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// decodeListDirCursor returns the name a listing resumes after
+func decodeListDirCursor(cursor string) (string, error) {
+	name, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(name) == 0 {
+		return "", errInvalidCursor
+	}
+	return string(name), nil
+}
+
+// readDirPage returns the first limit names of the directory at path that
+// sort after the name after, or from the start when after is empty, and
+// whether more follow. Pages are in name order like os.ReadDir, but the
+// directory is read in batches and only the page is kept in memory. Entries
+// are matched by name rather than position, so pages have no gaps or
+// duplicates while the directory changes: an entry added or removed between
+// two requests is listed or not, depending on where it sorts.
+func readDirPage(path, after string, limit int) ([]string, bool, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer dir.Close()
+
+	var page []string
+	more := false
+	for {
+		entries, err := dir.ReadDir(listDirBatchSize)
+		for _, entry := range entries {
+			if name := entry.Name(); name > after {
+				page = append(page, name)
+			}
+		}
+		// Keep only the names that can still be on the page
+		if len(page) >= 2*limit {
+			slices.Sort(page)
+			page, more = page[:limit], true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	slices.Sort(page)
+	if len(page) > limit {
+		page, more = page[:limit], true
+	}
+	return page, more, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func listDirPageRequest(t *testing.T, mux http.Handler, query url.Values, req ListDirRequest) ListDirResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/list_dir?"+query.Encode(), body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp ListDirResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestListDirPagination(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	// More entries than a batch, so pages span several reads
+	var want []string
+	for i := 0; i < 2*listDirBatchSize+500; i++ {
+		name := fmt.Sprintf("file-%05d", i)
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+
+	for _, limit := range []int{100, listDirBatchSize, 10000} {
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("limit %d: pagination does not end", limit)
+			}
+			query := url.Values{"limit": {fmt.Sprint(limit)}}
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+			resp := listDirPageRequest(t, mux, query, ListDirRequest{Path: dir})
+			if len(resp.Entries) > limit {
+				t.Fatalf("limit %d: got a page of %d entries", limit, len(resp.Entries))
+			}
+			got = append(got, resp.Entries...)
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("limit %d: expected every entry exactly once in name order, got %d entries", limit, len(got))
+		}
+	}
+
+	// The body takes the same options, e.g. for /batch
+	resp := listDirPageRequest(t, mux, nil, ListDirRequest{Path: dir, Limit: 2})
+	if !slices.Equal(resp.Entries, want[:2]) || resp.NextCursor == "" {
+		t.Fatalf("expected the first page, got %+v", resp)
+	}
+	resp = listDirPageRequest(t, mux, nil, ListDirRequest{Path: dir, Limit: 2, Cursor: resp.NextCursor})
+	if !slices.Equal(resp.Entries, want[2:4]) {
+		t.Errorf("expected the second page, got %+v", resp)
+	}
+
+	// Without a limit the whole directory is listed
+	resp = listDirPageRequest(t, mux, nil, ListDirRequest{Path: dir})
+	if len(resp.Entries) != len(want) || resp.NextCursor != "" {
+		t.Errorf("expected an unpaginated listing, got %d entries and cursor %q", len(resp.Entries), resp.NextCursor)
+	}
+}
+
+func TestListDirPaginationWhileChanging(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+
+	query := url.Values{"limit": {"2"}}
+	first := listDirPageRequest(t, mux, query, ListDirRequest{Path: dir})
+	if !slices.Equal(first.Entries, []string{"a", "b"}) {
+		t.Fatalf("expected the first page, got %v", first.Entries)
+	}
+
+	// Removing the last listed entry does not lose the cursor; an entry
+	// added before the cursor is skipped and one added after it is listed
+	os.Remove(filepath.Join(dir, "b"))
+	os.WriteFile(filepath.Join(dir, "aa"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "f"), nil, 0o644)
+
+	var rest []string
+	query.Set("cursor", first.NextCursor)
+	for {
+		resp := listDirPageRequest(t, mux, query, ListDirRequest{Path: dir})
+		rest = append(rest, resp.Entries...)
+		if resp.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+	if !slices.Equal(rest, []string{"c", "d", "e", "f"}) {
+		t.Errorf("expected the entries after the cursor, got %v", rest)
+	}
+}
+
+func TestListDirPaginationInvalid(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		query string
+		req   ListDirRequest
+	}{
+		{"zero limit", "limit=0", ListDirRequest{Path: dir}},
+		{"negative limit", "", ListDirRequest{Path: dir, Limit: -1}},
+		{"non-numeric limit", "limit=ten", ListDirRequest{Path: dir}},
+		{"cursor without limit", "cursor=" + encodeListDirCursor("a"), ListDirRequest{Path: dir}},
+		{"malformed cursor", "limit=5&cursor=%21%21", ListDirRequest{Path: dir}},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(tt.req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/list_dir?"+tt.query, body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", tt.name, w.Code, w.Body.String())
+		}
+	}
+}
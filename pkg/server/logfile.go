@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Size-based rotation settings for on-disk process logs
+const (
+	processLogFileMaxSize = 10 * 1024 * 1024
+	processLogFileBackups = 3
+)
+
+// processLogPath returns the on-disk log file for a stream of a process.
+// Rotated files carry a numeric suffix, ".1" being the most recent.
+func processLogPath(dir, id, stream string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.%s.log", id, stream))
+}
+
+// rotatingFile is an append-only file that is rotated once it grows past
+// maxSize, keeping up to backups older files
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.size > 0 && rf.size+int64(len(data)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(data)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N-1 to path.N down to path -> path.1 and reopens path
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	for i := rf.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.backups > 0 {
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// removeProcessLogs deletes the on-disk log of a process stream, rotated
+// files included
+func removeProcessLogs(dir, id, stream string) {
+	path := processLogPath(dir, id, stream)
+	os.Remove(path)
+	for i := 1; i <= processLogFileBackups; i++ {
+		os.Remove(fmt.Sprintf("%s.%d", path, i))
+	}
+}
+
+// openProcessLogHistory opens the on-disk log of a process stream, oldest
+// rotated file first. It returns os.ErrNotExist if nothing was persisted.
+func openProcessLogHistory(dir, id, stream string) (io.ReadCloser, error) {
+	// IDs are UUIDs; reject anything else so the id can't escape dir
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	path := processLogPath(dir, id, stream)
+	var files []*os.File
+	for i := processLogFileBackups; i >= 0; i-- {
+		name := path
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", path, i)
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return newMultiFileReader(files), nil
+}
+
+// multiFileReader reads a sequence of files back to back
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func newMultiFileReader(files []*os.File) *multiFileReader {
+	readers := make([]io.Reader, len(files))
+	for i, file := range files {
+		readers[i] = file
+	}
+	return &multiFileReader{Reader: io.MultiReader(readers...), files: files}
+}
+
+func (m *multiFileReader) Close() error {
+	var firstErr error
+	for _, file := range m.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("failed to open rotating file: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := fmt.Fprintf(rf, "line%d---\n", i); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	// 10-byte lines: one per file, only two backups kept
+	for name, want := range map[string]string{
+		"out.log":   "line4---\n",
+		"out.log.1": "line3---\n",
+		"out.log.2": "line2---\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", name, want, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.log.3")); !os.IsNotExist(err) {
+		t.Errorf("expected no third backup, got %v", err)
+	}
+}
+
+func TestOpenProcessLogHistoryOrdersRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	id := uuid.New().String()
+	path := processLogPath(dir, id, "stdout")
+
+	for name, content := range map[string]string{
+		path + ".2": "oldest\n",
+		path + ".1": "older\n",
+		path:        "newest\n",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := openProcessLogHistory(dir, id, "stdout")
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+	defer history.Close()
+
+	data, _ := io.ReadAll(history)
+	if string(data) != "oldest\nolder\nnewest\n" {
+		t.Errorf("expected rotated files oldest first, got %q", data)
+	}
+
+	if _, err := openProcessLogHistory(dir, "../../etc/passwd", "stdout"); !os.IsNotExist(err) {
+		t.Errorf("expected non-UUID ids to be rejected, got %v", err)
+	}
+}
+
+func TestProcessLogsDownload(t *testing.T) {
+	logDir := t.TempDir()
+	srv, mux := newTestServerWithConfig(t, Config{ProcessLogDir: logDir})
+
+	reqBody, _ := json.Marshal(StartProcessRequest{Cmd: "echo one; echo two; echo oops >&2", PersistLogs: true})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var started StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&started)
+
+	process, _ := srv.processManager.GetProcess(started.ID)
+	<-process.done
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_download?id="+started.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "one\ntwo\n" {
+		t.Errorf("expected stdout history, got %q", w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), started.ID+".stdout.log") {
+		t.Errorf("unexpected Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_download?id="+started.ID+"&stream=stderr", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), []byte("oops\n")) {
+		t.Errorf("expected stderr history, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_logs_download?id="+uuid.New().String(), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown process, got %d", w.Code)
+	}
+}
+
+func TestStartProcessPersistLogsRequiresLogDir(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	reqBody, _ := json.Marshal(StartProcessRequest{Cmd: "true", PersistLogs: true})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a log directory, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestForgottenProcessLogFilesAreDeleted(t *testing.T) {
+	logDir := t.TempDir()
+	pm := NewProcessManager()
+	pm.logDir = logDir
+
+	start := func(role string) *Process {
+		process, err := pm.StartProcessWithOptions(ProcessOptions{
+			CommandOptions: CommandOptions{Command: "echo out; echo err >&2"},
+			PersistLogs:    true,
+			Labels:         map[string]string{"role": role},
+		})
+		if err != nil {
+			t.Fatalf("failed to start process: %v", err)
+		}
+		<-process.doneChan()
+		return process
+	}
+
+	removed, reaped := start("removed"), start("reaped")
+	start("pruned")
+	// A rotated file goes too
+	os.WriteFile(processLogPath(logDir, removed.ID, "stdout")+".1", []byte("older\n"), 0o644)
+
+	if err := pm.RemoveProcess(removed.ID); err != nil {
+		t.Fatalf("failed to remove process: %v", err)
+	}
+	if ids := pm.PruneProcesses(ProcessFilter{Labels: map[string]string{"role": "pruned"}}, time.Time{}); len(ids) != 1 {
+		t.Fatalf("expected the process to be pruned, got %v", ids)
+	}
+	if ids := pm.reapExpired(time.Hour, time.Now().Add(2*time.Hour)); len(ids) != 1 || ids[0] != reaped.ID {
+		t.Fatalf("expected the process to be reaped, got %v", ids)
+	}
+
+	entries, _ := os.ReadDir(logDir)
+	if len(entries) != 0 {
+		t.Errorf("expected every log file to be deleted, got %v", entries)
+	}
+}
+
+func TestFailedStartLogFilesAreDeleted(t *testing.T) {
+	logDir := t.TempDir()
+	pm := NewProcessManager()
+	pm.logDir = logDir
+
+	_, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{Argv: []string{filepath.Join(logDir, "missing")}},
+		PersistLogs:    true,
+	})
+	if err == nil {
+		t.Fatal("expected the start to fail")
+	}
+
+	entries, _ := os.ReadDir(logDir)
+	if len(entries) != 0 {
+		t.Errorf("expected the log files to be deleted, got %v", entries)
+	}
+}
@@ -9,26 +9,36 @@ import (
 
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.Trace("Auth check", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		logger.TraceContext(r.Context(), "Auth check", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+
+		if s.auth.authorizeCertificate(r.TLS) {
+			logger.TraceContext(r.Context(), "Authorized request by client certificate", "method", r.Method, "path", r.URL.Path, "subject", r.TLS.VerifiedChains[0][0].Subject.String())
+			s.activity.begin()
+			defer s.activity.end()
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		authorized, bootstrapped, err := s.auth.authorize(r.Header.Get("Authorization"))
 		if err != nil {
-			slog.Error("Auth check failed", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Auth check failed", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error")
 			return
 		}
 
 		if !authorized {
-			logger.Trace("Unauthorized request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			logger.TraceContext(r.Context(), "Unauthorized request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Unauthorized")
 			return
 		}
 
 		if bootstrapped {
-			slog.Info("Pool auth bootstrapped", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			slog.InfoContext(r.Context(), "Pool auth bootstrapped", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
 		}
 
-		logger.Trace("Authorized request", "method", r.Method, "path", r.URL.Path)
+		logger.TraceContext(r.Context(), "Authorized request", "method", r.Method, "path", r.URL.Path)
+		s.activity.begin()
+		defer s.activity.end()
 		next.ServeHTTP(w, r)
 	})
 }
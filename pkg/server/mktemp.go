@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTempPattern names temporary entries when a request gives no pattern
+const defaultTempPattern = "tmp.*"
+
+// resolveTempDir returns the directory /mktemp creates entries in, creating
+// it if missing. A relative dir is taken relative to root, like request
+// paths. The default is the system temp directory, or root/.tmp when that is
+// outside root, so /mktemp works under any root.
+func resolveTempDir(root, dir string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+		if !isWithinRoot(root, dir) {
+			dir = filepath.Join(root, ".tmp")
+		}
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	dir = filepath.Clean(dir)
+
+	if !isWithinRoot(root, dir) {
+		return "", fmt.Errorf("SANDBOX_TEMP_DIR %q is outside SANDBOX_ROOT %q", dir, root)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	// A symlink must not lead the directory out of the root either
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid SANDBOX_TEMP_DIR %q: %w", dir, err)
+	}
+	if !isWithinRoot(root, resolved) {
+		return "", fmt.Errorf("SANDBOX_TEMP_DIR %q resolves outside SANDBOX_ROOT %q", dir, root)
+	}
+	return dir, nil
+}
+
+type MkTempRequest struct {
+	// Dir creates a directory instead of a file
+	Dir bool `json:"dir,omitempty"`
+	// Pattern names the entry: its last "*" is replaced with a random
+	// string, which is appended when there is none
+	Pattern string `json:"pattern,omitempty"`
+}
+
+type MkTempResponse struct {
+	Success bool   `json:"success"`
+	Path    string `json:"path"`
+	Dir     bool   `json:"dir"`
+}
+
+// mktempHandler creates a uniquely named, empty file or directory in the
+// server's temp directory, so concurrent scripts don't race on fixed paths
+func (s *Server) mktempHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req MkTempRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	pattern := req.Pattern
+	if pattern == "" {
+		pattern = defaultTempPattern
+	}
+	if strings.ContainsRune(pattern, os.PathSeparator) {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "pattern must not contain a path separator")
+		return
+	}
+
+	dir, ok := s.sandboxPath(w, s.tempDir)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Mktemp request", "temp_dir", dir, "pattern", pattern, "dir", req.Dir)
+
+	var path string
+	if req.Dir {
+		created, err := os.MkdirTemp(dir, pattern)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to create temp directory", "temp_dir", dir, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		path = created
+	} else {
+		file, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to create temp file", "temp_dir", dir, "error", err)
+			writeFileError(w, err)
+			return
+		}
+		path = file.Name()
+		file.Close()
+	}
+	auditTarget(r.Context(), path)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MkTempResponse{Success: true, Path: path, Dir: req.Dir})
+}
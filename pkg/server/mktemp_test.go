@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mktemp(t *testing.T, mux http.Handler, req MkTempRequest) (int, MkTempResponse) {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/mktemp", body))
+	var resp MkTempResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestMkTempCreatesUniqueEntries(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+	_, mux := newTestServerWithConfig(t, Config{TempDir: tempDir})
+
+	seen := make(map[string]bool)
+	for _, req := range []MkTempRequest{{}, {}, {Dir: true}, {Dir: true}} {
+		code, resp := mktemp(t, mux, req)
+		if code != http.StatusOK || !resp.Success || resp.Dir != req.Dir {
+			t.Fatalf("expected %+v to succeed, got %d %+v", req, code, resp)
+		}
+		if filepath.Dir(resp.Path) != tempDir || !strings.HasPrefix(filepath.Base(resp.Path), "tmp.") {
+			t.Errorf("expected a tmp.* entry in %s, got %s", tempDir, resp.Path)
+		}
+		if seen[resp.Path] {
+			t.Errorf("expected a unique path, got %s twice", resp.Path)
+		}
+		seen[resp.Path] = true
+
+		info, err := os.Stat(resp.Path)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", resp.Path, err)
+		}
+		if info.IsDir() != req.Dir {
+			t.Errorf("expected dir=%v for %s, got mode %s", req.Dir, resp.Path, info.Mode())
+		}
+		if !req.Dir && info.Size() != 0 {
+			t.Errorf("expected an empty file, got %d bytes", info.Size())
+		}
+	}
+
+	code, resp := mktemp(t, mux, MkTempRequest{Pattern: "build-*.log"})
+	if code != http.StatusOK {
+		t.Fatalf("expected a pattern to be accepted, got %d", code)
+	}
+	if name := filepath.Base(resp.Path); !strings.HasPrefix(name, "build-") || !strings.HasSuffix(name, ".log") || name == "build-.log" {
+		t.Errorf("expected the random part in place of *, got %s", name)
+	}
+
+	if code, _ := mktemp(t, mux, MkTempRequest{Pattern: "../escape-*"}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a pattern with a path separator, got %d", code)
+	}
+}
+
+func TestMkTempConfinedToRoot(t *testing.T) {
+	root := t.TempDir()
+
+	_, mux := newTestServerWithConfig(t, Config{Root: root, TempDir: filepath.Join(root, "tmp")})
+	code, resp := mktemp(t, mux, MkTempRequest{Dir: true})
+	if code != http.StatusOK || !strings.HasPrefix(resp.Path, filepath.Join(root, "tmp")+"/") {
+		t.Fatalf("expected a directory under the root, got %d %+v", code, resp)
+	}
+
+	_, mux = newTestServerWithConfig(t, Config{Root: root, TempDir: "scratch"})
+	code, resp = mktemp(t, mux, MkTempRequest{})
+	if code != http.StatusOK || filepath.Dir(resp.Path) != filepath.Join(root, "scratch") {
+		t.Errorf("expected a relative temp directory to be resolved against the root, got %d %+v", code, resp)
+	}
+
+	for _, tempDir := range []string{t.TempDir(), "../outside"} {
+		_, err := New(Config{
+			Auth:    AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"},
+			Root:    root,
+			TempDir: tempDir,
+		})
+		if err == nil {
+			t.Errorf("expected a temp directory %q outside the root to be rejected at startup", tempDir)
+		}
+	}
+}
+
+func TestMkTempDefaultsInsideRoot(t *testing.T) {
+	root := t.TempDir()
+
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+	code, resp := mktemp(t, mux, MkTempRequest{})
+	if code != http.StatusOK || filepath.Dir(resp.Path) != filepath.Join(root, ".tmp") {
+		t.Fatalf("expected the default temp directory to be %s, got %d %+v", filepath.Join(root, ".tmp"), code, resp)
+	}
+}
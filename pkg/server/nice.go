@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"syscall"
+)
+
+// Bounds of the niceness of a command, from the highest priority to the
+// lowest
+const (
+	MinNice = -20
+	MaxNice = 19
+)
+
+// niceValue returns the niceness a command asking for nice runs at, clamped
+// to MinNice..MaxNice. Raising the priority above the executor's own takes
+// privileges, CAP_SYS_NICE or RLIMIT_NICE; without them the niceness is
+// raised to the lowest the executor may set, so the command still runs.
+func niceValue(ctx context.Context, nice int) int {
+	nice = max(MinNice, min(nice, MaxNice))
+	_, lowest := niceBounds()
+	if nice < lowest {
+		slog.WarnContext(ctx, "Not permitted to raise the priority of a command, using the lowest permitted niceness", "nice", nice, "lowest", lowest)
+		nice = lowest
+	}
+	return nice
+}
+
+// applyNice sets the niceness of a started command, see niceValue. The
+// executor sets it on the command's process group rather than running the
+// command through nice, so it is set with the executor's privileges even when
+// the command runs as another user. The command runs at the executor's
+// niceness until then. Zero keeps the executor's niceness.
+func applyNice(ctx context.Context, cmd *exec.Cmd, nice int) {
+	if nice == 0 || cmd.Process == nil {
+		return
+	}
+	nice = niceValue(ctx, nice)
+	// A command that already exited has nothing left to adjust
+	if err := setGroupNice(cmd.Process.Pid, nice); err != nil && !errors.Is(err, syscall.ESRCH) {
+		slog.WarnContext(ctx, "Failed to set the niceness of a command", "pid", cmd.Process.Pid, "nice", nice, "error", err)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package server
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// capSysNice is the capability to set any niceness
+	capSysNice = 23
+	// rlimitNice is RLIMIT_NICE, which syscall does not define. Its limit
+	// allows a niceness down to 20 minus the limit.
+	rlimitNice = 13
+)
+
+// niceBounds returns the executor's own niceness and the lowest niceness it
+// may give its commands
+func niceBounds() (own, lowest int) {
+	// The raw syscall returns 20 minus the niceness so as not to be negative
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return 0, 0
+	}
+	own = 20 - priority
+
+	if hasCapability(capSysNice) {
+		return own, MinNice
+	}
+	lowest = own
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitNice, &limit); err == nil && limit.Cur > 0 {
+		lowest = min(lowest, 20-int(min(limit.Cur, 40)))
+	}
+	return own, lowest
+}
+
+// setGroupNice sets the niceness of the process group led by pid: commands
+// lead their own group, so processes they already forked follow too
+func setGroupNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pid, nice)
+}
+
+// hasCapability reports whether the executor has capability in its
+// effective set, as listed by /proc/self/status
+func hasCapability(capability uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		return err == nil && caps&(1<<capability) != 0
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// niceBounds returns the executor's own niceness and the lowest niceness it
+// may give its commands. Outside Linux the executor is assumed to run at the
+// default niceness and not to be permitted to raise priorities.
+func niceBounds() (own, lowest int) {
+	return 0, 0
+}
+
+// setGroupNice is not supported outside Linux; commands keep the executor's
+// niceness
+func setGroupNice(pid, nice int) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readNice reads the niceness of pid, field 19 of /proc/<pid>/stat
+func readNice(t *testing.T, pid int) int {
+	t.Helper()
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatalf("failed to read stat: %v", err)
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	nice, err := strconv.Atoi(fields[16])
+	if err != nil {
+		t.Fatalf("unexpected stat format: %s", stat)
+	}
+	return nice
+}
+
+func TestStartProcessWithNice(t *testing.T) {
+	own, _ := niceBounds()
+	if own > 10 {
+		t.Skipf("the executor already runs at niceness %d", own)
+	}
+	srv, mux := newTestServer(t)
+
+	reqBody, _ := json.Marshal(StartProcessRequest{Argv: []string{"sleep", "10"}, Nice: 10})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var started StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&started)
+	defer srv.processManager.KillProcess(started.ID)
+
+	// The niceness is set before the start is answered
+	if got := readNice(t, started.PID); got != 10 {
+		t.Errorf("expected the process to run at niceness 10, got %d", got)
+	}
+}
+
+func TestRunWithNice(t *testing.T) {
+	_, mux := newTestServer(t)
+	_, lowest := niceBounds()
+
+	tests := []struct {
+		name string
+		req  RunRequest
+		want int
+	}{
+		{"clamped", RunRequest{Nice: 50}, MaxNice},
+		{"with limits", RunRequest{Nice: 5, Limits: &ResourceLimits{MaxOpenFiles: 64}}, 5},
+		// Without privileges, the lowest permitted niceness is used instead
+		{"higher priority", RunRequest{Nice: -5}, max(-5, lowest)},
+	}
+	for _, tt := range tests {
+		// The niceness is set once the shell started, before its child
+		tt.req.Cmd = "sleep 0.1; cut -d ' ' -f 19 /proc/self/stat"
+		resp := runRequest(t, mux, tt.req)
+		if got := strings.TrimSpace(resp.Stdout); got != strconv.Itoa(tt.want) {
+			t.Errorf("%s: expected niceness %d, got %q (stderr %q)", tt.name, tt.want, got, resp.Stderr)
+		}
+	}
+}
+
+func TestRunWithNiceAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires running as root")
+	}
+	if _, lowest := niceBounds(); lowest > -5 {
+		t.Skip("the executor is not permitted to raise priorities")
+	}
+	_, mux := newTestServer(t)
+
+	// The command runs without the executor's privileges, but the executor
+	// sets its niceness
+	resp := runRequest(t, mux, RunRequest{Cmd: "sleep 0.1; id -u; cut -d ' ' -f 19 /proc/self/stat", Nice: -5, User: "65534"})
+	if got := strings.Fields(resp.Stdout); len(got) != 2 || got[0] != "65534" || got[1] != "-5" {
+		t.Errorf("expected uid 65534 at niceness -5, got %q (stderr %q)", resp.Stdout, resp.Stderr)
+	}
+}
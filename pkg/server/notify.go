@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NotifySignatureHeader carries the HMAC-SHA256 of an exit notification body,
+// keyed with the bearer secret, as "sha256=<hex>"
+const NotifySignatureHeader = "X-Sandbox-Signature"
+
+const (
+	// notifyTimeout bounds each attempt to deliver an exit notification
+	notifyTimeout = 10 * time.Second
+	// notifyAttempts is how many times delivery is tried
+	notifyAttempts = 3
+)
+
+// notifyRetryDelay is the wait before the second attempt, doubled for each
+// later one. Swappable in tests.
+var notifyRetryDelay = time.Second
+
+// ProcessExitNotification is POSTed to the notify_url of a process when it
+// exits
+type ProcessExitNotification struct {
+	ID       string        `json:"id"`
+	Status   ProcessStatus `json:"status"`
+	ExitCode int           `json:"exit_code"`
+	// Reason names the resource limit that terminated the process, if any
+	Reason string `json:"reason,omitempty"`
+	// Signal names the signal that terminated the process, if any
+	Signal  string    `json:"signal,omitempty"`
+	Command string    `json:"command"`
+	EndTime time.Time `json:"end_time"`
+}
+
+// validateNotifyURL accepts absolute http and https URLs
+func validateNotifyURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("expected an absolute http or https URL")
+	}
+	return nil
+}
+
+// signNotification returns the NotifySignatureHeader value for body
+func signNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendExitNotification POSTs notification to target, signed with secret when
+// one is set. Network errors, 429 and 5xx responses are retried.
+func sendExitNotification(target, secret string, notification ProcessExitNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	delay := notifyRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = postNotification(client, target, secret, body)
+		if err == nil {
+			return nil
+		}
+		retryable, _ := err.(retryableNotifyError)
+		if !retryable.retry || attempt == notifyAttempts {
+			return err
+		}
+		slog.Debug("Retrying exit notification", "id", notification.ID, "url", target, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// retryableNotifyError reports whether a failed delivery is worth retrying
+type retryableNotifyError struct {
+	err   error
+	retry bool
+}
+
+func (e retryableNotifyError) Error() string {
+	return e.err.Error()
+}
+
+func postNotification(client *http.Client, target, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return retryableNotifyError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(NotifySignatureHeader, signNotification(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return retryableNotifyError{err: err, retry: true}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return retryableNotifyError{
+		err:   fmt.Errorf("notify URL answered %s", resp.Status),
+		retry: resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+	}
+}
+
+// notifyExit delivers the exit notification of process, if it asked for one.
+// Callers hold process.mu.
+func (pm *ProcessManager) notifyExit(process *Process) {
+	target := process.opts.NotifyURL
+	if target == "" {
+		return
+	}
+	notification := ProcessExitNotification{
+		ID:       process.ID,
+		Status:   process.Status,
+		ExitCode: *process.ExitCode,
+		Reason:   process.Reason,
+		Signal:   process.Signal,
+		Command:  process.Command,
+		EndTime:  *process.EndTime,
+	}
+	var secret string
+	if pm.notifySecret != nil {
+		secret = pm.notifySecret()
+	}
+
+	go func() {
+		if err := sendExitNotification(target, secret, notification); err != nil {
+			slog.Warn("Failed to deliver exit notification", "id", notification.ID, "url", target, "error", err)
+			return
+		}
+		slog.Debug("Exit notification delivered", "id", notification.ID, "url", target)
+	}()
+}
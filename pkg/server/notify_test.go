@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type receivedNotification struct {
+	body      []byte
+	signature string
+}
+
+// startNotifyReceiver serves a notify URL that answers the first failures
+// requests with 503 and passes the others to the returned channel
+func startNotifyReceiver(t *testing.T, failures int32) (string, <-chan receivedNotification) {
+	t.Helper()
+
+	received := make(chan receivedNotification, 4)
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedNotification{body: body, signature: r.Header.Get(NotifySignatureHeader)}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver.URL, received
+}
+
+func startNotifiedProcess(t *testing.T, mux http.Handler, cmd, notifyURL string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(StartProcessRequest{Cmd: cmd, NotifyURL: notifyURL})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp.ID
+}
+
+func TestExitNotificationIsDelivered(t *testing.T) {
+	_, mux := newTestServer(t)
+	url, received := startNotifyReceiver(t, 0)
+
+	id := startNotifiedProcess(t, mux, "exit 3", url)
+
+	select {
+	case got := <-received:
+		var notification ProcessExitNotification
+		if err := json.Unmarshal(got.body, &notification); err != nil {
+			t.Fatalf("invalid notification body %s: %v", got.body, err)
+		}
+		if notification.ID != id || notification.Status != ProcessStatusFailed || notification.ExitCode != 3 || notification.EndTime.IsZero() {
+			t.Errorf("unexpected notification: %+v", notification)
+		}
+		if got.signature != signNotification("test-secret", got.body) {
+			t.Errorf("expected the body to be signed with the secret, got %q", got.signature)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the exit notification")
+	}
+}
+
+func TestExitNotificationIsRetried(t *testing.T) {
+	previous := notifyRetryDelay
+	notifyRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { notifyRetryDelay = previous })
+
+	_, mux := newTestServer(t)
+	url, received := startNotifyReceiver(t, notifyAttempts-1)
+
+	id := startNotifiedProcess(t, mux, "true", url)
+
+	select {
+	case got := <-received:
+		var notification ProcessExitNotification
+		json.Unmarshal(got.body, &notification)
+		if notification.ID != id || notification.Status != ProcessStatusCompleted || notification.ExitCode != 0 {
+			t.Errorf("unexpected notification: %+v", notification)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the retried exit notification")
+	}
+}
+
+func TestStartProcessRejectsInvalidNotifyURL(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for _, notifyURL := range []string{"ftp://example.com/hook", "/relative", "http://"} {
+		body, _ := json.Marshal(StartProcessRequest{Cmd: "true", NotifyURL: notifyURL})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected notify_url %q to be rejected, got %d", notifyURL, w.Code)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"hash/fnv"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// pathLockShards is the number of mutexes paths are spread over. Distinct
+// paths sharing a shard only wait on each other, they are never unsafe.
+const pathLockShards = 64
+
+// pathLocker serializes the operations that change the same path, such as a
+// write and a delete of one file, so they apply one after the other rather
+// than interleave. Paths are hashed onto a fixed set of mutexes, so locking
+// allocates nothing and no per-path state has to be cleaned up.
+type pathLocker struct {
+	shards [pathLockShards]sync.Mutex
+}
+
+func (l *pathLocker) shard(path string) int {
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Clean(path)))
+	return int(h.Sum32() % pathLockShards)
+}
+
+// lock locks paths and returns the function unlocking them. Shards are
+// always locked in the same order, so operations locking several paths, like
+// a move, cannot deadlock each other.
+func (l *pathLocker) lock(paths ...string) func() {
+	shards := make([]int, 0, len(paths))
+	for _, path := range paths {
+		shards = append(shards, l.shard(path))
+	}
+	slices.Sort(shards)
+	shards = slices.Compact(shards)
+
+	for _, shard := range shards {
+		l.shards[shard].Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			l.shards[shards[i]].Unlock()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentWritesAndDeletesOnOnePath(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "contended.txt")
+
+	// Versions of different sizes, so an interleaved write shows up as mixed
+	// bytes or a wrong length
+	versions := make(map[string]bool)
+	var contents []string
+	for i := range 8 {
+		content := string(bytes.Repeat([]byte{byte('a' + i)}, 64<<10+i*4099))
+		versions[content] = true
+		contents = append(contents, content)
+	}
+
+	for round := range 3 {
+		var wg sync.WaitGroup
+		var failures atomic.Int64
+		for i := range 32 {
+			wg.Go(func() {
+				var w *httptest.ResponseRecorder
+				if i%8 == 7 {
+					body, _ := json.Marshal(DeleteFileRequest{Path: path})
+					w = httptest.NewRecorder()
+					mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_file", body))
+					if w.Code == http.StatusNotFound {
+						return
+					}
+				} else {
+					body, _ := json.Marshal(WriteFileRequest{Path: path, Content: contents[i%len(contents)]})
+					w = httptest.NewRecorder()
+					mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", body))
+				}
+				if w.Code != http.StatusOK {
+					failures.Add(1)
+					t.Errorf("unexpected %d: %s", w.Code, w.Body.String())
+				}
+			})
+		}
+		wg.Wait()
+		if failures.Load() > 0 {
+			t.FailNow()
+		}
+
+		content, err := os.ReadFile(path)
+		if err == nil && !versions[string(content)] {
+			t.Fatalf("round %d: expected the file to hold one whole version, got %d bytes starting with %q", round, len(content), content[:min(len(content), 8)])
+		}
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("failed to read the file: %v", err)
+		}
+	}
+
+	// Once the contention is over, the last operation decides the state
+	body, _ := json.Marshal(WriteFileRequest{Path: path, Content: contents[0]})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", body))
+	if got, _ := os.ReadFile(path); w.Code != http.StatusOK || string(got) != contents[0] {
+		t.Errorf("expected the final write to win, got %d and %d bytes", w.Code, len(got))
+	}
+}
+
+func TestWriteWaitsForPathLock(t *testing.T) {
+	srv, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "locked.txt")
+
+	unlock := srv.pathLocks.lock(path)
+	done := make(chan int)
+	go func() {
+		body, _ := json.Marshal(WriteFileRequest{Path: path, Content: "after"})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", body))
+		done <- w.Code
+	}()
+
+	select {
+	case code := <-done:
+		t.Fatalf("expected the write to wait for the lock, it finished with %d", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written while the path is locked, got %v", err)
+	}
+
+	unlock()
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected the write to proceed once unlocked, got %d", code)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "after" {
+		t.Errorf("expected the write to apply, got %q", got)
+	}
+}
+
+func TestUploadAndDeleteDirWaitForPathLock(t *testing.T) {
+	srv, mux := newTestServer(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "uploaded.txt")
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		request *http.Request
+		applied func() bool
+	}{
+		{
+			path:    file,
+			request: newAuthRequest(http.MethodPost, "/upload?path="+file, []byte("after")),
+			applied: func() bool { _, err := os.Stat(file); return err == nil },
+		},
+		{
+			path:    sub,
+			request: newAuthRequest(http.MethodPost, "/delete_dir", []byte(`{"path":"`+sub+`"}`)),
+			applied: func() bool { _, err := os.Stat(sub); return os.IsNotExist(err) },
+		},
+	}
+	for _, tt := range tests {
+		unlock := srv.pathLocks.lock(tt.path)
+		done := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, tt.request)
+			done <- w.Code
+		}()
+
+		select {
+		case code := <-done:
+			t.Fatalf("%s: expected the request to wait for the lock, it finished with %d", tt.request.URL.Path, code)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if tt.applied() {
+			t.Fatalf("%s: expected nothing changed while the path is locked", tt.request.URL.Path)
+		}
+
+		unlock()
+		if code := <-done; code != http.StatusOK || !tt.applied() {
+			t.Errorf("%s: expected the request to apply once unlocked, got %d", tt.request.URL.Path, code)
+		}
+	}
+}
+
+func TestConcurrentBindPortHasOneWinner(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	var wg sync.WaitGroup
+	var bound, conflicts atomic.Int64
+	for i := range 16 {
+		wg.Go(func() {
+			w := bindPort(t, mux, BindPortRequest{Port: strconv.Itoa(3000 + i)})
+			switch w.Code {
+			case http.StatusOK:
+				bound.Add(1)
+			case http.StatusConflict:
+				conflicts.Add(1)
+			default:
+				t.Errorf("unexpected %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+	wg.Wait()
+
+	if bound.Load() != 1 || conflicts.Load() != 15 {
+		t.Errorf("expected exactly one bind to win, got %d bound and %d conflicts", bound.Load(), conflicts.Load())
+	}
+}
+
+func TestPathLockerSerializesAcrossShards(t *testing.T) {
+	var locks pathLocker
+
+	// Locking the same path twice in one call must not deadlock
+	unlock := locks.lock("/tmp/a", "/tmp/a", "/tmp/./a")
+	unlock()
+
+	// Opposite orders of the same paths must not deadlock either
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Go(func() {
+			if i%2 == 0 {
+				defer locks.lock("/tmp/src", "/tmp/dst")()
+			} else {
+				defer locks.lock("/tmp/dst", "/tmp/src")()
+			}
+		})
+	}
+	wg.Wait()
+}
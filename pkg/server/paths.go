@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultRoot is the sandbox root used when none is configured
+const DefaultRoot = "/"
+
+var errPathOutsideRoot = errors.New("path escapes sandbox root")
+
+// normalizeRoot validates the configured sandbox root and resolves any
+// symlinks in it so later containment checks compare canonical paths
+func normalizeRoot(root string) (string, error) {
+	if root == "" {
+		root = DefaultRoot
+	}
+
+	if !filepath.IsAbs(root) {
+		return "", fmt.Errorf("SANDBOX_ROOT must be an absolute path, got %q", root)
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(root))
+	if err != nil {
+		return "", fmt.Errorf("invalid SANDBOX_ROOT %q: %w", root, err)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("invalid SANDBOX_ROOT %q: %w", root, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("SANDBOX_ROOT %q is not a directory", root)
+	}
+
+	return resolved, nil
+}
+
+// resolvePath maps a client-supplied path onto the filesystem. Relative paths
+// are taken relative to the sandbox root; absolute paths must already lie
+// inside it. Paths that escape the root, lexically or through a symlink, are
+// rejected with errPathOutsideRoot.
+func (s *Server) resolvePath(path string) (string, error) {
+	var cleaned string
+	if filepath.IsAbs(path) {
+		cleaned = filepath.Clean(path)
+	} else {
+		cleaned = filepath.Join(s.root, path)
+	}
+
+	if !isWithinRoot(s.root, cleaned) {
+		return "", errPathOutsideRoot
+	}
+
+	// Every path is inside "/", so there is nothing a symlink could escape to
+	if s.root == "/" {
+		return cleaned, nil
+	}
+
+	resolved, err := evalExistingSymlinks(cleaned)
+	if err != nil {
+		return "", err
+	}
+	if !isWithinRoot(s.root, resolved) {
+		return "", errPathOutsideRoot
+	}
+
+	return cleaned, nil
+}
+
+// sandboxPath resolves path and writes a 403 response when it falls outside
+// the sandbox root. Callers should return immediately when ok is false.
+func (s *Server) sandboxPath(w http.ResponseWriter, path string) (resolved string, ok bool) {
+	resolved, err := s.resolvePath(path)
+	if err != nil {
+		if errors.Is(err, errPathOutsideRoot) {
+			writeError(w, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("Forbidden: %s: %s", errPathOutsideRoot, path))
+		} else {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid path: %s", path))
+		}
+		return "", false
+	}
+	return resolved, true
+}
+
+// evalExistingSymlinks resolves symlinks in the longest existing prefix of
+// path and re-appends the components that do not exist yet
+func evalExistingSymlinks(path string) (string, error) {
+	var missing []string
+	current := path
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+func isWithinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePathConfinesToRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "inner"), 0o755); err != nil {
+		t.Fatalf("failed to create inner dir: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "inner"), filepath.Join(root, "alias")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	srv, _ := newTestServerWithConfig(t, Config{Root: root})
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"relative path", "notes.txt", filepath.Join(srv.root, "notes.txt"), false},
+		{"absolute path inside root", filepath.Join(srv.root, "inner", "a.txt"), filepath.Join(srv.root, "inner", "a.txt"), false},
+		{"dot-dot inside root", "inner/../notes.txt", filepath.Join(srv.root, "notes.txt"), false},
+		{"symlink inside root", "alias/new.txt", filepath.Join(srv.root, "alias", "new.txt"), false},
+		{"dot-dot escape", "../../etc/passwd", "", true},
+		{"nested dot-dot escape", "inner/../../etc/passwd", "", true},
+		{"absolute path outside root", "/etc/passwd", "", true},
+		{"symlink escape", "escape/secret.txt", "", true},
+		{"symlink escape to missing file", "escape/missing/deeper.txt", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := srv.resolvePath(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected, got %q", tt.path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected %q to resolve, got error: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFileHandlersRejectPathsOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0o644); err != nil {
+		t.Fatalf("failed to write outside file: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	tests := []struct {
+		route string
+		body  interface{}
+	}{
+		{"/read_file", ReadFileRequest{Path: "../../../../../../" + secret}},
+		{"/read_file", ReadFileRequest{Path: secret}},
+		{"/read_file", ReadFileRequest{Path: "escape/secret.txt"}},
+		{"/write_file", WriteFileRequest{Path: "escape/pwned.txt", Content: "x"}},
+		{"/delete_file", DeleteFileRequest{Path: secret}},
+		{"/delete_dir", DeleteDirRequest{Path: "escape"}},
+		{"/make_dir", MakeDirRequest{Path: "../outside-dir"}},
+		{"/list_dir", ListDirRequest{Path: outside}},
+		{"/move", MoveRequest{Source: "escape/secret.txt", Destination: "stolen.txt"}},
+	}
+
+	for _, tt := range tests {
+		reqBody, _ := json.Marshal(tt.body)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.route, reqBody))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403, got %d: %s", tt.route, reqBody, w.Code, w.Body.String())
+		}
+	}
+
+	if content, err := os.ReadFile(secret); err != nil || string(content) != "top secret" {
+		t.Fatalf("expected outside file to be untouched, got %q (err=%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file written outside root, stat err=%v", err)
+	}
+}
+
+func TestDeleteDirRefusesRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "inner"), 0o755); err != nil {
+		t.Fatalf("failed to create inner dir: %v", err)
+	}
+	keep := filepath.Join(root, "keep.txt")
+	if err := os.WriteFile(keep, []byte("keep"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	srv, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	for _, path := range []string{".", "inner/..", srv.root, srv.root + "/", "/"} {
+		for _, onlyIfEmpty := range []bool{false, true} {
+			reqBody, _ := json.Marshal(DeleteDirRequest{Path: path, OnlyIfEmpty: onlyIfEmpty})
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_dir", reqBody))
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%q: expected 403, got %d: %s", path, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	// An empty path is a missing field, not the root
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_dir", []byte(`{"path":""}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("\"\": expected 400, got %d: %s", w.Code, w.Body.String())
+	} else if errResp := decodeError(t, w); errResp.Details["reason"] != decodeReasonMissingField {
+		t.Errorf("\"\": expected reason %s, got %+v", decodeReasonMissingField, errResp)
+	}
+
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatalf("expected the root to be untouched: %v", err)
+	}
+
+	// Directories below the root can still be deleted
+	reqBody, _ := json.Marshal(DeleteDirRequest{Path: "inner"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/delete_dir", reqBody))
+	if _, err := os.Stat(filepath.Join(root, "inner")); w.Code != http.StatusOK || !os.IsNotExist(err) {
+		t.Errorf("expected inner to be deleted, got %d (stat err=%v)", w.Code, err)
+	}
+}
+
+func TestFileHandlersResolveRelativeToRoot(t *testing.T) {
+	root := t.TempDir()
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	reqBody, _ := json.Marshal(WriteFileRequest{Path: "project/main.go", Content: "package main"})
+	if err := os.Mkdir(filepath.Join(root, "project"), 0o755); err != nil {
+		t.Fatalf("failed to create project dir: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/write_file", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "project", "main.go"))
+	if err != nil || string(content) != "package main" {
+		t.Fatalf("expected file written under root, got %q (err=%v)", content, err)
+	}
+}
+
+func TestNewRejectsInvalidRoot(t *testing.T) {
+	_, err := New(Config{
+		Auth: AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"},
+		Root: "relative/root",
+	})
+	if err == nil {
+		t.Fatal("expected relative root to be rejected")
+	}
+
+	_, err = New(Config{
+		Auth: AuthConfig{Mode: AuthModeStatic, Secret: "test-secret"},
+		Root: filepath.Join(t.TempDir(), "missing"),
+	})
+	if err == nil {
+		t.Fatal("expected missing root to be rejected")
+	}
+}
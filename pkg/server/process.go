@@ -1,18 +1,35 @@
 package server
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// maxLogLineSize caps how much of an unterminated line is buffered before it
+// is recorded as its own log entry
+const maxLogLineSize = 1024 * 1024
+
+// processOutputWaitDelay bounds how long completion waits for the output
+// pipes to close after the process exits, e.g. when a background child
+// inherited them
+const processOutputWaitDelay = 50 * time.Millisecond
+
 // ProcessStatus represents the status of a background process
 type ProcessStatus string
 
@@ -25,27 +42,62 @@ const (
 
 // Process represents a background process
 type Process struct {
-	ID        string        `json:"id"`
-	PID       int           `json:"pid"`
-	Status    ProcessStatus `json:"status"`
-	Command   string        `json:"command"`
-	Cwd       string        `json:"cwd,omitempty"`
-	StartTime time.Time     `json:"start_time"`
-	EndTime   *time.Time    `json:"end_time,omitempty"`
-	ExitCode  *int          `json:"exit_code,omitempty"`
+	ID      string        `json:"id"`
+	PID     int           `json:"pid"`
+	Status  ProcessStatus `json:"status"`
+	Command string        `json:"command"`
+	Cwd     string        `json:"cwd,omitempty"`
+	// User is the user the process runs as, when not the executor's own
+	User      string     `json:"user,omitempty"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	// Reason names the resource limit that terminated the process, if any
+	Reason string `json:"reason,omitempty"`
+	// Signaled is set when the process was terminated by Signal, such as
+	// "SIGKILL", rather than exiting on its own
+	Signaled bool   `json:"signaled,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+	// LogsPersisted is set when output is also written to the log directory
+	LogsPersisted bool `json:"logs_persisted,omitempty"`
+	// Restarts counts how many times the process was relaunched
+	Restarts int `json:"restarts,omitempty"`
+	// Env holds the extra environment variables the process was started
+	// with. It is only exposed through ToJSON, which redacts secrets.
+	Env map[string]string `json:"-"`
+	// Labels are arbitrary key/value tags set by the client
+	Labels map[string]string `json:"labels,omitempty"`
+	// effectiveEnv is the whole environment the current run started with:
+	// the executor's at that time, merged with Env. Later /setenv calls only
+	// reach processes started or restarted after them.
+	effectiveEnv map[string]string
 
 	// Internal fields
-	cmd       *exec.Cmd
-	stdout    *LogBuffer
-	stderr    *LogBuffer
-	mu        sync.RWMutex
-	logsMu    sync.RWMutex
-	done      chan struct{}
-	observers []chan LogEntry
+	cmd          *exec.Cmd
+	opts         ProcessOptions
+	limits       *ResourceLimits
+	stdout       *LogBuffer
+	stderr       *LogBuffer
+	stdoutWriter *logWriter
+	stderrWriter *logWriter
+	mu           sync.RWMutex
+	logsMu       sync.RWMutex
+	done         chan struct{}
+	observers    []chan LogEntry
+	logSeq       atomic.Uint64
+	// tty is the master side of the process terminal when it runs with
+	// TTY, and ttyDone is closed once its output has been copied
+	tty     *os.File
+	ttyDone chan struct{}
+	// readiness tracks the readiness probe of the current run, if the
+	// process has one
+	readiness atomic.Pointer[readiness]
 }
 
 // LogEntry represents a single log line
 type LogEntry struct {
+	// Seq increases monotonically across both streams of a process
+	Seq       uint64    `json:"seq"`
 	Timestamp time.Time `json:"timestamp"`
 	Stream    string    `json:"stream"` // "stdout" or "stderr"
 	Data      string    `json:"data"`
@@ -56,6 +108,7 @@ type LogBuffer struct {
 	entries    []LogEntry
 	mu         sync.RWMutex
 	maxEntries int
+	dropped    uint64
 }
 
 func NewLogBuffer(maxEntries int) *LogBuffer {
@@ -73,10 +126,19 @@ func (lb *LogBuffer) Append(entry LogEntry) {
 
 	// Keep only the last maxEntries
 	if len(lb.entries) > lb.maxEntries {
-		lb.entries = lb.entries[len(lb.entries)-lb.maxEntries:]
+		evicted := len(lb.entries) - lb.maxEntries
+		lb.entries = lb.entries[evicted:]
+		lb.dropped += uint64(evicted)
 	}
 }
 
+// Dropped returns how many entries have been evicted to stay within maxEntries
+func (lb *LogBuffer) Dropped() uint64 {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.dropped
+}
+
 func (lb *LogBuffer) GetAll() []LogEntry {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
@@ -87,10 +149,56 @@ func (lb *LogBuffer) GetAll() []LogEntry {
 	return result
 }
 
+// errProcessNotFound is returned for unknown process IDs
+var errProcessNotFound = errors.New("process not found")
+
+// errProcessRunning is returned when an operation requires a finished process
+var errProcessRunning = errors.New("process is still running")
+
+// errTooManyProcesses is returned when starting a process would exceed the
+// process limit
+var errTooManyProcesses = errors.New("too many processes")
+
+// ProcessLimitMode selects the processes counted against the process limit
+type ProcessLimitMode string
+
+const (
+	// ProcessLimitAll counts every managed process, finished ones included
+	// until they are removed or reaped, as their logs still take memory
+	ProcessLimitAll ProcessLimitMode = "all"
+	// ProcessLimitRunning counts running processes only
+	ProcessLimitRunning ProcessLimitMode = "running"
+)
+
+// ParseProcessLimitMode validates a ProcessLimitMode
+func ParseProcessLimitMode(value string) (ProcessLimitMode, error) {
+	switch mode := ProcessLimitMode(strings.ToLower(value)); mode {
+	case ProcessLimitAll, ProcessLimitRunning:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported process limit mode %q: expected all or running", value)
+}
+
+// errLogPersistenceDisabled is returned when persisted logs are requested but
+// no log directory is configured
+var errLogPersistenceDisabled = errors.New("log persistence is not enabled (no log directory configured)")
+
 // ProcessManager manages background processes
 type ProcessManager struct {
-	processes map[string]*Process
-	mu        sync.RWMutex
+	processes  map[string]*Process
+	mu         sync.RWMutex
+	reaperStop chan struct{}
+	// logDir holds persisted process logs; empty disables persistence
+	logDir string
+	// notifySecret returns the key exit notifications are signed with, or
+	// "" to send them unsigned
+	notifySecret func() string
+	// maxProcesses caps the processes counted by limitMode, zero is
+	// unlimited. limitMu guards both, and is held across starts while a
+	// limit is set so concurrent starts cannot both take the last slot.
+	maxProcesses int
+	limitMode    ProcessLimitMode
+	limitMu      sync.Mutex
 }
 
 func NewProcessManager() *ProcessManager {
@@ -99,116 +207,462 @@ func NewProcessManager() *ProcessManager {
 	}
 }
 
+// DefaultMaxLogEntries is the number of lines kept per stream of a process
+const DefaultMaxLogEntries = 10000
+
+// MaxLogEntriesLimit is the largest per-stream log buffer a request may ask for
+const MaxLogEntriesLimit = 1000000
+
+// ProcessOptions describes a background process to start
+type ProcessOptions struct {
+	CommandOptions
+	// MaxLogEntries is the number of lines kept per stream (default
+	// DefaultMaxLogEntries); older lines are dropped
+	MaxLogEntries int
+	// PersistLogs also writes each stream to a rotating file in the
+	// manager's log directory
+	PersistLogs bool
+	// Labels tag the process for filtering and grouping
+	Labels map[string]string
+	// NotifyURL receives a ProcessExitNotification each time the process
+	// exits
+	NotifyURL string
+	// Readiness, when set, is checked after each launch to tell when the
+	// process is ready
+	Readiness *ReadinessProbe
+	// OutputFiles receive the redirected streams instead of the logs. They
+	// are reopened on restart, so a truncated file only holds the last run.
+	OutputFiles OutputFiles
+}
+
 // StartProcess starts a new background process
 func (pm *ProcessManager) StartProcess(command, cwd string, env map[string]string) (*Process, error) {
-	id := uuid.New().String()
+	return pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{Command: command, Cwd: cwd, Env: env},
+	})
+}
 
-	slog.Debug("Starting background process", "id", id, "cmd", command, "cwd", cwd, "env", env)
+// SetMaxProcesses caps the number of processes counted by mode. Starts over
+// the limit fail with errTooManyProcesses; zero removes the limit.
+func (pm *ProcessManager) SetMaxProcesses(limit int, mode ProcessLimitMode) {
+	if mode == "" {
+		mode = ProcessLimitAll
+	}
+	pm.limitMu.Lock()
+	defer pm.limitMu.Unlock()
+	pm.maxProcesses = limit
+	pm.limitMode = mode
+}
 
-	cmd := exec.Command("sh", "-c", command)
+// lockLimit returns whether a process limit is set and the function ending
+// the start. With a limit, limitMu is held until then; without one, starts
+// run concurrently.
+func (pm *ProcessManager) lockLimit() (limited bool, unlock func()) {
+	pm.limitMu.Lock()
+	if pm.maxProcesses <= 0 {
+		pm.limitMu.Unlock()
+		return false, func() {}
+	}
+	return true, pm.limitMu.Unlock
+}
 
-	if cwd != "" {
-		cmd.Dir = cwd
+// checkLimit fails with errTooManyProcesses when another process would exceed
+// the process limit. Callers hold limitMu until the new process is counted.
+func (pm *ProcessManager) checkLimit() error {
+	if pm.maxProcesses <= 0 {
+		return nil
 	}
 
-	if len(env) > 0 {
-		cmd.Env = os.Environ()
-		for key, value := range env {
-			cmd.Env = append(cmd.Env, key+"="+value)
+	pm.mu.RLock()
+	processes := make([]*Process, 0, len(pm.processes))
+	for _, process := range pm.processes {
+		processes = append(processes, process)
+	}
+	pm.mu.RUnlock()
+
+	count := len(processes)
+	if pm.limitMode == ProcessLimitRunning {
+		count = 0
+		for _, process := range processes {
+			process.mu.RLock()
+			if process.Status == ProcessStatusRunning {
+				count++
+			}
+			process.mu.RUnlock()
+		}
+	}
+	if count >= pm.maxProcesses {
+		return fmt.Errorf("%w: %d of %d %s processes", errTooManyProcesses, count, pm.maxProcesses, pm.limitMode)
+	}
+	return nil
+}
+
+// StartProcessWithOptions starts a new background process
+func (pm *ProcessManager) StartProcessWithOptions(opts ProcessOptions) (*Process, error) {
+	limited, unlock := pm.lockLimit()
+	defer unlock()
+	if limited {
+		if err := pm.checkLimit(); err != nil {
+			return nil, err
 		}
 	}
 
+	id := uuid.New().String()
+	// Keep our own copy so later changes by the caller don't leak into
+	// restarts or the reported environment
+	opts.Env = copyStringMap(opts.Env)
+	opts.Argv = slices.Clone(opts.Argv)
+
+	slog.Debug("Starting background process", "id", id, "cmd", opts.String(), "cwd", opts.Cwd, "env", opts.Env, "limits", opts.Limits, "user", opts.User, "clean_env", opts.CleanEnv, "nice", opts.Nice)
+
+	maxLogEntries := opts.MaxLogEntries
+	if maxLogEntries <= 0 {
+		maxLogEntries = DefaultMaxLogEntries
+	}
+
 	process := &Process{
 		ID:        id,
-		Status:    ProcessStatusRunning,
-		Command:   command,
-		Cwd:       cwd,
-		StartTime: time.Now(),
-		cmd:       cmd,
-		stdout:    NewLogBuffer(10000), // Store up to 10k log lines
-		stderr:    NewLogBuffer(10000),
-		done:      make(chan struct{}),
+		Command:   opts.String(),
+		Cwd:       opts.Cwd,
+		User:      opts.User,
+		Env:       opts.Env,
+		Labels:    copyStringMap(opts.Labels),
+		opts:      opts,
+		limits:    opts.Limits,
+		stdout:    NewLogBuffer(maxLogEntries),
+		stderr:    NewLogBuffer(maxLogEntries),
 		observers: make([]chan LogEntry, 0),
 	}
 
-	// Get pipes for stdout and stderr
-	stdoutPipe, err := cmd.StdoutPipe()
+	if err := pm.launch(process); err != nil {
+		// The process is never registered, so nothing else would remove them
+		pm.removeLogFiles(process)
+		return nil, err
+	}
+
+	// Register the process
+	pm.mu.Lock()
+	pm.processes[id] = process
+	pm.mu.Unlock()
+
+	return process, nil
+}
+
+// RestartProcess relaunches a finished process with the command, cwd, env
+// and options it was started with, under the same ID. Its log history is
+// kept and new output continues the sequence numbering.
+func (pm *ProcessManager) RestartProcess(id string) (*Process, error) {
+	process, err := pm.GetProcess(id)
 	if err != nil {
-		slog.Debug("Failed to create stdout pipe for process", "id", id, "error", err)
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+		return nil, err
+	}
+
+	// A restart runs one more process, but adds none to the manager
+	limited, unlock := pm.lockLimit()
+	defer unlock()
+	process.mu.RLock()
+	running := process.Status == ProcessStatusRunning
+	process.mu.RUnlock()
+	if limited && pm.limitMode == ProcessLimitRunning && !running {
+		if err := pm.checkLimit(); err != nil {
+			return nil, err
+		}
+	}
+
+	process.mu.Lock()
+	defer process.mu.Unlock()
+
+	if process.Status == ProcessStatusRunning {
+		return nil, fmt.Errorf("cannot restart process %s: %w", id, errProcessRunning)
+	}
+
+	slog.Debug("Restarting process", "id", id, "cmd", process.Command, "previous_pid", process.PID)
+
+	if err := pm.launch(process); err != nil {
+		return nil, err
+	}
+	process.Restarts++
+	return process, nil
+}
+
+// launch starts a run of process and resets its run state. Callers either
+// own process exclusively or hold process.mu.
+func (pm *ProcessManager) launch(process *Process) error {
+	// The directory may also have been removed since a previous run
+	if err := validateCwd(process.opts.Cwd); err != nil {
+		return err
+	}
+
+	cmd := newCommand(context.Background(), process.opts.CommandOptions)
+	// Snapshot the inherited environment explicitly, so what the process
+	// reports is exactly what it was given
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
+	// Capture stdout and stderr line by line. cmd.Wait() returns only once
+	// both streams are fully copied, or processOutputWaitDelay after exit if a
+	// background child keeps the pipes open.
+	process.stdoutWriter = &logWriter{process: process, stream: "stdout"}
+	process.stderrWriter = &logWriter{process: process, stream: "stderr"}
+	cmd.Stdout = process.stdoutWriter
+	cmd.Stderr = process.stderrWriter
+	cmd.WaitDelay = processOutputWaitDelay
+
+	stdoutFile, stderrFile, err := process.opts.OutputFiles.open()
 	if err != nil {
-		slog.Debug("Failed to create stderr pipe for process", "id", id, "error", err)
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		return outputFileError{err}
+	}
+	// The command has its own copies once started
+	defer closeOutputFiles(stdoutFile, stderrFile)
+	if stdoutFile != nil {
+		cmd.Stdout = stdoutFile
+	}
+	if stderrFile != nil {
+		cmd.Stderr = stderrFile
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		slog.Debug("Failed to start process", "id", id, "cmd", command, "error", err)
-		return nil, fmt.Errorf("failed to start command: %w", err)
+	if process.opts.PersistLogs {
+		if err := pm.persistLogs(process); err != nil {
+			return err
+		}
 	}
 
-	process.PID = cmd.Process.Pid
-	slog.Debug("Process started successfully", "id", id, "pid", process.PID)
+	// Set up before the command starts so log probes see its first line
+	var probe *readiness
+	if process.opts.Readiness != nil {
+		var err error
+		if probe, err = newReadiness(process.opts.Readiness); err != nil {
+			process.closeLogFiles()
+			return err
+		}
+	}
+	process.readiness.Store(probe)
 
-	// Register the process
-	pm.mu.Lock()
-	pm.processes[id] = process
-	pm.mu.Unlock()
+	// Start the command
+	if err := pm.startCommand(process, cmd); err != nil {
+		slog.Debug("Failed to start process", "id", process.ID, "cmd", process.Command, "error", err)
+		process.closeLogFiles()
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	applyNice(context.Background(), cmd, process.opts.Nice)
 
-	// Start goroutines to capture stdout and stderr
-	go pm.captureOutput(process, stdoutPipe, "stdout")
-	go pm.captureOutput(process, stderrPipe, "stderr")
+	process.cmd = cmd
+	process.effectiveEnv = envMap(cmd.Env)
+	process.PID = cmd.Process.Pid
+	process.Status = ProcessStatusRunning
+	process.StartTime = time.Now()
+	process.EndTime = nil
+	process.ExitCode = nil
+	process.Reason = ""
+	process.Signaled = false
+	process.Signal = ""
+	process.done = make(chan struct{})
+	slog.Debug("Process started successfully", "id", process.ID, "pid", process.PID)
+
+	if probe != nil {
+		go probe.run(process.opts.Readiness, process.done)
+	}
 
 	// Wait for process completion in background
 	go pm.waitForCompletion(process)
 
-	return process, nil
+	return nil
 }
 
-// captureOutput captures output from a pipe and stores it in the log buffer
-func (pm *ProcessManager) captureOutput(process *Process, pipe io.Reader, stream string) {
-	scanner := bufio.NewScanner(pipe)
+// startCommand starts cmd for process, under a pseudo-terminal if the
+// process asked for one. The terminal merges both streams, so its output is
+// all logged as stdout.
+func (pm *ProcessManager) startCommand(process *Process, cmd *exec.Cmd) error {
+	if !process.opts.TTY {
+		return cmd.Start()
+	}
+
+	// pty only wires the terminal to streams that are not set yet
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	ptmx, err := startTTY(cmd)
+	if err != nil {
+		return err
+	}
 
-	// Increase buffer size for long lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	process.tty = ptmx
+	process.ttyDone = make(chan struct{})
+	go func() {
+		defer close(process.ttyDone)
+		io.Copy(process.stdoutWriter, ttyOutput{ptmx})
+	}()
+	return nil
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		slog.Debug("Process output", "id", process.ID, "stream", stream, "line", line)
+// doneChan returns the channel closed when the current run of p exits
+func (p *Process) doneChan() <-chan struct{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.done
+}
 
-		entry := LogEntry{
-			Timestamp: time.Now(),
-			Stream:    stream,
-			Data:      line,
+// persistLogs tees both output streams of process to rotating files
+func (pm *ProcessManager) persistLogs(process *Process) error {
+	if pm.logDir == "" {
+		return errLogPersistenceDisabled
+	}
+
+	for _, writer := range []*logWriter{process.stdoutWriter, process.stderrWriter} {
+		file, err := openRotatingFile(processLogPath(pm.logDir, process.ID, writer.stream), processLogFileMaxSize, processLogFileBackups)
+		if err != nil {
+			process.closeLogFiles()
+			return fmt.Errorf("failed to open log file: %w", err)
 		}
+		writer.file = file
+	}
 
-		// Store in appropriate buffer
-		if stream == "stdout" {
-			process.stdout.Append(entry)
-		} else {
-			process.stderr.Append(entry)
+	process.LogsPersisted = true
+	return nil
+}
+
+// OpenProcessLogFile returns the persisted history of a process stream. It
+// works for any process whose logs are still on disk, including processes
+// from before a restart.
+func (pm *ProcessManager) OpenProcessLogFile(id, stream string) (io.ReadCloser, error) {
+	if pm.logDir == "" {
+		return nil, errLogPersistenceDisabled
+	}
+	return openProcessLogHistory(pm.logDir, id, stream)
+}
+
+// removeLogFiles deletes the persisted log files of a process that is being
+// forgotten, so they do not pile up with a TTL. Files of processes from
+// before a restart are not tracked and stay on disk.
+func (pm *ProcessManager) removeLogFiles(process *Process) {
+	process.mu.RLock()
+	persisted := process.LogsPersisted
+	process.mu.RUnlock()
+	if !persisted || pm.logDir == "" {
+		return
+	}
+
+	for _, stream := range []string{"stdout", "stderr"} {
+		removeProcessLogs(pm.logDir, process.ID, stream)
+	}
+}
+
+// closeLogFiles closes the persisted log files, if any
+func (p *Process) closeLogFiles() {
+	for _, writer := range []*logWriter{p.stdoutWriter, p.stderrWriter} {
+		if writer.file != nil {
+			writer.file.Close()
 		}
+	}
+}
 
-		// Notify observers
-		process.logsMu.RLock()
-		for _, observer := range process.observers {
-			select {
-			case observer <- entry:
-			default:
-				// Don't block if observer is slow
+// appendLog records a line of output and notifies observers. Sequence number,
+// timestamp and buffer insertion happen under logsMu so both streams share a
+// single, consistent ordering.
+func (p *Process) appendLog(stream, line string) {
+	slog.Debug("Process output", "id", p.ID, "stream", stream, "line", line)
+
+	if probe := p.readiness.Load(); probe != nil {
+		probe.observe(line)
+	}
+
+	p.logsMu.Lock()
+	defer p.logsMu.Unlock()
+
+	entry := LogEntry{
+		Seq:       p.logSeq.Add(1),
+		Timestamp: time.Now(),
+		Stream:    stream,
+		Data:      line,
+	}
+
+	// Store in appropriate buffer
+	if stream == "stdout" {
+		p.stdout.Append(entry)
+	} else {
+		p.stderr.Append(entry)
+	}
+
+	// Notify observers
+	for _, observer := range p.observers {
+		select {
+		case observer <- entry:
+		default:
+			// Don't block if observer is slow
+		}
+	}
+}
+
+// logWriter splits a process output stream into lines and records each one.
+// os/exec calls Write from a single goroutine per stream.
+type logWriter struct {
+	process *Process
+	stream  string
+	partial []byte
+	// file receives the raw output when logs are persisted
+	file *rotatingFile
+}
+
+func (lw *logWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	if lw.file != nil {
+		// A full disk must not stop the process output from being captured
+		if _, err := lw.file.Write(data); err != nil {
+			slog.Debug("Failed to persist process output", "id", lw.process.ID, "stream", lw.stream, "error", err)
+		}
+	}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lw.partial = append(lw.partial, data...)
+			// Emit very long unterminated lines rather than buffering without bound
+			if len(lw.partial) >= maxLogLineSize {
+				lw.flush()
 			}
+			break
 		}
-		process.logsMu.RUnlock()
+
+		lw.partial = append(lw.partial, data[:i]...)
+		lw.process.appendLog(lw.stream, strings.TrimSuffix(string(lw.partial), "\r"))
+		lw.partial = lw.partial[:0]
+		data = data[i+1:]
+	}
+	return n, nil
+}
+
+// flush records any buffered output that was not newline-terminated
+func (lw *logWriter) flush() {
+	if len(lw.partial) == 0 {
+		return
 	}
+	lw.process.appendLog(lw.stream, strings.TrimSuffix(string(lw.partial), "\r"))
+	lw.partial = lw.partial[:0]
 }
 
 // waitForCompletion waits for the process to complete and updates its status
 func (pm *ProcessManager) waitForCompletion(process *Process) {
 	err := process.cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The process exited, but a background child kept the output pipes
+		// open. Its own exit status is what is reported.
+		err = nil
+	}
+
+	if process.tty != nil {
+		// Like cmd.WaitDelay for pipes, stop reading the terminal if a
+		// background child keeps it open after the process exits
+		select {
+		case <-process.ttyDone:
+		case <-time.After(processOutputWaitDelay):
+		}
+		process.tty.Close()
+		<-process.ttyDone
+	}
+
+	// Output copying has finished once Wait returns
+	process.stdoutWriter.flush()
+	process.stderrWriter.flush()
+	process.closeLogFiles()
 
 	process.mu.Lock()
 	defer process.mu.Unlock()
@@ -216,7 +670,11 @@ func (pm *ProcessManager) waitForCompletion(process *Process) {
 	now := time.Now()
 	process.EndTime = &now
 
-	if err != nil {
+	if reason := limitReason(process.cmd.ProcessState, process.limits); reason != "" {
+		process.Status = ProcessStatusKilled
+		process.Reason = reason
+		slog.Debug("Process exceeded resource limit", "id", process.ID, "pid", process.PID, "reason", reason)
+	} else if err != nil {
 		if process.cmd.ProcessState.ExitCode() == -1 {
 			// Process was killed
 			process.Status = ProcessStatusKilled
@@ -232,9 +690,38 @@ func (pm *ProcessManager) waitForCompletion(process *Process) {
 
 	exitCode := process.cmd.ProcessState.ExitCode()
 	process.ExitCode = &exitCode
+	if signal := terminatingSignal(process.cmd.ProcessState); signal != "" {
+		process.Signaled = true
+		process.Signal = signal
+		slog.Debug("Process terminated by signal", "id", process.ID, "pid", process.PID, "signal", process.Signal)
+	}
 	slog.Debug("Process exit", "id", process.ID, "pid", process.PID, "exit_code", exitCode)
 
 	close(process.done)
+	pm.notifyExit(process)
+}
+
+// WaitProcess blocks until the process exits, timeout elapses or ctx is
+// done, and reports whether the process exited. A zero timeout waits
+// indefinitely.
+func (pm *ProcessManager) WaitProcess(ctx context.Context, id string, timeout time.Duration) (bool, error) {
+	process, err := pm.GetProcess(id)
+	if err != nil {
+		return false, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case <-process.doneChan():
+		return true, nil
+	case <-ctx.Done():
+		return false, nil
+	}
 }
 
 // GetProcess retrieves a process by ID
@@ -244,7 +731,7 @@ func (pm *ProcessManager) GetProcess(id string) (*Process, error) {
 
 	process, exists := pm.processes[id]
 	if !exists {
-		return nil, fmt.Errorf("process not found: %s", id)
+		return nil, fmt.Errorf("%w: %s", errProcessNotFound, id)
 	}
 
 	return process, nil
@@ -263,8 +750,81 @@ func (pm *ProcessManager) ListProcesses() []*Process {
 	return processes
 }
 
+// ProcessFilter selects processes. Its zero value matches every process.
+type ProcessFilter struct {
+	// Labels must all be carried by the process, see HasLabels
+	Labels map[string]string
+	// Status, when set, must be the current status of the process
+	Status ProcessStatus
+	// CommandContains, when set, must be a substring of the command
+	CommandContains string
+}
+
+// matches reports whether p is selected by the filter
+func (f ProcessFilter) matches(p *Process) bool {
+	if !p.HasLabels(f.Labels) {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if f.Status != "" && p.Status != f.Status {
+		return false
+	}
+	return strings.Contains(p.Command, f.CommandContains)
+}
+
+// FilterProcesses returns the processes selected by filter, checked under
+// the manager lock so no process is added or removed meanwhile
+func (pm *ProcessManager) FilterProcesses(filter ProcessFilter) []*Process {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	processes := make([]*Process, 0)
+	for _, p := range pm.processes {
+		if filter.matches(p) {
+			processes = append(processes, p)
+		}
+	}
+
+	return processes
+}
+
+// RunningCount returns the number of processes still running
+func (pm *ProcessManager) RunningCount() int {
+	running := 0
+	for _, p := range pm.ListProcesses() {
+		p.mu.RLock()
+		if p.Status == ProcessStatusRunning {
+			running++
+		}
+		p.mu.RUnlock()
+	}
+	return running
+}
+
+// HasLabels reports whether the process carries every key/value pair in
+// selector. An empty selector matches all processes.
+func (p *Process) HasLabels(selector map[string]string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for key, value := range selector {
+		if label, ok := p.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
 // KillProcess kills a process by ID
 func (pm *ProcessManager) KillProcess(id string) error {
+	return pm.SignalProcess(id, syscall.SIGKILL)
+}
+
+// SignalProcess sends sig to a running process
+func (pm *ProcessManager) SignalProcess(id string, sig syscall.Signal) error {
 	process, err := pm.GetProcess(id)
 	if err != nil {
 		return err
@@ -284,8 +844,303 @@ func (pm *ProcessManager) KillProcess(id string) error {
 		return fmt.Errorf("process has no PID")
 	}
 
-	slog.Debug("Killing process", "id", id, "pid", pid)
-	return cmd.Process.Kill()
+	slog.Debug("Signaling process", "id", id, "pid", pid, "signal", signalName(sig))
+	return cmd.Process.Signal(sig)
+}
+
+// Shutdown sends SIGTERM to every running process and waits for them to exit
+// until ctx is done, then kills the stragglers. Signals go to each process
+// group so children started by a shell are not orphaned. It returns the
+// number of processes that exited on their own and the number killed.
+func (pm *ProcessManager) Shutdown(ctx context.Context) (terminated, killed int) {
+	pm.StopReaper()
+
+	type running struct {
+		process *Process
+		pid     int
+		done    <-chan struct{}
+	}
+	var processes []running
+	for _, process := range pm.ListProcesses() {
+		process.mu.RLock()
+		status, pid, done := process.Status, process.PID, process.done
+		process.mu.RUnlock()
+		if status != ProcessStatusRunning || pid <= 0 {
+			continue
+		}
+		slog.Debug("Terminating process for shutdown", "id", process.ID, "pid", pid)
+		if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+			slog.Debug("Failed to terminate process", "id", process.ID, "pid", pid, "error", err)
+		}
+		processes = append(processes, running{process, pid, done})
+	}
+
+	for _, r := range processes {
+		select {
+		case <-r.done:
+			terminated++
+			continue
+		case <-ctx.Done():
+		}
+
+		slog.Debug("Process did not exit before shutdown deadline, killing", "id", r.process.ID, "pid", r.pid)
+		if err := syscall.Kill(-r.pid, syscall.SIGKILL); err != nil {
+			slog.Debug("Failed to kill process", "id", r.process.ID, "pid", r.pid, "error", err)
+		}
+		<-r.done
+		killed++
+	}
+	return terminated, killed
+}
+
+// DefaultTerminateGracePeriod is how long TerminateProcess waits after SIGTERM
+// before escalating to SIGKILL
+const DefaultTerminateGracePeriod = 10 * time.Second
+
+// TerminateProcess sends SIGTERM and waits up to grace for the process to
+// exit, escalating to SIGKILL if it is still alive. It reports whether the
+// process exited on its own within the grace period.
+func (pm *ProcessManager) TerminateProcess(id string, grace time.Duration) (bool, error) {
+	process, err := pm.GetProcess(id)
+	if err != nil {
+		return false, err
+	}
+
+	done := process.doneChan()
+	if err := pm.SignalProcess(id, syscall.SIGTERM); err != nil {
+		return false, err
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		slog.Debug("Process terminated gracefully", "id", id)
+		return true, nil
+	case <-timer.C:
+	}
+
+	slog.Debug("Process did not exit within grace period, killing", "id", id, "grace", grace)
+	if err := pm.KillProcess(id); err != nil {
+		// It may have exited between the timeout and the kill
+		select {
+		case <-done:
+			return true, nil
+		default:
+			return false, err
+		}
+	}
+	<-done
+	return false, nil
+}
+
+// signalsByName maps the signals that can be sent to a process by name
+var signalsByName = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGSTOP":  syscall.SIGSTOP,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// signalNames names the signals reported when one terminates a process. It
+// also covers the faults and limits that cannot be sent by name.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:   "SIGHUP",
+	syscall.SIGINT:   "SIGINT",
+	syscall.SIGQUIT:  "SIGQUIT",
+	syscall.SIGABRT:  "SIGABRT",
+	syscall.SIGBUS:   "SIGBUS",
+	syscall.SIGKILL:  "SIGKILL",
+	syscall.SIGUSR1:  "SIGUSR1",
+	syscall.SIGSEGV:  "SIGSEGV",
+	syscall.SIGUSR2:  "SIGUSR2",
+	syscall.SIGPIPE:  "SIGPIPE",
+	syscall.SIGALRM:  "SIGALRM",
+	syscall.SIGTERM:  "SIGTERM",
+	syscall.SIGCONT:  "SIGCONT",
+	syscall.SIGSTOP:  "SIGSTOP",
+	syscall.SIGTSTP:  "SIGTSTP",
+	syscall.SIGXCPU:  "SIGXCPU",
+	syscall.SIGWINCH: "SIGWINCH",
+}
+
+// parseSignal accepts a signal name ("SIGTERM", "term") or number ("15")
+func parseSignal(value string) (syscall.Signal, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number: %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(value)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalsByName[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal: %s", value)
+	}
+	return sig, nil
+}
+
+// signalName returns the name of sig, or its number if it has none
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// KillAll kills every running process whose command contains filter (all
+// running processes if filter is empty). It returns the IDs that were killed
+// and the errors for those that could not be, keyed by ID.
+func (pm *ProcessManager) KillAll(filter string) ([]string, map[string]string) {
+	// Iterate over a snapshot taken under the lock so concurrent StartProcess
+	// calls can't race with the iteration
+	processes := pm.ListProcesses()
+
+	killed := make([]string, 0)
+	failed := make(map[string]string)
+	for _, process := range processes {
+		process.mu.RLock()
+		status := process.Status
+		process.mu.RUnlock()
+
+		if status != ProcessStatusRunning {
+			continue
+		}
+		if filter != "" && !strings.Contains(process.Command, filter) {
+			continue
+		}
+
+		if err := pm.KillProcess(process.ID); err != nil {
+			failed[process.ID] = err.Error()
+			continue
+		}
+		killed = append(killed, process.ID)
+	}
+
+	slog.Debug("Killed processes", "filter", filter, "killed", len(killed), "failed", len(failed))
+	return killed, failed
+}
+
+// RemoveProcess forgets a finished process and releases its log buffers and
+// persisted log files. Running processes must be killed first.
+func (pm *ProcessManager) RemoveProcess(id string) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	process, exists := pm.processes[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", errProcessNotFound, id)
+	}
+
+	process.mu.RLock()
+	status := process.Status
+	process.mu.RUnlock()
+
+	if status == ProcessStatusRunning {
+		return fmt.Errorf("cannot remove process %s: %w", id, errProcessRunning)
+	}
+
+	delete(pm.processes, id)
+	pm.removeLogFiles(process)
+	slog.Debug("Process removed", "id", id, "status", status)
+	return nil
+}
+
+// StartReaper periodically removes finished processes whose end time is older
+// than ttl. It is a no-op if ttl is not positive or a reaper is already running.
+func (pm *ProcessManager) StartReaper(ttl, interval time.Duration) {
+	if ttl <= 0 || interval <= 0 {
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.reaperStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	pm.reaperStop = stop
+
+	slog.Debug("Starting process reaper", "ttl", ttl, "interval", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				pm.reapExpired(ttl, now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopReaper stops the background reaper started by StartReaper
+func (pm *ProcessManager) StopReaper() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.reaperStop != nil {
+		close(pm.reaperStop)
+		pm.reaperStop = nil
+	}
+}
+
+// reapExpired removes finished processes that ended more than ttl before now
+// and returns their IDs
+func (pm *ProcessManager) reapExpired(ttl time.Duration, now time.Time) []string {
+	removed := pm.PruneProcesses(ProcessFilter{}, now.Add(-ttl))
+	if len(removed) > 0 {
+		slog.Debug("Reaped finished processes", "count", len(removed), "ttl", ttl)
+	}
+	return removed
+}
+
+// PruneProcesses removes the finished processes selected by filter that
+// ended before endedBefore, or whenever they ended if it is zero, and returns
+// their sorted IDs. Running processes are never removed.
+func (pm *ProcessManager) PruneProcesses(filter ProcessFilter, endedBefore time.Time) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var removed []string
+	for id, process := range pm.processes {
+		process.mu.RLock()
+		status := process.Status
+		endTime := process.EndTime
+		process.mu.RUnlock()
+
+		if status == ProcessStatusRunning || endTime == nil {
+			continue
+		}
+		if !endedBefore.IsZero() && !endTime.Before(endedBefore) {
+			continue
+		}
+		if filter.matches(process) {
+			delete(pm.processes, id)
+			pm.removeLogFiles(process)
+			removed = append(removed, id)
+		}
+	}
+
+	sort.Strings(removed)
+	return removed
 }
 
 // GetProcessLogs returns all logs for a process
@@ -303,41 +1158,150 @@ func (pm *ProcessManager) GetProcessLogs(id string) ([]LogEntry, error) {
 	allLogs = append(allLogs, stdoutLogs...)
 	allLogs = append(allLogs, stderrLogs...)
 
-	// Sort by timestamp
+	// Sort by timestamp, using the sequence number to order entries whose
+	// timestamps collide
+	sort.SliceStable(allLogs, func(i, j int) bool {
+		if allLogs[i].Timestamp.Equal(allLogs[j].Timestamp) {
+			return allLogs[i].Seq < allLogs[j].Seq
+		}
+		return allLogs[i].Timestamp.Before(allLogs[j].Timestamp)
+	})
 	return allLogs, nil
 }
 
 // StreamProcessLogs creates a channel that receives new log entries
 func (pm *ProcessManager) StreamProcessLogs(id string) (<-chan LogEntry, error) {
+	return pm.StreamProcessLogsSince(context.Background(), id, 0)
+}
+
+// StreamProcessLogsSince creates a channel that replays buffered log entries
+// with a sequence number greater than sinceSeq, then follows new entries until
+// the process exits or ctx is cancelled. Entries are delivered in order and
+// never duplicated. The channel is closed when the stream ends.
+//
+// The observer is registered and the buffer snapshotted under one lock, so
+// the replay ends exactly where the observer starts: a stream opened right
+// after the process started neither misses nor repeats a line.
+func (pm *ProcessManager) StreamProcessLogsSince(ctx context.Context, id string, sinceSeq uint64) (<-chan LogEntry, error) {
 	process, err := pm.GetProcess(id)
 	if err != nil {
 		return nil, err
 	}
 
+	observer, replay := process.subscribe(sinceSeq)
+	done := process.doneChan()
 	logChan := make(chan LogEntry, 100)
 
-	process.logsMu.Lock()
-	process.observers = append(process.observers, logChan)
-	process.logsMu.Unlock()
-
-	// Send existing logs first
-	existingLogs, _ := pm.GetProcessLogs(id)
 	go func() {
-		for _, entry := range existingLogs {
-			logChan <- entry
+		defer close(logChan)
+		defer process.removeObserver(observer)
+
+		lastSeq := sinceSeq
+		send := func(entry LogEntry) bool {
+			select {
+			case logChan <- entry:
+				lastSeq = entry.Seq
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		sendAfter := func(seq uint64, upTo uint64) bool {
+			for _, entry := range process.logsAfter(seq) {
+				if upTo != 0 && entry.Seq >= upTo {
+					break
+				}
+				if !send(entry) {
+					return false
+				}
+			}
+			return true
 		}
-	}()
 
-	// Close channel when process is done
-	go func() {
-		<-process.done
-		time.Sleep(100 * time.Millisecond) // Give time for final logs
-		close(logChan)
+		// Send existing logs first
+		for _, entry := range replay {
+			if !send(entry) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case entry := <-observer:
+				if entry.Seq <= lastSeq {
+					// Already sent as part of the replay
+					continue
+				}
+				if entry.Seq > lastSeq+1 {
+					// Entries were dropped while the observer was full;
+					// recover them from the buffer
+					if !sendAfter(lastSeq, entry.Seq) {
+						return
+					}
+				}
+				if !send(entry) {
+					return
+				}
+			case <-done:
+				// All output has been captured once the process is done
+				sendAfter(lastSeq, 0)
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
 	return logChan, nil
 }
 
+// subscribe registers a channel that receives every new log entry and returns
+// it with the buffered entries after sinceSeq. appendLog holds logsMu while
+// buffering and notifying, so each entry is either in the snapshot or sent to
+// the observer, never both nor neither.
+func (p *Process) subscribe(sinceSeq uint64) (chan LogEntry, []LogEntry) {
+	observer := make(chan LogEntry, 100)
+
+	p.logsMu.Lock()
+	defer p.logsMu.Unlock()
+	p.observers = append(p.observers, observer)
+	return observer, p.logsAfter(sinceSeq)
+}
+
+// removeObserver deregisters and closes an observer. Both happen under logsMu,
+// the same lock appendLog holds while notifying, so a removed observer is never
+// written to.
+func (p *Process) removeObserver(observer chan LogEntry) {
+	p.logsMu.Lock()
+	defer p.logsMu.Unlock()
+
+	for i, o := range p.observers {
+		if o == observer {
+			p.observers = append(p.observers[:i], p.observers[i+1:]...)
+			close(observer)
+			return
+		}
+	}
+}
+
+// logsAfter returns buffered entries from both streams with a sequence number
+// greater than seq, in order
+func (p *Process) logsAfter(seq uint64) []LogEntry {
+	var entries []LogEntry
+	for _, buffer := range []*LogBuffer{p.stdout, p.stderr} {
+		for _, entry := range buffer.GetAll() {
+			if entry.Seq > seq {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries
+}
+
 // ToJSON returns a JSON-serializable representation of the process
 func (p *Process) ToJSON() map[string]interface{} {
 	p.mu.RLock()
@@ -355,6 +1319,34 @@ func (p *Process) ToJSON() map[string]interface{} {
 		result["cwd"] = p.Cwd
 	}
 
+	if p.User != "" {
+		result["user"] = p.User
+	}
+
+	if len(p.Env) > 0 {
+		result["env"] = redactEnv(p.Env)
+	}
+
+	if p.effectiveEnv != nil {
+		result["effective_env"] = redactEnv(p.effectiveEnv)
+	}
+
+	if p.opts.CleanEnv {
+		result["clean_env"] = true
+	}
+
+	if p.opts.OutputFiles.StdoutFile != "" {
+		result["stdout_file"] = p.opts.OutputFiles.StdoutFile
+	}
+
+	if p.opts.OutputFiles.StderrFile != "" {
+		result["stderr_file"] = p.opts.OutputFiles.StderrFile
+	}
+
+	if len(p.Labels) > 0 {
+		result["labels"] = p.Labels
+	}
+
 	if p.EndTime != nil {
 		result["end_time"] = p.EndTime
 	}
@@ -363,18 +1355,110 @@ func (p *Process) ToJSON() map[string]interface{} {
 		result["exit_code"] = *p.ExitCode
 	}
 
+	if p.Reason != "" {
+		result["reason"] = p.Reason
+	}
+
+	if p.ExitCode != nil {
+		result["signaled"] = p.Signaled
+	}
+
+	if p.Signal != "" {
+		result["signal"] = p.Signal
+	}
+
+	if p.Restarts > 0 {
+		result["restarts"] = p.Restarts
+	}
+
+	if probe := p.readiness.Load(); probe != nil {
+		ready, reason := probe.state()
+		result["ready"] = ready
+		if reason != "" {
+			result["readiness_error"] = reason
+		}
+	}
+
+	if p.LogsPersisted {
+		result["logs_persisted"] = true
+	}
+
+	if dropped := p.DroppedLogs(); dropped > 0 {
+		result["logs_dropped"] = dropped
+	}
+
 	return result
 }
 
+// redactedValue replaces the value of environment variables whose name
+// suggests they hold a credential
+const redactedValue = "[REDACTED]"
+
+// secretEnvMarkers are matched case-insensitively against variable names
+var secretEnvMarkers = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE", "API_KEY", "APIKEY", "ACCESS_KEY", "AUTH"}
+
+func isSecretEnvName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretEnvMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for key, value := range env {
+		if isSecretEnvName(key) {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+// DroppedLogs returns how many log lines were evicted from the buffers of
+// both streams, i.e. how incomplete the logs returned for this process are
+func (p *Process) DroppedLogs() uint64 {
+	var dropped uint64
+	for _, buffer := range []*LogBuffer{p.stdout, p.stderr} {
+		if buffer != nil {
+			dropped += buffer.Dropped()
+		}
+	}
+	return dropped
+}
+
 // ToSummaryJSON returns a minimal JSON representation for list views
 func (p *Process) ToSummaryJSON() map[string]interface{} {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"id":      p.ID,
 		"pid":     p.PID,
 		"status":  p.Status,
 		"command": p.Command,
 	}
+
+	if len(p.Labels) > 0 {
+		result["labels"] = p.Labels
+	}
+
+	return result
 }
@@ -1,6 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -143,6 +148,66 @@ func TestProcessManager_GetProcessLogs(t *testing.T) {
 	}
 }
 
+func TestProcessManager_GetProcessLogsChronologicalOrder(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcess("echo out1; sleep 0.02; echo err1 >&2; sleep 0.02; echo out2; sleep 0.02; echo err2 >&2", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-process.done
+
+	logs, err := pm.GetProcessLogs(process.ID)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+
+	want := []string{"out1", "err1", "out2", "err2"}
+	if len(logs) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %v", len(want), len(logs), logs)
+	}
+	for i, entry := range logs {
+		if entry.Data != want[i] {
+			t.Errorf("Entry %d: expected %q, got %q", i, want[i], entry.Data)
+		}
+		if i > 0 && entry.Timestamp.Before(logs[i-1].Timestamp) {
+			t.Errorf("Entry %d is older than entry %d", i, i-1)
+		}
+	}
+}
+
+func TestProcessManager_LogSequenceNumbers(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcess("for i in 1 2 3 4 5 6 7 8 9 10; do echo out$i; echo err$i >&2; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-process.done
+
+	logs, err := pm.GetProcessLogs(process.ID)
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 20 {
+		t.Fatalf("Expected 20 entries, got %d", len(logs))
+	}
+
+	streams := map[string]bool{}
+	for i, entry := range logs {
+		streams[entry.Stream] = true
+		if entry.Seq == 0 {
+			t.Errorf("Entry %d has no sequence number", i)
+		}
+		if i > 0 && entry.Seq <= logs[i-1].Seq {
+			t.Errorf("Expected strictly increasing seq, entry %d has %d after %d", i, entry.Seq, logs[i-1].Seq)
+		}
+	}
+	if !streams["stdout"] || !streams["stderr"] {
+		t.Errorf("Expected entries from both streams, got %v", streams)
+	}
+}
+
 func TestProcessManager_StreamProcessLogs(t *testing.T) {
 	pm := NewProcessManager()
 
@@ -181,6 +246,530 @@ func TestProcessManager_StreamProcessLogs(t *testing.T) {
 	}
 }
 
+func TestProcessManager_StreamProcessLogsResume(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcess("for i in 1 2 3 4 5 6; do echo Line$i; sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+
+	collect := func(logChan <-chan LogEntry, max int) []LogEntry {
+		var entries []LogEntry
+		timeout := time.After(5 * time.Second)
+		for max == 0 || len(entries) < max {
+			select {
+			case entry, ok := <-logChan:
+				if !ok {
+					return entries
+				}
+				entries = append(entries, entry)
+			case <-timeout:
+				t.Fatal("Test timeout waiting for logs")
+			}
+		}
+		return entries
+	}
+
+	// Read part of the stream, then drop the connection
+	first, err := pm.StreamProcessLogs(process.ID)
+	if err != nil {
+		t.Fatalf("Failed to stream logs: %v", err)
+	}
+	received := collect(first, 3)
+
+	// Reconnect from the last seen sequence number
+	resumed, err := pm.StreamProcessLogsSince(context.Background(), process.ID, received[len(received)-1].Seq)
+	if err != nil {
+		t.Fatalf("Failed to resume stream: %v", err)
+	}
+	received = append(received, collect(resumed, 0)...)
+
+	if len(received) != 6 {
+		t.Fatalf("Expected 6 entries without duplicates, got %d: %+v", len(received), received)
+	}
+	for i, entry := range received {
+		if want := fmt.Sprintf("Line%d", i+1); entry.Data != want {
+			t.Errorf("Entry %d: expected %q, got %q", i, want, entry.Data)
+		}
+		if i > 0 && entry.Seq <= received[i-1].Seq {
+			t.Errorf("Entry %d: seq %d not after %d", i, entry.Seq, received[i-1].Seq)
+		}
+	}
+}
+
+func TestProcessManager_StreamProcessLogsDisconnectStress(t *testing.T) {
+	pm := NewProcessManager()
+
+	// A chatty process keeps appending while clients come and go
+	process, err := pm.StartProcess("while true; do echo tick; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(process.ID)
+
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		logChan, err := pm.StreamProcessLogsSince(ctx, process.ID, 0)
+		if err != nil {
+			t.Fatalf("Failed to stream logs: %v", err)
+		}
+
+		// Read a few entries, then disconnect without draining
+		for j := 0; j < 5; j++ {
+			select {
+			case <-logChan:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Test timeout waiting for logs")
+			}
+		}
+		cancel()
+
+		// The stream must end once the client goes away
+		timeout := time.After(2 * time.Second)
+	drain:
+		for {
+			select {
+			case _, ok := <-logChan:
+				if !ok {
+					break drain
+				}
+			case <-timeout:
+				t.Fatal("Stream did not close after cancellation")
+			}
+		}
+	}
+
+	process.logsMu.Lock()
+	remaining := len(process.observers)
+	process.logsMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected all observers to be removed, %d left", remaining)
+	}
+}
+
+func TestProcessManager_SignalProcessGraceful(t *testing.T) {
+	pm := NewProcessManager()
+
+	// The shell exits cleanly when it receives SIGTERM
+	process, err := pm.StartProcess("trap 'echo stopping; exit 0' TERM; echo ready; while :; do sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(process.ID)
+
+	// Wait for the trap to be installed
+	deadline := time.Now().Add(2 * time.Second)
+	for len(process.stdout.GetAll()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Process did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pm.SignalProcess(process.ID, syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to signal process: %v", err)
+	}
+
+	select {
+	case <-process.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Process did not exit after SIGTERM")
+	}
+
+	process.mu.RLock()
+	defer process.mu.RUnlock()
+	if process.Status != ProcessStatusCompleted || process.ExitCode == nil || *process.ExitCode != 0 {
+		t.Errorf("Expected graceful exit with code 0, got status %s exit code %v", process.Status, process.ExitCode)
+	}
+}
+
+func TestProcessManager_BackgroundChildKeepsPipesOpen(t *testing.T) {
+	pm := NewProcessManager()
+
+	// The shell exits at once, but sleep inherited its output pipes
+	process, err := pm.StartProcess("sleep 2 & echo started", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+
+	select {
+	case <-process.done:
+	case <-time.After(time.Second):
+		t.Fatal("Process completion waited for the background child")
+	}
+
+	process.mu.RLock()
+	defer process.mu.RUnlock()
+	if process.Status != ProcessStatusCompleted || process.ExitCode == nil || *process.ExitCode != 0 {
+		t.Errorf("Expected completion with code 0, got status %s exit code %v", process.Status, process.ExitCode)
+	}
+	if logs := process.stdout.GetAll(); len(logs) != 1 || logs[0].Data != "started" {
+		t.Errorf("Expected the output before exit, got %v", logs)
+	}
+}
+
+func TestProcessManager_TerminateProcess(t *testing.T) {
+	pm := NewProcessManager()
+
+	waitReady := func(process *Process) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(process.stdout.GetAll()) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Process did not become ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Exits on SIGTERM
+	graceful, err := pm.StartProcess("trap 'exit 0' TERM; echo ready; while :; do sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	waitReady(graceful)
+
+	exited, err := pm.TerminateProcess(graceful.ID, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to terminate process: %v", err)
+	}
+	if !exited {
+		t.Error("Expected process to exit gracefully")
+	}
+
+	// Ignores SIGTERM
+	stubborn, err := pm.StartProcess("trap '' TERM; echo ready; while :; do sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	waitReady(stubborn)
+
+	exited, err = pm.TerminateProcess(stubborn.ID, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to terminate process: %v", err)
+	}
+	if exited {
+		t.Error("Expected process to be force-killed")
+	}
+
+	stubborn.mu.RLock()
+	defer stubborn.mu.RUnlock()
+	if stubborn.Status != ProcessStatusKilled {
+		t.Errorf("Expected status killed, got %s", stubborn.Status)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{value: "SIGTERM", want: syscall.SIGTERM},
+		{value: "term", want: syscall.SIGTERM},
+		{value: "SIGHUP", want: syscall.SIGHUP},
+		{value: "2", want: syscall.SIGINT},
+		{value: "SIGBOGUS", wantErr: true},
+		// Only reported, never sent by name
+		{value: "SIGSEGV", wantErr: true},
+		{value: "abrt", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSignal(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSignal(%q): expected error, got %v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSignal(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	tests := map[syscall.Signal]string{
+		syscall.SIGTERM:    "SIGTERM",
+		syscall.SIGSEGV:    "SIGSEGV",
+		syscall.SIGXCPU:    "SIGXCPU",
+		syscall.Signal(40): "40",
+	}
+	for sig, want := range tests {
+		if got := signalName(sig); got != want {
+			t.Errorf("signalName(%d) = %q, want %q", sig, got, want)
+		}
+	}
+}
+
+func TestProcessManager_KillAll(t *testing.T) {
+	pm := NewProcessManager()
+
+	var workers []*Process
+	for i := 0; i < 3; i++ {
+		process, err := pm.StartProcess("sleep 10 # worker", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to start process: %v", err)
+		}
+		workers = append(workers, process)
+	}
+	other, err := pm.StartProcess("sleep 10 # other", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(other.ID)
+
+	killed, failed := pm.KillAll("worker")
+	if len(killed) != 3 || len(failed) != 0 {
+		t.Fatalf("Expected 3 workers killed without errors, got %v / %v", killed, failed)
+	}
+	for _, process := range workers {
+		select {
+		case <-process.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Process %s was not killed", process.ID)
+		}
+	}
+
+	other.mu.RLock()
+	status := other.Status
+	other.mu.RUnlock()
+	if status != ProcessStatusRunning {
+		t.Errorf("Expected unmatched process to keep running, got %s", status)
+	}
+
+	// Finished processes are skipped
+	killed, _ = pm.KillAll("worker")
+	if len(killed) != 0 {
+		t.Errorf("Expected no processes left to kill, got %v", killed)
+	}
+}
+
+func TestProcessManager_RemoveProcess(t *testing.T) {
+	pm := NewProcessManager()
+
+	running, err := pm.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(running.ID)
+
+	if err := pm.RemoveProcess(running.ID); !errors.Is(err, errProcessRunning) {
+		t.Errorf("Expected running process removal to fail with errProcessRunning, got %v", err)
+	}
+
+	finished, err := pm.StartProcess("echo done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-finished.done
+
+	if err := pm.RemoveProcess(finished.ID); err != nil {
+		t.Fatalf("Failed to remove finished process: %v", err)
+	}
+	if _, err := pm.GetProcess(finished.ID); err == nil {
+		t.Error("Expected removed process to be gone")
+	}
+	if err := pm.RemoveProcess(finished.ID); err == nil {
+		t.Error("Expected removing an unknown process to fail")
+	}
+}
+
+func TestProcessManager_ReapExpired(t *testing.T) {
+	pm := NewProcessManager()
+
+	running, err := pm.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(running.ID)
+
+	finished, err := pm.StartProcess("echo done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-finished.done
+
+	// Not old enough yet
+	if removed := pm.reapExpired(time.Hour, time.Now()); len(removed) != 0 {
+		t.Errorf("Expected nothing to be reaped, got %v", removed)
+	}
+
+	removed := pm.reapExpired(time.Hour, time.Now().Add(2*time.Hour))
+	if len(removed) != 1 || removed[0] != finished.ID {
+		t.Errorf("Expected only the finished process to be reaped, got %v", removed)
+	}
+	if _, err := pm.GetProcess(running.ID); err != nil {
+		t.Errorf("Expected running process to be kept: %v", err)
+	}
+}
+
+func TestProcessManager_Reaper(t *testing.T) {
+	pm := NewProcessManager()
+	pm.StartReaper(10*time.Millisecond, 10*time.Millisecond)
+	defer pm.StopReaper()
+
+	process, err := pm.StartProcess("echo done", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-process.done
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := pm.GetProcess(process.ID); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected finished process to be evicted by the reaper")
+}
+
+func TestProcessManager_MaxProcesses(t *testing.T) {
+	pm := NewProcessManager()
+	pm.SetMaxProcesses(2, ProcessLimitAll)
+	defer pm.KillAll("")
+
+	quick, err := pm.StartProcess("true", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	if _, err := pm.StartProcess("sleep 30", "", nil); err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	if _, err := pm.StartProcess("sleep 30", "", nil); !errors.Is(err, errTooManyProcesses) {
+		t.Fatalf("Expected the third start to be rejected, got %v", err)
+	}
+
+	// A finished process still counts until it is pruned
+	<-quick.done
+	if _, err := pm.StartProcess("true", "", nil); !errors.Is(err, errTooManyProcesses) {
+		t.Fatalf("Expected a finished process to count, got %v", err)
+	}
+
+	pm.StartReaper(10*time.Millisecond, 10*time.Millisecond)
+	defer pm.StopReaper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := pm.GetProcess(quick.ID); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the finished process to be reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := pm.StartProcess("sleep 30", "", nil); err != nil {
+		t.Fatalf("Expected a start once a slot was freed, got %v", err)
+	}
+}
+
+func TestProcessManager_MaxRunningProcesses(t *testing.T) {
+	pm := NewProcessManager()
+	pm.SetMaxProcesses(1, ProcessLimitRunning)
+	defer pm.KillAll("")
+
+	quick, err := pm.StartProcess("true", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-quick.done
+
+	// Finished processes do not count
+	running, err := pm.StartProcess("sleep 30", "", nil)
+	if err != nil {
+		t.Fatalf("Expected a start while nothing runs, got %v", err)
+	}
+	if _, err := pm.StartProcess("sleep 30", "", nil); !errors.Is(err, errTooManyProcesses) {
+		t.Fatalf("Expected a second running process to be rejected, got %v", err)
+	}
+	if _, err := pm.RestartProcess(quick.ID); !errors.Is(err, errTooManyProcesses) {
+		t.Fatalf("Expected a restart to count as a running process, got %v", err)
+	}
+	if _, err := pm.RestartProcess(running.ID); !errors.Is(err, errProcessRunning) {
+		t.Fatalf("Expected restarting a running process to conflict, got %v", err)
+	}
+}
+
+func TestProcessManager_LogBufferOverflow(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{Command: "for i in $(seq 1 25); do echo line$i; done; echo oops >&2"},
+		MaxLogEntries:  10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-process.done
+
+	stdout := process.stdout.GetAll()
+	if len(stdout) != 10 || stdout[0].Data != "line16" || stdout[9].Data != "line25" {
+		t.Errorf("Expected the last 10 stdout lines, got %v", stdout)
+	}
+	if dropped := process.stdout.Dropped(); dropped != 15 {
+		t.Errorf("Expected 15 dropped stdout lines, got %d", dropped)
+	}
+	if dropped := process.stderr.Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped stderr lines, got %d", dropped)
+	}
+	if details := process.ToJSON(); details["logs_dropped"] != uint64(15) {
+		t.Errorf("Expected logs_dropped of 15 in JSON, got %v", details["logs_dropped"])
+	}
+}
+
+func TestProcessManager_RestartProcess(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcess("echo run; exit 2", "", map[string]string{"GREETING": "hi"})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	<-process.doneChan()
+	firstPID := process.PID
+
+	restarted, err := pm.RestartProcess(process.ID)
+	if err != nil {
+		t.Fatalf("Failed to restart process: %v", err)
+	}
+	if restarted.ID != process.ID {
+		t.Errorf("Expected the same id, got %s", restarted.ID)
+	}
+
+	restarted.mu.RLock()
+	pid, status, exitCode := restarted.PID, restarted.Status, restarted.ExitCode
+	restarted.mu.RUnlock()
+	if pid == firstPID || pid == 0 {
+		t.Errorf("Expected a fresh PID, got %d (was %d)", pid, firstPID)
+	}
+	if status != ProcessStatusRunning && exitCode != nil && *exitCode != 2 {
+		t.Errorf("Expected a fresh run, got status %s exit code %v", status, exitCode)
+	}
+
+	<-restarted.doneChan()
+
+	logs, _ := pm.GetProcessLogs(process.ID)
+	if len(logs) != 2 || logs[0].Data != "run" || logs[1].Data != "run" || logs[1].Seq <= logs[0].Seq {
+		t.Errorf("Expected log history from both runs, got %v", logs)
+	}
+	if details := restarted.ToJSON(); details["restarts"] != 1 || details["exit_code"] != 2 {
+		t.Errorf("Expected one restart with exit code 2, got %v", details)
+	}
+
+	running, err := pm.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer pm.KillProcess(running.ID)
+	if _, err := pm.RestartProcess(running.ID); !errors.Is(err, errProcessRunning) {
+		t.Errorf("Expected restarting a running process to fail, got %v", err)
+	}
+}
+
 func TestProcess_ToJSON(t *testing.T) {
 	process := &Process{
 		ID:        "test-id",
@@ -288,7 +877,7 @@ func TestProcessWithEnvironment(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	logs, _ := pm.GetProcessLogs(process.ID)
-	
+
 	foundValue := false
 	for _, entry := range logs {
 		if entry.Data == "test_value" {
@@ -302,6 +891,53 @@ func TestProcessWithEnvironment(t *testing.T) {
 	}
 }
 
+func TestProcessEnvironmentSnapshot(t *testing.T) {
+	pm := NewProcessManager()
+	t.Setenv("SNAPSHOT_VAR", "first")
+	t.Setenv("SNAPSHOT_TOKEN", "secret")
+
+	// Each process reads the variable after the executor changed it
+	cmd := "sleep 0.3; echo $SNAPSHOT_VAR"
+	first, err := pm.StartProcess(cmd, "", map[string]string{"EXTRA": "1"})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	t.Setenv("SNAPSHOT_VAR", "second")
+	second, err := pm.StartProcess(cmd, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	t.Setenv("SNAPSHOT_VAR", "third")
+
+	for process, want := range map[*Process]string{first: "first", second: "second"} {
+		<-process.doneChan()
+		logs, _ := pm.GetProcessLogs(process.ID)
+		if len(logs) != 1 || logs[0].Data != want {
+			t.Errorf("expected the process to see %q, got %+v", want, logs)
+		}
+
+		env, _ := process.ToJSON()["effective_env"].(map[string]string)
+		if env["SNAPSHOT_VAR"] != want {
+			t.Errorf("expected effective_env to report %q, got %q", want, env["SNAPSHOT_VAR"])
+		}
+		if env["SNAPSHOT_TOKEN"] != redactedValue {
+			t.Errorf("expected secrets to be redacted, got %q", env["SNAPSHOT_TOKEN"])
+		}
+	}
+	if env := first.ToJSON()["effective_env"].(map[string]string); env["EXTRA"] != "1" {
+		t.Errorf("expected the request env to be merged in, got %v", env)
+	}
+
+	// A restart is a new start and takes a new snapshot
+	if _, err := pm.RestartProcess(second.ID); err != nil {
+		t.Fatalf("Failed to restart process: %v", err)
+	}
+	<-second.doneChan()
+	if env := second.ToJSON()["effective_env"].(map[string]string); env["SNAPSHOT_VAR"] != "third" {
+		t.Errorf("expected the restart to see %q, got %q", "third", env["SNAPSHOT_VAR"])
+	}
+}
+
 func TestProcessWithWorkingDirectory(t *testing.T) {
 	pm := NewProcessManager()
 
@@ -315,7 +951,7 @@ func TestProcessWithWorkingDirectory(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	logs, _ := pm.GetProcessLogs(process.ID)
-	
+
 	foundTmp := false
 	for _, entry := range logs {
 		if entry.Data == "/tmp" || entry.Data == "/private/tmp" { // macOS uses /private/tmp
@@ -328,3 +964,33 @@ func TestProcessWithWorkingDirectory(t *testing.T) {
 		t.Error("Expected working directory to be /tmp")
 	}
 }
+
+func TestProcessManager_StartProcessTTY(t *testing.T) {
+	pm := NewProcessManager()
+
+	process, err := pm.StartProcessWithOptions(ProcessOptions{
+		CommandOptions: CommandOptions{
+			Command: "test -t 1 && echo tty; test -t 2 && echo err >&2",
+			TTY:     true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+
+	if exited, err := pm.WaitProcess(context.Background(), process.ID, 5*time.Second); err != nil || !exited {
+		t.Fatalf("Process did not exit: exited=%v err=%v", exited, err)
+	}
+
+	// The terminal carries both streams, which are logged as stdout
+	var lines []string
+	for _, entry := range process.stdout.GetAll() {
+		lines = append(lines, entry.Data)
+	}
+	if strings.Join(lines, ",") != "tty,err" {
+		t.Errorf("Expected stdout lines [tty err], got %q", lines)
+	}
+	if stderr := process.stderr.GetAll(); len(stderr) != 0 {
+		t.Errorf("Expected no stderr lines, got %v", stderr)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errProcessNotRunning is returned when an operation requires a live process
+var errProcessNotRunning = errors.New("process is not running")
+
+// errStatsUnsupported is returned on platforms without /proc
+var errStatsUnsupported = errors.New("process stats are only supported on Linux")
+
+// ProcessStats is a point-in-time resource usage sample of a process
+type ProcessStats struct {
+	ID  string `json:"id"`
+	PID int    `json:"pid"`
+	// CPU time consumed so far, in seconds
+	CPUUserSeconds   float64 `json:"cpu_user_seconds"`
+	CPUSystemSeconds float64 `json:"cpu_system_seconds"`
+	CPUSeconds       float64 `json:"cpu_seconds"`
+	// RSSBytes is the resident set size
+	RSSBytes uint64 `json:"rss_bytes"`
+	Threads  int    `json:"threads"`
+}
+
+// ProcessStats samples the current resource usage of a running process
+func (pm *ProcessManager) ProcessStats(id string) (*ProcessStats, error) {
+	process, err := pm.GetProcess(id)
+	if err != nil {
+		return nil, err
+	}
+
+	process.mu.RLock()
+	status := process.Status
+	pid := process.PID
+	process.mu.RUnlock()
+
+	if status != ProcessStatusRunning {
+		return nil, fmt.Errorf("%w (status: %s)", errProcessNotRunning, status)
+	}
+
+	stats, err := readProcStats(pid)
+	if err != nil {
+		return nil, err
+	}
+	stats.ID = id
+	return stats, nil
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicksPerSecond is USER_HZ, which the kernel fixes at 100 for the
+// values it reports through /proc
+const clockTicksPerSecond = 100
+
+// readProcStats reads the usage of pid from /proc/<pid>/stat and
+// /proc/<pid>/status. A process that has exited, even if not yet reaped, is
+// reported as errProcessNotRunning.
+func readProcStats(pid int) (*ProcessStats, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errProcessNotRunning
+		}
+		return nil, err
+	}
+
+	// The command name is parenthesised and may contain spaces, so split
+	// after its closing parenthesis. fields[0] is then the state (field 3).
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 18 {
+		return nil, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return nil, errProcessNotRunning
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse utime: %w", err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stime: %w", err)
+	}
+	threads, err := strconv.Atoi(fields[17])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse thread count: %w", err)
+	}
+
+	rss, err := readProcRSS(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ProcessStats{
+		PID:              pid,
+		CPUUserSeconds:   float64(utime) / clockTicksPerSecond,
+		CPUSystemSeconds: float64(stime) / clockTicksPerSecond,
+		RSSBytes:         rss,
+		Threads:          threads,
+	}
+	stats.CPUSeconds = stats.CPUUserSeconds + stats.CPUSystemSeconds
+	return stats, nil
+}
+
+// readProcRSS returns VmRSS from /proc/<pid>/status in bytes
+func readProcRSS(pid int) (uint64, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, errProcessNotRunning
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			break
+		}
+		kb, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse VmRSS: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	// Kernel threads and exiting processes have no VmRSS line
+	return 0, nil
+}
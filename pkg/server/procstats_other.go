@@ -0,0 +1,7 @@
+//go:build !linux
+
+package server
+
+func readProcStats(pid int) (*ProcessStats, error) {
+	return nil, errStatsUnsupported
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReadProcStatsSelf(t *testing.T) {
+	stats, err := readProcStats(os.Getpid())
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+	if stats.RSSBytes == 0 || stats.Threads < 1 {
+		t.Errorf("expected non-zero RSS and threads, got %+v", stats)
+	}
+	if stats.CPUSeconds != stats.CPUUserSeconds+stats.CPUSystemSeconds {
+		t.Errorf("expected cpu_seconds to be the sum of user and system time, got %+v", stats)
+	}
+}
+
+func TestProcessStatsHandler(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	running, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(running.ID)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_stats?id="+running.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats ProcessStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if stats.ID != running.ID || stats.PID != running.PID || stats.RSSBytes == 0 || stats.Threads != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	finished, err := srv.processManager.StartProcess("true", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	<-finished.done
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_stats?id="+finished.ID, nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a finished process, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/process_stats?id=does-not-exist", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown process, got %d", w.Code)
+	}
+}
+
+func TestReadProcStatsExitedProcess(t *testing.T) {
+	// PIDs never exceed pid_max, which is at most 2^22
+	if _, err := readProcStats(1 << 30); !errors.Is(err, errProcessNotRunning) {
+		t.Errorf("expected errProcessNotRunning for a missing pid, got %v", err)
+	}
+}
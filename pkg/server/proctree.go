@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"sort"
+)
+
+// errProcTreeUnsupported is returned on platforms without /proc
+var errProcTreeUnsupported = errors.New("process trees are only supported on Linux")
+
+// ProcessTreeNode is an OS process in the tree of a managed process
+type ProcessTreeNode struct {
+	PID  int `json:"pid"`
+	PPID int `json:"ppid"`
+	// Command is the command line, or the bracketed process name when it
+	// has none, like ps shows kernel threads and zombies
+	Command string `json:"command"`
+	// State is the kernel scheduling state, e.g. "running", "sleeping" or
+	// "zombie"
+	State    string             `json:"state"`
+	Children []*ProcessTreeNode `json:"children,omitempty"`
+}
+
+// ProcessTree is the hierarchy of OS processes started by a managed process
+type ProcessTree struct {
+	ID     string        `json:"id"`
+	PID    int           `json:"pid"`
+	Status ProcessStatus `json:"status"`
+	// Processes are the roots of the tree: the managed process while it is
+	// alive, and processes of its group whose parent is gone, such as a
+	// server left running by a shell that exited
+	Processes []*ProcessTreeNode `json:"processes"`
+}
+
+// procEntry is a process as read from /proc
+type procEntry struct {
+	pid   int
+	ppid  int
+	pgrp  int
+	state string
+	// name is the executable name, used when the command line is empty
+	name string
+}
+
+// procStateNames maps the state letters of /proc/<pid>/stat to names
+var procStateNames = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "disk-sleep",
+	"T": "stopped",
+	"t": "tracing-stop",
+	"Z": "zombie",
+	"X": "dead",
+	"I": "idle",
+}
+
+// ProcessTree returns the OS processes descending from a managed process or
+// still in its process group
+func (pm *ProcessManager) ProcessTree(id string) (*ProcessTree, error) {
+	process, err := pm.GetProcess(id)
+	if err != nil {
+		return nil, err
+	}
+
+	process.mu.RLock()
+	tree := &ProcessTree{ID: id, PID: process.PID, Status: process.Status}
+	process.mu.RUnlock()
+
+	table, err := readProcTable()
+	if err != nil {
+		return nil, err
+	}
+	tree.Processes = buildProcessTree(tree.PID, table, readProcCommand)
+	return tree, nil
+}
+
+// buildProcessTree selects root and its descendants, and the members of the
+// process group root leads, from table. Each selected process hangs under
+// its parent when the parent is selected too, and is a root otherwise.
+func buildProcessTree(root int, table []procEntry, command func(procEntry) string) []*ProcessTreeNode {
+	children := make(map[int][]procEntry)
+	for _, entry := range table {
+		children[entry.ppid] = append(children[entry.ppid], entry)
+	}
+
+	selected := make(map[int]procEntry)
+	var visit func(entry procEntry)
+	visit = func(entry procEntry) {
+		if _, seen := selected[entry.pid]; seen {
+			return
+		}
+		selected[entry.pid] = entry
+		for _, child := range children[entry.pid] {
+			visit(child)
+		}
+	}
+	for _, entry := range table {
+		// The group outlives its leader, so it finds orphaned children
+		if entry.pid == root || entry.pgrp == root {
+			visit(entry)
+		}
+	}
+
+	nodes := make(map[int]*ProcessTreeNode, len(selected))
+	for pid, entry := range selected {
+		state, ok := procStateNames[entry.state]
+		if !ok {
+			state = entry.state
+		}
+		nodes[pid] = &ProcessTreeNode{PID: pid, PPID: entry.ppid, Command: command(entry), State: state}
+	}
+
+	roots := make([]*ProcessTreeNode, 0)
+	for _, node := range nodes {
+		if parent, ok := nodes[node.PPID]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	for _, node := range nodes {
+		sortProcessTreeNodes(node.Children)
+	}
+	sortProcessTreeNodes(roots)
+	return roots
+}
+
+func sortProcessTreeNodes(nodes []*ProcessTreeNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].PID < nodes[j].PID })
+}
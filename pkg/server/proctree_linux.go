@@ -0,0 +1,82 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readProcTable lists every process in /proc. Processes that exit while it
+// runs are skipped.
+func readProcTable() ([]procEntry, error) {
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var table []procEntry
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		entry, err := readProcEntry(pid)
+		if err != nil {
+			continue
+		}
+		table = append(table, entry)
+	}
+	return table, nil
+}
+
+// readProcEntry parses the state, parent and group of pid from
+// /proc/<pid>/stat
+func readProcEntry(pid int) (procEntry, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procEntry{}, err
+	}
+
+	// As in readProcStats, the name may contain spaces and parentheses, so
+	// fields are split after its last closing parenthesis
+	start := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if start < 0 || end < start {
+		return procEntry{}, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 3 {
+		return procEntry{}, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procEntry{}, fmt.Errorf("failed to parse ppid: %w", err)
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return procEntry{}, fmt.Errorf("failed to parse pgrp: %w", err)
+	}
+
+	return procEntry{
+		pid:   pid,
+		ppid:  ppid,
+		pgrp:  pgrp,
+		state: fields[0],
+		name:  string(stat[start+1 : end]),
+	}, nil
+}
+
+// readProcCommand returns the command line of entry, or its bracketed name
+// when the command line is empty or gone
+func readProcCommand(entry procEntry) string {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", entry.pid))
+	cmdline = bytes.TrimRight(cmdline, "\x00")
+	if err != nil || len(cmdline) == 0 {
+		return "[" + entry.name + "]"
+	}
+	return string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '}))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+func readProcTable() ([]procEntry, error) {
+	return nil, errProcTreeUnsupported
+}
+
+func readProcCommand(entry procEntry) string {
+	return "[" + entry.name + "]"
+}
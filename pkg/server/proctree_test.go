@@ -0,0 +1,90 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func processTree(t *testing.T, mux http.Handler, id string) ProcessTree {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/processes/tree?id="+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tree ProcessTree
+	if err := json.NewDecoder(w.Body).Decode(&tree); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return tree
+}
+
+// findNode returns the first node of nodes, or of their children, running
+// command
+func findNode(nodes []*ProcessTreeNode, command string) *ProcessTreeNode {
+	for _, node := range nodes {
+		if node.Command == command {
+			return node
+		}
+		if found := findNode(node.Children, command); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestProcessTreeShowsChildren(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("sleep 31 & wait", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(process.ID)
+
+	// Give the shell time to fork and the child time to go to sleep
+	var tree ProcessTree
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		tree = processTree(t, mux, process.ID)
+		child := findNode(tree.Processes, "sleep 31")
+		if (child != nil && child.State == "sleeping") || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(tree.Processes) != 1 || tree.Processes[0].PID != process.PID {
+		t.Fatalf("expected the shell as the only root, got %+v", tree.Processes)
+	}
+	child := findNode(tree.Processes[0].Children, "sleep 31")
+	if child == nil || child.PPID != process.PID || child.State != "sleeping" {
+		t.Errorf("expected the sleeping child under the shell, got %+v", tree.Processes[0].Children)
+	}
+}
+
+func TestProcessTreeShowsLeftoversOfFinishedProcess(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	process, err := srv.processManager.StartProcess("sleep 32 >/dev/null 2>&1 &", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	t.Cleanup(func() { syscall.Kill(-process.PID, syscall.SIGKILL) })
+	<-process.done
+
+	tree := processTree(t, mux, process.ID)
+	if tree.Status != ProcessStatusCompleted {
+		t.Errorf("expected the shell to have completed, got %s", tree.Status)
+	}
+	if len(tree.Processes) != 1 || tree.Processes[0].Command != "sleep 32" {
+		t.Fatalf("expected the orphaned sleep as the only root, got %+v", tree.Processes)
+	}
+}
@@ -0,0 +1,808 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koyeb/sandbox-container/pkg/logger"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// errProxyHostNotAllowed is returned for target hosts missing from the
+	// configured allowlist
+	errProxyHostNotAllowed = errors.New("target host not allowed")
+	// errPortAlreadyBound is returned when a listen port already forwards
+	// somewhere
+	errPortAlreadyBound = errors.New("port already bound")
+	// errPortNotBound is returned when unbinding a listen port that has no
+	// binding
+	errPortNotBound = errors.New("port not bound")
+)
+
+// DefaultProxyDialRetry is how long a proxied connection waits for its
+// target to accept connections before it is dropped
+const DefaultProxyDialRetry = 2 * time.Second
+
+// Backoff bounds between two attempts to reach a proxy target
+const (
+	proxyDialInitialBackoff = 25 * time.Millisecond
+	proxyDialMaxBackoff     = 250 * time.Millisecond
+)
+
+// unixTargetPrefix marks a target host that is a unix socket path
+const unixTargetPrefix = "unix:"
+
+// ProxyConnLog selects the proxied connections that are logged
+type ProxyConnLog string
+
+const (
+	// ProxyConnLogAll logs every connection when it is accepted and closed
+	ProxyConnLogAll ProxyConnLog = "all"
+	// ProxyConnLogErrors only logs connections whose target could not be
+	// reached or that ended with an error or an idle timeout
+	ProxyConnLogErrors ProxyConnLog = "errors"
+	// ProxyConnLogOff disables per-connection logs
+	ProxyConnLogOff ProxyConnLog = "off"
+)
+
+// DefaultProxyConnLog is used when no ProxyConnLog is configured
+const DefaultProxyConnLog = ProxyConnLogErrors
+
+// ProxyNoTarget is what the default proxy port does with connections that
+// arrive while no target is bound
+type ProxyNoTarget string
+
+const (
+	// ProxyNoTargetReject closes the connection right away, so clients see
+	// EOF
+	ProxyNoTargetReject ProxyNoTarget = "reject"
+	// ProxyNoTargetHold keeps the connection open briefly before closing it,
+	// so TCP health checks pass before anything is bound
+	ProxyNoTargetHold ProxyNoTarget = "hold"
+	// ProxyNoTargetRefuse resets the connection, so clients see a
+	// connection reset rather than a clean close
+	ProxyNoTargetRefuse ProxyNoTarget = "refuse"
+)
+
+// DefaultProxyNoTarget is used when no ProxyNoTarget is configured
+const DefaultProxyNoTarget = ProxyNoTargetHold
+
+// noTargetHold is how long ProxyNoTargetHold keeps a connection open
+const noTargetHold = 100 * time.Millisecond
+
+// Close reasons of proxied connections, as logged
+const (
+	proxyCloseDone       = "closed"
+	proxyCloseIdle       = "idle_timeout"
+	proxyCloseError      = "error"
+	proxyCloseDialFailed = "dial_failed"
+	proxyCloseNoTarget   = "no_target"
+)
+
+// ProxyTarget is where the proxy forwards connections: a TCP host and port,
+// or a unix socket when SocketPath is set
+type ProxyTarget struct {
+	Host       string `json:"host,omitempty"`
+	Port       string `json:"port,omitempty"`
+	SocketPath string `json:"socket_path,omitempty"`
+	// RateLimit caps each direction of a connection, in bytes per second.
+	// Zero uses the proxy's rate limit.
+	RateLimit int64 `json:"rate_limit,omitempty"`
+}
+
+// localTarget forwards to port on localhost
+func localTarget(port string) ProxyTarget {
+	return ProxyTarget{Host: "localhost", Port: port}
+}
+
+func (t ProxyTarget) String() string {
+	if t.SocketPath != "" {
+		return unixTargetPrefix + t.SocketPath
+	}
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+func (t ProxyTarget) dial() (net.Conn, error) {
+	if t.SocketPath != "" {
+		return net.Dial("unix", t.SocketPath)
+	}
+	return DialTCP(net.JoinHostPort(t.Host, t.Port))
+}
+
+// DefaultProxyProbeTimeout bounds the dial of a target probe that sets no
+// timeout
+const DefaultProxyProbeTimeout = 2 * time.Second
+
+// probe dials target once, giving up after timeout, and returns how long the
+// connection took to establish. The connection is closed right away.
+func (t ProxyTarget) probe(timeout time.Duration) (time.Duration, error) {
+	network, address := "tcp", net.JoinHostPort(t.Host, t.Port)
+	if t.SocketPath != "" {
+		network, address = "unix", t.SocketPath
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout(network, address, timeout)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	conn.Close()
+	return latency, nil
+}
+
+// dialWithRetry dials target until it succeeds or window has elapsed,
+// backing off between attempts, so connections made right after a bind wait
+// for the backend to start listening
+func dialWithRetry(target ProxyTarget, window time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(window)
+	backoff := proxyDialInitialBackoff
+	for {
+		conn, err := target.dial()
+		if err == nil {
+			return conn, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, err
+		}
+		time.Sleep(min(backoff, remaining))
+		backoff = min(backoff*2, proxyDialMaxBackoff)
+	}
+}
+
+// TCPProxy handles TCP forwarding. The default listener started by
+// StartTCPProxy forwards to the target set with SetTarget; further listen
+// ports can be forwarded to their own targets with Bind.
+type TCPProxy struct {
+	mu       sync.RWMutex
+	target   *ProxyTarget
+	listener *TCPListener
+	bindings map[string]*portBinding
+	// allowedHosts, when non-empty, lists the TCP hosts targets may use
+	// besides the loopback addresses
+	allowedHosts map[string]bool
+	// dialRetry is how long to keep retrying a target that refuses
+	// connections, e.g. because its server is still starting
+	dialRetry time.Duration
+	// idleTimeout closes connections that moved no bytes in either
+	// direction for that long. Zero keeps idle connections open.
+	idleTimeout time.Duration
+	// limiter, when set, caps the connections handled at once across all
+	// listeners
+	limiter *connLimiter
+	// connLog selects the connections that are logged
+	connLog ProxyConnLog
+	// noTarget handles connections to the default port while it is unbound
+	noTarget ProxyNoTarget
+	// rateLimit caps each direction of connections whose target sets no
+	// rate limit, in bytes per second. Zero leaves them unthrottled.
+	rateLimit int64
+	// activity records proxied bytes, nil unless idle shutdown is on. It is
+	// set before the proxy starts and never changes.
+	activity *activityTracker
+
+	// Counters reported by Stats
+	activeConns     atomic.Int64
+	totalConns      atomic.Uint64
+	failedConns     atomic.Uint64
+	bytesToTarget   atomic.Uint64
+	bytesFromTarget atomic.Uint64
+}
+
+// ProxyStats is a snapshot of the proxy activity since the server started
+type ProxyStats struct {
+	// ActiveConnections is the number of connections being forwarded
+	ActiveConnections int64 `json:"active_connections"`
+	// TotalConnections counts every connection forwarded to a target
+	TotalConnections uint64 `json:"total_connections"`
+	// FailedConnections counts connections dropped because their target
+	// could not be reached
+	FailedConnections uint64 `json:"failed_connections"`
+	// BytesToTarget counts bytes sent by clients to targets
+	BytesToTarget uint64 `json:"bytes_to_target"`
+	// BytesFromTarget counts bytes sent by targets back to clients
+	BytesFromTarget uint64 `json:"bytes_from_target"`
+	// OpenConnections is the number of accepted connections holding a slot
+	// of MaxConnections, including those still reaching their target
+	OpenConnections int `json:"open_connections"`
+	// MaxConnections is the concurrent connection limit, 0 if unlimited
+	MaxConnections int `json:"max_connections"`
+	// RejectedConnections counts connections closed because the limit was
+	// reached
+	RejectedConnections uint64 `json:"rejected_connections"`
+}
+
+// Stats returns the connection and byte counters of every listener
+func (p *TCPProxy) Stats() ProxyStats {
+	stats := ProxyStats{
+		ActiveConnections: p.activeConns.Load(),
+		TotalConnections:  p.totalConns.Load(),
+		FailedConnections: p.failedConns.Load(),
+		BytesToTarget:     p.bytesToTarget.Load(),
+		BytesFromTarget:   p.bytesFromTarget.Load(),
+	}
+
+	if limiter := p.connLimiter(); limiter != nil {
+		stats.OpenConnections = len(limiter.slots)
+		stats.MaxConnections = cap(limiter.slots)
+		stats.RejectedConnections = limiter.rejected.Load()
+	}
+	return stats
+}
+
+// portBinding is an extra listener forwarding to its own target
+type portBinding struct {
+	target   ProxyTarget
+	listener *TCPListener
+}
+
+func NewTCPProxy() *TCPProxy {
+	return &TCPProxy{
+		bindings:  make(map[string]*portBinding),
+		dialRetry: DefaultProxyDialRetry,
+		connLog:   DefaultProxyConnLog,
+		noTarget:  DefaultProxyNoTarget,
+	}
+}
+
+func (p *TCPProxy) SetTarget(target ProxyTarget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.target = &target
+}
+
+// GetTarget returns the target of the default listener, if one is bound
+func (p *TCPProxy) GetTarget() (ProxyTarget, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.target == nil {
+		return ProxyTarget{}, false
+	}
+	return *p.target, true
+}
+
+// BindTarget sets the target of the default listener unless one is already
+// bound, in which case it returns that target and bound=true. The check and
+// the set happen under one lock.
+func (p *TCPProxy) BindTarget(target ProxyTarget) (current ProxyTarget, bound bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.target != nil {
+		return *p.target, true
+	}
+	p.target = &target
+	return ProxyTarget{}, false
+}
+
+// ClearTarget unbinds the default listener and returns the target it had, if
+// any
+func (p *TCPProxy) ClearTarget() (ProxyTarget, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.target
+	p.target = nil
+	if previous == nil {
+		return ProxyTarget{}, false
+	}
+	return *previous, true
+}
+
+// SetDialRetry sets how long connections wait for their target to come up.
+// Zero disables retries.
+func (p *TCPProxy) SetDialRetry(window time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialRetry = window
+}
+
+// SetMaxConnections caps the connections handled at once across all
+// listeners; connections over the limit are closed as soon as they are
+// accepted. Zero removes the limit. It only applies to listeners started
+// afterwards.
+func (p *TCPProxy) SetMaxConnections(max int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limiter = nil
+	if max > 0 {
+		p.limiter = newConnLimiter(max)
+	}
+}
+
+// connLimiter returns the limiter shared by the proxy listeners, if any
+func (p *TCPProxy) connLimiter() *connLimiter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.limiter
+}
+
+// SetIdleTimeout sets how long a proxied connection may stay idle before it
+// is closed. Zero disables the timeout.
+func (p *TCPProxy) SetIdleTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idleTimeout = timeout
+}
+
+// SetRateLimit caps each direction of proxied connections to bytesPerSecond,
+// unless their target sets its own limit. Zero disables throttling.
+func (p *TCPProxy) SetRateLimit(bytesPerSecond int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rateLimit = bytesPerSecond
+}
+
+// SetConnLog selects the proxied connections that are logged. Empty uses
+// DefaultProxyConnLog.
+func (p *TCPProxy) SetConnLog(mode ProxyConnLog) {
+	if mode == "" {
+		mode = DefaultProxyConnLog
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connLog = mode
+}
+
+// SetNoTarget selects what happens to connections to the default port while
+// no target is bound. Empty uses DefaultProxyNoTarget.
+func (p *TCPProxy) SetNoTarget(policy ProxyNoTarget) {
+	if policy == "" {
+		policy = DefaultProxyNoTarget
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.noTarget = policy
+}
+
+// ParseProxyNoTarget validates a ProxyNoTarget
+func ParseProxyNoTarget(value string) (ProxyNoTarget, error) {
+	switch policy := ProxyNoTarget(strings.ToLower(value)); policy {
+	case ProxyNoTargetReject, ProxyNoTargetHold, ProxyNoTargetRefuse:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported proxy no-target policy %q: expected reject, hold or refuse", value)
+}
+
+// dropUnbound ends a connection to the default port while no target is
+// bound, according to the no-target policy
+func (p *TCPProxy) dropUnbound(conn *Connection) {
+	p.mu.RLock()
+	policy := p.noTarget
+	p.mu.RUnlock()
+
+	tracked := p.newProxyConn(conn, "")
+	switch policy {
+	case ProxyNoTargetHold:
+		buf := make([]byte, 1)
+		conn.SetReadDeadline(time.Now().Add(noTargetHold))
+		conn.Read(buf)
+	case ProxyNoTargetRefuse:
+		// Closing with no linger sends a RST instead of a FIN
+		if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+	}
+	conn.Close()
+	tracked.closed(proxyCloseNoTarget, 0, 0, nil)
+}
+
+// ParseProxyConnLog validates a ProxyConnLog
+func ParseProxyConnLog(value string) (ProxyConnLog, error) {
+	switch mode := ProxyConnLog(strings.ToLower(value)); mode {
+	case ProxyConnLogAll, ProxyConnLogErrors, ProxyConnLogOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported proxy connection log %q: expected all, errors or off", value)
+}
+
+// proxyConn is the logging state of a proxied connection
+type proxyConn struct {
+	ctx      context.Context
+	mode     ProxyConnLog
+	accepted time.Time
+}
+
+// newProxyConn starts tracking conn, tagging its log lines with a new
+// connection id, the client address and target
+func (p *TCPProxy) newProxyConn(conn *Connection, target string) *proxyConn {
+	p.mu.RLock()
+	mode := p.connLog
+	p.mu.RUnlock()
+
+	ctx := logger.WithAttrs(context.Background(), "conn_id", uuid.NewString(), "client", conn.RemoteAddr().String(), "target", target)
+	if mode == ProxyConnLogAll {
+		slog.InfoContext(ctx, "Proxy connection accepted")
+	}
+	return &proxyConn{ctx: ctx, mode: mode, accepted: time.Now()}
+}
+
+// closed logs the end of the connection. err is the first error that broke
+// it, if any.
+func (c *proxyConn) closed(reason string, bytesToTarget, bytesFromTarget int64, err error) {
+	failed := reason != proxyCloseDone && reason != proxyCloseNoTarget
+	if c.mode == ProxyConnLogOff || (c.mode == ProxyConnLogErrors && !failed) {
+		return
+	}
+
+	args := []any{
+		"reason", reason,
+		"bytes_to_target", bytesToTarget,
+		"bytes_from_target", bytesFromTarget,
+		"duration", time.Since(c.accepted),
+	}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	level := slog.LevelInfo
+	if failed {
+		level = slog.LevelWarn
+	}
+	slog.Log(c.ctx, level, "Proxy connection closed", args...)
+}
+
+// SetAllowedHosts restricts TCP targets to the given hosts. Loopback
+// addresses are always allowed; an empty list allows every host.
+func (p *TCPProxy) SetAllowedHosts(hosts []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.allowedHosts = make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			p.allowedHosts[host] = true
+		}
+	}
+}
+
+// checkHost validates a TCP target host and applies the allowlist
+func (p *TCPProxy) checkHost(host string) error {
+	if !isValidHost(host) {
+		return fmt.Errorf("invalid target host: %q", host)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	host = strings.ToLower(host)
+	if len(p.allowedHosts) == 0 || p.allowedHosts[host] || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errProxyHostNotAllowed, host)
+}
+
+// isValidHost accepts IP addresses and DNS names, and rejects anything that
+// carries a port, a path or other stray characters
+func isValidHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *TCPProxy) SetListener(listener *TCPListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listener = listener
+}
+
+func (p *TCPProxy) GetListener() *TCPListener {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.listener
+}
+
+// Listening reports whether the default listener accepts connections
+func (p *TCPProxy) Listening() bool {
+	listener := p.GetListener()
+	return listener != nil && listener.Listening()
+}
+
+// Bind starts listening on listenPort and forwards every connection to
+// target
+func (p *TCPProxy) Bind(listenPort string, target ProxyTarget) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if existing, ok := p.bindings[listenPort]; ok {
+		return fmt.Errorf("%w: %s forwards to %s", errPortAlreadyBound, listenPort, existing.target)
+	}
+
+	listener, err := NewTCPListener(listenPort)
+	if err != nil {
+		return fmt.Errorf("failed to create TCP listener: %w", err)
+	}
+	listener.limiter = p.limiter
+	if err := listener.Start(func(conn *Connection) {
+		defer conn.Close()
+		p.forward(conn, target)
+	}); err != nil {
+		return err
+	}
+
+	p.bindings[listenPort] = &portBinding{target: target, listener: listener}
+	return nil
+}
+
+// Unbind stops the listener on listenPort. It waits for the connections it
+// accepted to finish.
+func (p *TCPProxy) Unbind(listenPort string) error {
+	p.mu.Lock()
+	binding, ok := p.bindings[listenPort]
+	delete(p.bindings, listenPort)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", errPortNotBound, listenPort)
+	}
+
+	binding.listener.Stop()
+	return nil
+}
+
+// BindingTarget returns the target listenPort forwards to, or the target of
+// the default listener when listenPort is empty
+func (p *TCPProxy) BindingTarget(listenPort string) (ProxyTarget, bool) {
+	if listenPort == "" {
+		return p.GetTarget()
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	binding, ok := p.bindings[listenPort]
+	if !ok {
+		return ProxyTarget{}, false
+	}
+	return binding.target, true
+}
+
+// PortBinding describes a listen port and the target it forwards to
+type PortBinding struct {
+	ListenPort string      `json:"listen_port"`
+	Target     ProxyTarget `json:"target"`
+}
+
+// Bindings returns the extra port bindings sorted by listen port
+func (p *TCPProxy) Bindings() []PortBinding {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	bindings := make([]PortBinding, 0, len(p.bindings))
+	for listenPort, binding := range p.bindings {
+		bindings = append(bindings, PortBinding{ListenPort: listenPort, Target: binding.target})
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].ListenPort < bindings[j].ListenPort
+	})
+	return bindings
+}
+
+// Stop closes the default listener and every extra binding
+func (p *TCPProxy) Stop() {
+	p.mu.Lock()
+	listener := p.listener
+	bindings := p.bindings
+	p.bindings = make(map[string]*portBinding)
+	p.mu.Unlock()
+
+	if listener != nil {
+		listener.Stop()
+	}
+	for _, binding := range bindings {
+		binding.listener.Stop()
+	}
+}
+
+func (s *Server) StartTCPProxy(port string) error {
+	listener, err := NewTCPListener(port)
+	if err != nil {
+		return fmt.Errorf("failed to create TCP listener: %w", err)
+	}
+	listener.limiter = s.tcpProxy.connLimiter()
+
+	s.tcpProxy.SetListener(listener)
+
+	return listener.Start(func(conn *Connection) {
+		defer conn.Close()
+
+		target, ok := s.tcpProxy.GetTarget()
+		if !ok {
+			s.tcpProxy.dropUnbound(conn)
+			return
+		}
+
+		s.tcpProxy.forward(conn, target)
+	})
+}
+
+func (s *Server) StopTCPProxy() {
+	s.tcpProxy.Stop()
+}
+
+// forward copies data both ways between conn and target until either side
+// is done
+func (p *TCPProxy) forward(conn *Connection, target ProxyTarget) {
+	p.mu.RLock()
+	window := p.dialRetry
+	idle := &idleTracker{timeout: p.idleTimeout}
+	bytesPerSecond := p.rateLimit
+	p.mu.RUnlock()
+	if target.RateLimit > 0 {
+		bytesPerSecond = target.RateLimit
+	}
+
+	tracked := p.newProxyConn(conn, target.String())
+
+	targetConn, err := dialWithRetry(target, window)
+	if err != nil {
+		p.failedConns.Add(1)
+		slog.DebugContext(tracked.ctx, "Failed to connect to target", "error", err)
+		tracked.closed(proxyCloseDialFailed, 0, 0, err)
+		return
+	}
+	defer targetConn.Close()
+
+	p.totalConns.Add(1)
+	p.activeConns.Add(1)
+	defer p.activeConns.Add(-1)
+
+	// Copy both ways. When one side finishes sending, half-close the other
+	// so its peer sees EOF but can still answer, and only return once both
+	// directions are done so neither goroutine outlives the connection.
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	idle.touch()
+	pipe := func(dst, src net.Conn, counter *atomic.Uint64, copied *int64) {
+		defer wg.Done()
+		n, err := idle.copy(dst, throttle(observeActivity(src, p.activity), bytesPerSecond))
+		*copied = n
+		counter.Add(uint64(n))
+		if err != nil {
+			// A broken or idle side can't be half-closed cleanly, so
+			// unblock the other direction too. The other direction then
+			// fails because of it, so only the first error is kept.
+			errOnce.Do(func() { firstErr = err })
+			conn.Close()
+			targetConn.Close()
+			return
+		}
+		closeWrite(dst)
+	}
+
+	var toTarget, fromTarget int64
+	wg.Add(2)
+	go pipe(targetConn, conn.Conn, &p.bytesToTarget, &toTarget)
+	go pipe(conn.Conn, targetConn, &p.bytesFromTarget, &fromTarget)
+	wg.Wait()
+
+	reason := proxyCloseDone
+	var netErr net.Error
+	switch {
+	case firstErr == nil:
+	case idle.timeout > 0 && errors.As(firstErr, &netErr) && netErr.Timeout():
+		reason = proxyCloseIdle
+	default:
+		reason = proxyCloseError
+	}
+	tracked.closed(reason, toTarget, fromTarget, firstErr)
+}
+
+// closeWrite shuts down the writing side of conn, or closes it entirely
+// when it doesn't support half-closing
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// throttledConn is a net.Conn whose reads are held back to a rate limit
+type throttledConn struct {
+	net.Conn
+	limiter *rate.Limiter
+}
+
+// throttle limits the bytes read from conn to bytesPerSecond. Zero returns
+// conn unchanged.
+func throttle(conn net.Conn, bytesPerSecond int64) net.Conn {
+	if bytesPerSecond <= 0 {
+		return conn
+	}
+	// Allow up to a second worth of bytes at once, bounded by the copy
+	// buffer so a fast limit still spreads out its reads
+	burst := int(min(bytesPerSecond, 32*1024))
+	return &throttledConn{Conn: conn, limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst)}
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if len(b) > c.limiter.Burst() {
+		b = b[:c.limiter.Burst()]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		// The bytes are already read, so only the context could fail the
+		// wait and it is never cancelled
+		c.limiter.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
+// idleTracker records when bytes last moved in either direction of a
+// proxied connection
+type idleTracker struct {
+	timeout time.Duration
+	last    atomic.Int64
+}
+
+func (t *idleTracker) touch() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+// copy is io.Copy with a read deadline that is pushed back whenever bytes
+// move in either direction. It gives up once the connection has been idle
+// for the whole timeout.
+func (t *idleTracker) copy(dst, src net.Conn) (int64, error) {
+	if t.timeout <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		src.SetReadDeadline(time.Unix(0, t.last.Load()).Add(t.timeout))
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+
+		// The other direction may have been active while this one waited
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() &&
+			time.Since(time.Unix(0, t.last.Load())) < t.timeout {
+			continue
+		}
+		return written, err
+	}
+}
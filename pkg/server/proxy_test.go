@@ -0,0 +1,826 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/koyeb/sandbox-container/pkg/logger"
+)
+
+// startGreetingServer accepts connections on a random local port and
+// answers each one with greeting. It returns the port.
+func startGreetingServer(t *testing.T, greeting string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintln(conn, greeting)
+			conn.Close()
+		}
+	}()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// freePort returns a local TCP port that was free when checked
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// readGreeting connects to port and returns the first line it receives
+func readGreeting(t *testing.T, port string) string {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+port, 2*time.Second)
+	if err != nil {
+		t.Errorf("failed to connect to %s: %v", port, err)
+		return ""
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Errorf("failed to read from %s: %v", port, err)
+	}
+	return line
+}
+
+func bindPort(t *testing.T, mux http.Handler, req BindPortRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_port", body))
+	return w
+}
+
+func TestBindMultipleListenPorts(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	webPort := startGreetingServer(t, "web")
+	apiPort := startGreetingServer(t, "api")
+	webListen, apiListen := freePort(t), freePort(t)
+
+	for _, req := range []BindPortRequest{
+		{Port: webPort, ListenPort: webListen},
+		{Port: apiPort, ListenPort: apiListen},
+	} {
+		if w := bindPort(t, mux, req); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 binding %+v, got %d: %s", req, w.Code, w.Body.String())
+		}
+	}
+
+	if w := bindPort(t, mux, BindPortRequest{Port: apiPort, ListenPort: webListen}); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 rebinding a listen port, got %d", w.Code)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for port, want := range map[string]string{webListen: "web\n", apiListen: "api\n"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got := readGreeting(t, port); got != want {
+					t.Errorf("expected %q through %s, got %q", want, port, got)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if got := srv.tcpProxy.Bindings(); len(got) != 2 {
+		t.Errorf("expected two bindings, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/unbind_port", []byte(`{"listen_port":"`+webListen+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 unbinding, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := net.DialTimeout("tcp", "127.0.0.1:"+webListen, time.Second); err == nil {
+		t.Error("expected the unbound listen port to be closed")
+	}
+	if got := readGreeting(t, apiListen); got != "api\n" {
+		t.Errorf("expected the other binding to keep working, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/unbind_port", []byte(`{"listen_port":"`+webListen+`"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 unbinding twice, got %d", w.Code)
+	}
+}
+
+func TestBindPortDefaultProxy(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	proxyPort := freePort(t)
+	if err := srv.StartTCPProxy(proxyPort); err != nil {
+		t.Fatalf("failed to start proxy: %v", err)
+	}
+
+	target := startGreetingServer(t, "default")
+	if w := bindPort(t, mux, BindPortRequest{Port: target}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := bindPort(t, mux, BindPortRequest{Port: target}); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 binding the default port twice, got %d", w.Code)
+	}
+
+	if got := readGreeting(t, proxyPort); got != "default\n" {
+		t.Errorf("expected the default proxy to forward, got %q", got)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/unbind_port", nil))
+	if _, bound := srv.tcpProxy.GetTarget(); w.Code != http.StatusOK || bound {
+		t.Errorf("expected unbinding without a body to clear the default target, got %d", w.Code)
+	}
+}
+
+func TestProxyHalfClosesConnections(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	// The backend only answers once the client is done sending
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, _ := io.ReadAll(conn)
+				fmt.Fprintf(conn, "got %s", request)
+			}()
+		}
+	}()
+
+	listen := freePort(t)
+	target := strconv.Itoa(backend.Addr().(*net.TCPAddr).Port)
+	if w := bindPort(t, mux, BindPortRequest{Port: target, ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	baseline := runtime.NumGoroutine()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("ping"))
+		conn.(*net.TCPConn).CloseWrite()
+
+		reply, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(reply) != "got ping" {
+			t.Fatalf("expected the reply sent after the client finished, got %q (%v)", reply, err)
+		}
+	}
+
+	// Every proxy goroutine must exit once both sides are done
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("expected goroutines to return to %d, got %d", baseline, n)
+	}
+}
+
+// startTCPEchoServer accepts connections and echoes whatever they send back
+// to them. It returns the port.
+func startTCPEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestProxyClosesIdleConnections(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+	srv.tcpProxy.SetIdleTimeout(300 * time.Millisecond)
+
+	listen := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: startTCPEchoServer(t), ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected the connection to close after the idle timeout, took %v", elapsed)
+	}
+}
+
+func TestProxyKeepsActiveConnections(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+	srv.tcpProxy.SetIdleTimeout(300 * time.Millisecond)
+
+	listen := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: startTCPEchoServer(t), ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Keep traffic flowing for well past the idle timeout
+	buf := make([]byte, 1)
+	for i := 0; i < 8; i++ {
+		time.Sleep(100 * time.Millisecond)
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("x")); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("read %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestProxyStats(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+	srv.tcpProxy.SetDialRetry(0)
+
+	listen := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: startTCPEchoServer(t), ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		if reply, err := io.ReadAll(conn); err != nil || string(reply) != "hello" {
+			t.Fatalf("expected the echo, got %q (%v)", reply, err)
+		}
+		conn.Close()
+	}
+
+	// A target nothing listens on counts as a failed connection
+	closedListen := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: freePort(t), ListenPort: closedListen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+closedListen); err == nil {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	var stats ProxyStats
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/proxy_stats", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if stats.ActiveConnections == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := ProxyStats{TotalConnections: 3, FailedConnections: 1, BytesToTarget: 15, BytesFromTarget: 15}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestProxyNoTargetPolicies(t *testing.T) {
+	tests := []struct {
+		policy ProxyNoTarget
+		check  func(t *testing.T, elapsed time.Duration, err error)
+	}{
+		{ProxyNoTargetReject, func(t *testing.T, elapsed time.Duration, err error) {
+			if err != io.EOF || elapsed >= noTargetHold {
+				t.Errorf("expected an immediate EOF, got %v after %s", err, elapsed)
+			}
+		}},
+		{ProxyNoTargetHold, func(t *testing.T, elapsed time.Duration, err error) {
+			if err != io.EOF || elapsed < noTargetHold/2 {
+				t.Errorf("expected EOF once the connection was held, got %v after %s", err, elapsed)
+			}
+		}},
+		{ProxyNoTargetRefuse, func(t *testing.T, elapsed time.Duration, err error) {
+			if !errors.Is(err, syscall.ECONNRESET) {
+				t.Errorf("expected a connection reset, got %v after %s", err, elapsed)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			srv, _ := newTestServer(t)
+			t.Cleanup(srv.StopTCPProxy)
+			srv.tcpProxy.SetNoTarget(tt.policy)
+
+			proxyPort := freePort(t)
+			if err := srv.StartTCPProxy(proxyPort); err != nil {
+				t.Fatalf("failed to start proxy: %v", err)
+			}
+
+			// On loopback, a reset can arrive before the dial returns
+			start := time.Now()
+			conn, err := net.Dial("tcp", "127.0.0.1:"+proxyPort)
+			if err == nil {
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				_, err = conn.Read(make([]byte, 1))
+			}
+			tt.check(t, time.Since(start), err)
+		})
+	}
+}
+
+// startCountingServer reads each connection until the client half-closes it
+// and answers with the number of bytes received. It returns the port.
+func startCountingServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				n, _ := io.Copy(io.Discard, conn)
+				fmt.Fprint(conn, n)
+			}()
+		}
+	}()
+
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	const (
+		limit = 50000
+		// The first burst goes through at once, the rest takes half a second
+		size     = 32*1024 + limit/2
+		expected = 500 * time.Millisecond
+	)
+
+	tests := []struct {
+		name      string
+		global    int64
+		bindLimit int64
+	}{
+		{"global", limit, 0},
+		{"per bind", 10 * limit, limit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, mux := newTestServer(t)
+			t.Cleanup(srv.StopTCPProxy)
+			srv.tcpProxy.SetRateLimit(tt.global)
+
+			listen := freePort(t)
+			if w := bindPort(t, mux, BindPortRequest{Port: startCountingServer(t), ListenPort: listen, RateLimit: tt.bindLimit}); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			start := time.Now()
+			if _, err := conn.Write(make([]byte, size)); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+			reply, err := io.ReadAll(conn)
+			elapsed := time.Since(start)
+
+			if err != nil || string(reply) != strconv.Itoa(size) {
+				t.Fatalf("expected the target to receive %d bytes, got %q (%v)", size, reply, err)
+			}
+			if elapsed < expected*8/10 || elapsed > expected*3 {
+				t.Errorf("expected the transfer to take about %s, took %s", expected, elapsed)
+			}
+		})
+	}
+
+	_, mux := newTestServer(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: "8080", ListenPort: freePort(t), RateLimit: -1}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative rate_limit, got %d", w.Code)
+	}
+}
+
+// logBuffer collects the log lines written while a test runs
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(data)
+}
+
+// record returns the first JSON log line with message msg, if any
+func (b *logBuffer) record(t *testing.T, msg string) map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON log lines, got %q", line)
+		}
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestProxyConnectionLogs(t *testing.T) {
+	logs := &logBuffer{}
+	handler, err := logger.NewHandler(logs, slog.LevelDebug, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+	srv.tcpProxy.SetConnLog(ProxyConnLogAll)
+
+	listen := freePort(t)
+	target := startTCPEchoServer(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: target, ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("hello proxy"))
+	conn.(*net.TCPConn).CloseWrite()
+	if reply, err := io.ReadAll(conn); err != nil || string(reply) != "hello proxy" {
+		t.Fatalf("expected the echo, got %q (%v)", reply, err)
+	}
+	client := conn.LocalAddr().String()
+	conn.Close()
+
+	var closed map[string]any
+	deadline := time.Now().Add(2 * time.Second)
+	for closed == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		closed = logs.record(t, "Proxy connection closed")
+	}
+	if closed == nil {
+		t.Fatal("expected a close log for the proxied connection")
+	}
+	if closed["reason"] != "closed" || closed["bytes_to_target"] != float64(11) || closed["bytes_from_target"] != float64(11) {
+		t.Errorf("expected a clean close with 11 bytes each way, got %v", closed)
+	}
+	if closed["client"] != client || closed["target"] != "localhost:"+target {
+		t.Errorf("expected client %s and target localhost:%s, got %v", client, target, closed)
+	}
+	if _, ok := closed["duration"]; !ok {
+		t.Errorf("expected the connection duration, got %v", closed)
+	}
+	accepted := logs.record(t, "Proxy connection accepted")
+	if accepted == nil || accepted["conn_id"] == nil || accepted["conn_id"] != closed["conn_id"] {
+		t.Errorf("expected the accept and close logs to share a conn_id, got %v and %v", accepted, closed)
+	}
+}
+
+func TestProxyMaxConnections(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+	srv.tcpProxy.SetMaxConnections(2)
+
+	listen := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: startTCPEchoServer(t), ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	echo := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("x")); err != nil {
+			return err
+		}
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+
+	var open []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+listen)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+		if err := echo(conn); err != nil {
+			t.Fatalf("connection %d within the limit failed: %v", i, err)
+		}
+		open = append(open, conn)
+	}
+
+	extra, err := net.Dial("tcp", "127.0.0.1:"+listen)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer extra.Close()
+	if err := echo(extra); err == nil {
+		t.Error("expected the connection over the limit to be closed")
+	}
+
+	for i, conn := range open {
+		if err := echo(conn); err != nil {
+			t.Errorf("expected existing connection %d to keep working: %v", i, err)
+		}
+	}
+
+	stats := srv.tcpProxy.Stats()
+	if stats.MaxConnections != 2 || stats.OpenConnections != 2 || stats.RejectedConnections != 1 {
+		t.Errorf("expected 2/2 open connections and 1 rejected, got %+v", stats)
+	}
+}
+
+func TestProxyWaitsForBackend(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	target, listen := freePort(t), freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: target, ListenPort: listen}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Start the backend only after the client has connected
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		listener, err := net.Listen("tcp", "127.0.0.1:"+target)
+		if err != nil {
+			t.Errorf("failed to start backend: %v", err)
+			return
+		}
+		t.Cleanup(func() { listener.Close() })
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(conn, "late")
+		conn.Close()
+	}()
+
+	if got := readGreeting(t, listen); got != "late\n" {
+		t.Errorf("expected the connection to wait for the backend, got %q", got)
+	}
+}
+
+func TestProxyDialRetryGivesUp(t *testing.T) {
+	start := time.Now()
+	if _, err := dialWithRetry(localTarget(freePort(t)), 200*time.Millisecond); err == nil {
+		t.Fatal("expected dialing a closed port to fail")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected to give up after the retry window, took %v", elapsed)
+	}
+}
+
+func TestBindPortTargetHost(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	target := startGreetingServer(t, "ipv4")
+	listen := freePort(t)
+	w := bindPort(t, mux, BindPortRequest{Port: target, TargetHost: "127.0.0.1", ListenPort: listen})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := readGreeting(t, listen); got != "ipv4\n" {
+		t.Errorf("expected to reach 127.0.0.1, got %q", got)
+	}
+}
+
+func TestBindPortUnixSocket(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	socketPath := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintln(conn, "unix")
+			conn.Close()
+		}
+	}()
+
+	listen := freePort(t)
+	w := bindPort(t, mux, BindPortRequest{TargetHost: "unix:" + socketPath, ListenPort: listen})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := readGreeting(t, listen); got != "unix\n" {
+		t.Errorf("expected to reach the unix socket, got %q", got)
+	}
+}
+
+func TestBindPortTargetHostValidation(t *testing.T) {
+	root := t.TempDir()
+	srv, mux := newTestServerWithConfig(t, Config{Root: root, ProxyAllowedHosts: []string{"db.internal"}})
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"db.internal", http.StatusOK},
+		{"127.0.0.1", http.StatusOK},
+		{"169.254.169.254", http.StatusForbidden},
+		{"example.com", http.StatusForbidden},
+		{"db.internal:22", http.StatusBadRequest},
+		{"db internal", http.StatusBadRequest},
+		{"unix:", http.StatusBadRequest},
+		{"unix:/var/run/docker.sock", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := bindPort(t, mux, BindPortRequest{Port: "8080", TargetHost: tt.host})
+		if w.Code != tt.want {
+			t.Errorf("target_host %q: expected %d, got %d: %s", tt.host, tt.want, w.Code, w.Body.String())
+		}
+		srv.tcpProxy.ClearTarget()
+	}
+}
+
+func TestBindPortRejectsInvalidListenPort(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for _, req := range []BindPortRequest{
+		{Port: "3000", ListenPort: "http"},
+		{Port: "70000", ListenPort: "3001"},
+	} {
+		if w := bindPort(t, mux, req); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %+v, got %d", req, w.Code)
+		}
+	}
+}
+
+func TestProxyProbe(t *testing.T) {
+	srv, mux := newTestServer(t)
+	t.Cleanup(srv.StopTCPProxy)
+
+	probe := func(query string) (int, ProxyProbeResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/proxy/probe"+query, nil))
+		var resp ProxyProbeResponse
+		if w.Code == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&resp)
+		}
+		return w.Code, resp
+	}
+
+	if code, _ := probe(""); code != http.StatusConflict {
+		t.Errorf("expected 409 while no target is bound, got %d", code)
+	}
+
+	port := startGreetingServer(t, "hello")
+	if w := bindPort(t, mux, BindPortRequest{Port: port}); w.Code != http.StatusOK {
+		t.Fatalf("failed to bind: %d %s", w.Code, w.Body.String())
+	}
+	code, resp := probe("")
+	if code != http.StatusOK || !resp.Reachable || resp.Error != "" || resp.Target.Port != port {
+		t.Errorf("expected a listening target to be reachable, got %d %+v", code, resp)
+	}
+	if resp.LatencyMs < 0 {
+		t.Errorf("expected a non-negative latency, got %v", resp.LatencyMs)
+	}
+
+	closed := freePort(t)
+	listenPort := freePort(t)
+	if w := bindPort(t, mux, BindPortRequest{Port: closed, ListenPort: listenPort}); w.Code != http.StatusOK {
+		t.Fatalf("failed to bind: %d %s", w.Code, w.Body.String())
+	}
+	start := time.Now()
+	code, resp = probe("?listen_port=" + listenPort + "&timeout_ms=500")
+	if code != http.StatusOK || resp.Reachable || resp.Error == "" || resp.Target.Port != closed {
+		t.Errorf("expected a target without a listener to be unreachable, got %d %+v", code, resp)
+	}
+	// The probe does not wait for the target like proxied connections do
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the probe to give up quickly, took %s", elapsed)
+	}
+
+	if code, _ := probe("?listen_port=1"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a port that is not bound, got %d", code)
+	}
+	if code, _ := probe("?timeout_ms=0"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid timeout, got %d", code)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdleTTL is how long a client's bucket is kept after its last
+// request. A bucket idle that long is full again, so forgetting it is free.
+const rateLimitIdleTTL = 10 * time.Minute
+
+// RateLimit is a token bucket: RequestsPerSecond tokens are added per second
+// up to Burst. A zero RequestsPerSecond disables the limit.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// ParseRateLimit reads a limit written as "RPS" or "RPS:BURST", e.g. "5" or
+// "0.5:10". The burst defaults to RPS rounded up, and at least 1.
+func ParseRateLimit(value string) (RateLimit, error) {
+	rpsValue, burstValue, hasBurst := strings.Cut(value, ":")
+	rps, err := strconv.ParseFloat(rpsValue, 64)
+	if err != nil || !(rps > 0) || math.IsInf(rps, 0) {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected RPS or RPS:BURST with a positive RPS", value)
+	}
+	burst := max(1, int(math.Ceil(rps)))
+	if hasBurst {
+		burst, err = strconv.Atoi(burstValue)
+		if err != nil || burst <= 0 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected a positive burst", value)
+		}
+	}
+	return RateLimit{RequestsPerSecond: rps, Burst: burst}, nil
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one token bucket per client address
+type rateLimiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, clients: make(map[string]*clientBucket)}
+}
+
+// reserve takes a token from client's bucket
+func (l *rateLimiter) reserve(client string, now time.Time) *rate.Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdleTTL {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > rateLimitIdleTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rate.Limit(l.limit.RequestsPerSecond), l.limit.Burst)}
+		l.clients[client] = bucket
+	}
+	bucket.lastSeen = now
+	return bucket.limiter.ReserveN(now, 1)
+}
+
+// clientAddress keys rate limits by the client's IP, so every connection
+// from one host shares a bucket
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleware rejects requests over the global or per-route limit
+// with 429 and a Retry-After header. /health and /ready are never limited
+// so probes keep working under load.
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	if s.rateLimiter == nil && len(s.routeRateLimiters) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		client := clientAddress(r)
+		var reservations []*rate.Reservation
+		if limiter, ok := s.routeRateLimiters[r.URL.Path]; ok {
+			reservations = append(reservations, limiter.reserve(client, now))
+		}
+		if s.rateLimiter != nil {
+			reservations = append(reservations, s.rateLimiter.reserve(client, now))
+		}
+
+		var delay time.Duration
+		for _, reservation := range reservations {
+			delay = max(delay, reservation.DelayFrom(now))
+		}
+		if delay > 0 {
+			// Hand the tokens back: the request is rejected, not queued
+			for _, reservation := range reservations {
+				reservation.CancelAt(now)
+			}
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			slog.DebugContext(r.Context(), "Rate limit exceeded", "path", r.URL.Path, "client", client, "retry_after", retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeError(w, http.StatusTooManyRequests, ErrorCodeRateLimited, "Too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
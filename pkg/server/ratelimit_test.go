@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serveFrom(mux http.Handler, method, path, remoteAddr string) *httptest.ResponseRecorder {
+	req := newAuthRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{
+		RouteRateLimits: map[string]RateLimit{"/list_processes": {RequestsPerSecond: 10, Burst: 2}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := serveFrom(mux, http.MethodGet, "/list_processes", "10.0.0.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 within the burst, got %d", i, w.Code)
+		}
+	}
+
+	w := serveFrom(mux, http.MethodGet, "/list_processes", "10.0.0.1:1001")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the burst, got %d", w.Code)
+	}
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+		t.Errorf("expected a Retry-After of at least 1 second, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// Other clients and other routes have their own buckets
+	if w := serveFrom(mux, http.MethodGet, "/list_processes", "10.0.0.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected another client to be allowed, got %d", w.Code)
+	}
+	if w := serveFrom(mux, http.MethodGet, "/proxy_stats", "10.0.0.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected an unlimited route to be allowed, got %d", w.Code)
+	}
+
+	// A token is added every 100ms
+	time.Sleep(150 * time.Millisecond)
+	if w := serveFrom(mux, http.MethodGet, "/list_processes", "10.0.0.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 once the bucket refilled, got %d", w.Code)
+	}
+}
+
+func TestRateLimitGlobal(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{RateLimit: RateLimit{RequestsPerSecond: 10, Burst: 1}})
+
+	if w := serveFrom(mux, http.MethodGet, "/list_processes", "10.0.0.1:1000"); w.Code != http.StatusOK {
+		t.Fatalf("expected the first request to be allowed, got %d", w.Code)
+	}
+	if w := serveFrom(mux, http.MethodGet, "/proxy_stats", "10.0.0.1:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the global limit to apply across routes, got %d", w.Code)
+	}
+	if w := serveFrom(mux, http.MethodGet, "/health", "10.0.0.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected /health never to be limited, got %d", w.Code)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if w := serveFrom(mux, http.MethodGet, "/proxy_stats", "10.0.0.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 once the bucket refilled, got %d", w.Code)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  RateLimit
+	}{
+		{"5", RateLimit{RequestsPerSecond: 5, Burst: 5}},
+		{"0.5", RateLimit{RequestsPerSecond: 0.5, Burst: 1}},
+		{"2.5:10", RateLimit{RequestsPerSecond: 2.5, Burst: 10}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRateLimit(%q): expected %+v, got %+v (%v)", tt.value, tt.want, got, err)
+		}
+	}
+
+	for _, value := range []string{"", "0", "-1", "fast", "5:0", "5:x", "Inf", "NaN"} {
+		if _, err := ParseRateLimit(value); err == nil {
+			t.Errorf("ParseRateLimit(%q): expected an error", value)
+		}
+	}
+}
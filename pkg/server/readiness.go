@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Readiness probe types
+const (
+	// ReadinessProbeTCP passes once a local port accepts connections
+	ReadinessProbeTCP = "tcp"
+	// ReadinessProbeHTTP passes once a local port answers a GET with a 2xx
+	ReadinessProbeHTTP = "http"
+	// ReadinessProbeLog passes once an output line matches a pattern
+	ReadinessProbeLog = "log"
+)
+
+// defaultReadinessTimeout is how long a probe waits when it sets no timeout
+const defaultReadinessTimeout = 60 * time.Second
+
+// readinessPollInterval is the delay between tcp and http checks
+const readinessPollInterval = 100 * time.Millisecond
+
+// readinessHTTPTimeout bounds each request of an http probe
+const readinessHTTPTimeout = time.Second
+
+// errNoReadinessProbe is returned when waiting for a process to be ready
+// that was started without a readiness probe
+var errNoReadinessProbe = errors.New("process has no readiness probe")
+
+// ReadinessProbe tells when a started process is ready to serve, rather than
+// merely running
+type ReadinessProbe struct {
+	// Type is ReadinessProbeTCP, ReadinessProbeHTTP or ReadinessProbeLog
+	Type string `json:"type"`
+	// Port is the local port checked by tcp and http probes
+	Port int `json:"port,omitempty"`
+	// Path is requested by http probes (default "/")
+	Path string `json:"path,omitempty"`
+	// Pattern is the regular expression an output line of either stream must
+	// match for log probes
+	Pattern string `json:"pattern,omitempty"`
+	// Timeout is the number of seconds to wait for the probe to pass
+	// (default 60). The process keeps running when it does not.
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+// validate checks that the probe has what its type needs
+func (p *ReadinessProbe) validate() error {
+	switch p.Type {
+	case ReadinessProbeTCP, ReadinessProbeHTTP:
+		if p.Port < 1 || p.Port > 65535 {
+			return fmt.Errorf("%s probe needs a port between 1 and 65535", p.Type)
+		}
+	case ReadinessProbeLog:
+		if p.Pattern == "" {
+			return fmt.Errorf("log probe needs a pattern")
+		}
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported probe type %q: expected tcp, http or log", p.Type)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// check reports whether a tcp or http probe passes now
+func (p *ReadinessProbe) check() bool {
+	address := net.JoinHostPort("localhost", strconv.Itoa(p.Port))
+	if p.Type == ReadinessProbeTCP {
+		conn, err := net.DialTimeout("tcp", address, readinessPollInterval)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	path := p.Path
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+	client := &http.Client{Timeout: readinessHTTPTimeout}
+	resp, err := client.Get("http://" + address + path)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// readiness is the probe state of one run of a process
+type readiness struct {
+	mu    sync.Mutex
+	ready bool
+	// err explains why the probe gave up
+	err string
+	// done is closed once the probe passed or gave up
+	done chan struct{}
+	// pattern is matched against output lines by log probes
+	pattern *regexp.Regexp
+}
+
+func newReadiness(probe *ReadinessProbe) (*readiness, error) {
+	r := &readiness{done: make(chan struct{})}
+	if probe.Type == ReadinessProbeLog {
+		pattern, err := regexp.Compile(probe.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readiness pattern: %w", err)
+		}
+		r.pattern = pattern
+	}
+	return r, nil
+}
+
+// finish records the outcome of the probe. Only the first call counts.
+func (r *readiness) finish(ready bool, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	r.ready = ready
+	r.err = reason
+	close(r.done)
+}
+
+// state returns whether the run is ready and, if the probe gave up, why
+func (r *readiness) state() (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ready, r.err
+}
+
+// observe passes a log probe when line matches its pattern
+func (r *readiness) observe(line string) {
+	if r.pattern != nil && r.pattern.MatchString(line) {
+		r.finish(true, "")
+	}
+}
+
+// run checks probe until it passes, the run exits or the probe times out.
+// Log probes are passed by observe.
+func (r *readiness) run(probe *ReadinessProbe, exited <-chan struct{}) {
+	timeout := defaultReadinessTimeout
+	if probe.Timeout > 0 {
+		timeout = time.Duration(probe.Timeout * float64(time.Second))
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if probe.Type != ReadinessProbeLog && probe.check() {
+			r.finish(true, "")
+			return
+		}
+
+		select {
+		case <-r.done:
+			return
+		case <-exited:
+			r.finish(false, "process exited before it was ready")
+			return
+		case <-deadline.C:
+			r.finish(false, fmt.Sprintf("not ready after %s", timeout))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitReady blocks until the readiness probe of the process passed or gave
+// up, the process exited, timeout elapsed or ctx is done. It reports whether
+// the wait ended before the timeout. A zero timeout waits indefinitely.
+func (pm *ProcessManager) WaitReady(ctx context.Context, id string, timeout time.Duration) (bool, error) {
+	process, err := pm.GetProcess(id)
+	if err != nil {
+		return false, err
+	}
+	r := process.readiness.Load()
+	if r == nil {
+		return false, fmt.Errorf("%w: %s", errNoReadinessProbe, id)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case <-r.done:
+		return true, nil
+	case <-process.doneChan():
+		return true, nil
+	case <-ctx.Done():
+		return false, nil
+	}
+}
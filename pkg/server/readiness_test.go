@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// startWithProbe starts cmd through the API with probe and returns its ID
+func startWithProbe(t *testing.T, srv *Server, mux http.Handler, cmd string, probe *ReadinessProbe) string {
+	t.Helper()
+
+	body, _ := json.Marshal(StartProcessRequest{Cmd: cmd, Readiness: probe})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	t.Cleanup(func() { srv.processManager.KillProcess(resp.ID) })
+	return resp.ID
+}
+
+func waitReady(t *testing.T, mux http.Handler, id string, timeoutMs int64) WaitProcessResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(WaitProcessRequest{ID: id, TimeoutMs: timeoutMs, Until: "ready"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/wait_process", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp WaitProcessResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp
+}
+
+// processJSON returns the /get_process view of a process
+func processJSON(t *testing.T, mux http.Handler, id string) map[string]any {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/get_process?id="+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var process map[string]any
+	json.NewDecoder(w.Body).Decode(&process)
+	return process
+}
+
+func TestReadinessProbeLogMatch(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	id := startWithProbe(t, srv, mux, "sleep 0.3; echo booting; echo 'listening on :8080'; sleep 10",
+		&ReadinessProbe{Type: ReadinessProbeLog, Pattern: `listening on :\d+`})
+
+	if process := processJSON(t, mux, id); process["ready"] != false {
+		t.Errorf("expected the process not to be ready before its log line, got %v", process["ready"])
+	}
+
+	resp := waitReady(t, mux, id, 5000)
+	if resp.TimedOut || resp.Ready == nil || !*resp.Ready || resp.Status != ProcessStatusRunning {
+		t.Fatalf("expected a running, ready process, got %+v", resp)
+	}
+	if process := processJSON(t, mux, id); process["ready"] != true {
+		t.Errorf("expected the process JSON to report ready, got %v", process["ready"])
+	}
+}
+
+func TestReadinessProbePortOpen(t *testing.T) {
+	srv, mux := newTestServer(t)
+	port := freePort(t)
+	portNumber, _ := strconv.Atoi(port)
+
+	id := startWithProbe(t, srv, mux, "sleep 10", &ReadinessProbe{Type: ReadinessProbeTCP, Port: portNumber})
+
+	if resp := waitReady(t, mux, id, 300); !resp.TimedOut || resp.Ready == nil || *resp.Ready {
+		t.Fatalf("expected the wait to time out while nothing listens, got %+v", resp)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	if resp := waitReady(t, mux, id, 5000); resp.TimedOut || resp.Ready == nil || !*resp.Ready {
+		t.Fatalf("expected the process to be ready once the port accepts, got %+v", resp)
+	}
+}
+
+func TestReadinessProbeGivesUp(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	id := startWithProbe(t, srv, mux, "sleep 10", &ReadinessProbe{Type: ReadinessProbeLog, Pattern: "never", Timeout: 0.2})
+
+	resp := waitReady(t, mux, id, 5000)
+	if resp.TimedOut || resp.Ready == nil || *resp.Ready || resp.Status != ProcessStatusRunning {
+		t.Fatalf("expected the probe to give up on a running process, got %+v", resp)
+	}
+	if process := processJSON(t, mux, id); process["readiness_error"] == nil {
+		t.Errorf("expected the reason the probe gave up, got %v", process)
+	}
+
+	// Waiting for readiness needs a probe
+	plain, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(plain.ID)
+	body, _ := json.Marshal(WaitProcessRequest{ID: plain.ID, Until: "ready"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/wait_process", body))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 waiting for a process without a probe, got %d", w.Code)
+	}
+
+	for _, probe := range []ReadinessProbe{
+		{Type: "exec"},
+		{Type: ReadinessProbeTCP},
+		{Type: ReadinessProbeLog, Pattern: "("},
+	} {
+		body, _ := json.Marshal(StartProcessRequest{Cmd: "true", Readiness: &probe})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("probe %+v: expected 400, got %d", probe, w.Code)
+		}
+	}
+}
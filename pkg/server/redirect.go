@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// outputFileMode is the permission of output files created for a command
+const outputFileMode = 0o644
+
+// errOutputFilesStreamed rejects redirection on endpoints that stream
+// output, where it would leave nothing to stream
+var errOutputFilesStreamed = errors.New("stdout_file and stderr_file are only supported by /run and /start_process")
+
+// OutputFiles redirects the output of a command to files in the sandbox, for
+// output too large to send back. Redirected streams are not captured.
+type OutputFiles struct {
+	// StdoutFile receives stdout instead of the response or the process logs
+	StdoutFile string `json:"stdout_file,omitempty"`
+	// StderrFile receives stderr. It may be StdoutFile, like 2>&1.
+	StderrFile string `json:"stderr_file,omitempty"`
+	// AppendOutput appends to the files instead of truncating them
+	AppendOutput bool `json:"append_output,omitempty"`
+}
+
+// isSet reports whether any stream is redirected
+func (o OutputFiles) isSet() bool {
+	return o.StdoutFile != "" || o.StderrFile != ""
+}
+
+// open creates or opens the files, truncated unless AppendOutput is set. A
+// stream that is not redirected gets a nil file. Both streams share one file
+// when they name the same path, so their writes interleave rather than
+// overwrite each other. The caller closes the files once the command has
+// started, as it holds its own copies.
+func (o OutputFiles) open() (stdout, stderr *os.File, err error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if o.AppendOutput {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	if o.StdoutFile != "" {
+		if stdout, err = os.OpenFile(o.StdoutFile, flags, outputFileMode); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch o.StderrFile {
+	case "":
+	case o.StdoutFile:
+		stderr = stdout
+	default:
+		if stderr, err = os.OpenFile(o.StderrFile, flags, outputFileMode); err != nil {
+			closeOutputFiles(stdout, nil)
+			return nil, nil, err
+		}
+	}
+	return stdout, stderr, nil
+}
+
+// outputFileError reports an output file a process could not open. It
+// unwraps to the filesystem error, which tells the response status.
+type outputFileError struct {
+	err error
+}
+
+func (e outputFileError) Error() string {
+	return fmt.Sprintf("failed to open output file: %v", e.err)
+}
+
+func (e outputFileError) Unwrap() error {
+	return e.err
+}
+
+// closeOutputFiles closes files returned by OutputFiles.open
+func closeOutputFiles(stdout, stderr *os.File) {
+	if stdout != nil {
+		stdout.Close()
+	}
+	if stderr != nil && stderr != stdout {
+		stderr.Close()
+	}
+}
+
+// resolveOutputFiles confines the output files of a request to the sandbox
+// root and returns them resolved, writing an error response and returning
+// ok=false when a path is not allowed. A terminal merges both streams into
+// its own output, so it cannot be combined with redirection.
+func (s *Server) resolveOutputFiles(w http.ResponseWriter, files OutputFiles, tty bool) (resolved OutputFiles, ok bool) {
+	if !files.isSet() {
+		return files, true
+	}
+	if tty {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "stdout_file and stderr_file cannot be used with tty")
+		return OutputFiles{}, false
+	}
+
+	resolved = files
+	if files.StdoutFile != "" {
+		if resolved.StdoutFile, ok = s.sandboxPath(w, files.StdoutFile); !ok {
+			return OutputFiles{}, false
+		}
+	}
+	if files.StderrFile != "" {
+		if resolved.StderrFile, ok = s.sandboxPath(w, files.StderrFile); !ok {
+			return OutputFiles{}, false
+		}
+	}
+	return resolved, true
+}
+
+// rejectOutputFiles answers 400 with errOutputFilesStreamed when files
+// redirects a stream
+func rejectOutputFiles(w http.ResponseWriter, files OutputFiles) bool {
+	if !files.isSet() {
+		return false
+	}
+	writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, errOutputFilesStreamed.Error())
+	return true
+}
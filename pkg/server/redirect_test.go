@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func runRequest(t *testing.T, mux http.Handler, req RunRequest) RunResponse {
+	t.Helper()
+
+	reqBody, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/run", reqBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp RunResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func readOutputFile(t *testing.T, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	return string(content)
+}
+
+func TestRunRedirectsOutputToFile(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.log")
+	os.WriteFile(out, []byte("stale content that is longer\n"), 0o644)
+
+	resp := runRequest(t, mux, RunRequest{
+		Cmd:         "echo hello; echo oops >&2; exit 4",
+		OutputFiles: OutputFiles{StdoutFile: out},
+	})
+	if resp.Stdout != "" {
+		t.Errorf("expected no stdout in the response, got %q", resp.Stdout)
+	}
+	if resp.Stderr != "oops\n" || resp.ExitCode != 4 {
+		t.Errorf("expected stderr and the exit code to be returned, got %+v", resp)
+	}
+	if got := readOutputFile(t, out); got != "hello\n" {
+		t.Errorf("expected the file to be truncated and hold stdout, got %q", got)
+	}
+
+	// Appending keeps what earlier runs wrote, and both streams can share a
+	// file
+	resp = runRequest(t, mux, RunRequest{
+		Cmd:         "echo again; echo warning >&2",
+		OutputFiles: OutputFiles{StdoutFile: out, StderrFile: out, AppendOutput: true},
+	})
+	if resp.Stdout != "" || resp.Stderr != "" || resp.ExitCode != 0 {
+		t.Errorf("expected both streams to be redirected, got %+v", resp)
+	}
+	if got := readOutputFile(t, out); got != "hello\nagain\nwarning\n" {
+		t.Errorf("expected the output to be appended, got %q", got)
+	}
+}
+
+func TestStartProcessRedirectsOutputToFile(t *testing.T) {
+	srv, mux := newTestServer(t)
+	out := filepath.Join(t.TempDir(), "server.log")
+
+	reqBody, _ := json.Marshal(StartProcessRequest{
+		Cmd:         "echo started; echo failing >&2",
+		OutputFiles: OutputFiles{StdoutFile: out},
+	})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/start_process", reqBody))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var started StartProcessResponse
+	json.NewDecoder(w.Body).Decode(&started)
+
+	process, err := srv.processManager.GetProcess(started.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-process.doneChan()
+
+	if got := readOutputFile(t, out); got != "started\n" {
+		t.Errorf("expected stdout in the file, got %q", got)
+	}
+	logs, _ := srv.processManager.GetProcessLogs(process.ID)
+	if len(logs) != 1 || logs[0].Stream != "stderr" || logs[0].Data != "failing" {
+		t.Errorf("expected only stderr in the logs, got %+v", logs)
+	}
+	if got := process.ToJSON()["stdout_file"]; got != out {
+		t.Errorf("expected stdout_file to be reported, got %v", got)
+	}
+
+	// A restart truncates the file again
+	if _, err := srv.processManager.RestartProcess(process.ID); err != nil {
+		t.Fatal(err)
+	}
+	<-process.doneChan()
+	if got := readOutputFile(t, out); got != "started\n" {
+		t.Errorf("expected the restart to rewrite the file, got %q", got)
+	}
+}
+
+func TestOutputFilesRejected(t *testing.T) {
+	root := t.TempDir()
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	tests := []struct {
+		name   string
+		route  string
+		req    interface{}
+		status int
+	}{
+		{"outside the root", "/run", RunRequest{Cmd: "true", OutputFiles: OutputFiles{StdoutFile: "/etc/out.log"}}, http.StatusForbidden},
+		{"missing directory", "/run", RunRequest{Cmd: "true", OutputFiles: OutputFiles{StderrFile: filepath.Join(root, "missing", "err.log")}}, http.StatusNotFound},
+		{"with a terminal", "/run", RunRequest{Cmd: "true", TTY: true, OutputFiles: OutputFiles{StdoutFile: "out.log"}}, http.StatusBadRequest},
+		{"streamed output", "/run_streaming", RunRequest{Cmd: "true", OutputFiles: OutputFiles{StdoutFile: "out.log"}}, http.StatusBadRequest},
+		{"process outside the root", "/start_process", StartProcessRequest{Cmd: "true", OutputFiles: OutputFiles{StdoutFile: "../out.log"}}, http.StatusForbidden},
+		{"process in a missing directory", "/start_process", StartProcessRequest{Cmd: "true", OutputFiles: OutputFiles{StdoutFile: "missing/out.log"}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		reqBody, _ := json.Marshal(tt.req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, tt.route, reqBody))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "out.log")); !os.IsNotExist(err) {
+		t.Errorf("expected no output file to be created, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/koyeb/sandbox-container/pkg/logger"
+)
+
+// RequestIDHeader carries the correlation id of a request and its response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied ids so they cannot bloat logs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the id of the request ctx belongs to, or ""
+// outside of a request
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts non-empty ids of printable ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware tags each request with the X-Request-ID header, or a
+// new UUID when it is missing or invalid. The id is echoed in the response
+// and added to every log line written with the request context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logger.WithAttrs(ctx, "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
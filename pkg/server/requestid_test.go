@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/koyeb/sandbox-container/pkg/logger"
+)
+
+func TestRequestIDRoundTrips(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(RequestIDHeader, "trace-abc-123")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got != "trace-abc-123" {
+		t.Errorf("expected the request id to be echoed, got %q", got)
+	}
+
+	for _, header := range []string{"", "has spaces", strings.Repeat("x", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if header != "" {
+			req.Header.Set(RequestIDHeader, header)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if _, err := uuid.Parse(w.Header().Get(RequestIDHeader)); err != nil {
+			t.Errorf("header %q: expected a generated UUID, got %q", header, w.Header().Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	var got string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDFromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got == "" || got != w.Header().Get(RequestIDHeader) {
+		t.Errorf("expected the handler to see the response's request id %q, got %q", w.Header().Get(RequestIDHeader), got)
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := logger.NewHandler(&buf, slog.LevelDebug, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	_, mux := newTestServer(t)
+	req := newAuthRequest(http.MethodPost, "/read_file", []byte(`{"path": "/nonexistent/file.txt"}`))
+	req.Header.Set(RequestIDHeader, "req-42")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON log lines, got %q", line)
+		}
+		if record["request_id"] != "req-42" {
+			t.Errorf("expected request_id=req-42 on every line, got %q", line)
+		}
+	}
+	if len(lines) < 2 {
+		t.Errorf("expected the request to log, got %q", buf.String())
+	}
+}
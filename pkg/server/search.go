@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// DefaultSearchMaxResults is the number of matches /search returns unless
+// the request asks for another limit
+const DefaultSearchMaxResults = 1000
+
+// MaxSearchResultsLimit is the largest max_results a request may ask for
+const MaxSearchResultsLimit = 100000
+
+// Files whose first searchBinaryProbeSize bytes contain a null byte are
+// treated as binary and skipped
+const searchBinaryProbeSize = 8000
+
+// maxSearchLineSize is the longest line searched; files with longer lines
+// are only searched up to that line
+const maxSearchLineSize = 1024 * 1024
+
+// errSearchLimitReached stops the walk once enough matches were found
+var errSearchLimitReached = errors.New("search result limit reached")
+
+type SearchRequest struct {
+	Root    string `json:"root"`
+	Pattern string `json:"pattern"`
+	// Regex interprets Pattern as a regular expression instead of a literal
+	Regex      bool `json:"regex,omitempty"`
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// MaxResults caps the matches returned (default DefaultSearchMaxResults)
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+type SearchMatch struct {
+	File       string `json:"file"`
+	LineNumber int    `json:"line_number"`
+	Line       string `json:"line"`
+}
+
+type SearchResponse struct {
+	Matches []SearchMatch `json:"matches"`
+	// Truncated is set when the search stopped at MaxResults
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// searchPattern compiles the matcher of req
+func searchPattern(req SearchRequest) (*regexp.Regexp, error) {
+	pattern := req.Pattern
+	if !req.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if req.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// searchFiles walks root and returns up to maxResults lines matching re.
+// Symlinks are not followed, so the search stays inside root, and
+// unreadable or binary files are skipped.
+func searchFiles(ctx context.Context, root string, re *regexp.Regexp, maxResults int) ([]SearchMatch, bool, error) {
+	matches := []SearchMatch{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			slog.DebugContext(ctx, "Skipping unreadable search path", "path", path, "error", err)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		found, err := searchFile(path, re, maxResults-len(matches))
+		if err != nil {
+			slog.DebugContext(ctx, "Skipping unreadable search file", "path", path, "error", err)
+		}
+		matches = append(matches, found...)
+		if len(matches) >= maxResults {
+			return errSearchLimitReached
+		}
+		return nil
+	})
+	if errors.Is(err, errSearchLimitReached) {
+		return matches, true, nil
+	}
+	return matches, false, err
+}
+
+// searchFile returns up to limit lines of path matching re, or none if the
+// file looks binary
+func searchFile(path string, re *regexp.Regexp, limit int) ([]SearchMatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, searchBinaryProbeSize)
+	// Peek returns what it could read along with io.EOF for short files
+	head, _ := reader.Peek(searchBinaryProbeSize)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []SearchMatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchLineSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if !re.Match(scanner.Bytes()) {
+			continue
+		}
+		matches = append(matches, SearchMatch{File: path, LineNumber: lineNumber, Line: scanner.Text()})
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// searchHandler greps the contents of the files under a directory
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req SearchRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if req.Root == "" || req.Pattern == "" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "root and pattern are required")
+		return
+	}
+	if req.MaxResults < 0 || req.MaxResults > MaxSearchResultsLimit {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("max_results must be between 0 and %d", MaxSearchResultsLimit))
+		return
+	}
+	maxResults := req.MaxResults
+	if maxResults == 0 {
+		maxResults = DefaultSearchMaxResults
+	}
+
+	re, err := searchPattern(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid pattern: %v", err))
+		return
+	}
+
+	root, ok := s.sandboxPath(w, req.Root)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Searching files", "root", req.Root, "pattern", req.Pattern, "regex", req.Regex, "ignore_case", req.IgnoreCase, "max_results", maxResults)
+
+	matches, truncated, err := searchFiles(r.Context(), root, re, maxResults)
+	resp := SearchResponse{Matches: matches, Truncated: truncated}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to search files", "root", req.Root, "error", err)
+		resp.Error = err.Error()
+	} else {
+		slog.DebugContext(r.Context(), "Search completed", "root", req.Root, "matches", len(matches), "truncated", truncated)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files with the given content under root
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func search(t *testing.T, mux http.Handler, req SearchRequest) (int, SearchResponse) {
+	t.Helper()
+
+	reqBody, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/search", reqBody))
+
+	var resp SearchResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestSearchLiteral(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go":        "package main\n\nfunc main() {\n\tprintln(\"a.b\")\n}\n",
+		"pkg/util.go":    "package pkg\n// aXb is not a literal match\nvar s = \"a.b\"\n",
+		"image.png":      "a.b\x00binary",
+		"notes/TODO.txt": "nothing here\n",
+	})
+
+	code, resp := search(t, mux, SearchRequest{Root: root, Pattern: "a.b"})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	want := []SearchMatch{
+		{File: filepath.Join(root, "main.go"), LineNumber: 4, Line: "\tprintln(\"a.b\")"},
+		{File: filepath.Join(root, "pkg/util.go"), LineNumber: 3, Line: "var s = \"a.b\""},
+	}
+	if len(resp.Matches) != len(want) {
+		t.Fatalf("expected %d matches, got %+v", len(want), resp.Matches)
+	}
+	for i := range want {
+		if resp.Matches[i] != want[i] {
+			t.Errorf("match %d: expected %+v, got %+v", i, want[i], resp.Matches[i])
+		}
+	}
+	if resp.Truncated {
+		t.Error("expected the results not to be truncated")
+	}
+}
+
+func TestSearchRegex(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"app.py": "import os\nDEF = 1\ndef handler(event):\n    return event\ndef main():\n",
+	})
+
+	code, resp := search(t, mux, SearchRequest{Root: root, Pattern: `^def \w+\(`, Regex: true})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(resp.Matches) != 2 || resp.Matches[0].LineNumber != 3 || resp.Matches[1].LineNumber != 5 {
+		t.Errorf("expected matches on lines 3 and 5, got %+v", resp.Matches)
+	}
+
+	_, resp = search(t, mux, SearchRequest{Root: root, Pattern: `^def\b`, Regex: true, IgnoreCase: true})
+	if len(resp.Matches) != 3 || resp.Matches[0].LineNumber != 2 {
+		t.Errorf("expected case-insensitive matches on lines 2, 3 and 5, got %+v", resp.Matches)
+	}
+
+	if code, _ := search(t, mux, SearchRequest{Root: root, Pattern: "(", Regex: true}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid regex, got %d", code)
+	}
+}
+
+func TestSearchMaxResults(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt": "match\nmatch\nmatch\n",
+		"b.txt": "match\n",
+	})
+
+	_, resp := search(t, mux, SearchRequest{Root: root, Pattern: "match", MaxResults: 2})
+	if len(resp.Matches) != 2 || !resp.Truncated {
+		t.Errorf("expected 2 truncated matches, got %+v truncated=%v", resp.Matches, resp.Truncated)
+	}
+
+	if code, _ := search(t, mux, SearchRequest{Root: root, Pattern: "match", MaxResults: MaxSearchResultsLimit + 1}); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for max_results over the limit, got %d", code)
+	}
+}
+
+func TestSearchConfinedToRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeTree(t, root, map[string]string{"inside.txt": "secret inside\n"})
+	writeTree(t, outside, map[string]string{"outside.txt": "secret outside\n"})
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "outside.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	_, resp := search(t, mux, SearchRequest{Root: ".", Pattern: "secret"})
+	if len(resp.Matches) != 1 || resp.Matches[0].Line != "secret inside" {
+		t.Errorf("expected only the match inside the root, got %+v", resp.Matches)
+	}
+
+	if code, _ := search(t, mux, SearchRequest{Root: outside, Pattern: "secret"}); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a root outside the sandbox, got %d", code)
+	}
+}
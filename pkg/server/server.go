@@ -1,143 +1,340 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 )
 
+// Config holds the settings used to construct a Server
+type Config struct {
+	Auth AuthConfig
+	// Root confines all file operations to this directory tree (default "/")
+	Root string
+	// ProcessTTL, when positive, removes finished background processes this
+	// long after they exit
+	ProcessTTL time.Duration
+	// ProcessLogDir, when set, is where processes started with persisted logs
+	// write their output
+	ProcessLogDir string
+	// MaxProcesses, when positive, caps the background processes counted by
+	// MaxProcessesMode, which defaults to every managed process
+	MaxProcesses     int
+	MaxProcessesMode ProcessLimitMode
+	// ProxyAllowedHosts, when non-empty, restricts the hosts the TCP proxy
+	// may forward to. Loopback addresses are always allowed.
+	ProxyAllowedHosts []string
+	// ProxyDialRetry is how long proxied connections wait for their target
+	// to accept connections. Zero uses DefaultProxyDialRetry.
+	ProxyDialRetry time.Duration
+	// ProxyIdleTimeout, when positive, closes proxied connections that moved
+	// no bytes in either direction for that long
+	ProxyIdleTimeout time.Duration
+	// ProxyMaxConnections, when positive, caps the connections the TCP proxy
+	// handles at once
+	ProxyMaxConnections int
+	// ProxyConnLog selects the proxied connections that are logged. Empty
+	// uses DefaultProxyConnLog.
+	ProxyConnLog ProxyConnLog
+	// ProxyNoTarget selects what the default proxy port does with
+	// connections while no target is bound. Empty uses DefaultProxyNoTarget.
+	ProxyNoTarget ProxyNoTarget
+	// ProxyRateLimit, when positive, caps each direction of proxied
+	// connections in bytes per second, unless their binding sets a limit
+	ProxyRateLimit int64
+	// UDPMaxSessions caps the client sessions of the UDP proxy. Zero uses
+	// DefaultUDPMaxSessions.
+	UDPMaxSessions int
+	// MaxOutputBytes caps each output stream captured by /run. Zero uses
+	// DefaultMaxOutputBytes.
+	MaxOutputBytes int64
+	// MaxWatchers caps the /watch streams open at once. Zero uses
+	// DefaultMaxWatchers.
+	MaxWatchers int
+	// MaxUntarBytes caps the uncompressed size of an archive extracted by
+	// /untar. Zero uses DefaultMaxUntarBytes.
+	MaxUntarBytes int64
+	// MaxRequestBytes caps request bodies. Zero uses DefaultMaxRequestBytes.
+	MaxRequestBytes int64
+	// MaxUploadBytes caps the bodies of /upload, /upload/chunk and /untar.
+	// Zero uses DefaultMaxUploadBytes.
+	MaxUploadBytes int64
+	// RateLimit, when set, limits the requests of each client address across
+	// all routes
+	RateLimit RateLimit
+	// RouteRateLimits limits the requests of each client address to a route,
+	// keyed by path such as "/run", on top of RateLimit
+	RouteRateLimits map[string]RateLimit
+	// Shell runs the shell commands of requests that do not pick one. Empty
+	// uses DefaultShell.
+	Shell string
+	// TempDir is where /mktemp creates files and directories, created if
+	// missing. It must be inside Root and is relative to it when relative.
+	// Empty uses os.TempDir(), or Root/.tmp when that is outside Root.
+	TempDir string
+	// IdleTimeout, when positive, closes the channel returned by Idle once
+	// no authenticated request was handled and no byte proxied for that long
+	IdleTimeout time.Duration
+	// Audit, when it has a writer, records the privileged operations: running
+	// commands, writing and deleting files, and killing processes
+	Audit AuditConfig
+}
+
+// processReaperInterval is the longest delay between two reaper passes
+const processReaperInterval = time.Minute
+
 type Server struct {
 	auth           *authState
+	root           string
 	tcpProxy       *TCPProxy
+	udpProxy       *UDPProxy
 	processManager *ProcessManager
+	maxOutputBytes int64
+	watchers       *connLimiter
+	maxUntarBytes  int64
+	startedAt      time.Time
+	// Body limits applied by bodyLimitMiddleware
+	maxRequestBytes int64
+	maxUploadBytes  int64
+	// rateLimiter and routeRateLimiters are nil when no limit is configured
+	rateLimiter       *rateLimiter
+	routeRateLimiters map[string]*rateLimiter
+	// audit is nil when the audit log is disabled
+	audit *auditLogger
+	// shell runs shell commands that do not name one
+	shell string
+	// tempDir holds the entries created by /mktemp
+	tempDir string
+	// pathLocks serializes the operations changing the same path
+	pathLocks pathLocker
+	// activity is nil unless idle shutdown is on. idle is closed once the
+	// executor was idle for the configured timeout; idleStop ends the watch.
+	activity     *activityTracker
+	idle         chan struct{}
+	idleStop     chan struct{}
+	idleStopOnce sync.Once
+	// uploads holds the chunked uploads in progress, by id
+	uploadsMu sync.Mutex
+	uploads   map[string]*chunkedUpload
+	// signedURLs authorizes /download and /upload requests minted by
+	// /sign_url
+	signedURLs *signedURLs
 }
 
-func New(authConfig AuthConfig) (*Server, error) {
-	authState, err := newAuthState(authConfig)
+func New(config Config) (*Server, error) {
+	authState, err := newAuthState(config.Auth)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		auth:           authState,
-		tcpProxy:       NewTCPProxy(),
-		processManager: NewProcessManager(),
-	}, nil
-}
+	root, err := normalizeRoot(config.Root)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *Server) RegisterRoutes() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.healthHandler)
-	mux.Handle("/run", s.authMiddleware(http.HandlerFunc(s.runHandler)))
-	mux.Handle("/run_streaming", s.authMiddleware(http.HandlerFunc(s.runStreamingHandler)))
-	mux.Handle("/write_file", s.authMiddleware(http.HandlerFunc(s.writeFileHandler)))
-	mux.Handle("/read_file", s.authMiddleware(http.HandlerFunc(s.readFileHandler)))
-	mux.Handle("/delete_file", s.authMiddleware(http.HandlerFunc(s.deleteFileHandler)))
-	mux.Handle("/delete_dir", s.authMiddleware(http.HandlerFunc(s.deleteDirHandler)))
-	mux.Handle("/make_dir", s.authMiddleware(http.HandlerFunc(s.makeDirHandler)))
-	mux.Handle("/list_dir", s.authMiddleware(http.HandlerFunc(s.listDirHandler)))
-	mux.Handle("/bind_port", s.authMiddleware(http.HandlerFunc(s.bindPortHandler)))
-	mux.Handle("/unbind_port", s.authMiddleware(http.HandlerFunc(s.unbindPortHandler)))
-	mux.Handle("/start_process", s.authMiddleware(http.HandlerFunc(s.startProcessHandler)))
-	mux.Handle("/list_processes", s.authMiddleware(http.HandlerFunc(s.listProcessesHandler)))
-	mux.Handle("/kill_process", s.authMiddleware(http.HandlerFunc(s.killProcessHandler)))
-	mux.Handle("/process_logs_streaming", s.authMiddleware(http.HandlerFunc(s.processLogsStreamingHandler)))
-	return mux
-}
+	shell := config.Shell
+	if shell == "" {
+		shell = DefaultShell
+	} else if err := validateShell(shell); err != nil {
+		return nil, err
+	}
 
-// TCPProxy handles TCP forwarding to a configured target port
-type TCPProxy struct {
-	mu         sync.RWMutex
-	targetPort string
-	listener   *TCPListener
-}
+	tempDir, err := resolveTempDir(root, config.TempDir)
+	if err != nil {
+		return nil, err
+	}
 
-func NewTCPProxy() *TCPProxy {
-	return &TCPProxy{}
-}
+	processManager := NewProcessManager()
+	processManager.notifySecret = authState.currentSecret
+	if config.ProcessLogDir != "" {
+		if err := os.MkdirAll(config.ProcessLogDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create process log directory: %w", err)
+		}
+		processManager.logDir = config.ProcessLogDir
+	}
+	processManager.StartReaper(config.ProcessTTL, min(config.ProcessTTL, processReaperInterval))
+	processManager.SetMaxProcesses(config.MaxProcesses, config.MaxProcessesMode)
 
-func (p *TCPProxy) SetTargetPort(port string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.targetPort = port
-}
+	tcpProxy := NewTCPProxy()
+	tcpProxy.SetAllowedHosts(config.ProxyAllowedHosts)
+	if config.ProxyDialRetry > 0 {
+		tcpProxy.SetDialRetry(config.ProxyDialRetry)
+	}
+	tcpProxy.SetIdleTimeout(config.ProxyIdleTimeout)
+	tcpProxy.SetMaxConnections(config.ProxyMaxConnections)
+	tcpProxy.SetConnLog(config.ProxyConnLog)
+	tcpProxy.SetNoTarget(config.ProxyNoTarget)
+	tcpProxy.SetRateLimit(config.ProxyRateLimit)
 
-func (p *TCPProxy) GetTargetPort() string {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.targetPort
-}
+	maxOutputBytes := config.MaxOutputBytes
+	if maxOutputBytes <= 0 {
+		maxOutputBytes = DefaultMaxOutputBytes
+	}
 
-func (p *TCPProxy) ClearTargetPort() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.targetPort = ""
-}
+	maxWatchers := config.MaxWatchers
+	if maxWatchers <= 0 {
+		maxWatchers = DefaultMaxWatchers
+	}
 
-func (p *TCPProxy) SetListener(listener *TCPListener) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.listener = listener
-}
+	maxUntarBytes := config.MaxUntarBytes
+	if maxUntarBytes <= 0 {
+		maxUntarBytes = DefaultMaxUntarBytes
+	}
 
-func (p *TCPProxy) GetListener() *TCPListener {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.listener
-}
+	maxRequestBytes := config.MaxRequestBytes
+	if maxRequestBytes <= 0 {
+		maxRequestBytes = DefaultMaxRequestBytes
+	}
 
-func (s *Server) StartTCPProxy(port string) error {
-	listener, err := NewTCPListener(port)
-	if err != nil {
-		return fmt.Errorf("failed to create TCP listener: %w", err)
+	maxUploadBytes := config.MaxUploadBytes
+	if maxUploadBytes <= 0 {
+		maxUploadBytes = DefaultMaxUploadBytes
 	}
 
-	s.tcpProxy.SetListener(listener)
+	var globalLimiter *rateLimiter
+	if config.RateLimit.RequestsPerSecond > 0 {
+		globalLimiter = newRateLimiter(config.RateLimit)
+	}
+	routeLimiters := make(map[string]*rateLimiter)
+	for path, limit := range config.RouteRateLimits {
+		if limit.RequestsPerSecond > 0 {
+			routeLimiters[path] = newRateLimiter(limit)
+		}
+	}
 
-	return listener.Start(func(conn *Connection) {
-		defer conn.Close()
+	signed, err := newSignedURLs()
+	if err != nil {
+		return nil, err
+	}
 
-		targetPort := s.tcpProxy.GetTargetPort()
-		if targetPort == "" {
-			// No target port configured - accept connection and wait briefly
-			// This allows health checks to succeed
-			buf := make([]byte, 1)
-			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-			conn.Read(buf)
-			return
-		}
+	udpProxy := NewUDPProxy()
+	udpProxy.SetMaxSessions(config.UDPMaxSessions)
+	var activity *activityTracker
+	var idle, idleStop chan struct{}
+	if config.IdleTimeout > 0 {
+		activity = newActivityTracker()
+		tcpProxy.activity = activity
+		udpProxy.activity = activity
+		idle, idleStop = make(chan struct{}), make(chan struct{})
+		go activity.watch(config.IdleTimeout, idle, idleStop)
+	}
 
-		// Connect to target port
-		targetConn, err := DialTCP("localhost:" + targetPort)
-		if err != nil {
-			slog.Debug("Failed to connect to target port", "port", targetPort, "error", err)
-			return
-		}
-		defer targetConn.Close()
+	return &Server{
+		auth:           authState,
+		root:           root,
+		tcpProxy:       tcpProxy,
+		udpProxy:       udpProxy,
+		processManager: processManager,
+		maxOutputBytes: maxOutputBytes,
+		watchers:       newConnLimiter(maxWatchers),
+		maxUntarBytes:  maxUntarBytes,
+		startedAt:      time.Now(),
 
-		// Bidirectional copy
-		done := make(chan error, 2)
+		maxRequestBytes: maxRequestBytes,
+		maxUploadBytes:  maxUploadBytes,
 
-		go func() {
-			_, err := io.Copy(targetConn, conn)
-			done <- err
-		}()
+		rateLimiter:       globalLimiter,
+		routeRateLimiters: routeLimiters,
 
-		go func() {
-			_, err := io.Copy(conn, targetConn)
-			done <- err
-		}()
+		audit:   newAuditLogger(config.Audit),
+		shell:   shell,
+		tempDir: tempDir,
+		uploads: make(map[string]*chunkedUpload),
 
-		// Wait for either direction to complete
-		<-done
-	})
+		signedURLs: signed,
+
+		activity: activity,
+		idle:     idle,
+		idleStop: idleStop,
+	}, nil
 }
 
-func (s *Server) StopTCPProxy() {
-	if listener := s.tcpProxy.GetListener(); listener != nil {
-		listener.Stop()
+// Shutdown stops the TCP and UDP proxies and drains the background
+// processes: they get SIGTERM and until ctx is done to exit before being
+// killed. It returns ctx's error if any process had to be killed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.idleStop != nil {
+		s.idleStopOnce.Do(func() { close(s.idleStop) })
 	}
+	s.StopTCPProxy()
+	s.StopUDPProxy()
+
+	terminated, killed := s.processManager.Shutdown(ctx)
+	slog.Info("Background processes stopped", "terminated", terminated, "killed", killed)
+	if killed > 0 {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// RegisterRoutes returns the handler serving the API. Every request is tagged
+// with a request id, see RequestIDFromContext, checked against the configured
+// rate limits and has its body size capped. Privileged routes are audited.
+func (s *Server) RegisterRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.healthHandler)
+	mux.HandleFunc("/ready", s.readyHandler)
+	mux.Handle("/run", s.auditMiddleware("run", s.authMiddleware(http.HandlerFunc(s.runHandler))))
+	mux.Handle("/run_streaming", s.auditMiddleware("run_streaming", s.authMiddleware(http.HandlerFunc(s.runStreamingHandler))))
+	mux.Handle("/run_ws", s.auditMiddleware("run_ws", s.authMiddleware(http.HandlerFunc(s.runWebSocketHandler))))
+	mux.Handle("/exec/interactive", s.auditMiddleware("exec_interactive", s.authMiddleware(http.HandlerFunc(s.execInteractiveHandler))))
+	mux.Handle("/write_file", s.auditMiddleware("write_file", s.authMiddleware(http.HandlerFunc(s.writeFileHandler))))
+	mux.Handle("/upload", s.auditMiddleware("upload", s.signedURLMiddleware(SignedURLUpload, http.HandlerFunc(s.uploadHandler))))
+	mux.Handle("/upload/init", s.auditMiddleware("upload", s.authMiddleware(http.HandlerFunc(s.uploadInitHandler))))
+	mux.Handle("/upload/chunk", s.auditMiddleware("upload", s.authMiddleware(http.HandlerFunc(s.uploadChunkHandler))))
+	mux.Handle("/upload/status", s.authMiddleware(http.HandlerFunc(s.uploadStatusHandler)))
+	mux.Handle("/upload/complete", s.auditMiddleware("upload", s.authMiddleware(http.HandlerFunc(s.uploadCompleteHandler))))
+	mux.Handle("/read_file", s.authMiddleware(http.HandlerFunc(s.readFileHandler)))
+	mux.Handle("/download", s.signedURLMiddleware(SignedURLDownload, http.HandlerFunc(s.downloadHandler)))
+	mux.Handle("/sign_url", s.auditMiddleware("sign_url", s.authMiddleware(http.HandlerFunc(s.signURLHandler))))
+	mux.Handle("/delete_file", s.auditMiddleware("delete_file", s.authMiddleware(http.HandlerFunc(s.deleteFileHandler))))
+	mux.Handle("/delete_dir", s.auditMiddleware("delete_dir", s.authMiddleware(http.HandlerFunc(s.deleteDirHandler))))
+	mux.Handle("/make_dir", s.auditMiddleware("make_dir", s.authMiddleware(http.HandlerFunc(s.makeDirHandler))))
+	mux.Handle("/touch", s.auditMiddleware("touch", s.authMiddleware(http.HandlerFunc(s.touchHandler))))
+	mux.Handle("/mktemp", s.auditMiddleware("mktemp", s.authMiddleware(http.HandlerFunc(s.mktempHandler))))
+	mux.Handle("/truncate", s.auditMiddleware("truncate", s.authMiddleware(http.HandlerFunc(s.truncateHandler))))
+	mux.Handle("/stat", s.authMiddleware(http.HandlerFunc(s.statHandler)))
+	mux.Handle("/symlink", s.auditMiddleware("symlink", s.authMiddleware(http.HandlerFunc(s.symlinkHandler))))
+	mux.Handle("/readlink", s.authMiddleware(http.HandlerFunc(s.readlinkHandler)))
+	mux.Handle("/batch", s.authMiddleware(http.HandlerFunc(s.batchHandler)))
+	mux.Handle("/list_dir", s.authMiddleware(http.HandlerFunc(s.listDirHandler)))
+	mux.Handle("/move", s.auditMiddleware("move", s.authMiddleware(http.HandlerFunc(s.moveHandler))))
+	mux.Handle("/copy_stream", s.auditMiddleware("copy_stream", s.authMiddleware(http.HandlerFunc(s.copyStreamHandler))))
+	mux.Handle("/search", s.authMiddleware(http.HandlerFunc(s.searchHandler)))
+	mux.Handle("/glob", s.authMiddleware(http.HandlerFunc(s.globHandler)))
+	mux.Handle("/watch", s.authMiddleware(http.HandlerFunc(s.watchHandler)))
+	mux.Handle("/tail", s.authMiddleware(http.HandlerFunc(s.tailHandler)))
+	mux.Handle("/checksum", s.authMiddleware(http.HandlerFunc(s.checksumHandler)))
+	mux.Handle("/disk_usage", s.authMiddleware(http.HandlerFunc(s.diskUsageHandler)))
+	mux.Handle("/tar", s.authMiddleware(http.HandlerFunc(s.tarHandler)))
+	mux.Handle("/untar", s.auditMiddleware("untar", s.authMiddleware(http.HandlerFunc(s.untarHandler))))
+	mux.Handle("/bind_port", s.authMiddleware(http.HandlerFunc(s.bindPortHandler)))
+	mux.Handle("/unbind_port", s.authMiddleware(http.HandlerFunc(s.unbindPortHandler)))
+	mux.Handle("/bind_udp", s.authMiddleware(http.HandlerFunc(s.bindUDPHandler)))
+	mux.Handle("/unbind_udp", s.authMiddleware(http.HandlerFunc(s.unbindUDPHandler)))
+	mux.Handle("/env", s.authMiddleware(http.HandlerFunc(s.envHandler)))
+	mux.Handle("/setenv", s.auditMiddleware("setenv", s.authMiddleware(http.HandlerFunc(s.setEnvHandler))))
+	mux.Handle("/proxy_stats", s.authMiddleware(http.HandlerFunc(s.proxyStatsHandler)))
+	mux.Handle("/proxy/probe", s.authMiddleware(http.HandlerFunc(s.proxyProbeHandler)))
+	mux.Handle("/start_process", s.auditMiddleware("start_process", s.authMiddleware(http.HandlerFunc(s.startProcessHandler))))
+	mux.Handle("/list_processes", s.authMiddleware(http.HandlerFunc(s.listProcessesHandler)))
+	mux.Handle("/get_process", s.authMiddleware(http.HandlerFunc(s.getProcessHandler)))
+	mux.Handle("/kill_process", s.auditMiddleware("kill_process", s.authMiddleware(http.HandlerFunc(s.killProcessHandler))))
+	mux.Handle("/process_stats", s.authMiddleware(http.HandlerFunc(s.processStatsHandler)))
+	mux.Handle("/processes/tree", s.authMiddleware(http.HandlerFunc(s.processTreeHandler)))
+	mux.Handle("/wait_process", s.authMiddleware(http.HandlerFunc(s.waitProcessHandler)))
+	mux.Handle("/signal_process", s.auditMiddleware("signal_process", s.authMiddleware(http.HandlerFunc(s.signalProcessHandler))))
+	mux.Handle("/terminate_process", s.auditMiddleware("terminate_process", s.authMiddleware(http.HandlerFunc(s.terminateProcessHandler))))
+	mux.Handle("/kill_all_processes", s.auditMiddleware("kill_all_processes", s.authMiddleware(http.HandlerFunc(s.killAllProcessesHandler))))
+	mux.Handle("/restart_process", s.auditMiddleware("restart_process", s.authMiddleware(http.HandlerFunc(s.restartProcessHandler))))
+	mux.Handle("/remove_process", s.auditMiddleware("remove_process", s.authMiddleware(http.HandlerFunc(s.removeProcessHandler))))
+	mux.Handle("/processes/prune", s.auditMiddleware("prune_processes", s.authMiddleware(http.HandlerFunc(s.pruneProcessesHandler))))
+	mux.Handle("/process_logs", s.authMiddleware(http.HandlerFunc(s.processLogsHandler)))
+	mux.Handle("/process_logs_download", s.authMiddleware(http.HandlerFunc(s.processLogsDownloadHandler)))
+	mux.Handle("/process_logs_streaming", s.authMiddleware(http.HandlerFunc(s.processLogsStreamingHandler)))
+	return requestIDMiddleware(s.rateLimitMiddleware(s.bodyLimitMiddleware(mux)))
 }
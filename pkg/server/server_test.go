@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRegisterRoutesExposesAPI verifies every API route is wired up behind the
+// auth middleware: reachable with a valid token, rejected without one.
+func TestRegisterRoutesExposesAPI(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/run"},
+		{http.MethodPost, "/run_streaming"},
+		{http.MethodGet, "/run_ws"},
+		{http.MethodPost, "/exec/interactive"},
+		{http.MethodPost, "/write_file"},
+		{http.MethodPost, "/upload"},
+		{http.MethodPost, "/upload/init"},
+		{http.MethodPost, "/upload/chunk"},
+		{http.MethodGet, "/upload/status"},
+		{http.MethodPost, "/upload/complete"},
+		{http.MethodPost, "/read_file"},
+		{http.MethodGet, "/download"},
+		{http.MethodPost, "/sign_url"},
+		{http.MethodPost, "/delete_file"},
+		{http.MethodPost, "/delete_dir"},
+		{http.MethodPost, "/make_dir"},
+		{http.MethodPost, "/touch"},
+		{http.MethodPost, "/mktemp"},
+		{http.MethodPost, "/truncate"},
+		{http.MethodPost, "/stat"},
+		{http.MethodPost, "/symlink"},
+		{http.MethodPost, "/readlink"},
+		{http.MethodPost, "/batch"},
+		{http.MethodPost, "/list_dir"},
+		{http.MethodPost, "/move"},
+		{http.MethodPost, "/copy_stream"},
+		{http.MethodPost, "/search"},
+		{http.MethodPost, "/glob"},
+		{http.MethodGet, "/watch"},
+		{http.MethodGet, "/tail"},
+		{http.MethodPost, "/checksum"},
+		{http.MethodPost, "/disk_usage"},
+		{http.MethodGet, "/tar"},
+		{http.MethodPost, "/untar"},
+		{http.MethodPost, "/bind_port"},
+		{http.MethodPost, "/unbind_port"},
+		{http.MethodPost, "/bind_udp"},
+		{http.MethodPost, "/unbind_udp"},
+		{http.MethodGet, "/proxy_stats"},
+		{http.MethodGet, "/proxy/probe"},
+		{http.MethodGet, "/env"},
+		{http.MethodPost, "/setenv"},
+		{http.MethodPost, "/start_process"},
+		{http.MethodGet, "/list_processes"},
+		{http.MethodGet, "/get_process"},
+		{http.MethodPost, "/kill_process"},
+		{http.MethodGet, "/process_stats"},
+		{http.MethodGet, "/processes/tree"},
+		{http.MethodPost, "/wait_process"},
+		{http.MethodPost, "/signal_process"},
+		{http.MethodPost, "/terminate_process"},
+		{http.MethodPost, "/kill_all_processes"},
+		{http.MethodPost, "/restart_process"},
+		{http.MethodPost, "/remove_process"},
+		{http.MethodPost, "/processes/prune"},
+		{http.MethodGet, "/process_logs"},
+		{http.MethodGet, "/process_logs_download"},
+		{http.MethodGet, "/process_logs_streaming"},
+	}
+
+	for _, route := range routes {
+		t.Run(route.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newAuthRequest(route.method, route.path, nil))
+			if w.Code == http.StatusNotFound {
+				t.Fatalf("expected %s %s to be registered, got 404: %s", route.method, route.path, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized {
+				t.Fatalf("expected %s %s to accept a valid token, got 401", route.method, route.path)
+			}
+
+			unauthenticated := httptest.NewRecorder()
+			mux.ServeHTTP(unauthenticated, httptest.NewRequest(route.method, route.path, nil))
+			if unauthenticated.Code != http.StatusUnauthorized {
+				t.Fatalf("expected %s %s to require auth, got %d", route.method, route.path, unauthenticated.Code)
+			}
+		})
+	}
+}
+
+func checkHealth(t *testing.T, mux http.Handler, path string) (int, HealthResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var resp HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode %s response: %v", path, err)
+	}
+	return w.Code, resp
+}
+
+func TestHealthAndReadyWithProxyListening(t *testing.T) {
+	srv, mux := newTestServer(t)
+	if err := srv.StartTCPProxy("0"); err != nil {
+		t.Fatalf("failed to start TCP proxy: %v", err)
+	}
+	t.Cleanup(srv.StopTCPProxy)
+
+	running, err := srv.processManager.StartProcess("sleep 10", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer srv.processManager.KillProcess(running.ID)
+
+	code, health := checkHealth(t, mux, "/health")
+	if code != http.StatusOK || health.Status != "ok" || !health.TCPProxyListening {
+		t.Errorf("expected a healthy 200, got %d: %+v", code, health)
+	}
+	if health.RunningProcesses != 1 || health.UptimeSeconds <= 0 {
+		t.Errorf("expected 1 running process and a positive uptime, got %+v", health)
+	}
+
+	if code, _ := checkHealth(t, mux, "/ready"); code != http.StatusOK {
+		t.Errorf("expected /ready to answer 200, got %d", code)
+	}
+}
+
+func TestHealthAndReadyWithProxyDown(t *testing.T) {
+	srv, mux := newTestServer(t)
+
+	code, health := checkHealth(t, mux, "/health")
+	if code != http.StatusOK || health.Status != "degraded" || health.TCPProxyListening {
+		t.Errorf("expected a degraded 200 before the proxy starts, got %d: %+v", code, health)
+	}
+	if code, _ := checkHealth(t, mux, "/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /ready to answer 503 before the proxy starts, got %d", code)
+	}
+
+	// Stopping the proxy makes the server unready again
+	if err := srv.StartTCPProxy("0"); err != nil {
+		t.Fatalf("failed to start TCP proxy: %v", err)
+	}
+	srv.StopTCPProxy()
+	if code, _ := checkHealth(t, mux, "/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /ready to answer 503 after the proxy stopped, got %d", code)
+	}
+}
+
+func TestShutdownDrainsProcesses(t *testing.T) {
+	srv, _ := newTestServer(t)
+	pm := srv.processManager
+
+	graceful, err := pm.StartProcess("trap 'echo got-term; exit 0' TERM; echo ready; while :; do sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	stubborn, err := pm.StartProcess("trap '' TERM; echo ready; while :; do sleep 0.05; done", "", nil)
+	if err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	// Wait for the traps to be installed
+	for _, process := range []*Process{graceful, stubborn} {
+		deadline := time.Now().Add(2 * time.Second)
+		for len(process.stdout.GetAll()) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("process did not become ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline error since a process was killed, got %v", err)
+	}
+
+	graceful.mu.RLock()
+	status, exitCode := graceful.Status, graceful.ExitCode
+	graceful.mu.RUnlock()
+	if status != ProcessStatusCompleted || exitCode == nil || *exitCode != 0 {
+		t.Errorf("expected the trapping process to exit cleanly, got %s", status)
+	}
+	var gotTerm bool
+	for _, entry := range graceful.stdout.GetAll() {
+		gotTerm = gotTerm || entry.Data == "got-term"
+	}
+	if !gotTerm {
+		t.Error("expected the trapping process to receive SIGTERM")
+	}
+
+	stubborn.mu.RLock()
+	defer stubborn.mu.RUnlock()
+	if stubborn.Status != ProcessStatusKilled {
+		t.Errorf("expected the process ignoring SIGTERM to be killed, got %s", stubborn.Status)
+	}
+}
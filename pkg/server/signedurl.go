@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operations a signed URL can grant, named after the route they call
+const (
+	SignedURLDownload = "download"
+	SignedURLUpload   = "upload"
+)
+
+const (
+	// DefaultSignedURLLifetime is how long a signed URL is valid when the
+	// request does not say
+	DefaultSignedURLLifetime = 5 * time.Minute
+	// MaxSignedURLLifetime bounds the lifetime a client can ask for
+	MaxSignedURLLifetime = 24 * time.Hour
+)
+
+var (
+	errSignedURLInvalid = errors.New("invalid signed URL")
+	errSignedURLExpired = errors.New("signed URL has expired")
+	errSignedURLUsed    = errors.New("signed URL was already used")
+)
+
+// signedURLs mints and checks URLs granting a single download or upload of
+// one path without the bearer token, e.g. for a browser. They are signed
+// with a key drawn at startup, so they do not survive a restart, and each
+// one is accepted once: its nonce is remembered until it expires.
+type signedURLs struct {
+	key []byte
+
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+func newSignedURLs() (*signedURLs, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate the URL signing key: %w", err)
+	}
+	return &signedURLs{key: key, used: make(map[string]int64)}, nil
+}
+
+// sign returns the signature of a URL for operation on path
+func (u *signedURLs) sign(operation, path string, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, u.key)
+	// Fields are separated by a byte paths cannot hold
+	fmt.Fprintf(mac, "%s\x00%s\x00%d\x00%s", operation, path, expires, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// mint returns the URL, relative to the API, granting operation on path
+// until expiresAt
+func (u *signedURLs) mint(operation, path string, expiresAt time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"path":    {path},
+		"expires": {strconv.FormatInt(expiresAt.Unix(), 10)},
+		"nonce":   {hex.EncodeToString(nonce)},
+	}
+	query.Set("signature", u.sign(operation, path, expiresAt.Unix(), query.Get("nonce")))
+	return "/" + operation + "?" + query.Encode(), nil
+}
+
+// consume checks that query carries a valid signature for operation that has
+// neither expired nor been used, and marks it used
+func (u *signedURLs) consume(operation string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	nonce := query.Get("nonce")
+	if err != nil || nonce == "" {
+		return errSignedURLInvalid
+	}
+	want := u.sign(operation, query.Get("path"), expires, nonce)
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(want)) {
+		return errSignedURLInvalid
+	}
+	if now.Unix() >= expires {
+		return errSignedURLExpired
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for used, usedExpires := range u.used {
+		if now.Unix() >= usedExpires {
+			delete(u.used, used)
+		}
+	}
+	if _, ok := u.used[nonce]; ok {
+		return errSignedURLUsed
+	}
+	u.used[nonce] = expires
+	return nil
+}
+
+type signedPathKey struct{}
+
+// signedURLPath returns the path a signed URL granted the request, if it was
+// authorized by one
+func signedURLPath(ctx context.Context) (string, bool) {
+	path, ok := ctx.Value(signedPathKey{}).(string)
+	return path, ok
+}
+
+// signedURLMiddleware lets requests carrying a signature for operation
+// through without the bearer token, once. Other requests go through
+// authMiddleware.
+func (s *Server) signedURLMiddleware(operation string, next http.Handler) http.Handler {
+	authenticated := s.authMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has("signature") {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+
+		if err := s.signedURLs.consume(operation, query, time.Now()); err != nil {
+			slog.DebugContext(r.Context(), "Rejected signed URL", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+			return
+		}
+
+		slog.DebugContext(r.Context(), "Authorized request by signed URL", "path", r.URL.Path, "target", query.Get("path"))
+		s.activity.begin()
+		defer s.activity.end()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedPathKey{}, query.Get("path"))))
+	})
+}
+
+type SignURLRequest struct {
+	// Operation is SignedURLDownload or SignedURLUpload
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	// ExpiresIn is the lifetime of the URL in seconds, see
+	// DefaultSignedURLLifetime and MaxSignedURLLifetime
+	ExpiresIn int `json:"expires_in,omitempty"`
+}
+
+type SignURLResponse struct {
+	// URL is relative to the API, e.g. "/download?path=...&signature=..."
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signURLHandler mints a signed URL for /download or /upload
+func (s *Server) signURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req SignURLRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditTarget(r.Context(), req.Path)
+
+	if req.Operation != SignedURLDownload && req.Operation != SignedURLUpload {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("operation must be %q or %q", SignedURLDownload, SignedURLUpload))
+		return
+	}
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	if _, ok := s.sandboxPath(w, req.Path); !ok {
+		return
+	}
+	lifetime := DefaultSignedURLLifetime
+	if req.ExpiresIn != 0 {
+		maxSeconds := int(MaxSignedURLLifetime / time.Second)
+		if req.ExpiresIn < 0 || req.ExpiresIn > maxSeconds {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("expires_in must be between 1 and %d seconds", maxSeconds))
+			return
+		}
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	expiresAt := time.Now().Add(lifetime).Truncate(time.Second)
+	signed, err := s.signedURLs.mint(req.Operation, req.Path, expiresAt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to sign URL")
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Signed URL", "operation", req.Operation, "path", req.Path, "expires_at", expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SignURLResponse{URL: signed, ExpiresAt: expiresAt})
+}
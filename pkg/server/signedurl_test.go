@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signURL(t *testing.T, mux http.Handler, req SignURLRequest) SignURLResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/sign_url", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp SignURLResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// unauthenticatedRequest sends a request without the bearer token, as a
+// browser following a signed URL would
+func unauthenticatedRequest(mux http.Handler, method, target string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(body)))
+	return w
+}
+
+func TestSignedDownloadURL(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "report.txt")
+	os.WriteFile(path, []byte("quarterly numbers"), 0o644)
+
+	signed := signURL(t, mux, SignURLRequest{Operation: SignedURLDownload, Path: path, ExpiresIn: 60})
+	if until := time.Until(signed.ExpiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("expected the URL to expire within a minute, got %v", signed.ExpiresAt)
+	}
+
+	w := unauthenticatedRequest(mux, http.MethodGet, signed.URL, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "quarterly numbers" {
+		t.Fatalf("expected the signed URL to download the file, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The URL works once
+	w = unauthenticatedRequest(mux, http.MethodGet, signed.URL, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a used URL to be rejected, got %d", w.Code)
+	}
+
+	// Requests without a signature still need the token
+	w = unauthenticatedRequest(mux, http.MethodGet, "/download?path="+url.QueryEscape(path), nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a signature, got %d", w.Code)
+	}
+}
+
+func TestSignedUploadURL(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "upload.bin")
+
+	signed := signURL(t, mux, SignURLRequest{Operation: SignedURLUpload, Path: path})
+	w := unauthenticatedRequest(mux, http.MethodPut, signed.URL, []byte("browser upload"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the signed URL to upload, got %d: %s", w.Code, w.Body.String())
+	}
+	if content, _ := os.ReadFile(path); string(content) != "browser upload" {
+		t.Errorf("expected the uploaded content, got %q", content)
+	}
+
+	// A download URL does not grant an upload to the same path
+	signed = signURL(t, mux, SignURLRequest{Operation: SignedURLDownload, Path: path})
+	parsed, _ := url.Parse(signed.URL)
+	w = unauthenticatedRequest(mux, http.MethodPut, "/upload?"+parsed.RawQuery, []byte("overwrite"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a download URL to be rejected for an upload, got %d", w.Code)
+	}
+}
+
+func TestSignedURLRejectsExpiredAndTampered(t *testing.T) {
+	srv, mux := newTestServer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "public.txt")
+	secret := filepath.Join(dir, "secret.txt")
+	os.WriteFile(path, []byte("public"), 0o644)
+	os.WriteFile(secret, []byte("secret"), 0o644)
+
+	// Expired: minted directly since the API does not issue URLs in the past
+	expired, _ := srv.signedURLs.mint(SignedURLDownload, path, time.Now().Add(-time.Second))
+	w := unauthenticatedRequest(mux, http.MethodGet, expired, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an expired URL to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	signed := signURL(t, mux, SignURLRequest{Operation: SignedURLDownload, Path: path})
+	parsed, _ := url.Parse(signed.URL)
+	tamper := func(key, value string) string {
+		query := parsed.Query()
+		query.Set(key, value)
+		return parsed.Path + "?" + query.Encode()
+	}
+	for name, target := range map[string]string{
+		"path":      tamper("path", secret),
+		"expiry":    tamper("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)),
+		"signature": tamper("signature", strings.Repeat("0", 64)),
+	} {
+		w := unauthenticatedRequest(mux, http.MethodGet, target, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("tampered %s: expected 401, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	// Rejected attempts do not use the URL up
+	w = unauthenticatedRequest(mux, http.MethodGet, signed.URL, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "public" {
+		t.Errorf("expected the untampered URL to work, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSignURLValidation(t *testing.T) {
+	_, mux := newTestServerWithConfig(t, Config{Root: t.TempDir()})
+
+	tests := []struct {
+		name   string
+		req    SignURLRequest
+		status int
+	}{
+		{"unknown operation", SignURLRequest{Operation: "delete", Path: "file.txt"}, http.StatusBadRequest},
+		{"missing path", SignURLRequest{Operation: SignedURLDownload}, http.StatusBadRequest},
+		{"outside the root", SignURLRequest{Operation: SignedURLDownload, Path: "/etc/passwd"}, http.StatusForbidden},
+		{"too long", SignURLRequest{Operation: SignedURLUpload, Path: "file.txt", ExpiresIn: 2 * 24 * 3600}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(tt.req)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/sign_url", body))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTailLines is how many existing lines /tail sends before following
+	DefaultTailLines = 10
+	// maxTailLines caps the lines parameter of /tail
+	maxTailLines = 10000
+	// maxTailLineBytes is the longest line /tail buffers; longer lines are
+	// sent in pieces of this size
+	maxTailLineBytes = 64 * 1024
+	// maxTailHistoryBytes bounds how far back /tail reads for existing lines
+	maxTailHistoryBytes = 8 << 20
+)
+
+// tailPollInterval is how often /tail checks the file for new content,
+// truncation and rotation. Swappable in tests.
+var tailPollInterval = 250 * time.Millisecond
+
+// TailLine is sent for every line of a tailed file
+type TailLine struct {
+	Line string `json:"line"`
+}
+
+// lastLines returns up to n complete lines ending before end, and the offset
+// just past the last of them. A trailing line without a newline is left for
+// the caller to follow, so it is only sent once complete.
+func lastLines(file *os.File, end int64, n int) ([]string, int64, error) {
+	const chunk = 32 * 1024
+
+	var buf []byte
+	pos := end
+	// Read back until the window holds n+1 newlines, so the first line of it,
+	// which may be cut, can be dropped
+	for pos > 0 && bytes.Count(buf, []byte{'\n'}) <= n && len(buf) < maxTailHistoryBytes {
+		size := min(chunk, pos)
+		pos -= size
+		block := make([]byte, size)
+		if _, err := file.ReadAt(block, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(block, buf...)
+	}
+
+	complete := bytes.LastIndexByte(buf, '\n') + 1
+	follow := pos + int64(complete)
+	if complete == 0 || n == 0 {
+		return nil, follow, nil
+	}
+
+	lines := strings.Split(string(buf[:complete-1]), "\n")
+	if pos > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, follow, nil
+}
+
+// fileTailer follows a file by path the way tail -F does: it reads appended
+// content, restarts from the beginning when the file is truncated, and
+// reopens the path when the file is replaced or removed
+type fileTailer struct {
+	path    string
+	file    *os.File
+	offset  int64
+	partial []byte
+}
+
+// open opens the file at the path if it exists, positioned at its start
+func (t *fileTailer) open() (bool, error) {
+	file, err := os.Open(t.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	t.file, t.offset, t.partial = file, 0, nil
+	return true, nil
+}
+
+func (t *fileTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// readLines reads the content appended since the last call and returns the
+// complete lines in it
+func (t *fileTailer) readLines() ([]string, error) {
+	buf := make([]byte, 32*1024)
+	var lines []string
+	for {
+		n, err := t.file.ReadAt(buf, t.offset)
+		t.offset += int64(n)
+		t.partial = append(t.partial, buf[:n]...)
+		for {
+			i := bytes.IndexByte(t.partial, '\n')
+			if i < 0 {
+				break
+			}
+			lines = append(lines, string(t.partial[:i]))
+			t.partial = t.partial[i+1:]
+		}
+		for len(t.partial) >= maxTailLineBytes {
+			lines = append(lines, string(t.partial[:maxTailLineBytes]))
+			t.partial = t.partial[maxTailLineBytes:]
+		}
+		if err == io.EOF || n == 0 {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// check compares the open file with the one at the path. It reports
+// "truncated" after rewinding a file that shrank, "rotated" when the file was
+// replaced or removed, and "" otherwise
+func (t *fileTailer) check() (string, error) {
+	current, err := os.Stat(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	opened, err := t.file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if current == nil || !os.SameFile(current, opened) {
+		return "rotated", nil
+	}
+	if current.Size() < t.offset {
+		t.offset, t.partial = 0, nil
+		return "truncated", nil
+	}
+	return "", nil
+}
+
+// tailHandler streams the last lines of a file, then the lines appended to
+// it, as Server-Sent Events
+func (s *Server) tailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("path") == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	lines := DefaultTailLines
+	if value := query.Get("lines"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > maxTailLines {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("lines must be between 0 and %d", maxTailLines))
+			return
+		}
+		lines = parsed
+	}
+
+	path, ok := s.sandboxPath(w, query.Get("path"))
+	if !ok {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Path is a directory: %s", query.Get("path")))
+		return
+	}
+
+	tailer := &fileTailer{path: path}
+	defer tailer.close()
+	exists, err := tailer.open()
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to open file for tail", "path", path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	var history []string
+	if exists {
+		info, err := tailer.file.Stat()
+		if err == nil {
+			history, tailer.offset, err = lastLines(tailer.file, info.Size(), lines)
+		}
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to read end of file for tail", "path", path, "error", err)
+			writeFileError(w, err)
+			return
+		}
+	}
+
+	slog.DebugContext(r.Context(), "Tailing file", "path", path, "lines", lines, "exists", exists)
+
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writer, err := newSSEWriter(w)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create SSE writer for tail", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			data, _ := json.Marshal(TailLine{Line: line})
+			writer.writeSequencedEvent("line", string(data))
+		}
+	}
+	writeErrorEvent := func(err error) {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		writer.writeEvent("error", string(data))
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"path": path, "exists": exists})
+	writer.writeEvent("ready", string(data))
+	writeLines(history)
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			slog.DebugContext(r.Context(), "Tail client disconnected", "path", path)
+			return
+		case <-ticker.C:
+		}
+
+		if tailer.file == nil {
+			// Waiting for the file to appear, or to be recreated after a
+			// rotation: a new file is read from the start
+			if opened, err := tailer.open(); err != nil {
+				writeErrorEvent(err)
+				return
+			} else if !opened {
+				continue
+			}
+		}
+
+		lines, err := tailer.readLines()
+		writeLines(lines)
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to read tailed file", "path", path, "error", err)
+			writeErrorEvent(err)
+			return
+		}
+
+		reason, err := tailer.check()
+		if err != nil {
+			slog.DebugContext(r.Context(), "Failed to check tailed file", "path", path, "error", err)
+			writeErrorEvent(err)
+			return
+		}
+		if reason == "rotated" {
+			// Send what was written to the old file since the last read,
+			// including an unterminated last line
+			lines, _ := tailer.readLines()
+			if len(tailer.partial) > 0 {
+				lines = append(lines, string(tailer.partial))
+			}
+			writeLines(lines)
+			tailer.close()
+		}
+		if reason != "" {
+			slog.DebugContext(r.Context(), "Tailed file reset", "path", path, "reason", reason)
+			data, _ := json.Marshal(map[string]string{"reason": reason})
+			writer.writeEvent("reset", string(data))
+		}
+	}
+}
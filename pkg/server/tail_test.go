@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTail starts a /tail stream and waits for its ready event
+func openTail(t *testing.T, query url.Values) *bufio.Reader {
+	t.Helper()
+
+	previous := tailPollInterval
+	tailPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { tailPollInterval = previous })
+
+	_, mux := newTestServer(t)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/tail?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open tail: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event, _, err := readSSEEvent(reader); err != nil || event != "ready" {
+		t.Fatalf("expected a ready event, got %q: %v", event, err)
+	}
+	return reader
+}
+
+// readTailLines reads line events, skipping resets, until n lines arrived
+func readTailLines(t *testing.T, reader *bufio.Reader, n int) []string {
+	t.Helper()
+
+	result := make(chan []string, 1)
+	go func() {
+		var lines []string
+		for len(lines) < n {
+			event, data, err := readSSEEvent(reader)
+			if err != nil {
+				break
+			}
+			var line TailLine
+			if event == "line" && json.Unmarshal([]byte(data), &line) == nil {
+				lines = append(lines, line.Line)
+			}
+		}
+		result <- lines
+	}()
+
+	select {
+	case lines := <-result:
+		return lines
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %d lines", n)
+		return nil
+	}
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, line := range lines {
+		fmt.Fprintln(file, line)
+	}
+}
+
+func TestTailStreamsLastLinesThenAppended(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	for i := 1; i <= 20; i++ {
+		appendLines(t, path, fmt.Sprintf("line %d", i))
+	}
+
+	reader := openTail(t, url.Values{"path": {path}, "lines": {"3"}})
+	if got := readTailLines(t, reader, 3); strings.Join(got, ",") != "line 18,line 19,line 20" {
+		t.Fatalf("expected the last 3 lines, got %q", got)
+	}
+
+	appendLines(t, path, "line 21", "line 22")
+	appendLines(t, path, "line 23")
+	if got := readTailLines(t, reader, 3); strings.Join(got, ",") != "line 21,line 22,line 23" {
+		t.Errorf("expected the appended lines in order, got %q", got)
+	}
+}
+
+func TestTailWaitsForFileAndFollowsRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	reader := openTail(t, url.Values{"path": {path}})
+	appendLines(t, path, "first")
+	if got := readTailLines(t, reader, 1); len(got) != 1 || got[0] != "first" {
+		t.Fatalf("expected the line of the new file, got %q", got)
+	}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "rotated")
+	if got := readTailLines(t, reader, 1); len(got) != 1 || got[0] != "rotated" {
+		t.Fatalf("expected the line of the rotated file, got %q", got)
+	}
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendLines(t, path, "after truncate")
+	if got := readTailLines(t, reader, 1); len(got) != 1 || got[0] != "after truncate" {
+		t.Errorf("expected the line written after truncation, got %q", got)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []byte("a\nb\nc\npartial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines, follow, err := lastLines(file, 13, 2)
+	if err != nil || strings.Join(lines, ",") != "b,c" || follow != 6 {
+		t.Errorf("expected b,c following from 6, got %q from %d (err=%v)", lines, follow, err)
+	}
+	lines, follow, _ = lastLines(file, 13, 10)
+	if strings.Join(lines, ",") != "a,b,c" || follow != 6 {
+		t.Errorf("expected every complete line, got %q from %d", lines, follow)
+	}
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// DefaultMaxUntarBytes caps the uncompressed size of an archive extracted by
+// /untar
+const DefaultMaxUntarBytes = 1 << 30
+
+var (
+	// errUnsafeTarEntry rejects entries that would be written outside the
+	// destination directory
+	errUnsafeTarEntry = errors.New("archive entry escapes the destination")
+	// errUntarTooLarge stops an extraction at the uncompressed size cap
+	errUntarTooLarge = errors.New("archive exceeds the uncompressed size limit")
+)
+
+type UntarResponse struct {
+	Success bool   `json:"success"`
+	Path    string `json:"path"`
+	// Entries is the number of files, directories and symlinks extracted
+	Entries int `json:"entries"`
+	// Bytes is the uncompressed size of the extracted files
+	Bytes int64 `json:"bytes"`
+}
+
+// excluded reports whether the slash-separated relative path matches one of
+// the exclude patterns
+func excluded(rel string, excludes []string) bool {
+	for _, pattern := range excludes {
+		if match, _ := doublestar.Match(pattern, rel); match {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTar writes the tree under root to tw with paths relative to root.
+// Symlinks are stored as links rather than followed, sockets, devices and
+// pipes are skipped, and so are paths matching one of excludes.
+func writeTar(ctx context.Context, tw *tar.Writer, root string, excludes []string) (int, error) {
+	entries := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			slog.DebugContext(ctx, "Skipping unreadable path in tar", "path", path, "error", err)
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if excluded(rel, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			slog.DebugContext(ctx, "Skipping unreadable path in tar", "path", path, "error", err)
+			return nil
+		}
+		mode := info.Mode()
+		if !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		var link string
+		if mode&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				slog.DebugContext(ctx, "Skipping unreadable symlink in tar", "path", path, "error", err)
+				return nil
+			}
+		}
+
+		// Open regular files before writing their header so an unreadable
+		// file is skipped rather than leaving a truncated entry
+		var file *os.File
+		if mode.IsRegular() {
+			if file, err = os.Open(path); err != nil {
+				slog.DebugContext(ctx, "Skipping unreadable file in tar", "path", path, "error", err)
+				return nil
+			}
+			defer file.Close()
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if mode.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if file != nil {
+			if _, err := io.CopyN(tw, file, header.Size); err != nil {
+				return fmt.Errorf("failed to archive %s: %w", rel, err)
+			}
+		}
+		entries++
+		return nil
+	})
+	return entries, err
+}
+
+// tarHandler streams a directory as a gzip-compressed tar archive
+func (s *Server) tarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("path") == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	excludes := query["exclude"]
+	for _, pattern := range excludes {
+		if !doublestar.ValidatePattern(pattern) {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid exclude pattern: %s", pattern))
+			return
+		}
+	}
+
+	root, ok := s.sandboxPath(w, query.Get("path"))
+	if !ok {
+		return
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, "Directory not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to stat directory: %v", err))
+		}
+		return
+	}
+	if !info.IsDir() {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Path is not a directory")
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Archiving directory", "path", root, "exclude", excludes)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(root)+".tar.gz"))
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	entries, err := writeTar(r.Context(), tw, root, excludes)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if err != nil {
+		// The status was already sent, so abort the connection to stop the
+		// client from mistaking a truncated archive for a complete one
+		slog.DebugContext(r.Context(), "Failed to archive directory", "path", root, "entries", entries, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	slog.DebugContext(r.Context(), "Directory archived", "path", root, "entries", entries)
+}
+
+// untarTarget returns where the entry name is extracted under dest, which
+// must already have its symlinks resolved. Names that are absolute or climb
+// out with "..", and paths that leave dest through an existing symlink, are
+// rejected.
+func untarTarget(dest, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", errUnsafeTarEntry, name)
+	}
+	target := filepath.Join(dest, name)
+	resolved, err := evalExistingSymlinks(target)
+	if err != nil {
+		return "", err
+	}
+	if !isWithinRoot(dest, resolved) {
+		return "", fmt.Errorf("%w: %s", errUnsafeTarEntry, name)
+	}
+	return target, nil
+}
+
+// extractTar unpacks a tar stream into dest, restoring file modes. Regular
+// files, directories and symlinks pointing inside dest are extracted; other
+// entry types are skipped. At most maxBytes of file content is written.
+func extractTar(ctx context.Context, tr *tar.Reader, dest string, maxBytes int64) (int, int64, error) {
+	entries := 0
+	var written int64
+	// Directory modes are applied last so read-only directories can still
+	// be filled
+	dirModes := map[string]fs.FileMode{}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, written, err
+		}
+
+		target, err := untarTarget(dest, header.Name)
+		if err != nil {
+			return entries, written, err
+		}
+		if target == dest {
+			continue
+		}
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return entries, written, err
+			}
+			dirModes[target] = mode.Perm()
+
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(link) || !isWithinRoot(dest, filepath.Join(filepath.Dir(target), link)) {
+				return entries, written, fmt.Errorf("%w: %s -> %s", errUnsafeTarEntry, header.Name, header.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return entries, written, err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return entries, written, err
+			}
+
+		case tar.TypeReg:
+			if header.Size > maxBytes-written {
+				return entries, written, fmt.Errorf("%w of %d bytes", errUntarTooLarge, maxBytes)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return entries, written, err
+			}
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return entries, written, err
+			}
+			n, err := io.Copy(file, io.LimitReader(tr, header.Size))
+			written += n
+			if err == nil {
+				err = file.Chmod(mode.Perm())
+			}
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return entries, written, err
+			}
+
+		default:
+			slog.DebugContext(ctx, "Skipping unsupported tar entry", "name", header.Name, "type", string(header.Typeflag))
+			continue
+		}
+		entries++
+	}
+
+	for dir, mode := range dirModes {
+		if err := os.Chmod(dir, mode); err != nil {
+			return entries, written, err
+		}
+	}
+	return entries, written, nil
+}
+
+// untarHandler extracts a gzip-compressed tar archive from the request body
+// into a directory
+func (s *Server) untarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	auditTarget(r.Context(), path)
+	if path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	resolved, ok := s.sandboxPath(w, path)
+	if !ok {
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Extracting archive", "path", path, "content_length", r.ContentLength)
+
+	resp := UntarResponse{Path: path}
+	status := http.StatusOK
+	err := os.MkdirAll(resolved, 0o755)
+	var dest string
+	if err == nil {
+		dest, err = filepath.EvalSymlinks(resolved)
+	}
+	if err == nil {
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(r.Body); err == nil {
+			resp.Entries, resp.Bytes, err = extractTar(r.Context(), tar.NewReader(gr), dest, s.maxUntarBytes)
+		}
+		switch {
+		case errors.Is(err, errUntarTooLarge), isBodyTooLarge(err):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, errUnsafeTarEntry), errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum),
+			errors.Is(err, tar.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+			status = http.StatusBadRequest
+		}
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to extract archive", "path", path, "entries", resp.Entries, "error", err)
+		code := ErrorCodeInternal
+		switch status {
+		case http.StatusOK:
+			status = http.StatusInternalServerError
+		case http.StatusRequestEntityTooLarge:
+			code = ErrorCodePayloadTooLarge
+		case http.StatusBadRequest:
+			code = ErrorCodeInvalidRequest
+		}
+		writeErrorDetails(w, status, code, err.Error(), map[string]interface{}{"entries": resp.Entries, "bytes": resp.Bytes})
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Archive extracted", "path", path, "entries", resp.Entries, "bytes", resp.Bytes)
+	resp.Success = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
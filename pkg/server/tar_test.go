@@ -0,0 +1,295 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// extractTarGz unpacks a gzip-compressed tar into dir
+func extractTarGz(t *testing.T, r io.Reader, dir string) {
+	t.Helper()
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("failed to open gzip stream: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		path := filepath.Join(dir, header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, header.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeReg:
+			var content []byte
+			if content, err = io.ReadAll(tr); err == nil {
+				err = os.WriteFile(path, content, header.FileInfo().Mode().Perm())
+			}
+		default:
+			t.Fatalf("unexpected entry %s of type %c", header.Name, header.Typeflag)
+		}
+		if err != nil {
+			t.Fatalf("failed to extract %s: %v", header.Name, err)
+		}
+	}
+}
+
+func TestTarHandlerRoundTrip(t *testing.T) {
+	_, mux := newTestServer(t)
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"README.md":           "# project\n",
+		"bin/run.sh":          "#!/bin/sh\necho hi\n",
+		"src/lib/util.go":     "package lib\n",
+		"node_modules/x/a.js": "ignored\n",
+		"build.log":           "ignored\n",
+	})
+	if err := os.Chmod(filepath.Join(root, "bin/run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src/lib/util.go", filepath.Join(root, "util.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	query := url.Values{"path": {root}, "exclude": {"node_modules", "*.log"}}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/tar?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("expected application/gzip, got %q", ct)
+	}
+
+	out := t.TempDir()
+	extractTarGz(t, w.Body, out)
+
+	for name, mode := range map[string]fs.FileMode{
+		"README.md":       0644,
+		"bin/run.sh":      0755,
+		"src/lib/util.go": 0644,
+	} {
+		want, _ := os.ReadFile(filepath.Join(root, name))
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("%s: expected %q, got %q (%v)", name, want, got, err)
+			continue
+		}
+		if info, _ := os.Stat(filepath.Join(out, name)); info.Mode().Perm() != mode {
+			t.Errorf("%s: expected mode %v, got %v", name, mode, info.Mode().Perm())
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(out, "util.go")); err != nil || link != "src/lib/util.go" {
+		t.Errorf("expected util.go to be a symlink to src/lib/util.go, got %q (%v)", link, err)
+	}
+	for _, name := range []string{"node_modules", "build.log", "fifo"} {
+		if _, err := os.Lstat(filepath.Join(out, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be archived", name)
+		}
+	}
+}
+
+func TestTarHandlerRejectsInvalidRequests(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"file.txt": "hello"})
+	_, mux := newTestServerWithConfig(t, Config{Root: root})
+
+	tests := []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{}, http.StatusBadRequest},
+		{url.Values{"path": {"file.txt"}}, http.StatusBadRequest},
+		{url.Values{"path": {"missing"}}, http.StatusNotFound},
+		{url.Values{"path": {"."}, "exclude": {"["}}, http.StatusBadRequest},
+		{url.Values{"path": {t.TempDir()}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/tar?"+tt.query.Encode(), nil))
+		if w.Code != tt.want {
+			t.Errorf("query %q: expected %d, got %d", tt.query.Encode(), tt.want, w.Code)
+		}
+	}
+}
+
+// buildTarGz returns a gzip-compressed tar holding the given headers, with
+// contents for the regular files
+func buildTarGz(t *testing.T, headers []*tar.Header, contents map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, header := range headers {
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = []byte(contents[header.Name])
+			header.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func untar(t *testing.T, mux http.Handler, dest string, archive []byte) (int, UntarResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/untar?path="+url.QueryEscape(dest), archive))
+
+	var resp UntarResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return w.Code, resp
+}
+
+func TestUntarHandler(t *testing.T) {
+	_, mux := newTestServer(t)
+	dest := filepath.Join(t.TempDir(), "out")
+
+	archive := buildTarGz(t, []*tar.Header{
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "bin/run.sh", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "docs/notes.txt", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "readonly/", Typeflag: tar.TypeDir, Mode: 0555},
+		{Name: "readonly/file.txt", Typeflag: tar.TypeReg, Mode: 0444},
+		{Name: "run", Typeflag: tar.TypeSymlink, Linkname: "bin/run.sh"},
+	}, map[string]string{
+		"bin/run.sh":        "#!/bin/sh\necho hi\n",
+		"docs/notes.txt":    "notes",
+		"readonly/file.txt": "locked",
+	})
+	t.Cleanup(func() { os.Chmod(filepath.Join(dest, "readonly"), 0755) })
+
+	code, resp := untar(t, mux, dest, archive)
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("expected a successful extract, got %d: %+v", code, resp)
+	}
+	if resp.Entries != 6 || resp.Bytes != int64(len("#!/bin/sh\necho hi\nnoteslocked")) {
+		t.Errorf("expected 6 entries and the file sizes in bytes, got %+v", resp)
+	}
+
+	for name, want := range map[string]struct {
+		content string
+		mode    fs.FileMode
+	}{
+		"bin/run.sh":        {"#!/bin/sh\necho hi\n", 0755},
+		"docs/notes.txt":    {"notes", 0600},
+		"readonly/file.txt": {"locked", 0444},
+	} {
+		path := filepath.Join(dest, name)
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want.content {
+			t.Errorf("%s: expected %q, got %q (%v)", name, want.content, got, err)
+			continue
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != want.mode {
+			t.Errorf("%s: expected mode %v, got %v", name, want.mode, info.Mode().Perm())
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dest, "readonly")); err != nil || info.Mode().Perm() != 0555 {
+		t.Errorf("expected readonly/ to have mode 0555, got %v (%v)", info.Mode().Perm(), err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "run")); err != nil || link != "bin/run.sh" {
+		t.Errorf("expected run to link to bin/run.sh, got %q (%v)", link, err)
+	}
+}
+
+func TestUntarHandlerRejectsPathTraversal(t *testing.T) {
+	_, mux := newTestServer(t)
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, filepath.Join(dest, "existing-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"dot dot", []*tar.Header{{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644}}},
+		{"nested dot dot", []*tar.Header{{Name: "a/../../evil.txt", Typeflag: tar.TypeReg, Mode: 0644}}},
+		{"absolute", []*tar.Header{{Name: filepath.Join(parent, "evil.txt"), Typeflag: tar.TypeReg, Mode: 0644}}},
+		{"symlink out", []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../"}}},
+		{"absolute symlink", []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: parent}}},
+		{"through existing symlink", []*tar.Header{{Name: "existing-link/evil.txt", Typeflag: tar.TypeReg, Mode: 0644}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents := map[string]string{tt.headers[0].Name: "pwned"}
+			code, resp := untar(t, mux, dest, buildTarGz(t, tt.headers, contents))
+			if code != http.StatusBadRequest || resp.Success {
+				t.Errorf("expected 400, got %d: %+v", code, resp)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil.txt")); !errors.Is(err, fs.ErrNotExist) {
+				t.Error("expected nothing to be written outside the destination")
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "link")); !errors.Is(err, fs.ErrNotExist) {
+				t.Error("expected the escaping symlink not to be created")
+			}
+		})
+	}
+}
+
+func TestUntarHandlerSizeLimit(t *testing.T) {
+	srv, mux := newTestServer(t)
+	srv.maxUntarBytes = 10
+	dest := t.TempDir()
+
+	archive := buildTarGz(t, []*tar.Header{
+		{Name: "small.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "big.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"small.txt": "12345", "big.txt": "1234567890"})
+
+	code, resp := untar(t, mux, dest, archive)
+	if code != http.StatusRequestEntityTooLarge || resp.Success {
+		t.Errorf("expected 413, got %d: %+v", code, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "big.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected the file over the limit not to be written")
+	}
+
+	code, resp = untar(t, mux, dest, []byte("not a gzip stream"))
+	if code != http.StatusBadRequest || resp.Success {
+		t.Errorf("expected 400 for an invalid archive, got %d: %+v", code, resp)
+	}
+}
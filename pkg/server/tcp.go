@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // Connection wraps a net.Conn for easier handling
@@ -18,6 +19,34 @@ type TCPListener struct {
 	mu       sync.Mutex
 	stopChan chan struct{}
 	wg       sync.WaitGroup
+	// limiter, when set before Start, caps the connections handled at once
+	limiter *connLimiter
+}
+
+// connLimiter caps how many connections are handled at once. One limiter
+// can be shared by several listeners.
+type connLimiter struct {
+	slots    chan struct{}
+	rejected atomic.Uint64
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire takes a slot without blocking and reports whether one was free
+func (c *connLimiter) acquire() bool {
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+		c.rejected.Add(1)
+		return false
+	}
+}
+
+func (c *connLimiter) release() {
+	<-c.slots
 }
 
 // NewTCPListener creates a new TCP listener
@@ -62,14 +91,37 @@ func (l *TCPListener) acceptLoop(handler func(*Connection)) {
 			}
 		}
 
+		// Over the limit, close the connection right away rather than
+		// leaving it in the backlog
+		if l.limiter != nil && !l.limiter.acquire() {
+			conn.Close()
+			continue
+		}
+
 		l.wg.Add(1)
 		go func() {
 			defer l.wg.Done()
+			if l.limiter != nil {
+				defer l.limiter.release()
+			}
 			handler(&Connection{Conn: conn})
 		}()
 	}
 }
 
+// Listening reports whether the listener was started and not stopped since
+func (l *TCPListener) Listening() bool {
+	select {
+	case <-l.stopChan:
+		return false
+	default:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.listener != nil
+}
+
 // Stop closes the listener and waits for all connections to finish
 func (l *TCPListener) Stop() {
 	close(l.stopChan)
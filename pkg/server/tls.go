@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig holds the certificate the control API is served with. Cert, Key
+// and ClientCA are either PEM data or paths to PEM files
+type TLSConfig struct {
+	Cert string
+	Key  string
+	// ClientCA is the bundle client certificates are verified against
+	ClientCA string
+}
+
+// Enabled reports whether a certificate was configured
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != ""
+}
+
+// NewTLSConfig loads the certificate and key of c into a tls.Config that
+// accepts TLS 1.2 and later. With a client CA, clients must present a
+// certificate it signed, except in ClientAuthEither mode where a certificate
+// is only verified when one is given, since the token is accepted too
+func NewTLSConfig(c TLSConfig, clientAuth ClientAuthMode) (*tls.Config, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, fmt.Errorf("both a TLS certificate and a key are required")
+	}
+
+	certPEM, err := readPEM(c.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	keyPEM, err := readPEM(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCA == "" {
+		if clientAuth == ClientAuthMTLS || clientAuth == ClientAuthEither {
+			return nil, fmt.Errorf("client certificate authentication requires a client CA")
+		}
+		return config, nil
+	}
+
+	caPEM, err := readPEM(c.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("TLS client CA contains no certificates")
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if clientAuth == ClientAuthEither {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// readPEM returns value itself when it holds PEM data, or else the content of
+// the file it names
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate with its key
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// tlsClientCert returns the certificate in the form a TLS client presents it
+func (c testCert) tlsClientCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// generateCert creates a certificate from template, signed by parent, or
+// self-signed when parent is nil
+func generateCert(t *testing.T, template *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// generateCA creates a self-signed certificate authority
+func generateCA(t *testing.T, name string) testCert {
+	t.Helper()
+	return generateCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}, nil)
+}
+
+// generateClientCert creates a client certificate signed by ca
+func generateClientCert(t *testing.T, ca testCert, name string) testCert {
+	t.Helper()
+	return generateCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+}
+
+// writeSelfSignedCert writes a certificate for localhost and its key as PEM
+// files and returns their paths
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	server := generateCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}, nil)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, server.certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, server.keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// startMTLSServer serves a test server over TLS with client certificates
+// verified against clientCA, in the given client auth mode, and returns its
+// URL and the pool trusting its certificate
+func startMTLSServer(t *testing.T, clientCA testCert, mode ClientAuthMode) (string, *x509.CertPool) {
+	t.Helper()
+
+	certFile, keyFile := writeSelfSignedCert(t)
+	tlsConfig, err := NewTLSConfig(TLSConfig{Cert: certFile, Key: keyFile, ClientCA: string(clientCA.certPEM)}, mode)
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+
+	srv, err := New(Config{
+		Auth: AuthConfig{
+			Mode:       AuthModeStatic,
+			Secret:     "test-secret",
+			ClientAuth: mode,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test server: %v", err)
+	}
+
+	httpServer := httptest.NewUnstartedServer(srv.RegisterRoutes())
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	t.Cleanup(httpServer.Close)
+
+	certPEM, _ := os.ReadFile(certFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return httpServer.URL, pool
+}
+
+// getWithClientCert requests /list_processes presenting the first of certs, with the bearer
+// token when token is set, and returns the status code
+func getWithClientCert(url string, pool *x509.CertPool, token bool, certs ...tls.Certificate) (int, error) {
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if len(certs) > 0 {
+		// Present the certificate even when the server does not list its
+		// issuer as acceptable, as a misbehaving client would
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &certs[0], nil
+		}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	req, _ := http.NewRequest(http.MethodGet, url+"/list_processes", nil)
+	if token {
+		req.Header.Set("Authorization", "Bearer test-secret")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestTLSServesAuthenticatedRequests(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	tlsConfig, err := NewTLSConfig(TLSConfig{Cert: certFile, Key: keyFile}, ClientAuthToken)
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+
+	_, mux := newTestServer(t)
+	httpServer := httptest.NewUnstartedServer(mux)
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	certPEM, _ := os.ReadFile(certFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/list_processes", nil)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Fatalf("expected a 200 over TLS, got %d (tls=%v)", resp.StatusCode, resp.TLS != nil)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, httpServer.URL+"/list_processes", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected TLS not to replace the bearer token, got %d", resp.StatusCode)
+	}
+}
+
+func TestNewTLSConfigAcceptsInlinePEM(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(keyFile)
+
+	if _, err := NewTLSConfig(TLSConfig{Cert: string(certPEM), Key: string(keyPEM)}, ClientAuthToken); err != nil {
+		t.Errorf("expected inline PEM to load: %v", err)
+	}
+	if _, err := NewTLSConfig(TLSConfig{Cert: certFile}, ClientAuthToken); err == nil {
+		t.Error("expected a certificate without a key to be rejected")
+	}
+	if _, err := NewTLSConfig(TLSConfig{Cert: certFile, Key: certFile}, ClientAuthToken); err == nil {
+		t.Error("expected a mismatched key to be rejected")
+	}
+}
+
+func TestMTLSAcceptsClientCertificateInsteadOfToken(t *testing.T) {
+	ca := generateCA(t, "trusted-ca")
+	trusted := generateClientCert(t, ca, "trusted-client").tlsClientCert(t)
+	untrusted := generateClientCert(t, generateCA(t, "other-ca"), "untrusted-client").tlsClientCert(t)
+
+	url, pool := startMTLSServer(t, ca, ClientAuthEither)
+	if status, err := getWithClientCert(url, pool, false, trusted); err != nil || status != http.StatusOK {
+		t.Errorf("expected a trusted client certificate to be accepted without a token, got %d (%v)", status, err)
+	}
+	if status, err := getWithClientCert(url, pool, true); err != nil || status != http.StatusOK {
+		t.Errorf("expected the token to be accepted without a certificate, got %d (%v)", status, err)
+	}
+	if status, err := getWithClientCert(url, pool, false); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("expected a request without a certificate or token to be rejected, got %d (%v)", status, err)
+	}
+	if _, err := getWithClientCert(url, pool, true, untrusted); err == nil {
+		t.Error("expected an untrusted client certificate to fail the handshake")
+	}
+}
+
+func TestMTLSOnlyRejectsToken(t *testing.T) {
+	ca := generateCA(t, "trusted-ca")
+	trusted := generateClientCert(t, ca, "trusted-client").tlsClientCert(t)
+
+	url, pool := startMTLSServer(t, ca, ClientAuthMTLS)
+	if status, err := getWithClientCert(url, pool, false, trusted); err != nil || status != http.StatusOK {
+		t.Errorf("expected a trusted client certificate to be accepted, got %d (%v)", status, err)
+	}
+	if _, err := getWithClientCert(url, pool, true); err == nil {
+		t.Error("expected the handshake to require a client certificate")
+	}
+}
+
+func TestTokenModeRequiresTokenWithClientCertificate(t *testing.T) {
+	ca := generateCA(t, "trusted-ca")
+	trusted := generateClientCert(t, ca, "trusted-client").tlsClientCert(t)
+
+	url, pool := startMTLSServer(t, ca, ClientAuthToken)
+	if status, err := getWithClientCert(url, pool, false, trusted); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("expected a certificate alone to be rejected in token mode, got %d (%v)", status, err)
+	}
+	if status, err := getWithClientCert(url, pool, true, trusted); err != nil || status != http.StatusOK {
+		t.Errorf("expected a certificate and token to be accepted, got %d (%v)", status, err)
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUDPSessionTimeout is how long a UDP client may stay silent, in both
+// directions, before its session with the target is dropped
+const DefaultUDPSessionTimeout = time.Minute
+
+// DefaultUDPMaxSessions caps the client sessions open at once. Each one
+// holds a socket and a goroutine, and source addresses are easily spoofed.
+const DefaultUDPMaxSessions = 1024
+
+// maxUDPDatagramSize is the largest datagram the proxy forwards
+const maxUDPDatagramSize = 64 * 1024
+
+// errUDPSessionLimit drops the datagrams of new clients while every session
+// is in use
+var errUDPSessionLimit = errors.New("too many UDP sessions")
+
+// UDPProxy forwards UDP datagrams to a configured target. UDP has no
+// connections, so every client address gets its own session: a socket
+// connected to the target whose replies are sent back to that client.
+type UDPProxy struct {
+	mu             sync.RWMutex
+	target         *ProxyTarget
+	conn           *net.UDPConn
+	sessions       map[string]*udpSession
+	sessionTimeout time.Duration
+	maxSessions    int
+	stopChan       chan struct{}
+	wg             sync.WaitGroup
+	// activity records forwarded datagrams, nil unless idle shutdown is on
+	activity *activityTracker
+}
+
+// udpSession relays the datagrams of one client address
+type udpSession struct {
+	client   *net.UDPAddr
+	upstream *net.UDPConn
+	// lastActive is the UnixNano time a datagram last went either way
+	lastActive atomic.Int64
+}
+
+func NewUDPProxy() *UDPProxy {
+	return &UDPProxy{
+		sessions:       make(map[string]*udpSession),
+		sessionTimeout: DefaultUDPSessionTimeout,
+		maxSessions:    DefaultUDPMaxSessions,
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// SetSessionTimeout sets how long idle client sessions are kept
+func (p *UDPProxy) SetSessionTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessionTimeout = timeout
+}
+
+// SetMaxSessions caps the client sessions open at once; datagrams from new
+// clients past it are dropped. A max that is not positive uses
+// DefaultUDPMaxSessions.
+func (p *UDPProxy) SetMaxSessions(max int) {
+	if max <= 0 {
+		max = DefaultUDPMaxSessions
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxSessions = max
+}
+
+// SetTarget forwards datagrams to target. Sessions opened for a previous
+// target are closed.
+func (p *UDPProxy) SetTarget(target ProxyTarget) {
+	p.mu.Lock()
+	p.target = &target
+	sessions := p.takeSessions()
+	p.mu.Unlock()
+
+	closeSessions(sessions)
+}
+
+// GetTarget returns the target datagrams are forwarded to, if one is bound
+func (p *UDPProxy) GetTarget() (ProxyTarget, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.target == nil {
+		return ProxyTarget{}, false
+	}
+	return *p.target, true
+}
+
+// ClearTarget stops forwarding and closes every session
+func (p *UDPProxy) ClearTarget() {
+	p.mu.Lock()
+	p.target = nil
+	sessions := p.takeSessions()
+	p.mu.Unlock()
+
+	closeSessions(sessions)
+}
+
+// takeSessions empties the session map and returns its content. The caller
+// must hold p.mu.
+func (p *UDPProxy) takeSessions() map[string]*udpSession {
+	sessions := p.sessions
+	p.sessions = make(map[string]*udpSession)
+	return sessions
+}
+
+func closeSessions(sessions map[string]*udpSession) {
+	for _, session := range sessions {
+		session.upstream.Close()
+	}
+}
+
+// Start listens for datagrams on port
+func (p *UDPProxy) Start(port string) error {
+	addr, err := net.ResolveUDPAddr("udp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("invalid UDP port %s: %w", port, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP port %s: %w", port, err)
+	}
+
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go p.readLoop(conn)
+	return nil
+}
+
+// readLoop forwards every datagram received from clients to the target
+func (p *UDPProxy) readLoop(conn *net.UDPConn) {
+	defer p.wg.Done()
+
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-p.stopChan:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		session, err := p.session(conn, client)
+		if err != nil {
+			slog.Debug("Failed to open UDP session", "client", client, "error", err)
+			continue
+		}
+		if session == nil {
+			// No target bound, drop the datagram
+			continue
+		}
+
+		session.lastActive.Store(time.Now().UnixNano())
+		p.activity.touch()
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			slog.Debug("Failed to forward UDP datagram", "client", client, "error", err)
+		}
+	}
+}
+
+// session returns the session of client, opening one to the current target
+// if needed. It returns nil if no target is bound, and errUDPSessionLimit
+// when no session is left for a new client.
+func (p *UDPProxy) session(conn *net.UDPConn, client *net.UDPAddr) (*udpSession, error) {
+	p.mu.RLock()
+	session, ok := p.sessions[client.String()]
+	target := p.target
+	full := len(p.sessions) >= p.maxSessions
+	p.mu.RUnlock()
+
+	if ok {
+		return session, nil
+	}
+	if target == nil {
+		return nil, nil
+	}
+	if full {
+		return nil, errUDPSessionLimit
+	}
+
+	// Resolving may wait on DNS, so the target is dialed without holding
+	// p.mu, which would stall the replies of every other session
+	targetAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(target.Host, target.Port))
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.stopChan:
+		upstream.Close()
+		return nil, nil
+	default:
+	}
+	// The target may have been rebound or cleared meanwhile, in which case
+	// the datagram is dropped like those sent while it changes
+	if p.target != target {
+		upstream.Close()
+		return nil, nil
+	}
+	if len(p.sessions) >= p.maxSessions {
+		upstream.Close()
+		return nil, errUDPSessionLimit
+	}
+
+	session = &udpSession{client: client, upstream: upstream}
+	session.lastActive.Store(time.Now().UnixNano())
+	p.sessions[client.String()] = session
+
+	p.wg.Add(1)
+	go p.relayReplies(conn, session, p.sessionTimeout)
+	return session, nil
+}
+
+// relayReplies sends the target's replies back to the session's client
+// until the session has been idle for timeout or is closed
+func (p *UDPProxy) relayReplies(conn *net.UDPConn, session *udpSession, timeout time.Duration) {
+	defer p.wg.Done()
+	defer p.removeSession(session)
+
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		session.upstream.SetReadDeadline(time.Unix(0, session.lastActive.Load()).Add(timeout))
+
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			// Datagrams from the client may have kept the session alive
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() &&
+				time.Since(time.Unix(0, session.lastActive.Load())) < timeout {
+				continue
+			}
+			return
+		}
+
+		session.lastActive.Store(time.Now().UnixNano())
+		p.activity.touch()
+		if _, err := conn.WriteToUDP(buf[:n], session.client); err != nil {
+			slog.Debug("Failed to send UDP reply", "client", session.client, "error", err)
+		}
+	}
+}
+
+// removeSession closes session and forgets it unless it was already
+// replaced
+func (p *UDPProxy) removeSession(session *udpSession) {
+	session.upstream.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sessions[session.client.String()] == session {
+		delete(p.sessions, session.client.String())
+	}
+}
+
+// SessionCount returns the number of client sessions
+func (p *UDPProxy) SessionCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.sessions)
+}
+
+// Stop closes the listener and every session and waits for their
+// goroutines to exit
+func (p *UDPProxy) Stop() {
+	p.mu.Lock()
+	select {
+	case <-p.stopChan:
+	default:
+		close(p.stopChan)
+	}
+	conn := p.conn
+	sessions := p.takeSessions()
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	closeSessions(sessions)
+	p.wg.Wait()
+}
+
+func (s *Server) StartUDPProxy(port string) error {
+	return s.udpProxy.Start(port)
+}
+
+func (s *Server) StopUDPProxy() {
+	s.udpProxy.Stop()
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startUDPEchoServer echoes every datagram back to its sender. It returns
+// the port.
+func startUDPEchoServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	return strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// startTestUDPProxy starts the UDP proxy of srv on a free port and returns
+// the port
+func startTestUDPProxy(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := strconv.Itoa(probe.LocalAddr().(*net.UDPAddr).Port)
+	probe.Close()
+
+	if err := srv.StartUDPProxy(port); err != nil {
+		t.Fatalf("failed to start UDP proxy: %v", err)
+	}
+	t.Cleanup(srv.StopUDPProxy)
+	return port
+}
+
+func udpRoundTrip(t *testing.T, conn net.Conn, payload string) (string, error) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+func TestUDPProxyRoundTrip(t *testing.T) {
+	srv, mux := newTestServer(t)
+	proxyPort := startTestUDPProxy(t, srv)
+
+	body, _ := json.Marshal(BindUDPRequest{Port: startUDPEchoServer(t), TargetHost: "127.0.0.1"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_udp", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_udp", body))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 binding twice, got %d", w.Code)
+	}
+
+	// Two clients get their own sessions and their own replies
+	for _, payload := range []string{"ping", "pong"} {
+		conn, err := net.Dial("udp", "127.0.0.1:"+proxyPort)
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+
+		for i := 0; i < 2; i++ {
+			reply, err := udpRoundTrip(t, conn, payload)
+			if err != nil || reply != payload {
+				t.Fatalf("expected %q echoed back, got %q (%v)", payload, reply, err)
+			}
+		}
+	}
+	if n := srv.udpProxy.SessionCount(); n != 2 {
+		t.Errorf("expected 2 sessions, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/unbind_udp", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := srv.udpProxy.SessionCount(); n != 0 {
+		t.Errorf("expected unbinding to close sessions, got %d", n)
+	}
+}
+
+func TestUDPProxySessionTimeout(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.udpProxy.SetSessionTimeout(200 * time.Millisecond)
+	proxyPort := startTestUDPProxy(t, srv)
+	srv.udpProxy.SetTarget(ProxyTarget{Host: "127.0.0.1", Port: startUDPEchoServer(t)})
+
+	conn, err := net.Dial("udp", "127.0.0.1:"+proxyPort)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	if reply, err := udpRoundTrip(t, conn, "hi"); err != nil || reply != "hi" {
+		t.Fatalf("expected the datagram echoed back, got %q (%v)", reply, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.udpProxy.SessionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := srv.udpProxy.SessionCount(); n != 0 {
+		t.Errorf("expected the idle session to expire, got %d sessions", n)
+	}
+
+	// A new datagram opens a fresh session
+	if reply, err := udpRoundTrip(t, conn, "again"); err != nil || reply != "again" {
+		t.Errorf("expected a new session after expiry, got %q (%v)", reply, err)
+	}
+}
+
+func TestUDPProxySessionLimit(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.udpProxy.SetMaxSessions(1)
+	proxyPort := startTestUDPProxy(t, srv)
+	srv.udpProxy.SetTarget(ProxyTarget{Host: "127.0.0.1", Port: startUDPEchoServer(t)})
+
+	first, err := net.Dial("udp", "127.0.0.1:"+proxyPort)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer first.Close()
+	if reply, err := udpRoundTrip(t, first, "hi"); err != nil || reply != "hi" {
+		t.Fatalf("expected the datagram echoed back, got %q (%v)", reply, err)
+	}
+
+	// A second client gets no session while the first holds the only one
+	second, err := net.Dial("udp", "127.0.0.1:"+proxyPort)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	second.Write([]byte("dropped"))
+	if _, err := second.Read(make([]byte, 64)); err == nil {
+		t.Error("expected the datagram of a client over the limit to be dropped")
+	}
+	if n := srv.udpProxy.SessionCount(); n != 1 {
+		t.Errorf("expected 1 session, got %d", n)
+	}
+
+	// The first client keeps its session
+	if reply, err := udpRoundTrip(t, first, "again"); err != nil || reply != "again" {
+		t.Errorf("expected the existing session to keep working, got %q (%v)", reply, err)
+	}
+}
+
+func TestBindUDPRejectsUnixSocket(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/bind_udp", []byte(`{"target_host":"unix:/tmp/app.sock"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// chunkedUploadTTL is how long an upload may go without a chunk before it is
+// discarded
+const chunkedUploadTTL = 24 * time.Hour
+
+// maxChunkedUploads caps the uploads in progress, each of which holds a temp
+// file until it completes or expires
+const maxChunkedUploads = 64
+
+// chunkedUpload is a file being uploaded in chunks. Its content grows in a
+// temp file next to the destination, so completing it is a rename.
+type chunkedUpload struct {
+	// mu serializes the chunks and the completion of the upload
+	mu sync.Mutex
+	id string
+	// path is the destination as requested, resolved its sandboxed location
+	path     string
+	resolved string
+	tmpPath  string
+	mode     fs.FileMode
+	// size is the total size declared at init, zero if none was
+	size int64
+	// offset is the number of bytes committed to the temp file
+	offset   int64
+	activeAt time.Time
+	// closed is set once the upload completed or was discarded
+	closed bool
+}
+
+// discard removes the temp file of an upload that will not complete. The
+// caller holds u.mu.
+func (u *chunkedUpload) discard() {
+	u.closed = true
+	_ = os.Remove(u.tmpPath)
+}
+
+type UploadInitRequest struct {
+	Path string `json:"path"`
+	// Mode is the octal permission of the completed file (default "0644")
+	Mode string `json:"mode,omitempty"`
+	// Size, when set, is the total size of the file; chunks past it are
+	// rejected
+	Size int64 `json:"size,omitempty"`
+}
+
+// UploadStatusResponse describes an upload in progress. Offset is where its
+// next chunk must start.
+type UploadStatusResponse struct {
+	UploadID string `json:"upload_id"`
+	Path     string `json:"path"`
+	Offset   int64  `json:"offset"`
+}
+
+type UploadCompleteRequest struct {
+	UploadID string `json:"upload_id"`
+	// Checksum is the hex digest the uploaded content must have
+	Checksum string `json:"checksum"`
+	// Algorithm is md5, sha1 or sha256 (default sha256)
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+type UploadCompleteResponse struct {
+	Success   bool   `json:"success"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+}
+
+// lookupUpload returns the open upload with id, answering 400 without an id
+// and 404 if there is no such upload
+func (s *Server) lookupUpload(w http.ResponseWriter, id string) (*chunkedUpload, bool) {
+	if id == "" {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Upload ID is required")
+		return nil, false
+	}
+	s.expireUploads(time.Now())
+	s.uploadsMu.Lock()
+	upload, ok := s.uploads[id]
+	s.uploadsMu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("Upload not found: %s", id))
+		return nil, false
+	}
+	return upload, true
+}
+
+// removeUpload forgets the upload with id
+func (s *Server) removeUpload(id string) {
+	s.uploadsMu.Lock()
+	delete(s.uploads, id)
+	s.uploadsMu.Unlock()
+}
+
+// expireUploads discards the uploads that received no chunk for
+// chunkedUploadTTL. It runs on every upload request; uploads busy with a
+// chunk are skipped, as they are active anyway.
+func (s *Server) expireUploads(now time.Time) {
+	s.uploadsMu.Lock()
+	uploads := make([]*chunkedUpload, 0, len(s.uploads))
+	for _, upload := range s.uploads {
+		uploads = append(uploads, upload)
+	}
+	s.uploadsMu.Unlock()
+
+	for _, upload := range uploads {
+		if !upload.mu.TryLock() {
+			continue
+		}
+		if !upload.closed && now.Sub(upload.activeAt) > chunkedUploadTTL {
+			upload.discard()
+			s.removeUpload(upload.id)
+			slog.Debug("Chunked upload expired", "upload_id", upload.id, "path", upload.path)
+		}
+		upload.mu.Unlock()
+	}
+}
+
+// uploadInitHandler starts a chunked upload: it creates the temp file the
+// chunks are written to and returns the id of the upload
+func (s *Server) uploadInitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req UploadInitRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	auditTarget(r.Context(), req.Path)
+
+	if req.Path == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	mode := fs.FileMode(0o644)
+	if req.Mode != "" {
+		parsed, err := parseFileMode(req.Mode)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid mode: %s", req.Mode))
+			return
+		}
+		mode = parsed
+	}
+	if req.Size < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Invalid size: %d", req.Size))
+		return
+	}
+	if req.Size > s.maxUploadBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, fmt.Sprintf("Upload of %d bytes exceeds the %d byte limit", req.Size, s.maxUploadBytes))
+		return
+	}
+
+	resolved, ok := s.sandboxPath(w, req.Path)
+	if !ok {
+		return
+	}
+
+	s.expireUploads(time.Now())
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(resolved), "."+filepath.Base(resolved)+".upload-*")
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create upload temp file", "path", req.Path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	tmpFile.Close()
+
+	upload := &chunkedUpload{
+		id:       uuid.New().String(),
+		path:     req.Path,
+		resolved: resolved,
+		tmpPath:  tmpFile.Name(),
+		mode:     mode,
+		size:     req.Size,
+		activeAt: time.Now(),
+	}
+	s.uploadsMu.Lock()
+	if len(s.uploads) >= maxChunkedUploads {
+		s.uploadsMu.Unlock()
+		_ = os.Remove(upload.tmpPath)
+		writeError(w, http.StatusTooManyRequests, ErrorCodeRateLimited, fmt.Sprintf("Too many uploads in progress (max %d)", maxChunkedUploads))
+		return
+	}
+	s.uploads[upload.id] = upload
+	s.uploadsMu.Unlock()
+
+	slog.DebugContext(r.Context(), "Chunked upload started", "upload_id", upload.id, "path", req.Path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadStatusResponse{UploadID: upload.id, Path: req.Path, Offset: 0})
+}
+
+// uploadChunkHandler appends the raw request body to an upload. The "offset"
+// query parameter must be the committed offset of the upload, so chunks that
+// are out of order, duplicated or missing are rejected rather than written.
+func (s *Server) uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	upload, ok := s.lookupUpload(w, query.Get("id"))
+	if !ok {
+		return
+	}
+	auditTarget(r.Context(), upload.path)
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if upload.closed {
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("Upload not found: %s", upload.id))
+		return
+	}
+	if offset != upload.offset {
+		slog.DebugContext(r.Context(), "Chunk offset mismatch", "upload_id", upload.id, "offset", offset, "committed", upload.offset)
+		writeErrorDetails(w, http.StatusConflict, ErrorCodeConflict,
+			fmt.Sprintf("Chunk starts at offset %d, expected %d", offset, upload.offset),
+			map[string]interface{}{"offset": upload.offset})
+		return
+	}
+
+	// The upload as a whole is held to the declared size, or the upload limit
+	limit := s.maxUploadBytes
+	if upload.size > 0 {
+		limit = upload.size
+	}
+	remaining := limit - upload.offset
+	if r.ContentLength > remaining {
+		writeUploadTooLarge(w, upload, limit)
+		return
+	}
+
+	file, err := os.OpenFile(upload.tmpPath, os.O_WRONLY, 0)
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+	defer file.Close()
+
+	// Read one byte past the limit to tell a body that reaches it from one
+	// that exceeds it
+	written, err := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(r.Body, remaining+1))
+	if err == nil && written > remaining {
+		_ = file.Truncate(upload.offset)
+		writeUploadTooLarge(w, upload, limit)
+		return
+	}
+	if err != nil {
+		// Drop the partial chunk so the committed offset stays the resume point
+		_ = file.Truncate(upload.offset)
+		slog.DebugContext(r.Context(), "Failed to write chunk", "upload_id", upload.id, "offset", offset, "error", err)
+		if isBodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge, err.Error())
+		} else {
+			writeFileError(w, err)
+		}
+		return
+	}
+
+	upload.offset += written
+	upload.activeAt = time.Now()
+
+	slog.DebugContext(r.Context(), "Chunk written", "upload_id", upload.id, "offset", offset, "bytes", written)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadStatusResponse{UploadID: upload.id, Path: upload.path, Offset: upload.offset})
+}
+
+// writeUploadTooLarge rejects a chunk that would take upload past limit
+func writeUploadTooLarge(w http.ResponseWriter, upload *chunkedUpload, limit int64) {
+	writeErrorDetails(w, http.StatusRequestEntityTooLarge, ErrorCodePayloadTooLarge,
+		fmt.Sprintf("Chunk would exceed the %d byte size of the upload", limit),
+		map[string]interface{}{"offset": upload.offset, "limit": limit})
+}
+
+// uploadStatusHandler returns the committed offset of an upload, where a
+// client resuming it sends its next chunk
+func (s *Server) uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	upload, ok := s.lookupUpload(w, r.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+
+	upload.mu.Lock()
+	resp := UploadStatusResponse{UploadID: upload.id, Path: upload.path, Offset: upload.offset}
+	upload.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// uploadCompleteHandler verifies the checksum of an upload and renames it
+// into place. An upload whose content does not match is discarded.
+func (s *Server) uploadCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req UploadCompleteRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	algorithm := strings.ToLower(req.Algorithm)
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Unsupported algorithm: %s (expected md5, sha1 or sha256)", req.Algorithm))
+		return
+	}
+	if req.Checksum == "" {
+		writeMissingField(w, "checksum")
+		return
+	}
+
+	upload, ok := s.lookupUpload(w, req.UploadID)
+	if !ok {
+		return
+	}
+	auditTarget(r.Context(), upload.path)
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if upload.closed {
+		writeError(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("Upload not found: %s", upload.id))
+		return
+	}
+
+	size, sum, err := checksumFile(upload.tmpPath, newHash())
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to checksum upload", "upload_id", upload.id, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	if !strings.EqualFold(sum, req.Checksum) {
+		slog.DebugContext(r.Context(), "Upload checksum mismatch", "upload_id", upload.id, "expected", req.Checksum, "actual", sum)
+		upload.discard()
+		s.removeUpload(upload.id)
+		writeErrorDetails(w, http.StatusPreconditionFailed, ErrorCodePreconditionFailed,
+			fmt.Sprintf("%s checksum mismatch: expected %s, got %s", algorithm, req.Checksum, sum),
+			map[string]interface{}{"expected": req.Checksum, "actual": sum, "bytes": size})
+		return
+	}
+
+	if err := os.Chmod(upload.tmpPath, upload.mode); err != nil {
+		writeFileError(w, err)
+		return
+	}
+	unlock := s.pathLocks.lock(upload.resolved)
+	err = os.Rename(upload.tmpPath, upload.resolved)
+	unlock()
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to move upload into place", "upload_id", upload.id, "path", upload.path, "error", err)
+		writeFileError(w, err)
+		return
+	}
+	upload.closed = true
+	s.removeUpload(upload.id)
+
+	slog.DebugContext(r.Context(), "Chunked upload completed", "upload_id", upload.id, "path", upload.path, "bytes", size)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadCompleteResponse{Success: true, Path: upload.path, Bytes: size, Algorithm: algorithm, Checksum: sum})
+}
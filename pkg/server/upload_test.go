@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func initUpload(t *testing.T, mux http.Handler, path string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(UploadInitRequest{Path: path})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/init", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp UploadStatusResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.UploadID == "" || resp.Offset != 0 {
+		t.Fatalf("expected a new upload at offset 0, got %+v", resp)
+	}
+	return resp.UploadID
+}
+
+func sendChunk(mux http.Handler, id string, offset int64, chunk []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	target := fmt.Sprintf("/upload/chunk?id=%s&offset=%d", url.QueryEscape(id), offset)
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, target, chunk))
+	return w
+}
+
+func completeUpload(mux http.Handler, id, checksum string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(UploadCompleteRequest{UploadID: id, Checksum: checksum})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/complete", body))
+	return w
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChunkedUpload(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	id := initUpload(t, mux, path)
+	for offset := 0; offset < len(content); offset += 300 {
+		chunk := content[offset:min(offset+300, len(content))]
+		w := sendChunk(mux, id, int64(offset), chunk)
+		if w.Code != http.StatusOK {
+			t.Fatalf("chunk at %d: expected 200, got %d: %s", offset, w.Code, w.Body.String())
+		}
+		var resp UploadStatusResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Offset != int64(offset+len(chunk)) {
+			t.Fatalf("expected the offset to advance to %d, got %d", offset+len(chunk), resp.Offset)
+		}
+	}
+
+	// Resuming starts from the committed offset
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/upload/status?id="+id, nil))
+	var status UploadStatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if w.Code != http.StatusOK || status.Offset != int64(len(content)) || status.Path != path {
+		t.Fatalf("expected the committed offset %d, got %d %+v", len(content), w.Code, status)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the destination not to exist before completion, got %v", err)
+	}
+
+	w = completeUpload(mux, id, sha256Hex(content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var done UploadCompleteResponse
+	json.NewDecoder(w.Body).Decode(&done)
+	if !done.Success || done.Bytes != int64(len(content)) || done.Algorithm != "sha256" {
+		t.Errorf("unexpected completion: %+v", done)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+		t.Errorf("expected the uploaded content in place, got %d bytes", len(got))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the destination left in the directory, got %d entries", len(entries))
+	}
+
+	if w := completeUpload(mux, id, sha256Hex(content)); w.Code != http.StatusNotFound {
+		t.Errorf("expected a completed upload to be gone, got %d", w.Code)
+	}
+}
+
+func TestChunkedUploadRejectsOutOfOrderChunk(t *testing.T) {
+	_, mux := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "data.txt")
+
+	id := initUpload(t, mux, path)
+	if w := sendChunk(mux, id, 0, []byte("hello ")); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, offset := range []int64{0, 3, 20} {
+		w := sendChunk(mux, id, offset, []byte("world"))
+		if w.Code != http.StatusConflict {
+			t.Fatalf("offset %d: expected 409, got %d: %s", offset, w.Code, w.Body.String())
+		}
+		if errResp := decodeError(t, w); errResp.Code != ErrorCodeConflict || errResp.Details["offset"] != float64(6) {
+			t.Errorf("expected the committed offset in the error, got %+v", errResp)
+		}
+	}
+
+	if w := sendChunk(mux, id, 6, []byte("world")); w.Code != http.StatusOK {
+		t.Fatalf("expected the chunk at the committed offset to be accepted, got %d", w.Code)
+	}
+	if w := completeUpload(mux, id, sha256Hex([]byte("hello world"))); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got, _ := os.ReadFile(path); string(got) != "hello world" {
+		t.Errorf("expected the rejected chunks not to be written, got %q", got)
+	}
+}
+
+func TestChunkedUploadChecksumMismatch(t *testing.T) {
+	_, mux := newTestServer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.txt")
+
+	id := initUpload(t, mux, path)
+	if w := sendChunk(mux, id, 0, []byte("corrupted")); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	expected := sha256Hex([]byte("original"))
+	w := completeUpload(mux, id, expected)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d: %s", w.Code, w.Body.String())
+	}
+	errResp := decodeError(t, w)
+	if errResp.Code != ErrorCodePreconditionFailed || errResp.Details["expected"] != expected || errResp.Details["actual"] != sha256Hex([]byte("corrupted")) {
+		t.Errorf("expected both checksums in the error, got %+v", errResp)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the mismatched upload to be discarded, got %d entries", len(entries))
+	}
+	if w := sendChunk(mux, id, 9, []byte("more")); w.Code != http.StatusNotFound {
+		t.Errorf("expected a discarded upload to be gone, got %d", w.Code)
+	}
+	if w := completeUpload(mux, "", expected); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without an upload id, got %d", w.Code)
+	}
+}
+
+func TestChunkedUploadSizeLimits(t *testing.T) {
+	srv, mux := newTestServer(t)
+	srv.maxUploadBytes = 10
+	dir := t.TempDir()
+
+	// The upload limit applies to the whole upload, not only to each chunk
+	id := initUpload(t, mux, filepath.Join(dir, "limited.txt"))
+	if w := sendChunk(mux, id, 0, []byte("012345")); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := sendChunk(mux, id, 6, []byte("6789a")); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 past the upload limit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A body of unknown length is cut off at the limit, and dropped whole
+	req := newAuthRequest(http.MethodPost, fmt.Sprintf("/upload/chunk?id=%s&offset=6", id), []byte("6789a"))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a streamed chunk past the limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := sendChunk(mux, id, 6, []byte("6789")); w.Code != http.StatusOK {
+		t.Fatalf("expected a chunk reaching the limit to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A declared size is enforced the same way
+	body, _ := json.Marshal(UploadInitRequest{Path: filepath.Join(dir, "sized.txt"), Size: 4})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/init", body))
+	var sized UploadStatusResponse
+	json.NewDecoder(w.Body).Decode(&sized)
+	if w := sendChunk(mux, sized.UploadID, 0, []byte("12345")); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 past the declared size, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ = json.Marshal(UploadInitRequest{Path: filepath.Join(dir, "huge.txt"), Size: 11})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/init", body))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a declared size over the upload limit, got %d", w.Code)
+	}
+}
+
+func TestChunkedUploadLimitsOpenUploads(t *testing.T) {
+	srv, mux := newTestServer(t)
+	dir := t.TempDir()
+
+	var first string
+	for i := range maxChunkedUploads {
+		id := initUpload(t, mux, filepath.Join(dir, fmt.Sprintf("file-%d", i)))
+		if first == "" {
+			first = id
+		}
+	}
+	body, _ := json.Marshal(UploadInitRequest{Path: filepath.Join(dir, "one-too-many")})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodPost, "/upload/init", body))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the upload cap, got %d: %s", w.Code, w.Body.String())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != maxChunkedUploads {
+		t.Errorf("expected the rejected upload to leave no temp file, got %d entries", len(entries))
+	}
+
+	// A stale upload is purged by the next upload request of any kind
+	srv.uploadsMu.Lock()
+	stale := srv.uploads[first]
+	srv.uploadsMu.Unlock()
+	stale.mu.Lock()
+	stale.activeAt = stale.activeAt.Add(-chunkedUploadTTL - 1)
+	stale.mu.Unlock()
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/upload/status?id="+first, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the stale upload to be discarded, got %d", w.Code)
+	}
+	if _, err := os.Stat(stale.tmpPath); !os.IsNotExist(err) {
+		t.Errorf("expected the temp file of the stale upload to be removed, got %v", err)
+	}
+	initUpload(t, mux, filepath.Join(dir, "after-purge"))
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultMaxWatchers is how many /watch streams may be open at once
+const DefaultMaxWatchers = 16
+
+// WatchEvent is sent for every change to a watched path
+type WatchEvent struct {
+	Path string `json:"path"`
+	// Op is one of create, write, remove or rename
+	Op string `json:"op"`
+}
+
+// watchOps maps the reported fsnotify operations to their event name.
+// Permission changes are not reported.
+var watchOps = []struct {
+	op   fsnotify.Op
+	name string
+}{
+	{fsnotify.Create, "create"},
+	{fsnotify.Write, "write"},
+	{fsnotify.Remove, "remove"},
+	{fsnotify.Rename, "rename"},
+}
+
+// addWatchTree watches root and, if recursive, every directory below it.
+// Symlinked directories are not followed.
+func addWatchTree(ctx context.Context, watcher *fsnotify.Watcher, root string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(root)
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			slog.DebugContext(ctx, "Skipping unreadable watch path", "path", path, "error", err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return watcher.Add(path)
+	})
+}
+
+// watchHandler streams changes to a file or directory as Server-Sent Events
+func (s *Server) watchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("path") == "" {
+		writeMissingField(w, "path")
+		return
+	}
+	var recursive bool
+	if value := query.Get("recursive"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "recursive must be a boolean")
+			return
+		}
+		recursive = parsed
+	}
+
+	path, ok := s.sandboxPath(w, query.Get("path"))
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("Path not found: %s", query.Get("path")))
+		} else {
+			writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to watch path: %v", err))
+		}
+		return
+	}
+
+	if !s.watchers.acquire() {
+		writeError(w, http.StatusTooManyRequests, ErrorCodeRateLimited, "Too many active watchers")
+		return
+	}
+	defer s.watchers.release()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create watcher", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to watch path: %v", err))
+		return
+	}
+	defer watcher.Close()
+
+	recursive = recursive && info.IsDir()
+	if err := addWatchTree(r.Context(), watcher, path, recursive); err != nil {
+		slog.DebugContext(r.Context(), "Failed to add watch", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("Failed to watch path: %v", err))
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Watching path", "path", path, "recursive", recursive)
+
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writer, err := newSSEWriter(w)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to create SSE writer for watch", "path", path, "error", err)
+		writeError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Changes made from now on are reported
+	writer.writeEventf("ready", "{\"path\": %q}", path)
+
+	eventCount := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			slog.DebugContext(r.Context(), "Watch client disconnected", "path", path, "events_sent", eventCount)
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// New directories of a recursive watch are watched as well
+			if recursive && event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := addWatchTree(r.Context(), watcher, event.Name, true); err != nil {
+						slog.DebugContext(r.Context(), "Failed to watch new directory", "path", event.Name, "error", err)
+					}
+				}
+			}
+			for _, op := range watchOps {
+				if !event.Has(op.op) {
+					continue
+				}
+				data, _ := json.Marshal(WatchEvent{Path: event.Name, Op: op.name})
+				writer.writeSequencedEvent("change", string(data))
+				eventCount++
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.DebugContext(r.Context(), "Watcher error", "path", path, "error", err)
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			writer.writeEvent("error", string(data))
+		}
+	}
+}
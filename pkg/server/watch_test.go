@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openWatch starts a /watch stream and waits for its ready event
+func openWatch(t *testing.T, httpServer *httptest.Server, query url.Values) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/watch?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer test-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open watch: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event, _, err := readSSEEvent(reader); err != nil || event != "ready" {
+		t.Fatalf("expected a ready event, got %q: %v", event, err)
+	}
+	return resp, reader
+}
+
+// readSSEEvent returns the name and data of the next event
+func readSSEEvent(reader *bufio.Reader) (string, string, error) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data, nil
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// waitForWatchEvent reads change events until one for path arrives, with
+// one of ops if any are given
+func waitForWatchEvent(t *testing.T, reader *bufio.Reader, path string, ops ...string) {
+	t.Helper()
+
+	found := make(chan bool, 1)
+	go func() {
+		for {
+			event, data, err := readSSEEvent(reader)
+			if err != nil {
+				found <- false
+				return
+			}
+			var change WatchEvent
+			if event != "change" || json.Unmarshal([]byte(data), &change) != nil || change.Path != path {
+				continue
+			}
+			if len(ops) == 0 || slices.Contains(ops, change.Op) {
+				found <- true
+				return
+			}
+		}
+	}()
+
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Fatalf("watch stream ended before a %v event for %s", ops, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %v event for %s", ops, path)
+	}
+}
+
+func TestWatchReportsChanges(t *testing.T) {
+	_, mux := newTestServer(t)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	dir := t.TempDir()
+	_, reader := openWatch(t, httpServer, url.Values{"path": {dir}})
+
+	path := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchEvent(t, reader, path, "create", "write")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchEvent(t, reader, path, "remove")
+}
+
+func TestWatchRecursive(t *testing.T) {
+	_, mux := newTestServer(t)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "existing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, reader := openWatch(t, httpServer, url.Values{"path": {dir}, "recursive": {"true"}})
+
+	path := filepath.Join(dir, "existing", "file.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchEvent(t, reader, path)
+
+	// Directories created after the watch started are watched as well
+	created := filepath.Join(dir, "created")
+	if err := os.Mkdir(created, 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchEvent(t, reader, created)
+	path = filepath.Join(created, "file.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchEvent(t, reader, path)
+}
+
+func TestWatchLimitsConcurrentWatchers(t *testing.T) {
+	srv, mux := newTestServer(t)
+	srv.watchers = newConnLimiter(1)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	dir := t.TempDir()
+	first, _ := openWatch(t, httpServer, url.Values{"path": {dir}})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/watch?path="+url.QueryEscape(dir), nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 with the watcher limit reached, got %d", w.Code)
+	}
+
+	// Disconnecting frees the watcher's slot
+	first.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !srv.watchers.acquire() {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher slot was not released after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.watchers.release()
+}
+
+func TestWatchRejectsInvalidRequests(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusBadRequest},
+		{"path=" + url.QueryEscape(filepath.Join(t.TempDir(), "missing")), http.StatusNotFound},
+		{"path=/tmp&recursive=maybe", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAuthRequest(http.MethodGet, "/watch?"+tt.query, nil))
+		if w.Code != tt.want {
+			t.Errorf("query %q: expected %d, got %d", tt.query, tt.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame types of the /run_ws protocol. Every binary message starts with one
+// of these bytes, followed by the payload.
+const (
+	// wsFrameStdin carries client input. An empty payload closes stdin, or
+	// sends end-of-file on a terminal.
+	wsFrameStdin byte = 0
+	// wsFrameStdout and wsFrameStderr carry raw process output
+	wsFrameStdout byte = 1
+	wsFrameStderr byte = 2
+	// wsFrameResize carries a JSON wsResize that sets the terminal size of
+	// a tty command
+	wsFrameResize byte = 3
+	// wsFrameExit carries a JSON ExecExit and is the last frame of a session
+	wsFrameExit byte = 4
+	// wsFrameError carries a message explaining why the command could not
+	// run; the server closes the socket after it
+	wsFrameError byte = 5
+)
+
+// wsResize is the payload of a resize frame
+type wsResize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Requests are authenticated with a bearer token rather than cookies,
+	// so cross-origin pages can't ride on a user's session
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsConn serializes writes to a websocket, which supports a single writer
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) writeFrame(frameType byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frame := make([]byte, 1+len(payload))
+	frame[0] = frameType
+	copy(frame[1:], payload)
+	return c.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (c *wsConn) writeJSONFrame(frameType byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(frameType, payload)
+}
+
+// readFrame returns the next binary frame, skipping other messages. Any
+// close of the socket ends the input.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	for {
+		messageType, data, err := c.ReadMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		if messageType == websocket.BinaryMessage && len(data) > 0 {
+			return data[0], data[1:], nil
+		}
+	}
+}
+
+// close sends a close message with code and text, then closes the socket
+func (c *wsConn) close(code int, text string) {
+	c.mu.Lock()
+	c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+	c.mu.Unlock()
+	c.Close()
+}
+
+// runWebSocketHandler runs a command interactively over a websocket. The
+// client sends a RunRequest as the first text message, then stdin and resize
+// frames; the server streams stdout and stderr frames and ends with an exit
+// frame.
+func (s *Server) runWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an HTTP error
+		slog.DebugContext(r.Context(), "Failed to upgrade websocket", "error", err)
+		return
+	}
+	conn := &wsConn{Conn: ws}
+
+	var req RunRequest
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to read websocket run request", "error", err)
+		conn.Close()
+		return
+	}
+	if messageType != websocket.TextMessage || decodeJSON(bytes.NewReader(data), &req) != nil || (req.Cmd == "" && len(req.Argv) == 0) {
+		conn.writeFrame(wsFrameError, []byte("Invalid request: the first message must be a JSON run request with a cmd or argv"))
+		conn.close(websocket.CloseUnsupportedData, "invalid request")
+		return
+	}
+	auditCommand(r.Context(), req.Cmd, req.Argv)
+	if req.OutputFiles.isSet() {
+		conn.writeFrame(wsFrameError, []byte(errOutputFilesStreamed.Error()))
+		conn.close(websocket.CloseUnsupportedData, "invalid request")
+		return
+	}
+	if err := req.expandEnv(); err != nil {
+		conn.writeFrame(wsFrameError, []byte(err.Error()))
+		conn.close(websocket.CloseUnsupportedData, "invalid env")
+		return
+	}
+	if err := validateCwd(req.Cwd); err != nil {
+		conn.writeFrame(wsFrameError, []byte(err.Error()))
+		conn.close(websocket.CloseUnsupportedData, "invalid working directory")
+		return
+	}
+	if req.User != "" {
+		if _, err := lookupCommandUser(req.User); err != nil {
+			conn.writeFrame(wsFrameError, []byte("Invalid user: "+err.Error()))
+			conn.close(websocket.CloseUnsupportedData, "invalid user")
+			return
+		}
+	}
+	shell := s.shell
+	if req.Shell != "" {
+		if err := validateShell(req.Shell); err != nil {
+			conn.writeFrame(wsFrameError, []byte(err.Error()))
+			conn.close(websocket.CloseUnsupportedData, "invalid shell")
+			return
+		}
+		shell = req.Shell
+	}
+
+	slog.DebugContext(r.Context(), "Executing websocket command", "cmd", req.Cmd, "argv", req.Argv, "cwd", req.Cwd, "env", req.Env, "limits", req.Limits, "tty", req.TTY, "user", req.User, "shell", shell, "clean_env", req.CleanEnv, "nice", req.Nice)
+
+	// The command is killed if the client goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	command, err := startInteractive(ctx, CommandOptions{
+		Command:  req.Cmd,
+		Cwd:      req.Cwd,
+		Env:      req.Env,
+		Limits:   req.Limits,
+		Argv:     req.Argv,
+		User:     req.User,
+		Shell:    shell,
+		CleanEnv: req.CleanEnv,
+		Nice:     req.Nice,
+	}, req.TTY)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to start websocket command", "cmd", req.Cmd, "error", err)
+		conn.writeFrame(wsFrameError, []byte("Failed to start command"))
+		conn.close(websocket.CloseInternalServerErr, "failed to start command")
+		return
+	}
+
+	exit := command.run(r.Context(), conn, conn, cancel)
+
+	slog.DebugContext(r.Context(), "Websocket command completed", "cmd", req.Cmd, "exit_code", exit.Code, "signal", exit.Signal)
+
+	conn.writeJSONFrame(wsFrameExit, exit)
+	conn.close(websocket.CloseNormalClosure, "")
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialRunWebSocket opens /run_ws on a test server and sends req, usually a
+// RunRequest
+func dialRunWebSocket(t *testing.T, req interface{}) *websocket.Conn {
+	t.Helper()
+
+	_, mux := newTestServer(t)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	header := http.Header{"Authorization": {"Bearer test-secret"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/run_ws", header)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatalf("failed to send run request: %v", err)
+	}
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) (byte, []byte) {
+	t.Helper()
+
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if messageType != websocket.BinaryMessage || len(data) == 0 {
+		t.Fatalf("expected a binary frame, got type %d %q", messageType, data)
+	}
+	return data[0], data[1:]
+}
+
+func TestRunWebSocketEchoesStdin(t *testing.T) {
+	conn := dialRunWebSocket(t, RunRequest{Cmd: "cat"})
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{wsFrameStdin}, "hello\n"...)); err != nil {
+		t.Fatalf("failed to send stdin: %v", err)
+	}
+
+	var stdout strings.Builder
+	for stdout.String() != "hello\n" {
+		frameType, payload := readFrame(t, conn)
+		if frameType != wsFrameStdout {
+			t.Fatalf("expected stdout frame, got type %d %q", frameType, payload)
+		}
+		stdout.Write(payload)
+	}
+
+	// An empty stdin frame closes stdin so cat exits
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{wsFrameStdin}); err != nil {
+		t.Fatalf("failed to close stdin: %v", err)
+	}
+
+	frameType, payload := readFrame(t, conn)
+	if frameType != wsFrameExit {
+		t.Fatalf("expected exit frame, got type %d %q", frameType, payload)
+	}
+	var exit ExecExit
+	if err := json.Unmarshal(payload, &exit); err != nil || exit.Code != 0 {
+		t.Errorf("expected exit code 0, got %s (%v)", payload, err)
+	}
+
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expected a normal close after the exit frame, got %v", err)
+	}
+}
+
+func TestRunWebSocketStreamsStderrAndExitCode(t *testing.T) {
+	conn := dialRunWebSocket(t, RunRequest{Cmd: "echo oops >&2; exit 3"})
+
+	frameType, payload := readFrame(t, conn)
+	if frameType != wsFrameStderr || string(payload) != "oops\n" {
+		t.Fatalf("expected stderr frame, got type %d %q", frameType, payload)
+	}
+
+	frameType, payload = readFrame(t, conn)
+	if frameType != wsFrameExit || string(payload) != `{"code":3}` {
+		t.Errorf("expected exit code 3, got type %d %q", frameType, payload)
+	}
+}
+
+func TestRunWebSocketRejectsInvalidRequest(t *testing.T) {
+	// No command, and a misspelled field rejected like on the other routes
+	for _, req := range []interface{}{RunRequest{}, map[string]string{"cmd": "true", "cwdd": "/tmp"}} {
+		conn := dialRunWebSocket(t, req)
+
+		frameType, payload := readFrame(t, conn)
+		if frameType != wsFrameError {
+			t.Fatalf("expected error frame, got type %d %q", frameType, payload)
+		}
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+			t.Errorf("expected the socket to be closed, got %v", err)
+		}
+	}
+}
+
+func TestRunWebSocketTTYResize(t *testing.T) {
+	conn := dialRunWebSocket(t, RunRequest{Cmd: "read line; stty size", TTY: true})
+
+	resize, _ := json.Marshal(wsResize{Rows: 40, Cols: 120})
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{wsFrameResize}, resize...)); err != nil {
+		t.Fatalf("failed to send resize: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{wsFrameStdin}, "\n"...)); err != nil {
+		t.Fatalf("failed to send stdin: %v", err)
+	}
+
+	var stdout strings.Builder
+	for {
+		frameType, payload := readFrame(t, conn)
+		if frameType == wsFrameExit {
+			break
+		}
+		if frameType != wsFrameStdout {
+			t.Fatalf("expected stdout frame, got type %d %q", frameType, payload)
+		}
+		stdout.Write(payload)
+	}
+
+	if !strings.Contains(stdout.String(), "40 120") {
+		t.Errorf("expected the terminal size to be 40 120, got %q", stdout.String())
+	}
+}